@@ -0,0 +1,298 @@
+// Package client allows writing headless players for scribble.rs, such as
+// bots filling up lobbies or load tests. It speaks the same websocket
+// protocol as the official web client.
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+const (
+	// eventBufferSize is the amount of events buffered for the consumer of
+	// Events. If the consumer doesn't keep up, the connection stalls.
+	eventBufferSize = 256
+	// writeTimeout is the maximum time sending a single event may take.
+	writeTimeout = 10 * time.Second
+)
+
+// ErrClosed is returned when trying to send events after the connection
+// has been closed.
+var ErrClosed = errors.New("connection closed")
+
+// LobbyData contains the static information about a lobby, as returned by
+// the server when joining.
+type LobbyData struct {
+	LobbyID                string `json:"lobbyId"`
+	ProtocolVersion        int    `json:"protocolVersion"`
+	DrawingBoardBaseWidth  int    `json:"drawingBoardBaseWidth"`
+	DrawingBoardBaseHeight int    `json:"drawingBoardBaseHeight"`
+	MinBrushSize           int    `json:"minBrushSize"`
+	MaxBrushSize           int    `json:"maxBrushSize"`
+}
+
+// Event is an event received from the server. The structure of Data
+// depends on the type of the event and can be decoded via Decode.
+type Event struct {
+	// Sequence is the number of the event, see Client.LastSequence.
+	Sequence uint64          `json:"seq"`
+	Type     string          `json:"type"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Decode unmarshals the events data into the given value, for example a
+// game.Ready for "ready" events.
+func (event *Event) Decode(target interface{}) error {
+	return json.Unmarshal(event.Data, target)
+}
+
+// Client is a single player connected to a lobby. All methods are safe for
+// concurrent use.
+type Client struct {
+	lobby       *LobbyData
+	userSession string
+	socket      *websocket.Conn
+	writeMutex  *sync.Mutex
+	events      chan *Event
+
+	mutex        *sync.Mutex
+	err          error
+	lastSequence uint64
+}
+
+// Join enters the lobby with the given ID as a new player and connects to
+// it. The serverURL is the base URL of the server, such as
+// "https://scribble.rs". The first event received is always a "ready" event.
+func Join(serverURL, lobbyID, playerName string) (*Client, error) {
+	baseURL, parseError := url.Parse(strings.TrimSuffix(serverURL, "/"))
+	if parseError != nil {
+		return nil, parseError
+	}
+
+	lobby, userSession, joinError := join(baseURL, lobbyID, playerName)
+	if joinError != nil {
+		return nil, joinError
+	}
+
+	return connect(baseURL, lobby, userSession)
+}
+
+// Connect connects to the lobby as the player identified by the given user
+// session, for example in order to reconnect a client via its UserSession.
+// The session is a signed token issued by the server, which expires after a
+// while and is replaced whenever the player enters the lobby again.
+// Just like with Join, the first event received is always a "ready" event.
+func Connect(serverURL string, lobby *LobbyData, userSession string) (*Client, error) {
+	baseURL, parseError := url.Parse(strings.TrimSuffix(serverURL, "/"))
+	if parseError != nil {
+		return nil, parseError
+	}
+
+	return connect(baseURL, lobby, userSession)
+}
+
+func connect(baseURL *url.URL, lobby *LobbyData, userSession string) (*Client, error) {
+	socketURL := *baseURL
+	if baseURL.Scheme == "https" {
+		socketURL.Scheme = "wss"
+	} else {
+		socketURL.Scheme = "ws"
+	}
+	socketURL.Path += "/v1/ws"
+	socketURL.RawQuery = url.Values{
+		"lobby_id":         {lobby.LobbyID},
+		"protocol_version": {strconv.Itoa(game.ProtocolVersion)},
+	}.Encode()
+
+	header := http.Header{}
+	header.Set("Usersession", userSession)
+	socket, _, dialError := websocket.DefaultDialer.Dial(socketURL.String(), header)
+	if dialError != nil {
+		return nil, fmt.Errorf("error connecting to lobby: %s", dialError)
+	}
+
+	client := &Client{
+		lobby:       lobby,
+		userSession: userSession,
+		socket:      socket,
+		writeMutex:  &sync.Mutex{},
+		events:      make(chan *Event, eventBufferSize),
+		mutex:       &sync.Mutex{},
+	}
+	go client.listen()
+
+	return client, nil
+}
+
+func join(baseURL *url.URL, lobbyID, playerName string) (*LobbyData, string, error) {
+	joinURL := *baseURL
+	joinURL.Path += "/v1/lobby/player"
+	joinURL.RawQuery = url.Values{"lobby_id": {lobbyID}}.Encode()
+
+	response, requestError := http.PostForm(joinURL.String(), url.Values{"username": {playerName}})
+	if requestError != nil {
+		return nil, "", requestError
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		message := make([]byte, 512)
+		length, _ := response.Body.Read(message)
+		return nil, "", fmt.Errorf("error joining lobby (%d): %s", response.StatusCode, strings.TrimSpace(string(message[:length])))
+	}
+
+	var userSession string
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == "usersession" {
+			userSession = cookie.Value
+		}
+	}
+	if userSession == "" {
+		return nil, "", errors.New("server didn't assign a usersession")
+	}
+
+	lobby := &LobbyData{}
+	if decodeError := json.NewDecoder(response.Body).Decode(lobby); decodeError != nil {
+		return nil, "", decodeError
+	}
+
+	return lobby, userSession, nil
+}
+
+func (client *Client) listen() {
+	defer close(client.events)
+
+	for {
+		_, data, readError := client.socket.ReadMessage()
+		if readError != nil {
+			client.mutex.Lock()
+			if client.err == nil {
+				client.err = readError
+			}
+			client.mutex.Unlock()
+			return
+		}
+
+		event := &Event{}
+		if decodeError := json.Unmarshal(data, event); decodeError != nil {
+			continue
+		}
+
+		client.mutex.Lock()
+		client.lastSequence = event.Sequence
+		client.mutex.Unlock()
+
+		client.events <- event
+	}
+}
+
+// Lobby returns the information about the lobby the client has joined.
+func (client *Client) Lobby() *LobbyData {
+	return client.lobby
+}
+
+// UserSession returns the session token identifying the player. It allows
+// reconnecting as the same player until it expires.
+func (client *Client) UserSession() string {
+	return client.userSession
+}
+
+// Events returns all events received from the server. The channel is
+// closed as soon as the connection has been closed, see Err.
+func (client *Client) Events() <-chan *Event {
+	return client.events
+}
+
+// LastSequence returns the sequence number of the last event received.
+func (client *Client) LastSequence() uint64 {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.lastSequence
+}
+
+// Err returns the reason for the connection having been closed. If the
+// server closed the connection, it's a *websocket.CloseError containing one
+// of the close codes defined by the game package.
+func (client *Client) Err() error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.err
+}
+
+func (client *Client) send(eventType string, data interface{}) error {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+
+	if client.Err() != nil {
+		return ErrClosed
+	}
+
+	client.socket.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return client.socket.WriteJSON(&game.GameEvent{Type: eventType, Data: data})
+}
+
+// SendMessage sends a chat message. While guessing, messages are treated as
+// guesses. Messages starting with an exclamation mark are commands.
+func (client *Client) SendMessage(text string) error {
+	return client.send(game.EventTypeMessage, text)
+}
+
+// ChooseWord chooses one of the words offered via the "your-turn" event.
+func (client *Client) ChooseWord(index int) error {
+	return client.send(game.EventTypeChooseWord, index)
+}
+
+// DrawLine draws a line, which is only allowed while drawing. The
+// coordinates are relative to the lobbies drawing board base size.
+func (client *Client) DrawLine(line game.Line) error {
+	return client.send(game.EventTypeLine, &line)
+}
+
+// Fill uses the fill bucket at the given position.
+func (client *Client) Fill(fill game.Fill) error {
+	return client.send(game.EventTypeFill, &fill)
+}
+
+// ClearDrawingBoard clears the drawing board.
+func (client *Client) ClearDrawingBoard() error {
+	return client.send(game.EventTypeClearDrawingBoard, nil)
+}
+
+// Start starts the game, which is only allowed for the lobby owner.
+func (client *Client) Start() error {
+	return client.send(game.EventTypeStart, nil)
+}
+
+// VoteKick votes for kicking the player with the given ID.
+func (client *Client) VoteKick(playerID string) error {
+	return client.send(game.EventTypeKickVote, playerID)
+}
+
+// ChangeName changes the name of the player.
+func (client *Client) ChangeName(name string) error {
+	return client.send(game.EventTypeNameChange, name)
+}
+
+// Close leaves the lobby. The player keeps their slot for a while, just
+// like players closing the web client.
+func (client *Client) Close() error {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+
+	client.socket.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	return client.socket.Close()
+}
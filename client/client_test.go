@@ -0,0 +1,72 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/state"
+
+	//Registers the HTTP endpoints.
+	"github.com/scribble-rs/scribble.rs/communication"
+)
+
+func nextEvent(t *testing.T, client *Client, eventType string) *Event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, open := <-client.Events():
+			if !open {
+				t.Fatalf("connection closed while waiting for %s: %v", eventType, client.Err())
+			}
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", eventType)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	_, lobby, err := communication.GameServer().CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.AddLobby(lobby)
+	defer state.RemoveLobby(lobby.ID)
+
+	server := httptest.NewServer(http.DefaultServeMux)
+	defer server.Close()
+
+	bot, err := Join(server.URL, lobby.ID, "bot")
+	if err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+	defer bot.Close()
+
+	ready := &game.Ready{}
+	if err := nextEvent(t, bot, "ready").Decode(ready); err != nil {
+		t.Fatal(err)
+	}
+	if ready.PlayerName != "bot" || ready.PlayerID == "" {
+		t.Errorf("unexpected ready event %+v", ready)
+	}
+
+	if err := bot.SendMessage("hello"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	message := &game.Message{}
+	if err := nextEvent(t, bot, "message").Decode(message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Content != "hello" {
+		t.Errorf("unexpected message %v", message)
+	}
+
+	if bot.LastSequence() == 0 {
+		t.Error("sequence numbers weren't tracked")
+	}
+}
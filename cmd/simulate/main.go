@@ -0,0 +1,55 @@
+// simulate runs lobbies full of bots against an in-process server and
+// reports the throughput and latency of the broadcast path. A latency budget
+// can be given via maxLatencyP99, in which case the exit code tells whether
+// it has been exceeded, so that it can be used before deploying.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/simulation"
+)
+
+func main() {
+	defaults := simulation.DefaultConfig()
+	lobbies := flag.Int("lobbies", defaults.Lobbies, "the amount of lobbies to run concurrently")
+	players := flag.Int("players", defaults.PlayersPerLobby, "the amount of bots in each lobby")
+	duration := flag.Duration("duration", defaults.Duration, "how long the bots play")
+	drawingTime := flag.Int("drawingTime", defaults.DrawingTime, "the drawing time of each turn in seconds")
+	strokeInterval := flag.Duration("strokeInterval", defaults.StrokeInterval, "the time between two lines drawn by a drawer")
+	guessDelay := flag.Duration("guessDelay", defaults.GuessDelay, "the maximum time a bot takes for guessing the word")
+	kickInterval := flag.Duration("kickInterval", defaults.KickInterval, "the time between two votekicks in each lobby. 0 disables kicking")
+	seed := flag.Int64("seed", defaults.Seed, "the seed for all decisions of the bots")
+	maxLatencyP99 := flag.Duration("maxLatencyP99", 0, "if set, the exit code is 1 if the 99th percentile of the line latency exceeds this value")
+	logLevel := flag.String("logLevel", "error", "the minimum level of log entries written by the server: debug, info, warn or error")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	logging.Configure(level, false)
+
+	report, err := simulation.Run(simulation.Config{
+		Lobbies:         *lobbies,
+		PlayersPerLobby: *players,
+		Duration:        *duration,
+		DrawingTime:     *drawingTime,
+		StrokeInterval:  *strokeInterval,
+		GuessDelay:      *guessDelay,
+		KickInterval:    *kickInterval,
+		Seed:            *seed,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	report.Write(os.Stdout)
+	if *maxLatencyP99 > 0 && report.LatencyP99 > *maxLatencyP99 {
+		log.Printf("p99 latency of %s exceeds %s\n", report.LatencyP99, *maxLatencyP99)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,304 @@
+package communication
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+const (
+	// accountTokenVersion is the first field of account tokens. It differs
+	// from sessionTokenVersion, so that neither can be used as the other.
+	accountTokenVersion = "a1"
+	// accountLifetime is how long a player stays logged in.
+	accountLifetime = 30 * 24 * time.Hour
+	// oauthStateLifetime is how long a player has for logging in at the
+	// provider.
+	oauthStateLifetime = 10 * time.Minute
+)
+
+var (
+	// accountStore is nil unless accounts have been enabled, in which case
+	// players can optionally log in. See ConfigureAccounts.
+	accountStore   state.AccountStore
+	oauthProviders map[string]*OAuthProvider
+	// accountsPublicURL is the base of the redirect URLs registered at the
+	// providers.
+	accountsPublicURL string
+)
+
+// ConfigureAccounts allows players to log in via the given providers, so
+// that they keep their name and avatar across lobbies and devices. Playing
+// anonymously stays possible. The publicURL is where the site is reached by
+// players, it's used for building the redirect URLs. Since the login is
+// remembered via a token signed with the session secret, it has to be
+// configured for logins to survive restarts, see ConfigureSessionTokens.
+func ConfigureAccounts(store state.AccountStore, providers []*OAuthProvider, publicURL string) {
+	accountStore = store
+	oauthProviders = make(map[string]*OAuthProvider)
+	for _, provider := range providers {
+		oauthProviders[provider.name] = provider
+	}
+	accountsPublicURL = strings.TrimSuffix(publicURL, "/")
+}
+
+// loginProviders returns the names of all providers, ordered by name.
+func loginProviders() []string {
+	var names []string
+	for name := range oauthProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func oauthRedirectURL(provider *OAuthProvider) string {
+	return accountsPublicURL + "/login/callback/" + provider.name
+}
+
+// loginEndpoint sends the player to the provider for logging in. The state
+// passed along is remembered in a cookie, so that the callback can make sure
+// the login has been started by the same browser.
+func loginEndpoint(w http.ResponseWriter, r *http.Request) {
+	provider, found := oauthProviders[r.URL.Query().Get("provider")]
+	if !found {
+		http.Error(w, "unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	oauthState := base64.RawURLEncoding.EncodeToString(nonce)
+
+	//The cookie has to be sent along with the redirect from the provider,
+	//which is a cross-site navigation, so Strict can't be used here.
+	http.SetCookie(w, &http.Cookie{
+		Name:     "oauthstate",
+		Value:    oauthState + "|" + localRedirectTarget(r.URL.Query().Get("redirect")),
+		Path:     "/login/",
+		MaxAge:   int(oauthStateLifetime / time.Second),
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+	})
+	http.Redirect(w, r, provider.authCodeURL(oauthState, oauthRedirectURL(provider)), http.StatusFound)
+}
+
+// localRedirectTarget makes sure that players are only ever sent to pages
+// of this site after logging in.
+func localRedirectTarget(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// loginCallbackEndpoint is where the provider sends the player after logging
+// in. The account belonging to the login is created on first use.
+func loginCallbackEndpoint(w http.ResponseWriter, r *http.Request) {
+	provider, found := oauthProviders[strings.TrimPrefix(r.URL.Path, "/login/callback/")]
+	if !found {
+		http.Error(w, "unknown login provider", http.StatusNotFound)
+		return
+	}
+
+	stateCookie, noCookieError := r.Cookie("oauthstate")
+	if noCookieError != nil {
+		userFacingError(w, "The login has expired, please try again.")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: "oauthstate", Path: "/login/", MaxAge: -1})
+
+	oauthState, redirectTarget := stateCookie.Value, "/"
+	if separator := strings.IndexByte(stateCookie.Value, '|'); separator != -1 {
+		oauthState, redirectTarget = stateCookie.Value[:separator], localRedirectTarget(stateCookie.Value[separator+1:])
+	}
+	if subtle.ConstantTimeCompare([]byte(oauthState), []byte(r.URL.Query().Get("state"))) != 1 {
+		userFacingError(w, "The login has expired, please try again.")
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		//The player has most likely declined the login at the provider.
+		http.Redirect(w, r, redirectTarget, http.StatusFound)
+		return
+	}
+
+	user, exchangeError := provider.exchange(r.Context(), code, oauthRedirectURL(provider))
+	if exchangeError != nil {
+		logging.Warn("error logging in", "provider", provider.name, "error", exchangeError)
+		userFacingError(w, "Logging in failed, please try again later.")
+		return
+	}
+
+	account, loginError := loginAccount(provider.name, user)
+	if loginError != nil {
+		logging.Error("error saving account", "provider", provider.name, "error", loginError)
+		userFacingError(w, "Logging in failed, please try again later.")
+		return
+	}
+
+	setAccountCookie(w, account)
+	http.Redirect(w, r, redirectTarget, http.StatusFound)
+}
+
+// loginAccount returns the account belonging to the user, creating it if
+// needed. The avatar is refreshed on every login, while the name is only
+// taken from the provider once, as players may change it afterwards.
+func loginAccount(providerName string, user *oauthUser) (*state.Account, error) {
+	account, findError := accountStore.FindByLogin(providerName, user.subject)
+	if findError == state.ErrAccountNotExistent {
+		name := sanitizeAccountName(user.name)
+		if name == "" {
+			name = game.GeneratePlayerName()
+		}
+		account = state.NewAccount(providerName, user.subject, name, user.avatar)
+		return account, accountStore.Save(account)
+	}
+	if findError != nil {
+		return nil, findError
+	}
+
+	if account.Avatar != user.avatar {
+		account.Avatar = user.avatar
+		return account, accountStore.Save(account)
+	}
+	return account, nil
+}
+
+// sanitizeAccountName trims the name to the maximum length of player names.
+// Unlike player names, account names are stored unescaped.
+func sanitizeAccountName(name string) string {
+	name = strings.TrimSpace(name)
+	for len(html.EscapeString(name)) > game.MaxPlayerNameLength {
+		name = name[:len(name)-1]
+	}
+	return strings.ToValidUTF8(name, "")
+}
+
+// logoutEndpoint forgets about the account, the player is anonymous again
+// in all lobbies joined afterwards.
+func logoutEndpoint(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: "account", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// accountEndpoint returns the account of the player on GET and changes
+// its name on POST.
+func accountEndpoint(w http.ResponseWriter, r *http.Request) {
+	account := getAccount(r)
+	if account == nil {
+		http.Error(w, "you aren't logged in", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if parseError := r.ParseForm(); parseError != nil {
+			http.Error(w, parseError.Error(), http.StatusBadRequest)
+			return
+		}
+		name := sanitizeAccountName(r.Form.Get("name"))
+		if name == "" {
+			http.Error(w, "the name must not be empty", http.StatusBadRequest)
+			return
+		}
+		account.Name = name
+		if saveError := accountStore.Save(account); saveError != nil {
+			http.Error(w, saveError.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if encodingError := json.NewEncoder(w).Encode(account); encodingError != nil {
+		http.Error(w, encodingError.Error(), http.StatusInternalServerError)
+	}
+}
+
+// getAccount returns the account the player has logged in with or nil if
+// the player is anonymous or accounts are disabled.
+func getAccount(r *http.Request) *state.Account {
+	if accountStore == nil {
+		return nil
+	}
+	accountCookie, noCookieError := r.Cookie("account")
+	if noCookieError != nil {
+		return nil
+	}
+	accountID, tokenError := parseAccountToken(accountCookie.Value, time.Now())
+	if tokenError != nil {
+		return nil
+	}
+
+	account, findError := accountStore.Get(accountID)
+	if findError != nil {
+		return nil
+	}
+	return account
+}
+
+// linkAccount marks the player as belonging to the account the request has
+// been made with, if any.
+func linkAccount(lobby *game.Lobby, player *game.Player, r *http.Request) {
+	if account := getAccount(r); account != nil {
+		lobby.LinkAccount(player, account.ID, account.Avatar)
+	}
+}
+
+// setAccountCookie remembers the login. It's sent along with the redirect
+// from the provider, so Strict can't be used here either.
+func setAccountCookie(w http.ResponseWriter, account *state.Account) {
+	expiry := time.Now().Add(accountLifetime)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "account",
+		Value:    encodeAccountToken(account.ID, expiry),
+		Path:     "/",
+		Expires:  expiry,
+		SameSite: http.SameSiteLaxMode,
+		HttpOnly: true,
+	})
+}
+
+// encodeAccountToken signs the account ID the same way as session tokens.
+func encodeAccountToken(accountID string, expiry time.Time) string {
+	payload := accountTokenVersion + "." + accountID + "." + strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + signSessionPayload(payload)
+}
+
+func parseAccountToken(encoded string, now time.Time) (string, error) {
+	fields := strings.Split(encoded, ".")
+	if len(fields) != 4 || fields[0] != accountTokenVersion {
+		return "", errSessionTokenInvalid
+	}
+	payload := strings.Join(fields[:3], ".")
+	if subtle.ConstantTimeCompare([]byte(fields[3]), []byte(signSessionPayload(payload))) != 1 {
+		return "", errSessionTokenInvalid
+	}
+
+	expiry, parseError := strconv.ParseInt(fields[2], 10, 64)
+	if parseError != nil {
+		return "", errSessionTokenInvalid
+	}
+	if !now.Before(time.Unix(expiry, 0)) {
+		return "", errSessionTokenExpired
+	}
+	return fields[1], nil
+}
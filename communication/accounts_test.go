@@ -0,0 +1,132 @@
+package communication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+func Test_localRedirectTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{target: "", want: "/"},
+		{target: "/ssrEnterLobby?lobby_id=abc", want: "/ssrEnterLobby?lobby_id=abc"},
+		{target: "https://example.com", want: "/"},
+		{target: "//example.com", want: "/"},
+		{target: "/\\example.com", want: "/"},
+	}
+	for _, tt := range tests {
+		if got := localRedirectTarget(tt.target); got != tt.want {
+			t.Errorf("localRedirectTarget(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func Test_parseAccountToken(t *testing.T) {
+	valid := encodeAccountToken("account", time.Now().Add(time.Hour))
+	if id, err := parseAccountToken(valid, time.Now()); err != nil || id != "account" {
+		t.Errorf("valid token was rejected: %q, %v", id, err)
+	}
+	if _, err := parseAccountToken(valid, time.Now().Add(2*time.Hour)); err != errSessionTokenExpired {
+		t.Errorf("expected expired token to be rejected, got %v", err)
+	}
+	forged := strings.Replace(valid, "account", "other", 1)
+	if _, err := parseAccountToken(forged, time.Now()); err != errSessionTokenInvalid {
+		t.Errorf("expected forged token to be rejected, got %v", err)
+	}
+}
+
+func Test_login(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.FormValue("code") != "secret-code" || r.FormValue("client_secret") != "client-secret" {
+				http.Error(w, "invalid code", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"access","token_type":"Bearer"}`))
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer access" {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"id":"1234","username":"marcel","avatar":"hash"}`))
+		}
+	}))
+	defer provider.Close()
+
+	discord, err := NewOAuthProvider("discord", "client-id", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	discord.authURL = provider.URL + "/authorize"
+	discord.tokenURL = provider.URL + "/token"
+	discord.userURL = provider.URL + "/user"
+	store := &state.MemoryAccountStore{}
+	ConfigureAccounts(store, []*OAuthProvider{discord}, "https://scribble.example.com/")
+	defer ConfigureAccounts(nil, nil, "")
+
+	recorder := httptest.NewRecorder()
+	loginEndpoint(recorder, httptest.NewRequest(http.MethodGet, "/login?provider=discord&redirect=/ssrEnterLobby", nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("login returned %d", recorder.Code)
+	}
+	authURL, _ := url.Parse(recorder.Header().Get("Location"))
+	if authURL.Query().Get("redirect_uri") != "https://scribble.example.com/login/callback/discord" {
+		t.Errorf("unexpected redirect URL %s", authURL.Query().Get("redirect_uri"))
+	}
+	stateCookie := recorder.Result().Cookies()[0]
+
+	//A callback without the state issued to the browser is refused.
+	recorder = httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/login/callback/discord?code=secret-code&state=guessed", nil)
+	request.AddCookie(stateCookie)
+	loginCallbackEndpoint(recorder, request)
+	if len(recorder.Result().Cookies()) != 1 {
+		t.Errorf("callback with invalid state has been accepted")
+	}
+
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/login/callback/discord?code=secret-code&state="+authURL.Query().Get("state"), nil)
+	request.AddCookie(stateCookie)
+	loginCallbackEndpoint(recorder, request)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/ssrEnterLobby" {
+		t.Fatalf("callback returned %d, redirecting to %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+	var accountCookie *http.Cookie
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == "account" {
+			accountCookie = cookie
+		}
+	}
+	if accountCookie == nil {
+		t.Fatal("account cookie hasn't been set")
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/v1/account", strings.NewReader("name=renamed"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.AddCookie(accountCookie)
+	recorder = httptest.NewRecorder()
+	accountEndpoint(recorder, request)
+	account := &state.Account{}
+	if err := json.NewDecoder(recorder.Body).Decode(account); err != nil {
+		t.Fatal(err)
+	}
+	if account.Name != "renamed" || account.Avatar != "https://cdn.discordapp.com/avatars/1234/hash.png" {
+		t.Errorf("unexpected account %+v", account)
+	}
+
+	//The name is kept when logging in again.
+	loggedIn, err := loginAccount("discord", &oauthUser{subject: "1234", name: "marcel"})
+	if err != nil || loggedIn.ID != account.ID || loggedIn.Name != "renamed" || loggedIn.Avatar != "" {
+		t.Errorf("unexpected account after logging in again: %+v, %v", loggedIn, err)
+	}
+}
@@ -0,0 +1,395 @@
+package communication
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// AdminScope limits what a credential may do with the admin API. Each scope
+// includes the permissions of the ones below it.
+type AdminScope int
+
+const (
+	// AdminScopeRead allows inspecting lobbies.
+	AdminScopeRead AdminScope = iota + 1
+	// AdminScopeModerate additionally allows closing lobbies, kicking
+	// players and sending announcements.
+	AdminScopeModerate
+	// AdminScopeFull additionally allows changing the instance, such as
+	// reloading the configuration.
+	AdminScopeFull
+)
+
+var adminScopeNames = map[string]AdminScope{
+	"read":     AdminScopeRead,
+	"moderate": AdminScopeModerate,
+	"full":     AdminScopeFull,
+}
+
+// ParseAdminScope parses "read", "moderate" or "full".
+func ParseAdminScope(name string) (AdminScope, error) {
+	scope, known := adminScopeNames[strings.ToLower(strings.TrimSpace(name))]
+	if !known {
+		return 0, fmt.Errorf("unknown admin scope '%s', expected read, moderate or full", name)
+	}
+	return scope, nil
+}
+
+// AdminCredential grants access to the admin API. The token can either be
+// sent as a bearer token or as the password of basic auth, using the name as
+// the username.
+type AdminCredential struct {
+	// Name identifies who used the admin API in the logs.
+	Name  string
+	Token string
+	Scope AdminScope
+}
+
+type adminContextKey struct{}
+
+var (
+	// adminCredentials are all credentials allowed to use the admin API.
+	// If there are none, the admin API is disabled.
+	adminCredentials []AdminCredential
+	// reloadConfiguration reloads the configuration file, if this is
+	// supported by the caller of ConfigureAdmin.
+	reloadConfiguration func() error
+)
+
+// ConfigureAdmin enables the admin API for the given credentials.
+// Optionally, a function for reloading the configuration can be passed,
+// which is then available via the API as well.
+func ConfigureAdmin(credentials []AdminCredential, reload func() error) {
+	adminCredentials = credentials
+	reloadConfiguration = reload
+}
+
+// AdminLobbyEntry describes a lobby in the list of all lobbies.
+type AdminLobbyEntry struct {
+	ID               string `json:"id"`
+	Public           bool   `json:"public"`
+	State            string `json:"state"`
+	Round            int    `json:"round"`
+	MaxRounds        int    `json:"maxRounds"`
+	PlayerCount      int    `json:"playerCount"`
+	ConnectedPlayers int    `json:"connectedPlayers"`
+	MaxPlayers       int    `json:"maxPlayers"`
+	Wordpack         string `json:"wordpack"`
+	Owner            string `json:"owner,omitempty"`
+}
+
+// AdminLobbyDetails is the current state of a lobby. Unlike the events sent
+// to players, it contains the word being drawn and the players addresses.
+type AdminLobbyDetails struct {
+	AdminLobbyEntry
+	DrawingTime  int                `json:"drawingTime"`
+	CurrentWord  string             `json:"currentWord"`
+	Drawer       string             `json:"drawer,omitempty"`
+	RoundEndTime int64              `json:"roundEndTime,omitempty"`
+	Tags         []string           `json:"tags"`
+	Region       string             `json:"region"`
+	Players      []*AdminPlayerInfo `json:"players"`
+	Bans         []string           `json:"bans"`
+	Spectators   int                `json:"spectators"`
+}
+
+// AdminPlayerInfo describes a single player of a lobby.
+type AdminPlayerInfo struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Score     int    `json:"score"`
+	Rank      int    `json:"rank"`
+	State     string `json:"state"`
+	Connected bool   `json:"connected"`
+	Latency   int    `json:"latency"`
+	Address   string `json:"address"`
+}
+
+// withAdminAuth only passes requests to the handler if they contain a
+// credential with at least the given scope. If the admin API is disabled, it
+// pretends not to exist.
+func withAdminAuth(scope AdminScope, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(adminCredentials) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		credential := authenticateAdmin(r)
+		if credential == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="scribble.rs admin"`)
+			http.Error(w, "invalid admin credentials", http.StatusUnauthorized)
+			return
+		}
+		if credential.Scope < scope {
+			logging.Warn("admin request with insufficient scope", "admin", credential.Name, "path", r.URL.Path)
+			http.Error(w, "the admin credentials don't allow this", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		handler(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, credential.Name)))
+	}
+}
+
+// authenticateAdmin returns the credential matching the bearer token or
+// basic auth of the request. All credentials are compared in constant time,
+// so that the response time doesn't reveal which one almost matched.
+func authenticateAdmin(r *http.Request) *AdminCredential {
+	name, token, isBasicAuth := r.BasicAuth()
+	if !isBasicAuth {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			return nil
+		}
+		token = strings.TrimPrefix(authorization, "Bearer ")
+	}
+	if token == "" {
+		return nil
+	}
+
+	var match *AdminCredential
+	for index := range adminCredentials {
+		credential := &adminCredentials[index]
+		tokenMatches := subtle.ConstantTimeCompare([]byte(token), []byte(credential.Token)) == 1
+		nameMatches := !isBasicAuth || subtle.ConstantTimeCompare([]byte(name), []byte(credential.Name)) == 1
+		if tokenMatches && nameMatches && match == nil {
+			match = credential
+		}
+	}
+
+	return match
+}
+
+// getAdminName returns the name of the credential used for the request.
+func getAdminName(r *http.Request) string {
+	name, _ := r.Context().Value(adminContextKey{}).(string)
+	return name
+}
+
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func getAdminLobby(w http.ResponseWriter, r *http.Request) *game.Lobby {
+	lobbyID := r.URL.Query().Get("lobby_id")
+	if lobbyID == "" {
+		http.Error(w, errNoLobbyIDSupplied.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	lobby, err := state.GetLobby(lobbyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	return lobby
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if encodingError := json.NewEncoder(w).Encode(data); encodingError != nil {
+		http.Error(w, encodingError.Error(), http.StatusInternalServerError)
+	}
+}
+
+func newAdminLobbyEntry(lobby *game.Lobby) AdminLobbyEntry {
+	entry := AdminLobbyEntry{
+		ID:               lobby.ID,
+		Public:           lobby.IsPublic(),
+		State:            lobby.GetState(),
+		Round:            lobby.Round,
+		MaxRounds:        lobby.MaxRounds,
+		PlayerCount:      len(lobby.SnapshotPlayers()),
+		ConnectedPlayers: lobby.GetConnectedPlayerCount(),
+		MaxPlayers:       lobby.MaxPlayers,
+		Wordpack:         lobby.Wordpack,
+	}
+	if owner := lobby.GetOwner(); owner != nil {
+		entry.Owner = owner.ID
+	}
+	return entry
+}
+
+// adminLobbiesEndpoint lists all lobbies, including private ones.
+func adminLobbiesEndpoint(w http.ResponseWriter, r *http.Request) {
+	lobbies := state.GetLobbies()
+	entries := make([]AdminLobbyEntry, 0, len(lobbies))
+	for _, lobby := range lobbies {
+		entries = append(entries, newAdminLobbyEntry(lobby))
+	}
+
+	writeJSON(w, entries)
+}
+
+// adminLobbyEndpoint shows the current state of the lobby given via the
+// 'lobby_id' query parameter.
+func adminLobbyEndpoint(w http.ResponseWriter, r *http.Request) {
+	lobby := getAdminLobby(w, r)
+	if lobby == nil {
+		return
+	}
+
+	details := &AdminLobbyDetails{
+		AdminLobbyEntry: newAdminLobbyEntry(lobby),
+		DrawingTime:     lobby.DrawingTime,
+		CurrentWord:     lobby.CurrentWord,
+		Tags:            lobby.Tags,
+		Region:          lobby.Region,
+		Players:         make([]*AdminPlayerInfo, 0),
+		Bans:            make([]string, 0),
+		Spectators:      getSpectatorCount(lobby.ID),
+	}
+	if drawer := lobby.GetDrawer(); drawer != nil {
+		details.Drawer = drawer.ID
+	}
+	if lobby.IsTurnInProgress() {
+		details.RoundEndTime = lobby.RoundEndTime
+	}
+	for _, player := range lobby.SnapshotPlayers() {
+		details.Players = append(details.Players, &AdminPlayerInfo{
+			ID:        player.ID,
+			Name:      player.Name,
+			Score:     player.Score,
+			Rank:      player.Rank,
+			State:     string(player.State),
+			Connected: player.Connected,
+			Latency:   player.Latency,
+			Address:   player.GetLastKnownAddress(),
+		})
+	}
+	for _, ban := range lobby.GetBans() {
+		details.Bans = append(details.Bans, ban.PlayerName)
+	}
+
+	writeJSON(w, details)
+}
+
+// adminCloseLobbyEndpoint closes the lobby given via the 'lobby_id' query
+// parameter. The optional 'reason' is shown to the players.
+func adminCloseLobbyEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if reason == "" {
+		reason = "The lobby has been closed by an administrator."
+	}
+	if forwardAdminAction(w, r, &state.ClusterMessage{Type: "close-lobby", Text: reason}) {
+		return
+	}
+	lobby := getAdminLobby(w, r)
+	if lobby == nil {
+		return
+	}
+
+	lobby.Logger().Info("closing lobby via admin API", "admin", getAdminName(r), "reason", reason)
+	recordAdminAudit(getAdminName(r), lobby.ID, "close", "", "", reason)
+	game.CloseLobby(lobby, reason)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminKickEndpoint removes the player given via the 'player_id' query
+// parameter from the lobby. If 'ban' is true, the player can't rejoin.
+func adminKickEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	playerID := r.URL.Query().Get("player_id")
+	ban := r.URL.Query().Get("ban") == "true"
+	reason := "You have been removed from the lobby by an administrator."
+	if forwardAdminAction(w, r, &state.ClusterMessage{Type: "kick-player", PlayerID: playerID, Ban: ban, Text: reason}) {
+		return
+	}
+	lobby := getAdminLobby(w, r)
+	if lobby == nil {
+		return
+	}
+	playerName := findPlayerName(lobby, playerID)
+	if !lobby.KickPlayer(playerID, ban, reason) {
+		http.Error(w, "the player doesn't exist", http.StatusNotFound)
+		return
+	}
+	recordAdminAudit(getAdminName(r), lobby.ID, kickAuditAction(ban), playerID, playerName, "")
+
+	lobby.Logger().Info("kicked player via admin API", "admin", getAdminName(r), "player", playerID, "ban", ban)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findPlayerName returns the name of the player with the given ID, or an
+// empty string if there's no such player.
+func findPlayerName(lobby *game.Lobby, playerID string) string {
+	for _, player := range lobby.SnapshotPlayers() {
+		if player.ID == playerID {
+			return player.Name
+		}
+	}
+	return ""
+}
+
+func kickAuditAction(ban bool) string {
+	if ban {
+		return "ban"
+	}
+	return "kick"
+}
+
+// adminAnnouncementEndpoint shows the 'message' query parameter in the chat
+// of every lobby.
+func adminAnnouncementEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	message := strings.TrimSpace(r.URL.Query().Get("message"))
+	if message == "" {
+		http.Error(w, "please supply a message via the 'message' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	lobbies := state.GetLobbies()
+	for _, lobby := range lobbies {
+		WritePublicSystemMessage(lobby, message)
+	}
+	if cluster != nil {
+		if err := cluster.Publish(&state.ClusterMessage{Type: "announcement", Sender: instanceID, Text: message}); err != nil {
+			logging.Warn("error sending announcement to the cluster", "error", err)
+		}
+	}
+
+	logging.Info("sent announcement via admin API", "admin", getAdminName(r), "lobbies", len(lobbies))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminReloadEndpoint reloads the configuration and word lists, just like
+// SIGHUP does.
+func adminReloadEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if reloadConfiguration == nil {
+		http.Error(w, "reloading isn't supported", http.StatusNotImplemented)
+		return
+	}
+
+	logging.Info("reloading configuration via admin API", "admin", getAdminName(r))
+	if reloadError := reloadConfiguration(); reloadError != nil {
+		http.Error(w, reloadError.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package communication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+func Test_withAdminAuth(t *testing.T) {
+	defer ConfigureAdmin(nil, nil)
+
+	credentials := []AdminCredential{
+		{Name: "viewer", Token: "read-token", Scope: AdminScopeRead},
+		{Name: "moderator", Token: "moderate-token", Scope: AdminScopeModerate},
+	}
+
+	tests := []struct {
+		name          string
+		credentials   []AdminCredential
+		scope         AdminScope
+		authorization string
+		basicAuth     []string
+		want          int
+	}{
+		{"disabled", nil, AdminScopeRead, "Bearer read-token", nil, http.StatusNotFound},
+		{"missing token", credentials, AdminScopeRead, "", nil, http.StatusUnauthorized},
+		{"empty token", credentials, AdminScopeRead, "Bearer ", nil, http.StatusUnauthorized},
+		{"wrong token", credentials, AdminScopeRead, "Bearer read-tokeN", nil, http.StatusUnauthorized},
+		{"valid token", credentials, AdminScopeRead, "Bearer read-token", nil, http.StatusOK},
+		{"insufficient scope", credentials, AdminScopeModerate, "Bearer read-token", nil, http.StatusForbidden},
+		{"higher scope", credentials, AdminScopeModerate, "Bearer moderate-token", nil, http.StatusOK},
+		{"full scope required", credentials, AdminScopeFull, "Bearer moderate-token", nil, http.StatusForbidden},
+		{"basic auth", credentials, AdminScopeModerate, "", []string{"moderator", "moderate-token"}, http.StatusOK},
+		{"basic auth with wrong name", credentials, AdminScopeRead, "", []string{"viewer", "moderate-token"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ConfigureAdmin(tt.credentials, nil)
+			request := httptest.NewRequest(http.MethodGet, "/v1/admin/lobbies", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.basicAuth != nil {
+				request.SetBasicAuth(tt.basicAuth[0], tt.basicAuth[1])
+			}
+
+			var adminName string
+			recorder := httptest.NewRecorder()
+			withAdminAuth(tt.scope, func(w http.ResponseWriter, r *http.Request) {
+				adminName = getAdminName(r)
+			})(recorder, request)
+
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && adminName == "" {
+				t.Errorf("the admin name isn't available to the handler")
+			}
+		})
+	}
+}
+
+func Test_adminEndpoints(t *testing.T) {
+	_, lobby, err := gameServer.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.AddLobby(lobby)
+	defer state.RemoveLobby(lobby.ID)
+	guest, err := lobby.JoinPlayer("guest", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	adminLobbyEndpoint(recorder, httptest.NewRequest(http.MethodGet, "/v1/admin/lobby?lobby_id="+lobby.ID, nil))
+	details := &AdminLobbyDetails{}
+	if err := json.NewDecoder(recorder.Body).Decode(details); err != nil {
+		t.Fatal(err)
+	}
+	if details.State != "unstarted" || len(details.Players) != 2 || details.Players[1].Address != "10.0.0.1" {
+		t.Errorf("unexpected lobby details: %+v", details)
+	}
+
+	recorder = httptest.NewRecorder()
+	adminKickEndpoint(recorder, httptest.NewRequest(http.MethodGet, "/v1/admin/lobby/kick?lobby_id="+lobby.ID+"&player_id="+guest.ID, nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("kicking via GET returned %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	adminKickEndpoint(recorder, httptest.NewRequest(http.MethodPost, "/v1/admin/lobby/kick?lobby_id="+lobby.ID+"&player_id="+guest.ID+"&ban=true", nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("kicking returned %d", recorder.Code)
+	}
+	if len(lobby.SnapshotPlayers()) != 1 || !lobby.IsBanned("", "10.0.0.1") {
+		t.Errorf("player hasn't been kicked and banned")
+	}
+
+	recorder = httptest.NewRecorder()
+	adminCloseLobbyEndpoint(recorder, httptest.NewRequest(http.MethodPost, "/v1/admin/lobby/close?lobby_id="+lobby.ID, nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("closing returned %d", recorder.Code)
+	}
+	if _, err := state.GetLobby(lobby.ID); err != state.ErrLobbyNotExistent {
+		t.Errorf("lobby hasn't been removed")
+	}
+}
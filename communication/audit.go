@@ -0,0 +1,182 @@
+package communication
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+const (
+	// auditMemoryLimit is the amount of entries kept in memory if there's no
+	// audit log file.
+	auditMemoryLimit = 1000
+	// defaultAuditQueryLimit and maxAuditQueryLimit limit the amount of
+	// entries returned by the admin API.
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 1000
+)
+
+var (
+	// auditLogPath is the file all moderation actions are appended to. If
+	// it's empty, only the latest actions are kept in memory.
+	auditLogPath  string
+	auditLogFile  *os.File
+	auditEntries  []*game.AuditEntry
+	auditLogMutex = &sync.Mutex{}
+)
+
+// ConfigureAuditLog appends all moderation actions to the given file, which
+// is created if it doesn't exist yet. Existing entries are kept.
+func ConfigureAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+	auditLogPath = path
+	auditLogFile = file
+	return nil
+}
+
+// recordAuditEntry appends the entry to the audit log.
+func recordAuditEntry(entry *game.AuditEntry) {
+	logging.Info("moderation action", "lobby", entry.LobbyID, "action", entry.Action,
+		"actor", entry.ActorName, "target", entry.TargetName, "details", entry.Details)
+
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+
+	if auditLogFile == nil {
+		auditEntries = append(auditEntries, entry)
+		if len(auditEntries) > auditMemoryLimit {
+			auditEntries = auditEntries[len(auditEntries)-auditMemoryLimit:]
+		}
+		return
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logging.Error("error encoding audit entry", "error", err)
+		return
+	}
+	if _, err := auditLogFile.Write(append(line, '\n')); err != nil {
+		logging.Error("error writing audit log", "error", err)
+	}
+}
+
+// auditFilter selects entries of the audit log. Empty fields match every
+// entry.
+type auditFilter struct {
+	lobbyID string
+	action  string
+	// since is a unix timestamp in milliseconds.
+	since int64
+}
+
+func (filter *auditFilter) matches(entry *game.AuditEntry) bool {
+	return (filter.lobbyID == "" || filter.lobbyID == entry.LobbyID) &&
+		(filter.action == "" || filter.action == entry.Action) &&
+		entry.Time >= filter.since
+}
+
+// queryAuditLog returns the latest entries matching the filter, oldest
+// first.
+func queryAuditLog(filter *auditFilter, limit int) ([]*game.AuditEntry, error) {
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+
+	matches := make([]*game.AuditEntry, 0)
+	addMatch := func(entry *game.AuditEntry) {
+		if !filter.matches(entry) {
+			return
+		}
+		matches = append(matches, entry)
+		if len(matches) > limit {
+			matches = matches[1:]
+		}
+	}
+
+	if auditLogFile == nil {
+		for _, entry := range auditEntries {
+			addMatch(entry)
+		}
+		return matches, nil
+	}
+
+	file, err := os.Open(auditLogPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry game.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			//A partially written line, for example due to a crash, shouldn't
+			//make the whole log unreadable.
+			continue
+		}
+		addMatch(&entry)
+	}
+	return matches, scanner.Err()
+}
+
+// adminAuditEndpoint returns the latest moderation actions. They can be
+// filtered via the query parameters 'lobby_id', 'action' and 'since', which
+// is a unix timestamp in milliseconds. 'limit' defaults to 100 entries.
+func adminAuditEndpoint(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &auditFilter{
+		lobbyID: query.Get("lobby_id"),
+		action:  query.Get("action"),
+	}
+	if since := query.Get("since"); since != "" {
+		parsed, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			http.Error(w, "the parameter 'since' must be a unix timestamp in milliseconds", http.StatusBadRequest)
+			return
+		}
+		filter.since = parsed
+	}
+	limit := defaultAuditQueryLimit
+	if limitValue := query.Get("limit"); limitValue != "" {
+		parsed, err := strconv.Atoi(limitValue)
+		if err != nil || parsed < 1 || parsed > maxAuditQueryLimit {
+			http.Error(w, "the parameter 'limit' must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := queryAuditLog(filter, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, entries)
+}
+
+// recordAdminAudit records an action taken via the admin API by the given
+// admin.
+func recordAdminAudit(admin, lobbyID, action, targetID, targetName, details string) {
+	recordAuditEntry(&game.AuditEntry{
+		Time:       time.Now().UnixNano() / int64(time.Millisecond),
+		LobbyID:    lobbyID,
+		Action:     action,
+		ActorType:  "admin",
+		ActorName:  admin,
+		TargetID:   targetID,
+		TargetName: targetName,
+		Details:    details,
+	})
+}
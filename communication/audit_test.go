@@ -0,0 +1,87 @@
+package communication
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+func Test_auditLog(t *testing.T) {
+	directory, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	if err := ConfigureAuditLog(filepath.Join(directory, "audit.jsonl")); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		auditLogFile.Close()
+		auditLogFile = nil
+		auditLogPath = ""
+	}()
+
+	recordAdminAudit("moderator", "lobby-a", "ban", "player-1", "Troll", "")
+	recordAuditEntry(&game.AuditEntry{Time: 1000, LobbyID: "lobby-b", Action: "votekick", ActorType: "vote"})
+	recordAdminAudit("moderator", "lobby-a", "close", "", "", "spam")
+
+	tests := []struct {
+		name    string
+		query   string
+		want    int
+		actions []string
+	}{
+		{"all", "", http.StatusOK, []string{"ban", "votekick", "close"}},
+		{"by lobby", "?lobby_id=lobby-a", http.StatusOK, []string{"ban", "close"}},
+		{"by action", "?action=votekick", http.StatusOK, []string{"votekick"}},
+		{"since", "?since=2000", http.StatusOK, []string{"ban", "close"}},
+		{"limit keeps the latest", "?limit=1", http.StatusOK, []string{"close"}},
+		{"invalid limit", "?limit=0", http.StatusBadRequest, nil},
+		{"invalid since", "?since=yesterday", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			adminAuditEndpoint(recorder, httptest.NewRequest(http.MethodGet, "/v1/admin/audit"+tt.query, nil))
+			if recorder.Code != tt.want {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var entries []*game.AuditEntry
+			if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.actions) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(tt.actions))
+			}
+			for index, entry := range entries {
+				if entry.Action != tt.actions[index] {
+					t.Errorf("entry %d has action %s, want %s", index, entry.Action, tt.actions[index])
+				}
+			}
+		})
+	}
+
+	//Reopening the file must keep the existing entries.
+	auditLogFile.Close()
+	if err := ConfigureAuditLog(auditLogPath); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := queryAuditLog(&auditFilter{lobbyID: "lobby-a"}, maxAuditQueryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ActorName != "moderator" || entries[0].TargetName != "Troll" {
+		t.Errorf("unexpected entries after reopening: %+v", entries)
+	}
+}
@@ -0,0 +1,87 @@
+package communication
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	hCaptchaVerifyURL  = "https://hcaptcha.com/siteverify"
+	reCaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+// CaptchaVerifier validates hCaptcha or reCAPTCHA tokens, which the client
+// obtains by solving the CAPTCHA widget.
+type CaptchaVerifier struct {
+	provider  string
+	siteKey   string
+	secret    string
+	verifyURL string
+	client    *http.Client
+}
+
+// NewCaptchaVerifier creates a verifier for the given provider, which is
+// either "hcaptcha" or "recaptcha".
+func NewCaptchaVerifier(provider, siteKey, secret string) (*CaptchaVerifier, error) {
+	verifier := &CaptchaVerifier{
+		provider: provider,
+		siteKey:  siteKey,
+		secret:   secret,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	switch provider {
+	case "hcaptcha":
+		verifier.verifyURL = hCaptchaVerifyURL
+	case "recaptcha":
+		verifier.verifyURL = reCaptchaVerifyURL
+	default:
+		return nil, fmt.Errorf("unknown CAPTCHA provider '%s', expected hcaptcha or recaptcha", provider)
+	}
+	if siteKey == "" || secret == "" {
+		return nil, fmt.Errorf("%s requires a site key and a secret", provider)
+	}
+
+	return verifier, nil
+}
+
+// NewChallenge tells the client which widget to show.
+func (verifier *CaptchaVerifier) NewChallenge() (*VerificationChallenge, error) {
+	return &VerificationChallenge{Type: verifier.provider, SiteKey: verifier.siteKey}, nil
+}
+
+type captchaResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// Verify asks the provider whether the token is valid.
+func (verifier *CaptchaVerifier) Verify(solution, address string) error {
+	response, requestError := verifier.client.PostForm(verifier.verifyURL, url.Values{
+		"secret":   {verifier.secret},
+		"response": {solution},
+		"remoteip": {address},
+	})
+	if requestError != nil {
+		return requestError
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered with status %d", verifier.provider, response.StatusCode)
+	}
+
+	result := &captchaResponse{}
+	if decodeError := json.NewDecoder(response.Body).Decode(result); decodeError != nil {
+		return decodeError
+	}
+	if !result.Success {
+		return fmt.Errorf("invalid CAPTCHA token: %s", strings.Join(result.ErrorCodes, ", "))
+	}
+
+	return nil
+}
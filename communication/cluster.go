@@ -0,0 +1,205 @@
+package communication
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+const (
+	// directoryRefreshInterval is the interval in which the instance
+	// publishes its lobbies to the directory of the cluster.
+	directoryRefreshInterval = 10 * time.Second
+	// resubscribeDelay is the time to wait before subscribing to the
+	// messages of the cluster again, after the connection has been lost.
+	resubscribeDelay = 5 * time.Second
+)
+
+var (
+	// cluster is shared with other instances. If it's nil, the instance
+	// runs on its own.
+	cluster     state.ClusterBackend
+	instanceID  string
+	instanceURL string
+)
+
+// ConfigureCluster makes the instance part of a cluster. Each lobby is only
+// hosted by the instance that created it. Requests for lobbies of other
+// instances are redirected to the given URLs, which therefore have to reach
+// the respective instance directly. Public lobbies of all instances are
+// shown in the lobby browser, while moderation via the admin API is passed
+// on to the hosting instance.
+func ConfigureCluster(backend state.ClusterBackend, id, url string) {
+	cluster = backend
+	instanceID = id
+	instanceURL = strings.TrimSuffix(url, "/")
+
+	go refreshDirectory()
+	go receiveClusterMessages()
+}
+
+func refreshDirectory() {
+	ticker := time.NewTicker(directoryRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := cluster.UpdateDirectory(localDirectoryEntries(time.Now())); err != nil {
+			logging.Warn("error updating the lobby directory", "error", err)
+		}
+		<-ticker.C
+	}
+}
+
+func localDirectoryEntries(now time.Time) []*state.DirectoryEntry {
+	lobbies := state.GetLobbies()
+	entries := make([]*state.DirectoryEntry, 0, len(lobbies))
+	for _, lobby := range lobbies {
+		entry := &state.DirectoryEntry{
+			LobbyID:     lobby.ID,
+			InstanceID:  instanceID,
+			InstanceURL: instanceURL,
+			Public:      lobby.IsPublic(),
+			UpdatedAt:   now.UnixNano() / int64(time.Millisecond),
+		}
+		if entry.Public {
+			//A LobbyEntry can always be encoded.
+			entry.Listing, _ = json.Marshal(newLobbyEntry(lobby))
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func receiveClusterMessages() {
+	for {
+		err := cluster.Subscribe(handleClusterMessage)
+		logging.Warn("lost connection to the cluster, resubscribing", "error", err)
+		time.Sleep(resubscribeDelay)
+	}
+}
+
+// handleClusterMessage applies messages sent by other instances. Messages
+// concerning lobbies hosted elsewhere are ignored.
+func handleClusterMessage(message *state.ClusterMessage) {
+	if message.Sender == instanceID {
+		return
+	}
+
+	switch message.Type {
+	case "announcement":
+		for _, lobby := range state.GetLobbies() {
+			WritePublicSystemMessage(lobby, message.Text)
+		}
+	case "close-lobby":
+		if lobby, err := state.GetLobby(message.LobbyID); err == nil {
+			lobby.Logger().Info("closing lobby on behalf of another instance", "instance", message.Sender)
+			recordAdminAudit(message.Admin, lobby.ID, "close", "", "", message.Text)
+			game.CloseLobby(lobby, message.Text)
+		}
+	case "kick-player":
+		if lobby, err := state.GetLobby(message.LobbyID); err == nil {
+			lobby.Logger().Info("kicking player on behalf of another instance", "instance", message.Sender, "player", message.PlayerID)
+			playerName := findPlayerName(lobby, message.PlayerID)
+			if lobby.KickPlayer(message.PlayerID, message.Ban, message.Text) {
+				recordAdminAudit(message.Admin, lobby.ID, kickAuditAction(message.Ban), message.PlayerID, playerName, "")
+			}
+		}
+	}
+}
+
+// lookupRemoteLobby returns the directory entry of the lobby given via the
+// 'lobby_id' query parameter, if it's hosted by another instance.
+func lookupRemoteLobby(r *http.Request) *state.DirectoryEntry {
+	lobbyID := r.URL.Query().Get("lobby_id")
+	if cluster == nil || lobbyID == "" {
+		return nil
+	}
+
+	entry, err := cluster.LookupLobby(lobbyID)
+	if err != nil {
+		logging.Warn("error looking up lobby in the directory", "lobby", lobbyID, "error", err)
+		return nil
+	}
+	if entry == nil || entry.InstanceID == instanceID {
+		return nil
+	}
+	return entry
+}
+
+// redirectToLobbyInstance redirects requests for lobbies hosted by other
+// instances of the cluster. False is returned if the lobby isn't hosted by
+// any other instance.
+func redirectToLobbyInstance(w http.ResponseWriter, r *http.Request) bool {
+	entry := lookupRemoteLobby(r)
+	if entry == nil {
+		return false
+	}
+
+	http.Redirect(w, r, entry.InstanceURL+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+	return true
+}
+
+// remoteLobbyEntries returns the public lobbies of all other instances that
+// match the filter.
+func remoteLobbyEntries(tags []string, region string) []*LobbyEntry {
+	if cluster == nil {
+		return nil
+	}
+
+	directory, err := cluster.ListLobbies()
+	if err != nil {
+		logging.Warn("error listing the lobby directory", "error", err)
+		return nil
+	}
+
+	var entries []*LobbyEntry
+	for _, directoryEntry := range directory {
+		if !directoryEntry.Public || directoryEntry.InstanceID == instanceID {
+			continue
+		}
+
+		entry := &LobbyEntry{}
+		if err := json.Unmarshal(directoryEntry.Listing, entry); err != nil {
+			continue
+		}
+		if matchesFilter(entry.Tags, entry.Region, tags, region) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// forwardAdminAction passes the message on to the instance hosting the
+// lobby given via the 'lobby_id' query parameter, if that's another
+// instance. The request is then answered with 202 Accepted.
+func forwardAdminAction(w http.ResponseWriter, r *http.Request, message *state.ClusterMessage) bool {
+	if cluster == nil {
+		return false
+	}
+	if _, err := state.GetLobby(r.URL.Query().Get("lobby_id")); err == nil {
+		return false
+	}
+	entry := lookupRemoteLobby(r)
+	if entry == nil {
+		return false
+	}
+
+	message.Sender = instanceID
+	message.Admin = getAdminName(r)
+	message.LobbyID = entry.LobbyID
+	if err := cluster.Publish(message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return true
+	}
+
+	logging.Info("forwarded admin action", "admin", getAdminName(r), "type", message.Type, "lobby", entry.LobbyID, "instance", entry.InstanceID)
+	w.WriteHeader(http.StatusAccepted)
+	return true
+}
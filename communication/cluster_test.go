@@ -0,0 +1,125 @@
+package communication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// fakeCluster keeps the directory in memory and records published messages.
+type fakeCluster struct {
+	directory map[string]*state.DirectoryEntry
+	published []*state.ClusterMessage
+}
+
+func (cluster *fakeCluster) UpdateDirectory(entries []*state.DirectoryEntry) error {
+	for _, entry := range entries {
+		cluster.directory[entry.LobbyID] = entry
+	}
+	return nil
+}
+
+func (cluster *fakeCluster) LookupLobby(lobbyID string) (*state.DirectoryEntry, error) {
+	return cluster.directory[lobbyID], nil
+}
+
+func (cluster *fakeCluster) ListLobbies() ([]*state.DirectoryEntry, error) {
+	var entries []*state.DirectoryEntry
+	for _, entry := range cluster.directory {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (cluster *fakeCluster) Publish(message *state.ClusterMessage) error {
+	cluster.published = append(cluster.published, message)
+	return nil
+}
+
+func (cluster *fakeCluster) Subscribe(handler func(*state.ClusterMessage)) error {
+	select {}
+}
+
+func useFakeCluster() (*fakeCluster, func()) {
+	fake := &fakeCluster{directory: make(map[string]*state.DirectoryEntry)}
+	cluster, instanceID, instanceURL = fake, "local", "https://local.example.com"
+	return fake, func() {
+		cluster, instanceID, instanceURL = nil, "", ""
+	}
+}
+
+func Test_clusterRouting(t *testing.T) {
+	fake, reset := useFakeCluster()
+	defer reset()
+
+	listing, _ := json.Marshal(&LobbyEntry{ID: "remote", Tags: []string{"beginners"}})
+	fake.directory["remote"] = &state.DirectoryEntry{
+		LobbyID:     "remote",
+		InstanceID:  "other",
+		InstanceURL: "https://other.example.com",
+		Public:      true,
+		Listing:     listing,
+	}
+
+	recorder := httptest.NewRecorder()
+	enterLobby(recorder, httptest.NewRequest(http.MethodPost, "/v1/lobby/player?lobby_id=remote", nil))
+	if recorder.Code != http.StatusTemporaryRedirect ||
+		recorder.Header().Get("Location") != "https://other.example.com/v1/lobby/player?lobby_id=remote" {
+		t.Errorf("expected redirect to the hosting instance, got %d %s", recorder.Code, recorder.Header().Get("Location"))
+	}
+
+	recorder = httptest.NewRecorder()
+	enterLobby(recorder, httptest.NewRequest(http.MethodPost, "/v1/lobby/player?lobby_id=unknown", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected unknown lobby not to be found, got %d", recorder.Code)
+	}
+
+	if entries := remoteLobbyEntries([]string{"beginners"}, ""); len(entries) != 1 || entries[0].ID != "remote" {
+		t.Errorf("expected remote lobby to be listed, got %v", entries)
+	}
+	if entries := remoteLobbyEntries([]string{"experts"}, ""); len(entries) != 0 {
+		t.Errorf("expected filter to apply to remote lobbies, got %v", entries)
+	}
+
+	recorder = httptest.NewRecorder()
+	adminKickEndpoint(recorder, httptest.NewRequest(http.MethodPost, "/v1/admin/lobby/kick?lobby_id=remote&player_id=player&ban=true", nil))
+	if recorder.Code != http.StatusAccepted {
+		t.Errorf("expected kick to be forwarded, got %d", recorder.Code)
+	}
+	if len(fake.published) != 1 || fake.published[0].Type != "kick-player" ||
+		fake.published[0].LobbyID != "remote" || !fake.published[0].Ban || fake.published[0].Sender != "local" {
+		t.Errorf("unexpected messages %+v", fake.published)
+	}
+}
+
+func Test_localDirectoryEntries(t *testing.T) {
+	_, reset := useFakeCluster()
+	defer reset()
+
+	_, lobby, err := gameServer.CreateLobby("owner", "english", true, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.AddLobby(lobby)
+	defer state.RemoveLobby(lobby.ID)
+
+	for _, entry := range localDirectoryEntries(time.Now()) {
+		if entry.LobbyID != lobby.ID {
+			continue
+		}
+
+		listing := &LobbyEntry{}
+		if err := json.Unmarshal(entry.Listing, listing); err != nil || listing.ID != lobby.ID {
+			t.Errorf("expected public lobby to have a listing, got %s", entry.Listing)
+		}
+		if entry.InstanceURL != "https://local.example.com" || entry.InstanceID != "local" {
+			t.Errorf("expected entry to point to this instance, got %+v", entry)
+		}
+		return
+	}
+	t.Errorf("lobby is missing in the directory")
+}
@@ -0,0 +1,98 @@
+package communication
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// corsOrigins are the origins, consisting of scheme, host and port,
+	// that may access the API from other sites. If this is empty, no CORS
+	// headers are sent and websockets can be opened from any origin.
+	corsOrigins map[string]bool
+	// corsAllowAll allows every origin, but without credentials, meaning
+	// that the session cookie isn't sent by browsers.
+	corsAllowAll bool
+)
+
+// ConfigureCORS sets the origins that may access the API and open
+// websockets from other sites. The origins are passed as a comma separated
+// list, such as "https://example.com,https://www.example.com". "*" allows
+// any origin, but doesn't allow sending cookies.
+func ConfigureCORS(origins string) error {
+	allowed := make(map[string]bool)
+	allowAll := false
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			allowAll = true
+			continue
+		}
+
+		parsed, parseError := url.Parse(origin)
+		if parseError != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") ||
+			parsed.Host == "" || (parsed.Path != "" && parsed.Path != "/") {
+			return fmt.Errorf("invalid CORS origin '%s', expected scheme and host, such as https://example.com", origin)
+		}
+		allowed[strings.ToLower(parsed.Scheme+"://"+parsed.Host)] = true
+	}
+
+	corsOrigins = allowed
+	corsAllowAll = allowAll
+	return nil
+}
+
+func corsEnabled() bool {
+	return corsAllowAll || len(corsOrigins) > 0
+}
+
+// isAllowedOrigin decides whether a websocket may be opened. Requests
+// without an origin don't come from browsers, so they are always allowed.
+func isAllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !corsEnabled() || origin == "" || corsAllowAll {
+		return true
+	}
+
+	parsed, parseError := url.Parse(origin)
+	if parseError != nil {
+		return false
+	}
+	//Our own frontend is always allowed.
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	return corsOrigins[strings.ToLower(origin)]
+}
+
+// withCORS adds the CORS headers for allowed origins and answers preflight
+// requests.
+func withCORS(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && corsEnabled() {
+			w.Header().Add("Vary", "Origin")
+			if corsOrigins[strings.ToLower(origin)] {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else if corsAllowAll {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+
+		handler(w, r)
+	}
+}
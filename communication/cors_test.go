@@ -0,0 +1,98 @@
+package communication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_withCORS(t *testing.T) {
+	defer ConfigureCORS("")
+	if err := ConfigureCORS("https://embed.example.com, *"); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := withCORS(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		name            string
+		method          string
+		origin          string
+		wantStatus      int
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{"listed origin", http.MethodGet, "https://embed.example.com", http.StatusTeapot, "https://embed.example.com", "true"},
+		{"any origin", http.MethodGet, "https://other.example.com", http.StatusTeapot, "*", ""},
+		{"no origin", http.MethodGet, "", http.StatusTeapot, "", ""},
+		{"preflight", http.MethodOptions, "https://embed.example.com", http.StatusNoContent, "https://embed.example.com", "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/v1/lobby", nil)
+			if tt.origin != "" {
+				request.Header.Set("Origin", tt.origin)
+			}
+			if tt.method == http.MethodOptions {
+				request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			recorder := httptest.NewRecorder()
+			handler(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("allowed origin = %s, want %s", got, tt.wantOrigin)
+			}
+			if got := recorder.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("allowed credentials = %s, want %s", got, tt.wantCredentials)
+			}
+		})
+	}
+}
+
+func Test_isAllowedOrigin(t *testing.T) {
+	defer ConfigureCORS("")
+
+	tests := []struct {
+		origins string
+		origin  string
+		want    bool
+	}{
+		{"", "https://evil.example.com", true},
+		{"https://embed.example.com", "https://embed.example.com", true},
+		{"https://embed.example.com", "https://EMBED.example.com", true},
+		{"https://embed.example.com", "https://scribble.example.com", true},
+		{"https://embed.example.com", "https://evil.example.com", false},
+		{"https://embed.example.com", "", true},
+		{"*", "https://evil.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origins+" "+tt.origin, func(t *testing.T) {
+			if err := ConfigureCORS(tt.origins); err != nil {
+				t.Fatal(err)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "http://scribble.example.com/v1/ws", nil)
+			if tt.origin != "" {
+				request.Header.Set("Origin", tt.origin)
+			}
+			if got := isAllowedOrigin(request); got != tt.want {
+				t.Errorf("isAllowedOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ConfigureCORS_invalid(t *testing.T) {
+	defer ConfigureCORS("")
+
+	for _, origin := range []string{"example.com", "ftp://example.com", "https://example.com/path"} {
+		if err := ConfigureCORS(origin); err == nil {
+			t.Errorf("invalid origin %s has been accepted", origin)
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/scribble-rs/scribble.rs/game"
 	"github.com/scribble-rs/scribble.rs/state"
+	"github.com/scribble-rs/scribble.rs/storage"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
@@ -18,7 +20,13 @@ import (
 // homePage servers the default page for scribble.rs, which is the page to
 // create a new lobby.
 func homePage(w http.ResponseWriter, r *http.Request) {
-	err := lobbyCreatePage.ExecuteTemplate(w, "lobby_create.html", createDefaultLobbyCreatePageData())
+	pageData := createDefaultLobbyCreatePageData()
+	pageData.Verification = initialChallenge()
+	pageData.Account = getAccount(r)
+	pageData.LoginProviders = loginProviders()
+	pageData.Presets = getPresets(pageData.Account)
+	applyPreset(pageData, r)
+	err := lobbyCreatePage.ExecuteTemplate(w, "lobby_create.html", pageData)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -26,8 +34,8 @@ func homePage(w http.ResponseWriter, r *http.Request) {
 
 func createDefaultLobbyCreatePageData() *CreatePageData {
 	return &CreatePageData{
-		SettingBounds:          game.LobbySettingBounds,
-		Languages:              game.SupportedLanguages,
+		SettingBounds:          game.GetSettingBounds(),
+		Languages:              game.GetSupportedLanguages(),
 		Public:                 "false",
 		DrawingTime:            "120",
 		Rounds:                 "4",
@@ -35,8 +43,12 @@ func createDefaultLobbyCreatePageData() *CreatePageData {
 		CustomWordsChance:      "50",
 		ClientsPerIPLimit:      "1",
 		EnableVotekick:         "true",
+		StartDelay:             "0",
+		Tags:                   "",
+		Region:                 "",
 		Language:               "english",
 		CurrentlyActiveLobbies: state.GetActiveLobbyCount(),
+		TwitchEnabled:          twitchEnabled,
 	}
 }
 
@@ -53,8 +65,24 @@ type CreatePageData struct {
 	CustomWordsChance      string
 	ClientsPerIPLimit      string
 	EnableVotekick         string
+	StartDelay             string
+	Tags                   string
+	Region                 string
 	Language               string
 	CurrentlyActiveLobbies int
+	// TwitchChannel is only offered if TwitchEnabled, see ConfigureTwitch.
+	TwitchChannel string
+	TwitchEnabled bool
+	// Verification has to be solved before the lobby can be created, see
+	// ConfigureVerification.
+	Verification *VerificationChallenge
+	// Account is the account the player has logged in with, if any.
+	Account *state.Account
+	// LoginProviders are the providers players can log in with. It's
+	// empty if accounts are disabled.
+	LoginProviders []string
+	// Presets are the saved lobby settings of the players account.
+	Presets []*storage.Preset
 }
 
 // ssrCreateLobby allows creating a lobby, optionally returning errors that
@@ -73,13 +101,17 @@ func ssrCreateLobby(w http.ResponseWriter, r *http.Request) {
 	customWords, customWordsInvalid := parseCustomWords(r.Form.Get("custom_words"))
 	customWordChance, customWordChanceInvalid := parseCustomWordsChance(r.Form.Get("custom_words_chance"))
 	clientsPerIPLimit, clientsPerIPLimitInvalid := parseClientsPerIPLimit(r.Form.Get("clients_per_ip_limit"))
+	startDelay, startDelayInvalid := parseStartDelay(r.Form.Get("start_delay"))
+	tags, tagsInvalid := parseTags(r.Form.Get("tags"))
+	region, regionInvalid := parseRegion(r.Form.Get("region"))
+	twitchChannel, twitchChannelInvalid := parseTwitchChannel(r.Form.Get("twitch_channel"))
 	enableVotekick := r.Form.Get("enable_votekick") == "true"
 	publicLobby := r.Form.Get("public") == "true"
 
 	//Prevent resetting the form, since that would be annoying as hell.
 	pageData := CreatePageData{
-		SettingBounds:          game.LobbySettingBounds,
-		Languages:              game.SupportedLanguages,
+		SettingBounds:          game.GetSettingBounds(),
+		Languages:              game.GetSupportedLanguages(),
 		Public:                 r.Form.Get("public"),
 		DrawingTime:            r.Form.Get("drawing_time"),
 		Rounds:                 r.Form.Get("rounds"),
@@ -88,8 +120,15 @@ func ssrCreateLobby(w http.ResponseWriter, r *http.Request) {
 		CustomWordsChance:      r.Form.Get("custom_words_chance"),
 		ClientsPerIPLimit:      r.Form.Get("clients_per_ip_limit"),
 		EnableVotekick:         r.Form.Get("enable_votekick"),
+		StartDelay:             r.Form.Get("start_delay"),
+		Tags:                   r.Form.Get("tags"),
+		Region:                 r.Form.Get("region"),
 		Language:               r.Form.Get("language"),
 		CurrentlyActiveLobbies: state.GetActiveLobbyCount(),
+		TwitchChannel:          r.Form.Get("twitch_channel"),
+		TwitchEnabled:          twitchEnabled,
+		Account:                getAccount(r),
+		LoginProviders:         loginProviders(),
 	}
 
 	if languageInvalid != nil {
@@ -113,6 +152,18 @@ func ssrCreateLobby(w http.ResponseWriter, r *http.Request) {
 	if clientsPerIPLimitInvalid != nil {
 		pageData.Errors = append(pageData.Errors, clientsPerIPLimitInvalid.Error())
 	}
+	if startDelayInvalid != nil {
+		pageData.Errors = append(pageData.Errors, startDelayInvalid.Error())
+	}
+	if tagsInvalid != nil {
+		pageData.Errors = append(pageData.Errors, tagsInvalid.Error())
+	}
+	if regionInvalid != nil {
+		pageData.Errors = append(pageData.Errors, regionInvalid.Error())
+	}
+	if twitchChannelInvalid != nil {
+		pageData.Errors = append(pageData.Errors, twitchChannelInvalid.Error())
+	}
 
 	if len(pageData.Errors) != 0 {
 		err := lobbyCreatePage.ExecuteTemplate(w, "lobby_create.html", pageData)
@@ -122,9 +173,46 @@ func ssrCreateLobby(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	requestAddress, addressError := getIPAddressFromRequest(r)
+	if addressError != nil {
+		userFacingError(w, addressError.Error())
+		return
+	}
+	if findInstanceBan(r, requestAddress) != nil {
+		userFacingError(w, instanceBanMessage)
+		return
+	}
+
+	challenge, verificationError := requireVerification(r, requestAddress)
+	if verificationError != nil {
+		if challenge == nil {
+			userFacingError(w, verificationError.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusForbidden)
+		pageData.Verification = challenge
+		if verificationError != errVerificationRequired {
+			pageData.Errors = append(pageData.Errors, "The verification failed, please try again.")
+		}
+		if templateError := lobbyCreatePage.ExecuteTemplate(w, "lobby_create.html", pageData); templateError != nil {
+			userFacingError(w, templateError.Error())
+		}
+		return
+	}
+
+	if allowed, retryAfter := lobbyCreationLimit.reserve(requestAddress, time.Now()); !allowed {
+		w.WriteHeader(http.StatusTooManyRequests)
+		pageData.Errors = append(pageData.Errors, lobbyCreationLimitError(retryAfter))
+		if templateError := lobbyCreatePage.ExecuteTemplate(w, "lobby_create.html", pageData); templateError != nil {
+			userFacingError(w, templateError.Error())
+		}
+		return
+	}
+
 	var playerName = getPlayername(r)
 
-	player, lobby, createError := game.CreateLobby(playerName, language, publicLobby, drawingTime, rounds, maxPlayers, customWordChance, clientsPerIPLimit, customWords, enableVotekick)
+	player, lobby, createError := gameServer.CreateLobby(playerName, language, publicLobby, drawingTime, rounds, maxPlayers, customWordChance, clientsPerIPLimit, customWords, enableVotekick)
 	if createError != nil {
 		pageData.Errors = append(pageData.Errors, createError.Error())
 		templateError := lobbyCreatePage.ExecuteTemplate(w, "lobby_create.html", pageData)
@@ -135,16 +223,26 @@ func ssrCreateLobby(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	player.SetLastKnownAddress(getIPAddressFromRequest(r))
+	player.SetLastKnownAddress(requestAddress)
+	lobby.Tags = tags
+	lobby.Region = region
+
+	linkAccount(lobby, player, r)
+	setSessionCookie(w, lobby, player, false)
+
+	if startDelay > 0 {
+		lobby.ScheduleStart(time.Now().Add(time.Duration(startDelay) * time.Minute))
+	}
 
-	// Use the players generated usersession and pass it as a cookie.
-	http.SetCookie(w, &http.Cookie{
-		Name:     "usersession",
-		Value:    player.GetUserSession(),
-		Path:     "/",
-		SameSite: http.SameSiteStrictMode,
-	})
+	if twitchChannel != "" {
+		if err := linkTwitchChannel(lobby, twitchChannel); err != nil {
+			lobby.Logger().Error("error linking twitch channel", "channel", twitchChannel, "error", err)
+		}
+	}
 
+	//Nobody can join before the lobby has been added, so the players can
+	//safely be read for the notification.
+	notifyWebhooks("lobby-created", lobby)
 	//We only add the lobby if we could do all neccessary pre-steps successfully.
 	state.AddLobby(lobby)
 
@@ -166,7 +264,7 @@ func parsePassword(value string) (string, error) {
 
 func parseLanguage(value string) (string, error) {
 	toLower := strings.ToLower(strings.TrimSpace(value))
-	for languageKey := range game.SupportedLanguages {
+	for languageKey := range game.GetSupportedLanguages() {
 		if toLower == languageKey {
 			return languageKey, nil
 		}
@@ -181,12 +279,12 @@ func parseDrawingTime(value string) (int, error) {
 		return 0, errors.New("the drawing time must be numeric")
 	}
 
-	if result < game.LobbySettingBounds.MinDrawingTime {
-		return 0, fmt.Errorf("drawing time must not be smaller than %d", game.LobbySettingBounds.MinDrawingTime)
+	if result < game.GetSettingBounds().MinDrawingTime {
+		return 0, fmt.Errorf("drawing time must not be smaller than %d", game.GetSettingBounds().MinDrawingTime)
 	}
 
-	if result > game.LobbySettingBounds.MaxDrawingTime {
-		return 0, fmt.Errorf("drawing time must not be greater than %d", game.LobbySettingBounds.MaxDrawingTime)
+	if result > game.GetSettingBounds().MaxDrawingTime {
+		return 0, fmt.Errorf("drawing time must not be greater than %d", game.GetSettingBounds().MaxDrawingTime)
 	}
 
 	return int(result), nil
@@ -198,12 +296,12 @@ func parseRounds(value string) (int, error) {
 		return 0, errors.New("the rounds amount must be numeric")
 	}
 
-	if result < game.LobbySettingBounds.MinRounds {
-		return 0, fmt.Errorf("rounds must not be smaller than %d", game.LobbySettingBounds.MinRounds)
+	if result < game.GetSettingBounds().MinRounds {
+		return 0, fmt.Errorf("rounds must not be smaller than %d", game.GetSettingBounds().MinRounds)
 	}
 
-	if result > game.LobbySettingBounds.MaxRounds {
-		return 0, fmt.Errorf("rounds must not be greater than %d", game.LobbySettingBounds.MaxRounds)
+	if result > game.GetSettingBounds().MaxRounds {
+		return 0, fmt.Errorf("rounds must not be greater than %d", game.GetSettingBounds().MaxRounds)
 	}
 
 	return int(result), nil
@@ -215,12 +313,12 @@ func parseMaxPlayers(value string) (int, error) {
 		return 0, errors.New("the max players amount must be numeric")
 	}
 
-	if result < game.LobbySettingBounds.MinMaxPlayers {
-		return 0, fmt.Errorf("maximum players must not be smaller than %d", game.LobbySettingBounds.MinMaxPlayers)
+	if result < game.GetSettingBounds().MinMaxPlayers {
+		return 0, fmt.Errorf("maximum players must not be smaller than %d", game.GetSettingBounds().MinMaxPlayers)
 	}
 
-	if result > game.LobbySettingBounds.MaxMaxPlayers {
-		return 0, fmt.Errorf("maximum players must not be greater than %d", game.LobbySettingBounds.MaxMaxPlayers)
+	if result > game.GetSettingBounds().MaxMaxPlayers {
+		return 0, fmt.Errorf("maximum players must not be greater than %d", game.GetSettingBounds().MaxMaxPlayers)
 	}
 
 	return int(result), nil
@@ -251,12 +349,12 @@ func parseClientsPerIPLimit(value string) (int, error) {
 		return 0, errors.New("the clients per IP limit must be numeric")
 	}
 
-	if result < game.LobbySettingBounds.MinClientsPerIPLimit {
-		return 0, fmt.Errorf("the clients per IP limit must not be lower than %d", game.LobbySettingBounds.MinClientsPerIPLimit)
+	if result < game.GetSettingBounds().MinClientsPerIPLimit {
+		return 0, fmt.Errorf("the clients per IP limit must not be lower than %d", game.GetSettingBounds().MinClientsPerIPLimit)
 	}
 
-	if result > game.LobbySettingBounds.MaxClientsPerIPLimit {
-		return 0, fmt.Errorf("the clients per IP limit must not be higher than %d", game.LobbySettingBounds.MaxClientsPerIPLimit)
+	if result > game.GetSettingBounds().MaxClientsPerIPLimit {
+		return 0, fmt.Errorf("the clients per IP limit must not be higher than %d", game.GetSettingBounds().MaxClientsPerIPLimit)
 	}
 
 	return int(result), nil
@@ -278,3 +376,75 @@ func parseCustomWordsChance(value string) (int, error) {
 
 	return int(result), nil
 }
+
+// parseStartDelay parses the amount of minutes until a scheduled lobby
+// starts. As scheduling is optional, an empty value equals no delay.
+func parseStartDelay(value string) (int, error) {
+	trimmedValue := strings.TrimSpace(value)
+	if trimmedValue == "" {
+		return 0, nil
+	}
+
+	result, parseErr := strconv.ParseInt(trimmedValue, 10, 64)
+	if parseErr != nil {
+		return 0, errors.New("the start delay must be numeric")
+	}
+
+	if result < 0 {
+		return 0, errors.New("the start delay must not be lower than 0")
+	}
+
+	if result > game.GetSettingBounds().MaxStartDelayMinutes {
+		return 0, fmt.Errorf("the start delay must not be higher than %d", game.GetSettingBounds().MaxStartDelayMinutes)
+	}
+
+	return int(result), nil
+}
+
+// parseTags parses a comma separated list of lobby tags. Tags are optional,
+// lowercased and duplicates are dropped.
+func parseTags(value string) ([]string, error) {
+	trimmedValue := strings.TrimSpace(value)
+	if trimmedValue == "" {
+		return nil, nil
+	}
+
+	var result []string
+	for _, item := range strings.Split(trimmedValue, ",") {
+		tag := strings.ToLower(strings.TrimSpace(item))
+		if tag == "" {
+			continue
+		}
+
+		if int64(len(tag)) > game.GetSettingBounds().MaxTagLength {
+			return nil, fmt.Errorf("tags must not be longer than %d characters", game.GetSettingBounds().MaxTagLength)
+		}
+
+		duplicate := false
+		for _, existingTag := range result {
+			if existingTag == tag {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			result = append(result, tag)
+		}
+	}
+
+	if int64(len(result)) > game.GetSettingBounds().MaxTags {
+		return nil, fmt.Errorf("there must not be more than %d tags", game.GetSettingBounds().MaxTags)
+	}
+
+	return result, nil
+}
+
+// parseRegion parses the optional region label of a lobby.
+func parseRegion(value string) (string, error) {
+	trimmedValue := strings.TrimSpace(value)
+	if int64(len(trimmedValue)) > game.GetSettingBounds().MaxRegionLength {
+		return "", fmt.Errorf("the region must not be longer than %d characters", game.GetSettingBounds().MaxRegionLength)
+	}
+
+	return trimmedValue, nil
+}
@@ -0,0 +1,93 @@
+package communication
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// addressLimiter limits how often a single address may do something, such
+// as creating a lobby, within a sliding window. It's safe for concurrent use.
+type addressLimiter struct {
+	mutex *sync.Mutex
+	// limit is the amount of actions allowed per window. 0 disables the
+	// limit.
+	limit  int
+	window time.Duration
+	// actions contains the times of all actions within the window,
+	// oldest first, for each address.
+	actions     map[string][]time.Time
+	lastCleanup time.Time
+}
+
+func newAddressLimiter() *addressLimiter {
+	return &addressLimiter{
+		mutex:   &sync.Mutex{},
+		actions: make(map[string][]time.Time),
+	}
+}
+
+var lobbyCreationLimit = newAddressLimiter()
+
+// ConfigureLobbyCreationLimit allows each address to create at most limit
+// lobbies within the given window. A limit of 0 disables the check. Since
+// public instances can be flooded with lobbies otherwise, this can be
+// changed by reloading the configuration.
+func ConfigureLobbyCreationLimit(limit int, window time.Duration) {
+	lobbyCreationLimit.mutex.Lock()
+	defer lobbyCreationLimit.mutex.Unlock()
+
+	lobbyCreationLimit.limit = limit
+	lobbyCreationLimit.window = window
+}
+
+// reserve counts an action for the address, unless the address has already
+// reached the limit. In that case, the time until the next action is allowed
+// is returned.
+func (limiter *addressLimiter) reserve(address string, now time.Time) (bool, time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if limiter.limit <= 0 {
+		return true, 0
+	}
+
+	//Addresses that haven't done anything for a while would otherwise
+	//be kept forever.
+	if now.Sub(limiter.lastCleanup) >= limiter.window {
+		for otherAddress, actions := range limiter.actions {
+			if remaining := limiter.expire(actions, now); len(remaining) == 0 {
+				delete(limiter.actions, otherAddress)
+			} else {
+				limiter.actions[otherAddress] = remaining
+			}
+		}
+		limiter.lastCleanup = now
+	}
+
+	actions := limiter.expire(limiter.actions[address], now)
+	if len(actions) >= limiter.limit {
+		limiter.actions[address] = actions
+		return false, actions[len(actions)-limiter.limit].Add(limiter.window).Sub(now)
+	}
+
+	limiter.actions[address] = append(actions, now)
+	return true, 0
+}
+
+// expire drops all actions that are outside of the window.
+func (limiter *addressLimiter) expire(actions []time.Time, now time.Time) []time.Time {
+	for len(actions) > 0 && now.Sub(actions[0]) >= limiter.window {
+		actions = actions[1:]
+	}
+	return actions
+}
+
+// lobbyCreationLimitError tells the user how long to wait.
+func lobbyCreationLimitError(retryAfter time.Duration) string {
+	minutes := int((retryAfter + time.Minute - 1) / time.Minute)
+	if minutes <= 1 {
+		return "You have created too many lobbies, please try again in a minute."
+	}
+	return fmt.Sprintf("You have created too many lobbies, please try again in %d minutes.", minutes)
+}
@@ -0,0 +1,45 @@
+package communication
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_addressLimiter(t *testing.T) {
+	limiter := newAddressLimiter()
+	limiter.limit = 2
+	limiter.window = time.Hour
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if allowed, _ := limiter.reserve("1.2.3.4", now.Add(time.Duration(i)*time.Minute)); !allowed {
+			t.Fatalf("creation %d within limit wasn't allowed", i)
+		}
+	}
+
+	allowed, retryAfter := limiter.reserve("1.2.3.4", now.Add(30*time.Minute))
+	if allowed || retryAfter != 30*time.Minute {
+		t.Errorf("expected creation to be refused for 30 minutes, got %v, %v", allowed, retryAfter)
+	}
+	if allowed, _ := limiter.reserve("5.6.7.8", now.Add(30*time.Minute)); !allowed {
+		t.Errorf("other addresses mustn't be limited")
+	}
+
+	//Once the first creation has left the window, another lobby may be created.
+	if allowed, _ := limiter.reserve("1.2.3.4", now.Add(time.Hour)); !allowed {
+		t.Errorf("creation after the window wasn't allowed")
+	}
+
+	//Addresses without actions in the window are forgotten.
+	limiter.reserve("1.2.3.4", now.Add(3*time.Hour))
+	if _, available := limiter.actions["5.6.7.8"]; available {
+		t.Errorf("expired address hasn't been cleaned up")
+	}
+
+	limiter.limit = 0
+	for i := 0; i < 5; i++ {
+		if allowed, _ := limiter.reserve("1.2.3.4", now.Add(3*time.Hour)); !allowed {
+			t.Errorf("creation was refused despite the limit being disabled")
+		}
+	}
+}
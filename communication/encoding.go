@@ -0,0 +1,258 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+const (
+	// encodingJSON is the default wire format, sent as text messages.
+	encodingJSON = "json"
+	// encodingMessagePack is an optional, more compact wire format, sent as
+	// binary messages. It uses the same structure as the JSON format.
+	encodingMessagePack = "msgpack"
+)
+
+var errUnsupportedEncoding = errors.New("the encoding must be either 'json' or 'msgpack'")
+
+// negotiateEncoding determines the wire format to use for a connection,
+// given the format requested by the client. Clients that don't request a
+// format use JSON.
+func negotiateEncoding(requestedEncoding string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(requestedEncoding)) {
+	case "", encodingJSON:
+		return encodingJSON, nil
+	case encodingMessagePack:
+		return encodingMessagePack, nil
+	}
+
+	return "", errUnsupportedEncoding
+}
+
+// encodeMessage marshals the given object using the given encoding and
+// returns the websocket message type to send it as.
+func encodeMessage(encoding string, object interface{}) (int, []byte, error) {
+	if encoding == encodingMessagePack {
+		data, err := marshalMessagePack(object)
+		return websocket.BinaryMessage, data, err
+	}
+
+	data, err := json.Marshal(object)
+	return websocket.TextMessage, data, err
+}
+
+// preparedMessage is a message that's sent to multiple players. It's only
+// encoded once per wire format, no matter how many players receive it.
+type preparedMessage struct {
+	object    interface{}
+	eventType string
+	// encoded contains the message in each wire format it has been encoded
+	// in so far, indexed by encodingIndex. An array is used instead of a
+	// map, as there are only two formats and this is created for every
+	// broadcast.
+	encoded   [2]outgoingMessage
+	available [2]bool
+	// mutex is required, since messages are kept for resending them after
+	// a reconnect, which can happen concurrently for multiple players.
+	mutex sync.Mutex
+}
+
+func newPreparedMessage(object interface{}) *preparedMessage {
+	return &preparedMessage{
+		object:    object,
+		eventType: eventTypeOf(object),
+	}
+}
+
+func encodingIndex(encoding string) int {
+	if encoding == encodingMessagePack {
+		return 1
+	}
+	return 0
+}
+
+// encode returns the message encoded in the given wire format, encoding
+// it only if that hasn't happened before.
+func (message *preparedMessage) encode(encoding string) (outgoingMessage, error) {
+	message.mutex.Lock()
+	defer message.mutex.Unlock()
+
+	index := encodingIndex(encoding)
+	if message.available[index] {
+		return message.encoded[index], nil
+	}
+
+	messageType, data, err := encodeMessage(encoding, message.object)
+	if err != nil {
+		return outgoingMessage{}, err
+	}
+
+	message.encoded[index] = outgoingMessage{messageType: messageType, data: data}
+	message.available[index] = true
+	return message.encoded[index], nil
+}
+
+// maxPooledBufferSize is the capacity up to which buffers are returned to
+// sequencedBufferPool. Bigger buffers, for example the ones used for
+// sending the full drawing, are left to the garbage collector, so that the
+// pool doesn't keep lots of memory alive.
+const maxPooledBufferSize = 16 * 1024
+
+// sequencedBuffer holds the data of an event that has been sent to a single
+// player, see withSequence.
+type sequencedBuffer struct {
+	data []byte
+}
+
+// sequencedBufferPool provides the buffers for withSequence. A buffer is
+// used for every event sent to every player, but only until the event has
+// been written to the connection, so reusing them avoids most of the
+// allocations of a broadcast.
+var sequencedBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &sequencedBuffer{}
+	},
+}
+
+func releaseSequencedBuffer(buffer *sequencedBuffer) {
+	if cap(buffer.data) <= maxPooledBufferSize {
+		buffer.data = buffer.data[:0]
+		sequencedBufferPool.Put(buffer)
+	}
+}
+
+// withSequence adds the sequence number to an encoded event, as the field
+// "seq". Since the sequence number differs for each player, it's added to
+// the already encoded event, instead of encoding the event for every player.
+// If the message isn't an encoded object, it's returned unchanged. The
+// result uses a pooled buffer, which has to be released once the message
+// has been sent, see sendQueue.recycle.
+func withSequence(encoding string, encoded outgoingMessage, sequence uint64) outgoingMessage {
+	data := encoded.data
+	if len(data) < 2 {
+		return encoded
+	}
+
+	if encoding == encodingMessagePack {
+		//Only fixmaps are supported, which is enough for events.
+		if data[0]&0xf0 != 0x80 || data[0] == 0x8f {
+			return encoded
+		}
+	} else if data[0] != '{' {
+		return encoded
+	}
+
+	buffer := sequencedBufferPool.Get().(*sequencedBuffer)
+	if encoding == encodingMessagePack {
+		//Only fixmaps are supported, which is enough for events.
+		result := bytes.NewBuffer(buffer.data[:0])
+		result.Grow(len(data) + 16)
+		result.WriteByte(data[0] + 1)
+		encodeMessagePackString(result, "seq")
+		encodeMessagePackUint(result, sequence)
+		result.Write(data[1:])
+		buffer.data = result.Bytes()
+	} else {
+		result := buffer.data[:0]
+		result = append(result, `{"seq":`...)
+		result = strconv.AppendUint(result, sequence, 10)
+		if data[1] != '}' {
+			result = append(result, ',')
+		}
+		buffer.data = append(result, data[1:]...)
+	}
+
+	return outgoingMessage{messageType: encoded.messageType, data: buffer.data, buffer: buffer}
+}
+
+// eventTypeOf returns the type of the given event or an empty string if the
+// object isn't an event.
+func eventTypeOf(object interface{}) string {
+	switch event := object.(type) {
+	case *game.GameEvent:
+		return event.Type
+	case game.GameEvent:
+		return event.Type
+	}
+	return ""
+}
+
+// decodeEvent parses an incoming message using the given encoding. The data
+// of the event is decoded by the game package, once the type is known.
+// MessagePack data is decoded straight into the payload, without being
+// converted to JSON first.
+func decodeEvent(encoding string, messageType int, data []byte) (*game.InboundEvent, error) {
+	if encoding == encodingMessagePack {
+		if messageType != websocket.BinaryMessage {
+			return nil, errors.New("expected binary message for msgpack encoding")
+		}
+		return decodeMessagePackEvent(data)
+	}
+
+	if messageType != websocket.TextMessage {
+		return nil, errors.New("expected text message for json encoding")
+	}
+
+	received := &game.InboundEvent{}
+	if err := json.Unmarshal(data, received); err != nil {
+		return nil, err
+	}
+
+	return received, nil
+}
+
+// decodeMessagePackEvent reads the type of the event and keeps its data in
+// its encoded form, until the game package decodes it into the payload.
+func decodeMessagePackEvent(data []byte) (*game.InboundEvent, error) {
+	decoder := &messagePackDecoder{data: data}
+	length, err := decoder.readContainerLength(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var eventType string
+	var eventData []byte
+	for i := 0; i < length; i++ {
+		var key string
+		if err := decoder.decodeInto(reflect.ValueOf(&key).Elem()); err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "type":
+			if err := decoder.decodeInto(reflect.ValueOf(&eventType).Elem()); err != nil {
+				return nil, err
+			}
+		case "data":
+			start := decoder.offset
+			if _, err := decoder.decode(); err != nil {
+				return nil, err
+			}
+			eventData = data[start:decoder.offset]
+		default:
+			if _, err := decoder.decode(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if decoder.offset != len(data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	//Missing data is treated like nil, the same way it's done for JSON.
+	if eventData == nil {
+		eventData = []byte{0xc0}
+	}
+
+	return game.NewInboundEvent(eventType, func(payload interface{}) error {
+		return unmarshalMessagePackInto(eventData, payload)
+	}), nil
+}
@@ -0,0 +1,369 @@
+package communication
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+//This file contains the gRPC API, which is defined in api/scribblers.proto.
+//Since the API only consists of a few calls, the gRPC protocol is spoken
+//directly via HTTP/2, see https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md,
+//instead of depending on the gRPC library and generated code.
+
+const (
+	// grpcServicePath is the path prefix of all methods of the service.
+	grpcServicePath = "/scribblers.v1.Scribblers/"
+	// maxGRPCMessageSize is the maximum size of a request. Requests are
+	// small, except for lobbies with lots of custom words.
+	maxGRPCMessageSize = 1024 * 1024
+)
+
+// These are the gRPC status codes used by the API.
+const (
+	grpcCodeOK                = 0
+	grpcCodeInvalidArgument   = 3
+	grpcCodeNotFound          = 5
+	grpcCodePermissionDenied  = 7
+	grpcCodeResourceExhausted = 8
+	grpcCodeUnimplemented     = 12
+	grpcCodeInternal          = 13
+	grpcCodeUnavailable       = 14
+	grpcCodeUnauthenticated   = 16
+)
+
+// grpcError ends a call with the given status code.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (err *grpcError) Error() string {
+	return err.message
+}
+
+func newGRPCError(code int, format string, arguments ...interface{}) *grpcError {
+	return &grpcError{code: code, message: fmt.Sprintf(format, arguments...)}
+}
+
+// grpcMethod is a method of the service, which may only be called with a
+// credential having at least the given scope.
+type grpcMethod struct {
+	scope AdminScope
+	call  func(w http.ResponseWriter, r *http.Request, credential *AdminCredential) error
+}
+
+var grpcMethods = map[string]grpcMethod{
+	"ListLobbies":  {scope: AdminScopeRead, call: grpcListLobbies},
+	"CreateLobby":  {scope: AdminScopeModerate, call: grpcCreateLobby},
+	"StreamEvents": {scope: AdminScopeRead, call: grpcStreamEvents},
+}
+
+var (
+	// grpcPort is the port the gRPC API is served on. 0 means that it's
+	// disabled.
+	grpcPort   int
+	grpcServer = &http.Server{}
+)
+
+// ConfigureGRPC enables the gRPC API on the given port. It's served along
+// with the website by Serve, using TLS if HTTPS has been configured. Every
+// call requires an admin credential, see ConfigureAdmin.
+func ConfigureGRPC(port int) {
+	grpcPort = port
+}
+
+// serveGRPC blocks until the gRPC server has been shut down.
+func serveGRPC() error {
+	grpcServer.Addr = fmt.Sprintf(":%d", grpcPort)
+	if httpsPort != 0 {
+		grpcServer.Handler = http.HandlerFunc(grpcHandler)
+		grpcServer.TLSConfig = server.TLSConfig.Clone()
+		return grpcServer.ListenAndServeTLS("", "")
+	}
+
+	//Without TLS, HTTP/2 has to be used without negotiating it first, which
+	//gRPC clients refer to as insecure credentials.
+	grpcServer.Handler = h2c.NewHandler(http.HandlerFunc(grpcHandler), &http2.Server{})
+	return grpcServer.ListenAndServe()
+}
+
+// grpcResponseWriter remembers whether any response messages have been
+// sent, as this decides how the status has to be sent.
+type grpcResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *grpcResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *grpcResponseWriter) Flush() {
+	if flusher, canFlush := w.ResponseWriter.(http.Flusher); canFlush {
+		flusher.Flush()
+	}
+}
+
+// grpcHandler authenticates the call and passes it to the method. The
+// status is sent after the response messages, see writeGRPCStatus.
+func grpcHandler(writer http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 ||
+		(contentType != "application/grpc" && contentType != "application/grpc+proto") {
+		http.Error(writer, "only gRPC is served on this port", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w := &grpcResponseWriter{ResponseWriter: writer}
+	w.Header().Set("Content-Type", "application/grpc")
+	err := callGRPCMethod(w, r)
+	if err != nil {
+		if _, isGRPCError := err.(*grpcError); !isGRPCError {
+			logging.Error("error handling gRPC call", "method", r.URL.Path, "error", err)
+			err = newGRPCError(grpcCodeInternal, "%s", err)
+		}
+	}
+	writeGRPCStatus(w, err)
+}
+
+func callGRPCMethod(w http.ResponseWriter, r *http.Request) error {
+	method, known := grpcMethods[strings.TrimPrefix(r.URL.Path, grpcServicePath)]
+	if !known || !strings.HasPrefix(r.URL.Path, grpcServicePath) {
+		return newGRPCError(grpcCodeUnimplemented, "unknown method %s", r.URL.Path)
+	}
+
+	credential := authenticateAdmin(r)
+	if credential == nil {
+		return newGRPCError(grpcCodeUnauthenticated, "invalid admin credentials")
+	}
+	if credential.Scope < method.scope {
+		logging.Warn("gRPC call with insufficient scope", "admin", credential.Name, "method", r.URL.Path)
+		return newGRPCError(grpcCodePermissionDenied, "the admin credentials don't allow this")
+	}
+
+	return method.call(w, r, credential)
+}
+
+// writeGRPCStatus ends the call with the status matching the error. The
+// message has to be percent-encoded. If no response message has been sent,
+// the headers haven't been sent either. In that case the status has to be
+// part of the headers, as clients expect a "Trailers-Only" response.
+func writeGRPCStatus(w *grpcResponseWriter, err error) {
+	code, message := grpcCodeOK, ""
+	if callError, isGRPCError := err.(*grpcError); isGRPCError {
+		code, message = callError.code, callError.message
+	}
+
+	prefix := http.TrailerPrefix
+	if !w.written {
+		prefix = ""
+	}
+	w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		var encoded strings.Builder
+		for _, character := range []byte(message) {
+			if character < ' ' || character > '~' || character == '%' {
+				fmt.Fprintf(&encoded, "%%%02X", character)
+			} else {
+				encoded.WriteByte(character)
+			}
+		}
+		w.Header().Set(prefix+"Grpc-Message", encoded.String())
+	}
+}
+
+// readGRPCMessage reads the single request message of a call. Compression
+// isn't supported, so clients must not compress their messages.
+func readGRPCMessage(r *http.Request, message protoUnmarshaler) error {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+		return newGRPCError(grpcCodeInvalidArgument, "missing request message")
+	}
+	if prefix[0] != 0 {
+		return newGRPCError(grpcCodeUnimplemented, "compressed messages aren't supported")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxGRPCMessageSize {
+		return newGRPCError(grpcCodeResourceExhausted, "the request must not be larger than %d bytes", maxGRPCMessageSize)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r.Body, data); err != nil {
+		return newGRPCError(grpcCodeInvalidArgument, "incomplete request message")
+	}
+	if err := message.unmarshalProto(data); err != nil {
+		return newGRPCError(grpcCodeInvalidArgument, "invalid request message: %s", err)
+	}
+	return nil
+}
+
+// writeGRPCMessage sends a response message right away.
+func writeGRPCMessage(w http.ResponseWriter, message protoMarshaler) error {
+	data := message.marshalProto()
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	if _, err := w.Write(append(frame, data...)); err != nil {
+		return err
+	}
+	if flusher, canFlush := w.(http.Flusher); canFlush {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func grpcListLobbies(w http.ResponseWriter, r *http.Request, _ *AdminCredential) error {
+	request := &grpcListLobbiesRequest{}
+	if err := readGRPCMessage(r, request); err != nil {
+		return err
+	}
+
+	return writeGRPCMessage(w, &grpcListLobbiesResponse{Lobbies: listPublicLobbies(request.Tags, request.Region)})
+}
+
+// form turns the request into the form values of the HTTP API, so that the
+// settings are validated the same way. Settings that haven't been set are
+// taken from the lobby creation page.
+func (request *grpcCreateLobbyRequest) form() url.Values {
+	defaults := createDefaultLobbyCreatePageData()
+	orDefault := func(value int, defaultValue string) string {
+		if value == 0 {
+			return defaultValue
+		}
+		return strconv.Itoa(value)
+	}
+
+	form := url.Values{}
+	form.Set("language", defaults.Language)
+	if request.Language != "" {
+		form.Set("language", request.Language)
+	}
+	form.Set("drawing_time", orDefault(request.DrawingTime, defaults.DrawingTime))
+	form.Set("rounds", orDefault(request.Rounds, defaults.Rounds))
+	form.Set("max_players", orDefault(request.MaxPlayers, defaults.MaxPlayers))
+	form.Set("custom_words", strings.Join(request.CustomWords, ","))
+	form.Set("custom_words_chance", orDefault(request.CustomWordsChance, defaults.CustomWordsChance))
+	form.Set("clients_per_ip_limit", orDefault(request.ClientsPerIPLimit, defaults.ClientsPerIPLimit))
+	form.Set("start_delay", strconv.Itoa(request.StartDelay))
+	form.Set("tags", strings.Join(request.Tags, ","))
+	form.Set("region", request.Region)
+	form.Set("twitch_channel", request.TwitchChannel)
+	form.Set("enable_votekick", strconv.FormatBool(request.EnableVotekick))
+	form.Set("public", strconv.FormatBool(request.Public))
+	return form
+}
+
+func grpcCreateLobby(w http.ResponseWriter, r *http.Request, credential *AdminCredential) error {
+	request := &grpcCreateLobbyRequest{}
+	if err := readGRPCMessage(r, request); err != nil {
+		return err
+	}
+
+	settings, errors := parseLobbySettings(request.form())
+	if len(errors) != 0 {
+		return newGRPCError(grpcCodeInvalidArgument, "%s", strings.Join(errors, ";"))
+	}
+
+	ownerName := html.EscapeString(strings.TrimSpace(request.OwnerName))
+	if ownerName == "" {
+		ownerName = game.GeneratePlayerName()
+	}
+	owner, lobby, err := settings.create(trimDownTo(ownerName, game.MaxPlayerNameLength))
+	if err == game.ErrShuttingDown {
+		return newGRPCError(grpcCodeUnavailable, "%s", err)
+	}
+	if err != nil {
+		return newGRPCError(grpcCodeInvalidArgument, "%s", err)
+	}
+	if address, err := getIPAddressFromRequest(r); err == nil {
+		owner.SetLastKnownAddress(address)
+	}
+
+	response := &grpcCreateLobbyResponse{
+		Lobby:             newLobbyEntry(lobby),
+		OwnerID:           owner.ID,
+		OwnerSessionToken: SessionToken(lobby, owner),
+	}
+	settings.open(lobby)
+	lobby.Logger().Info("created lobby via gRPC", "admin", credential.Name)
+
+	return writeGRPCMessage(w, response)
+}
+
+// grpcStreamEvents works the same way as spectateEndpoint.
+func grpcStreamEvents(w http.ResponseWriter, r *http.Request, _ *AdminCredential) error {
+	request := &grpcStreamEventsRequest{}
+	if err := readGRPCMessage(r, request); err != nil {
+		return err
+	}
+
+	lobby, err := state.GetLobby(request.LobbyID)
+	if err != nil {
+		return newGRPCError(grpcCodeNotFound, "%s", err)
+	}
+
+	//The spectator has to be registered before generating the initial
+	//state, otherwise events could get lost in between.
+	lobbySpectator, err := addSpectator(lobby.ID)
+	if err != nil {
+		return newGRPCError(grpcCodeResourceExhausted, "%s", err)
+	}
+	defer removeSpectator(lobby.ID, lobbySpectator)
+
+	initialState := game.SnapshotSpectatorReadyData(lobby)
+	if initialState == nil {
+		return newGRPCError(grpcCodeNotFound, "%s", game.ErrLobbyClosed)
+	}
+	readyData, err := json.Marshal(initialState)
+	if err != nil {
+		return err
+	}
+	if err := writeGRPCMessage(w, &grpcEvent{Type: game.EventTypeReady, Data: readyData}); err != nil {
+		return nil
+	}
+
+	//Lobbies can also be removed without being closed, for example when
+	//they have been suspended for a restart, so this is checked regularly.
+	lobbyCheckTicker := time.NewTicker(spectatorKeepAliveInterval)
+	defer lobbyCheckTicker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case data, open := <-lobbySpectator.events:
+			if !open {
+				return nil
+			}
+			var event struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(data, &event); err != nil {
+				return err
+			}
+			if err := writeGRPCMessage(w, &grpcEvent{Type: event.Type, Data: event.Data}); err != nil {
+				return nil
+			}
+		case <-lobbyCheckTicker.C:
+			if _, err := state.GetLobby(lobby.ID); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package communication
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+func Test_protoCodec(t *testing.T) {
+	encoded := (&grpcLobby{ID: "abc", PlayerCount: 2, Votekick: true, Tags: []string{"", "x"}}).marshalProto()
+	expected := []byte{
+		0x0a, 0x03, 'a', 'b', 'c',
+		0x10, 0x02,
+		0x40, 0x01,
+		0x5a, 0x00,
+		0x5a, 0x01, 'x',
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("unexpected encoding % x", encoded)
+	}
+
+	//Contains a negative value, an unknown varint and an unknown fixed32
+	//field, which have to be skipped.
+	request := &grpcCreateLobbyRequest{}
+	err := request.unmarshalProto([]byte{
+		0x0a, 0x02, 'm', 'e',
+		0x18, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+		0xa0, 0x06, 0x05,
+		0xad, 0x06, 1, 2, 3, 4,
+		0x32, 0x01, 'a',
+		0x32, 0x01, 'b',
+		0x50, 0x01,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &grpcCreateLobbyRequest{OwnerName: "me", DrawingTime: -1, CustomWords: []string{"a", "b"}, Public: true}
+	if !reflect.DeepEqual(request, want) {
+		t.Errorf("unexpected request %+v", request)
+	}
+
+	//Truncated values and fields of the wrong type.
+	for _, invalid := range [][]byte{{0x0a, 0x05, 'a'}, {0x18}, {0x0d, 1, 2}, {0x08, 0x01}} {
+		if err := (&grpcCreateLobbyRequest{}).unmarshalProto(invalid); err == nil {
+			t.Errorf("invalid message % x was accepted", invalid)
+		}
+	}
+}
+
+// grpcCall is the outcome of calling a method of the test server.
+type grpcCall struct {
+	status   string
+	messages [][]byte
+}
+
+func callGRPC(t *testing.T, serverURL, method, token string, message []byte) *grpcCall {
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, address string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, address)
+		},
+	}}
+
+	body := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(message)))
+	request, err := http.NewRequest(http.MethodPost, serverURL+grpcServicePath+method, bytes.NewReader(append(body, message...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	call := &grpcCall{}
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(response.Body, prefix[:]); err != nil {
+			break
+		}
+		data := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(response.Body, data); err != nil {
+			t.Fatal(err)
+		}
+		call.messages = append(call.messages, data)
+		//Streams don't end on their own, so only the first event is read.
+		if method == "StreamEvents" {
+			return call
+		}
+	}
+	ioutil.ReadAll(response.Body)
+	//Calls failing without any messages are "Trailers-Only" responses.
+	if len(call.messages) == 0 {
+		call.status = response.Header.Get("Grpc-Status")
+	} else {
+		call.status = response.Trailer.Get("Grpc-Status")
+	}
+	return call
+}
+
+func Test_grpcHandler(t *testing.T) {
+	ConfigureAdmin([]AdminCredential{
+		{Name: "viewer", Token: "read-token", Scope: AdminScopeRead},
+		{Name: "moderator", Token: "moderate-token", Scope: AdminScopeModerate},
+	}, nil)
+	defer ConfigureAdmin(nil, nil)
+
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(grpcHandler), &http2.Server{}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		status string
+	}{
+		{"unknown method", "DeleteLobby", "moderate-token", "12"},
+		{"missing token", "ListLobbies", "", "16"},
+		{"wrong token", "ListLobbies", "guessed", "16"},
+		{"insufficient scope", "CreateLobby", "read-token", "7"},
+		{"list", "ListLobbies", "read-token", "0"},
+	}
+	for _, tt := range tests {
+		if call := callGRPC(t, server.URL, tt.method, tt.token, nil); call.status != tt.status {
+			t.Errorf("%s: expected status %s, got %s", tt.name, tt.status, call.status)
+		}
+	}
+
+	invalid := callGRPC(t, server.URL, "CreateLobby", "moderate-token", []byte{0x18, 0x01})
+	if invalid.status != "3" {
+		t.Errorf("invalid drawing time wasn't rejected, got status %s", invalid.status)
+	}
+
+	//Owner "owner", language "english", public and tagged "test".
+	created := callGRPC(t, server.URL, "CreateLobby", "moderate-token", []byte{
+		0x0a, 0x05, 'o', 'w', 'n', 'e', 'r',
+		0x12, 0x07, 'e', 'n', 'g', 'l', 'i', 's', 'h',
+		0x50, 0x01,
+		0x62, 0x04, 't', 'e', 's', 't',
+	})
+	if created.status != "0" || len(created.messages) != 1 {
+		t.Fatalf("lobby wasn't created, got status %s", created.status)
+	}
+	response := &protoDecoder{data: created.messages[0]}
+	var lobbyID, sessionToken string
+	for !response.done() {
+		field, err := response.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch field {
+		case 1:
+			lobby, err := response.bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			lobbyDecoder := &protoDecoder{data: lobby}
+			if field, _ := lobbyDecoder.next(); field == 1 {
+				lobbyID, _ = lobbyDecoder.string()
+			}
+		case 3:
+			sessionToken, err = response.string()
+		default:
+			err = response.skip()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	lobby, err := state.GetLobby(lobbyID)
+	if err != nil {
+		t.Fatalf("created lobby doesn't exist: %s", err)
+	}
+	defer state.RemoveLobby(lobby.ID)
+	if sessionToken == "" || !lobby.IsPublic() || len(lobby.Tags) != 1 || lobby.Tags[0] != "test" {
+		t.Errorf("lobby wasn't created as requested")
+	}
+
+	//Only lobbies tagged "test".
+	listed := callGRPC(t, server.URL, "ListLobbies", "read-token", []byte{0x0a, 0x04, 't', 'e', 's', 't'})
+	if len(listed.messages) != 1 || !bytes.Contains(listed.messages[0], []byte(lobby.ID)) {
+		t.Errorf("created lobby wasn't listed")
+	}
+
+	streamed := callGRPC(t, server.URL, "StreamEvents", "read-token", append([]byte{0x0a, byte(len(lobby.ID))}, lobby.ID...))
+	if len(streamed.messages) != 1 || !bytes.HasPrefix(streamed.messages[0], []byte{0x0a, 0x05, 'r', 'e', 'a', 'd', 'y'}) {
+		t.Errorf("stream didn't start with ready event")
+	}
+	if missing := callGRPC(t, server.URL, "StreamEvents", "read-token", []byte{0x0a, 0x01, 'x'}); missing.status != "5" {
+		t.Errorf("expected unknown lobby to be reported, got status %s", missing.status)
+	}
+}
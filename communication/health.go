@@ -0,0 +1,134 @@
+package communication
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// healthChecker can optionally be implemented by external dependencies, such
+// as storages, in order to take part in the readiness check.
+type healthChecker interface {
+	CheckHealth() error
+}
+
+// maxLobbies is the amount of lobbies after which the instance reports not
+// being ready anymore. 0 means there's no limit. It's accessed atomically,
+// since it can be changed by reloading the configuration.
+var maxLobbies int32
+
+// ConfigureCapacity sets the amount of lobbies the instance is meant to
+// handle. Once it's reached, /readyz tells load balancers to prefer other
+// instances. Lobbies can still be created though.
+func ConfigureCapacity(lobbyLimit int) {
+	atomic.StoreInt32(&maxLobbies, int32(lobbyLimit))
+}
+
+// CheckHealth makes sure that the directory exists and can be written to.
+func (storage *FileRecordingStorage) CheckHealth() error {
+	info, statError := os.Stat(storage.Directory)
+	if statError != nil {
+		return statError
+	}
+	if !info.IsDir() {
+		return errors.New("the recording directory isn't a directory")
+	}
+
+	testFile, createError := ioutil.TempFile(storage.Directory, ".healthcheck-*")
+	if createError != nil {
+		return createError
+	}
+	testFile.Close()
+	return os.Remove(testFile.Name())
+}
+
+// readiness is the response of /readyz.
+type readiness struct {
+	Ready           bool              `json:"ready"`
+	WordListsLoaded bool              `json:"wordListsLoaded"`
+	ShuttingDown    bool              `json:"shuttingDown"`
+	Storage         map[string]string `json:"storage"`
+	Capacity        capacity          `json:"capacity"`
+}
+
+type capacity struct {
+	Lobbies    int `json:"lobbies"`
+	MaxLobbies int `json:"maxLobbies,omitempty"`
+	// Headroom is the amount of lobbies that can still be created before
+	// the limit has been reached. It's omitted if there's no limit.
+	Headroom *int `json:"headroom,omitempty"`
+}
+
+// healthEndpoint tells whether the process is alive. It doesn't check any
+// dependencies, since restarting the process wouldn't fix them.
+func healthEndpoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readinessEndpoint tells whether the instance is able to serve new players.
+// If not, 503 is returned. The body always contains the detailed status.
+func readinessEndpoint(w http.ResponseWriter, r *http.Request) {
+	status := checkReadiness()
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if encodingError := json.NewEncoder(w).Encode(status); encodingError != nil {
+		logging.Error("error writing readiness", "error", encodingError)
+	}
+}
+
+func checkReadiness() *readiness {
+	status := &readiness{
+		Ready:           true,
+		WordListsLoaded: game.WordListsLoaded(),
+		ShuttingDown:    game.IsShuttingDown(),
+		Storage:         make(map[string]string),
+		Capacity:        capacity{Lobbies: state.GetActiveLobbyCount()},
+	}
+
+	if !status.WordListsLoaded || status.ShuttingDown {
+		status.Ready = false
+	}
+
+	if checker, isChecker := recordingStorage.(healthChecker); isChecker {
+		if checkError := checker.CheckHealth(); checkError != nil {
+			status.Storage["recordings"] = checkError.Error()
+			status.Ready = false
+		} else {
+			status.Storage["recordings"] = "ok"
+		}
+	}
+
+	if checker, isChecker := lobbyStore.(healthChecker); isChecker {
+		if checkError := checker.CheckHealth(); checkError != nil {
+			status.Storage["lobbies"] = checkError.Error()
+			status.Ready = false
+		} else {
+			status.Storage["lobbies"] = "ok"
+		}
+	}
+
+	if lobbyLimit := int(atomic.LoadInt32(&maxLobbies)); lobbyLimit > 0 {
+		headroom := lobbyLimit - status.Capacity.Lobbies
+		if headroom <= 0 {
+			headroom = 0
+			status.Ready = false
+		}
+		status.Capacity.MaxLobbies = lobbyLimit
+		status.Capacity.Headroom = &headroom
+	}
+
+	return status
+}
@@ -0,0 +1,59 @@
+package communication
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+func Test_checkReadiness(t *testing.T) {
+	if err := game.LoadWordLists(); err != nil {
+		t.Fatalf("error loading word lists: %s", err)
+	}
+
+	directory, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	previousStorage := recordingStorage
+	defer func() {
+		recordingStorage = previousStorage
+		ConfigureCapacity(0)
+	}()
+
+	t.Run("ready", func(t *testing.T) {
+		recordingStorage = &FileRecordingStorage{Directory: directory}
+		ConfigureCapacity(0)
+
+		status := checkReadiness()
+		if !status.Ready || status.Storage["recordings"] != "ok" || status.Capacity.Headroom != nil {
+			t.Errorf("unexpected status: %+v", status)
+		}
+	})
+
+	t.Run("storage unavailable", func(t *testing.T) {
+		recordingStorage = &FileRecordingStorage{Directory: filepath.Join(directory, "missing")}
+		ConfigureCapacity(0)
+
+		status := checkReadiness()
+		if status.Ready || status.Storage["recordings"] == "ok" {
+			t.Errorf("unexpected status: %+v", status)
+		}
+	})
+
+	t.Run("headroom", func(t *testing.T) {
+		recordingStorage = nil
+		ConfigureCapacity(state.GetActiveLobbyCount() + 1)
+
+		status := checkReadiness()
+		if !status.Ready || status.Capacity.Headroom == nil || *status.Capacity.Headroom != 1 {
+			t.Errorf("unexpected status: %+v", status)
+		}
+	})
+}
@@ -30,8 +30,39 @@ func remoteAddressToSimpleIP(input string) string {
 	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
 }
 
-// Serve will start an HTTP server listening on the given port.
-// This is a blocking call-
+// server is the HTTP server started by Serve.
+var server = &http.Server{}
+
+// Serve will start an HTTP server listening on the given port. If TLS has
+// been configured, HTTPS is served as well, see ConfigureTLS. The same goes
+// for the gRPC API, see ConfigureGRPC.
+// This is a blocking call. After Shutdown has been called,
+// http.ErrServerClosed is returned.
 func Serve(port int) error {
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	//Whichever server fails first stops the process.
+	serveErrors := make(chan error, 3)
+	if grpcPort != 0 {
+		go func() {
+			serveErrors <- serveGRPC()
+		}()
+	}
+
+	if httpsPort == 0 {
+		go func() {
+			server.Addr = fmt.Sprintf(":%d", port)
+			serveErrors <- server.ListenAndServe()
+		}()
+		return <-serveErrors
+	}
+
+	go func() {
+		redirectServer.Addr = fmt.Sprintf(":%d", port)
+		serveErrors <- redirectServer.ListenAndServe()
+	}()
+	go func() {
+		server.Addr = fmt.Sprintf(":%d", httpsPort)
+		//The certificates are part of the servers TLS configuration.
+		serveErrors <- server.ListenAndServeTLS("", "")
+	}()
+	return <-serveErrors
 }
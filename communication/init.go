@@ -8,9 +8,10 @@ import (
 )
 
 var (
-	errorPage       *template.Template
-	lobbyCreatePage *template.Template
-	lobbyPage       *template.Template
+	errorPage        *template.Template
+	lobbyCreatePage  *template.Template
+	lobbyPage        *template.Template
+	verificationPage *template.Template
 )
 
 func findStringFromBox(box *packr.Box, name string) string {
@@ -44,6 +45,11 @@ func init() {
 		panic(parseError)
 	}
 
+	verificationPage, parseError = template.New("verification.html").Parse(findStringFromBox(templates, "verification.html"))
+	if parseError != nil {
+		panic(parseError)
+	}
+
 	setupRoutes()
 }
 
@@ -60,6 +66,42 @@ func setupRoutes() {
 
 	//These exist only for the public API. We version them in order to ensure
 	//backwards compatibility as far as possible.
-	http.HandleFunc("/v1/lobby", lobbyEndpoint)
-	http.HandleFunc("/v1/lobby/player", enterLobby)
+	//Other sites may only use them if configured, see ConfigureCORS.
+	http.HandleFunc("/v1/lobby", withCORS(lobbyEndpoint))
+	http.HandleFunc("/v1/lobby/player", withCORS(enterLobby))
+	http.HandleFunc("/v1/lobby/events", withCORS(spectateEndpoint))
+	http.HandleFunc("/v1/lobby/results", withCORS(resultsEndpoint))
+	//Fallback for clients that can't use the websocket.
+	http.HandleFunc("/v1/lobby/poll", withCORS(pollEndpoint))
+	//Replays keep whole recordings in memory, so only admins may start them.
+	http.HandleFunc("/v1/replay", withAdminAuth(AdminScopeRead, createReplayEndpoint))
+	http.HandleFunc("/v1/replay/ws", replayWebsocketEndpoint)
+	http.HandleFunc("/v1/verification", withCORS(verificationEndpoint))
+
+	//Optional accounts, only available if login providers have been
+	//configured, see ConfigureAccounts.
+	http.HandleFunc("/login", loginEndpoint)
+	http.HandleFunc("/login/callback/", loginCallbackEndpoint)
+	http.HandleFunc("/logout", logoutEndpoint)
+	http.HandleFunc("/v1/account", withCORS(accountEndpoint))
+	http.HandleFunc("/v1/account/games", withCORS(accountGamesEndpoint))
+	http.HandleFunc("/v1/presets", withCORS(presetsEndpoint))
+
+	//Administration of the instance, disabled by default.
+	http.HandleFunc("/v1/admin/lobbies", withAdminAuth(AdminScopeRead, adminLobbiesEndpoint))
+	http.HandleFunc("/v1/admin/lobby", withAdminAuth(AdminScopeRead, adminLobbyEndpoint))
+	http.HandleFunc("/v1/admin/lobby/close", withAdminAuth(AdminScopeModerate, adminCloseLobbyEndpoint))
+	http.HandleFunc("/v1/admin/lobby/kick", withAdminAuth(AdminScopeModerate, adminKickEndpoint))
+	http.HandleFunc("/v1/admin/audit", withAdminAuth(AdminScopeRead, adminAuditEndpoint))
+	http.HandleFunc("/v1/admin/bans", withAdminAuth(AdminScopeRead, adminBansEndpoint))
+	http.HandleFunc("/v1/admin/ban", withAdminAuth(AdminScopeModerate, adminBanEndpoint))
+	http.HandleFunc("/v1/admin/unban", withAdminAuth(AdminScopeModerate, adminUnbanEndpoint))
+	http.HandleFunc("/v1/admin/announcement", withAdminAuth(AdminScopeModerate, adminAnnouncementEndpoint))
+	http.HandleFunc("/v1/admin/reload", withAdminAuth(AdminScopeFull, adminReloadEndpoint))
+	http.HandleFunc("/v1/admin/pprof/", withAdminAuth(AdminScopeFull, profilingEndpoint))
+
+	//Monitoring for operators, disabled by default.
+	http.HandleFunc("/metrics", metricsEndpoint)
+	http.HandleFunc("/healthz", healthEndpoint)
+	http.HandleFunc("/readyz", readinessEndpoint)
 }
@@ -6,6 +6,7 @@ import (
 	"html"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/scribble-rs/scribble.rs/game"
 	"github.com/scribble-rs/scribble.rs/state"
@@ -13,22 +14,51 @@ import (
 
 var (
 	errNoLobbyIDSupplied = errors.New("please supply a lobby id via the 'lobby_id' query parameter")
-	errLobbyNotExistent  = errors.New("the requested lobby doesn't exist")
+	errInviteInvalid     = errors.New("the invite is invalid or has expired")
 )
 
+// getLobby retrieves the lobby either via the 'lobby_id' query parameter or,
+// if no ID has been supplied, via the 'invite' query parameter. Without
+// either, the lobby the players session belongs to is used.
 func getLobby(r *http.Request) (*game.Lobby, error) {
 	lobbyID := r.URL.Query().Get("lobby_id")
 	if lobbyID == "" {
-		return nil, errNoLobbyIDSupplied
+		inviteToken := getInviteToken(r)
+		if inviteToken == "" {
+			return getSessionLobby(r)
+		}
+
+		lobby := state.GetLobbyByInviteToken(inviteToken)
+		if lobby == nil {
+			return nil, errInviteInvalid
+		}
+
+		return lobby, nil
+	}
+
+	return state.GetLobby(lobbyID)
+}
+
+// getInviteToken returns the invite token used for accessing the lobby. If
+// the lobby has been accessed via its ID, there's no token.
+func getInviteToken(r *http.Request) string {
+	if r.URL.Query().Get("lobby_id") != "" {
+		return ""
 	}
 
-	lobby := state.GetLobby(lobbyID)
+	return r.URL.Query().Get("invite")
+}
 
-	if lobby == nil {
-		return nil, errLobbyNotExistent
+// getSessionLobby retrieves the lobby the requests session token has been
+// issued for. This allows players that have joined via an invite to continue
+// without the lobby ID ever being sent to them.
+func getSessionLobby(r *http.Request) (*game.Lobby, error) {
+	token, parseError := parseSessionToken(getUserSession(r), time.Now())
+	if parseError != nil {
+		return nil, errNoLobbyIDSupplied
 	}
 
-	return lobby, nil
+	return state.GetLobby(token.lobbyID)
 }
 
 func getUserSession(r *http.Request) string {
@@ -45,13 +75,20 @@ func getUserSession(r *http.Request) string {
 	return ""
 }
 
+// getPlayer returns the player the requests session token belongs to or nil
+// if the token is missing or not valid for this lobby.
 func getPlayer(lobby *game.Lobby, r *http.Request) *game.Player {
-	return lobby.GetPlayer(getUserSession(r))
+	player, _ := findSessionPlayer(lobby, getUserSession(r))
+	return player
 }
 
-// getPlayername either retrieves the playername from a cookie, the URL form
-// or generates a new random name if no name can be found.
+// getPlayername either retrieves the playername from the players account, a
+// cookie, the URL form or generates a new random name if no name can be found.
 func getPlayername(r *http.Request) string {
+	if account := getAccount(r); account != nil {
+		return trimDownTo(html.EscapeString(account.Name), game.MaxPlayerNameLength)
+	}
+
 	parseError := r.ParseForm()
 	if parseError == nil {
 		username := html.EscapeString(strings.TrimSpace(r.Form.Get("username")))
@@ -93,7 +130,7 @@ func GetPlayers(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(lobby.GetPlayers())
+	err = json.NewEncoder(w).Encode(lobby.SnapshotPlayers())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -114,6 +151,10 @@ var (
 // official webclient doesn't use all of them as of now.
 type LobbyData struct {
 	LobbyID string `json:"lobbyId"`
+	//ProtocolVersion is the newest websocket protocol version supported by
+	//the server. Clients should declare the version they speak via the
+	//'protocol_version' query parameter when connecting.
+	ProtocolVersion int `json:"protocolVersion"`
 	//DrawingBoardBaseWidth is the internal canvas width and is needed for
 	//correctly up- / downscaling drawing instructions.
 	DrawingBoardBaseWidth int `json:"drawingBoardBaseWidth"`
@@ -136,6 +177,7 @@ type LobbyData struct {
 func createLobbyData(lobbyID string) *LobbyData {
 	return &LobbyData{
 		LobbyID:                lobbyID,
+		ProtocolVersion:        game.ProtocolVersion,
 		DrawingBoardBaseWidth:  game.DrawingBoardBaseWidth,
 		DrawingBoardBaseHeight: game.DrawingBoardBaseHeight,
 		MinBrushSize:           game.MinBrushSize,
@@ -148,6 +190,9 @@ func createLobbyData(lobbyID string) *LobbyData {
 // ssrEnterLobby opens a lobby, either opening it directly or asking for a lobby.
 func ssrEnterLobby(w http.ResponseWriter, r *http.Request) {
 	lobby, err := getLobby(r)
+	if err == state.ErrLobbyNotExistent && redirectToLobbyInstance(w, r) {
+		return
+	}
 	if err != nil {
 		userFacingError(w, err.Error())
 		return
@@ -166,9 +211,20 @@ func ssrEnterLobby(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	requestAddress, addressError := getIPAddressFromRequest(r)
+	if addressError != nil {
+		userFacingError(w, addressError.Error())
+		return
+	}
+	if findInstanceBan(r, requestAddress) != nil {
+		userFacingError(w, instanceBanMessage)
+		return
+	}
+
 	player := getPlayer(lobby, r)
 
-	pageData := createLobbyData(lobby.ID)
+	//Players that have joined without the ID are identified by their session.
+	pageData := createLobbyData(r.URL.Query().Get("lobby_id"))
 
 	if player == nil {
 		if !lobby.HasFreePlayerSlot() {
@@ -177,8 +233,7 @@ func ssrEnterLobby(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var clientsWithSameIP int
-		requestAddress := getIPAddressFromRequest(r)
-		for _, otherPlayer := range lobby.GetPlayers() {
+		for _, otherPlayer := range lobby.SnapshotPlayers() {
 			if otherPlayer.GetLastKnownAddress() == requestAddress {
 				clientsWithSameIP++
 				if clientsWithSameIP >= lobby.ClientsPerIPLimit {
@@ -188,21 +243,44 @@ func ssrEnterLobby(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		newPlayer := lobby.JoinPlayer(getPlayername(r))
+		if !ssrCheckVerification(w, r, requestAddress) {
+			return
+		}
+
+		inviteToken := getInviteToken(r)
+		//The use is consumed before joining, so that concurrent joins can't
+		//exceed the tokens uses.
+		if inviteToken != "" && !lobby.UseInviteToken(inviteToken) {
+			userFacingError(w, errInviteInvalid.Error())
+			return
+		}
+
+		newPlayer, joinError := lobby.JoinPlayer(getPlayername(r), requestAddress, getPreviousUserSession(r))
+		if joinError != nil {
+			if inviteToken != "" {
+				lobby.ReturnInviteToken(inviteToken)
+			}
+			userFacingError(w, joinError.Error())
+			return
+		}
 
-		// Use the players generated usersession and pass it as a cookie.
-		http.SetCookie(w, &http.Cookie{
-			Name:     "usersession",
-			Value:    newPlayer.GetUserSession(),
-			Path:     "/",
-			SameSite: http.SameSiteStrictMode,
-		})
+		linkAccount(lobby, newPlayer, r)
+		setSessionCookie(w, lobby, newPlayer, false)
 	} else {
-		if player.Connected && player.GetWebsocket() != nil {
+		if player.Connected && getConnection(player) != nil {
 			userFacingError(w, "It appears you already have an open tab for this lobby.")
 			return
 		}
-		player.SetLastKnownAddress(getIPAddressFromRequest(r))
+		player.SetLastKnownAddress(requestAddress)
+		setSessionCookie(w, lobby, player, true)
+	}
+
+	//Invites are single-use per player, therefore we continue with the
+	//session, so that refreshing the page neither requires the invite nor
+	//reveals the lobby ID.
+	if getInviteToken(r) != "" {
+		http.Redirect(w, r, "/ssrEnterLobby", http.StatusFound)
+		return
 	}
 
 	templateError := lobbyPage.ExecuteTemplate(w, "lobby.html", pageData)
@@ -211,35 +289,11 @@ func ssrEnterLobby(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getIPAddressFromRequest(r *http.Request) string {
-	//See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Forwarded-For
-	//See https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Forwarded
-
-	//The following logic has been implemented according to the spec, therefore please
-	//refer to the spec if you have a question.
-
-	forwardedAddress := r.Header.Get("X-Forwarded-For")
-	if forwardedAddress != "" {
-		//Since the field may contain multiple addresses separated by commas, we use the first
-		//one, which according to the docs is supposed to be the client address.
-		clientAddress := strings.TrimSpace(strings.Split(forwardedAddress, ",")[0])
-		return remoteAddressToSimpleIP(clientAddress)
-	}
-
-	standardForwardedHeader := r.Header.Get("Forwarded")
-	if standardForwardedHeader != "" {
-		targetPrefix := "for="
-		//Since forwarded can contain more than one field, we search for one specific field.
-		for _, part := range strings.Split(standardForwardedHeader, ";") {
-			trimmed := strings.TrimSpace(part)
-			if strings.HasPrefix(trimmed, targetPrefix) {
-				//FIXME Maybe checking for a valid IP-Address would make sense here, not sure tho.
-				address := remoteAddressToSimpleIP(strings.TrimPrefix(trimmed, targetPrefix))
-				//Since the documentation doesn't mention which quotes are used, I just remove all ;)
-				return strings.NewReplacer("`", "", "'", "", "\"", "", "[", "", "]", "").Replace(address)
-			}
-		}
-	}
-
-	return remoteAddressToSimpleIP(r.RemoteAddr)
+// getIPAddressFromRequest determines the clients address. Forwarding headers
+// are only taken into account if the request comes from a trusted proxy,
+// otherwise the remote address is used, as any client could set them. An
+// error is only returned if strict proxy header parsing is enabled and a
+// header couldn't be parsed.
+func getIPAddressFromRequest(r *http.Request) (string, error) {
+	return getTrustedClientIP(r)
 }
@@ -1,9 +1,14 @@
 package communication
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/state"
 )
 
 func TestCreateLobby(t *testing.T) {
@@ -26,3 +31,96 @@ func TestCreateLobby(t *testing.T) {
 		}
 	}
 }
+
+func Test_ssrEnterLobbyViaInvite(t *testing.T) {
+	_, lobby, err := gameServer.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.AddLobby(lobby)
+	defer state.RemoveLobby(lobby.ID)
+	invite := lobby.CreateInviteToken(time.Hour, 1)
+
+	enterLobby := func(target string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("User-Agent", "Mozilla/5.0 Gecko/20100101 Firefox/115.0")
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		ssrEnterLobby(recorder, request)
+		return recorder
+	}
+
+	joined := enterLobby("/ssrEnterLobby?invite="+invite.Token, nil)
+	if location := joined.Header().Get("Location"); joined.Code != http.StatusFound || strings.Contains(location, lobby.ID) {
+		t.Fatalf("expected redirect without the lobby ID, got %d to %q", joined.Code, location)
+	}
+
+	//The invite has been used up, so only the session leads to the lobby.
+	continued := enterLobby(joined.Header().Get("Location"), joined.Result().Cookies())
+	if continued.Code != http.StatusOK || !strings.Contains(continued.Body.String(), "/v1/ws") {
+		t.Errorf("expected the lobby page, got %d", continued.Code)
+	}
+	if strings.Contains(continued.Body.String(), lobby.ID) {
+		t.Error("the lobby page revealed the lobby ID to an invited player")
+	}
+	if withoutSession := enterLobby("/ssrEnterLobby", nil); strings.Contains(withoutSession.Body.String(), "/v1/ws") {
+		t.Error("the lobby was opened without a session")
+	}
+}
+
+func Test_matchesLobbyFilter(t *testing.T) {
+	lobby := &game.Lobby{
+		Tags:   []string{"beginners", "speedrun"},
+		Region: "EU West",
+	}
+
+	tests := []struct {
+		name   string
+		tags   []string
+		region string
+		want   bool
+	}{
+		{"no filter", nil, "", true},
+		{"matching region", nil, "eu west", true},
+		{"other region", nil, "US", false},
+		{"matching tag", []string{"Beginners"}, "", true},
+		{"matching tags and region", []string{"beginners", "speedrun"}, "EU West", true},
+		{"one missing tag", []string{"beginners", "german"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesLobbyFilter(lobby, tt.tags, tt.region); got != tt.want {
+				t.Errorf("matchesLobbyFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_negotiateProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"undeclared version", "", game.MinProtocolVersion, false},
+		{"current version", "1", game.ProtocolVersion, false},
+		{"newer client", "999", game.ProtocolVersion, false},
+		{"invalid version", "abc", 0, true},
+		{"zero", "0", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := negotiateProtocolVersion(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("negotiateProtocolVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("negotiateProtocolVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
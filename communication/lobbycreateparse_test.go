@@ -204,3 +204,62 @@ func Test_parseCustomWordChance(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseStartDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"empty value", "", 0, false},
+		{"space", " ", 0, false},
+		{"not numeric", "soon", 0, true},
+		{"less than minimum", "-1", 0, true},
+		{"more than maximum", "1441", 0, true},
+		{"maximum", "1440", 1440, false},
+		{"minimum", "0", 0, false},
+		{"something valid", "15", 15, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStartDelay(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseStartDelay() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseStartDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"empty value", "", nil, false},
+		{"space", " ", nil, false},
+		{"single tag", "Beginners", []string{"beginners"}, false},
+		{"multiple tags", "beginners, speedrun", []string{"beginners", "speedrun"}, false},
+		{"duplicate and empty tags", "a,,A, a", []string{"a"}, false},
+		{"too long tag", "abcdefghijklmnopqrstu", nil, true},
+		{"too many tags", "a,b,c,d,e,f", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTags(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseTags() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package communication
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// broadcastDurationBuckets are the upper bounds in seconds of the buckets
+// used for the broadcast duration histogram.
+var broadcastDurationBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+var (
+	// metricsEnabled is 1 if metrics are served. It's accessed atomically,
+	// since it can be changed by reloading the configuration.
+	metricsEnabled int32
+
+	inboundEvents     = newLabeledCounter()
+	outboundEvents    = newLabeledCounter()
+	broadcastDuration = newHistogram(broadcastDurationBuckets)
+)
+
+// ConfigureMetrics decides whether /metrics is served. The metrics are
+// written in the Prometheus text format.
+func ConfigureMetrics(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&metricsEnabled, value)
+}
+
+// labeledCounter is a set of counters, one for each value of a single label.
+type labeledCounter struct {
+	mutex  *sync.Mutex
+	values map[string]uint64
+}
+
+func newLabeledCounter() *labeledCounter {
+	return &labeledCounter{
+		mutex:  &sync.Mutex{},
+		values: make(map[string]uint64),
+	}
+}
+
+func (counter *labeledCounter) inc(label string) {
+	counter.mutex.Lock()
+	counter.values[label]++
+	counter.mutex.Unlock()
+}
+
+// write writes one sample per label value, sorted by the label value.
+func (counter *labeledCounter) write(w io.Writer, name, labelName string) {
+	counter.mutex.Lock()
+	labels := make([]string, 0, len(counter.values))
+	for label := range counter.values {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, labelName, escapeLabelValue(label), counter.values[label])
+	}
+	counter.mutex.Unlock()
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	mutex   *sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		mutex:   &sync.Mutex{},
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mutex.Lock()
+	for index, bound := range h.buckets {
+		if value <= bound {
+			h.counts[index]++
+		}
+	}
+	h.sum += value
+	h.count++
+	h.mutex.Unlock()
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	h.mutex.Lock()
+	for index, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.counts[index])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+	h.mutex.Unlock()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// countInboundEvent counts an event received from a client. Since clients
+// can send arbitrary event types, all unknown ones share a single label.
+func countInboundEvent(eventType string) {
+	if !game.IsKnownEvent(eventType) {
+		eventType = "unknown"
+	}
+	inboundEvents.inc(eventType)
+}
+
+// countOutboundEvent counts an event queued for a single player.
+func countOutboundEvent(eventType string) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	outboundEvents.inc(eventType)
+}
+
+// observeBroadcast records the time it took to queue an event for all
+// players of a lobby.
+func observeBroadcast(start time.Time) {
+	broadcastDuration.observe(time.Since(start).Seconds())
+}
+
+func metricsEndpoint(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&metricsEnabled) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+func writeMetrics(w io.Writer) {
+	writeHeader(w, "scribblers_lobbies", "gauge", "The amount of lobbies that currently exist.")
+	fmt.Fprintf(w, "scribblers_lobbies %d\n", state.GetActiveLobbyCount())
+
+	writeHeader(w, "scribblers_connected_players", "gauge", "The amount of players connected to any lobby.")
+	fmt.Fprintf(w, "scribblers_connected_players %d\n", state.GetConnectedPlayerCount())
+
+	writeHeader(w, "scribblers_inbound_events_total", "counter", "The amount of events received from clients by type.")
+	inboundEvents.write(w, "scribblers_inbound_events_total", "type")
+
+	writeHeader(w, "scribblers_outbound_events_total", "counter", "The amount of events queued for sending to players by type.")
+	outboundEvents.write(w, "scribblers_outbound_events_total", "type")
+
+	writeHeader(w, "scribblers_broadcast_duration_seconds", "histogram", "The time it takes to queue an event for all players of a lobby.")
+	broadcastDuration.write(w, "scribblers_broadcast_duration_seconds")
+
+	writeHeader(w, "scribblers_word_list_size", "gauge", "The amount of words of each loaded word list.")
+	for _, size := range game.GetLoadedWordListSizes() {
+		fmt.Fprintf(w, "scribblers_word_list_size{language=\"%s\"} %d\n", escapeLabelValue(size.Language), size.Words)
+	}
+
+	writeHeader(w, "go_goroutines", "gauge", "The amount of goroutines that currently exist.")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+}
+
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
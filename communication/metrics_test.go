@@ -0,0 +1,70 @@
+package communication
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_labeledCounter(t *testing.T) {
+	counter := newLabeledCounter()
+	counter.inc("message")
+	counter.inc("line")
+	counter.inc("message")
+	counter.inc(`"quoted"`)
+
+	buffer := &bytes.Buffer{}
+	counter.write(buffer, "events_total", "type")
+
+	expected := "events_total{type=\"\\\"quoted\\\"\"} 1\n" +
+		"events_total{type=\"line\"} 1\n" +
+		"events_total{type=\"message\"} 2\n"
+	if buffer.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", buffer.String(), expected)
+	}
+}
+
+func Test_histogram(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1})
+	h.observe(0.05)
+	h.observe(0.5)
+	h.observe(2)
+
+	buffer := &bytes.Buffer{}
+	h.write(buffer, "duration_seconds")
+
+	expected := "duration_seconds_bucket{le=\"0.1\"} 1\n" +
+		"duration_seconds_bucket{le=\"1\"} 2\n" +
+		"duration_seconds_bucket{le=\"+Inf\"} 3\n" +
+		"duration_seconds_sum 2.55\n" +
+		"duration_seconds_count 3\n"
+	if buffer.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", buffer.String(), expected)
+	}
+}
+
+func Test_writeMetrics(t *testing.T) {
+	countInboundEvent("message")
+	countInboundEvent("made-up-event")
+
+	buffer := &bytes.Buffer{}
+	writeMetrics(buffer)
+	output := buffer.String()
+
+	for _, expected := range []string{
+		"# TYPE scribblers_lobbies gauge\n",
+		"# TYPE scribblers_connected_players gauge\n",
+		"scribblers_inbound_events_total{type=\"message\"} ",
+		"scribblers_inbound_events_total{type=\"unknown\"} ",
+		"# TYPE scribblers_broadcast_duration_seconds histogram\n",
+		"# TYPE go_goroutines gauge\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("metrics don't contain %q:\n%s", expected, output)
+		}
+	}
+
+	if strings.Contains(output, "made-up-event") {
+		t.Errorf("unknown event types must not be exposed:\n%s", output)
+	}
+}
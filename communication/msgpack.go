@@ -0,0 +1,874 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+//This file contains a minimal MessagePack (https://msgpack.org/) codec.
+//Encoding works on arbitrary values and follows the same rules as
+//encoding/json, meaning that the json struct tags are respected. This way
+//all events can be sent in both formats without any additional annotations.
+//Decoding either produces generic values or decodes straight into typed
+//values, again respecting the json struct tags.
+
+// maxMessagePackDepth limits how deeply maps and arrays may be nested, so
+// that malicious messages can't exhaust the stack.
+const maxMessagePackDepth = 64
+
+var (
+	errMessagePackTruncated = errors.New("msgpack: unexpected end of data")
+	errMessagePackTooDeep   = errors.New("msgpack: maximum nesting depth exceeded")
+	jsonMarshalerType       = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType     = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	messagePackFieldCache   sync.Map
+)
+
+// marshalMessagePack encodes the given value as MessagePack.
+func marshalMessagePack(value interface{}) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if err := encodeMessagePackValue(buffer, reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func encodeMessagePackValue(buffer *bytes.Buffer, value reflect.Value) error {
+	if !value.IsValid() {
+		buffer.WriteByte(0xc0)
+		return nil
+	}
+
+	if value.Type().Implements(jsonMarshalerType) &&
+		!(value.Kind() == reflect.Ptr && value.IsNil()) {
+		return encodeJSONMarshaler(buffer, value.Interface().(json.Marshaler))
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			buffer.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMessagePackValue(buffer, value.Elem())
+	case reflect.Bool:
+		if value.Bool() {
+			buffer.WriteByte(0xc3)
+		} else {
+			buffer.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		encodeMessagePackInt(buffer, value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		encodeMessagePackUint(buffer, value.Uint())
+	case reflect.Float32:
+		buffer.WriteByte(0xca)
+		binary.Write(buffer, binary.BigEndian, math.Float32bits(float32(value.Float())))
+	case reflect.Float64:
+		buffer.WriteByte(0xcb)
+		binary.Write(buffer, binary.BigEndian, math.Float64bits(value.Float()))
+	case reflect.String:
+		encodeMessagePackString(buffer, value.String())
+	case reflect.Slice:
+		if value.IsNil() {
+			buffer.WriteByte(0xc0)
+			return nil
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			encodeMessagePackBinary(buffer, value.Bytes())
+			return nil
+		}
+		return encodeMessagePackArray(buffer, value)
+	case reflect.Array:
+		return encodeMessagePackArray(buffer, value)
+	case reflect.Map:
+		if value.IsNil() {
+			buffer.WriteByte(0xc0)
+			return nil
+		}
+		encodeMessagePackLength(buffer, value.Len(), 0x80, 16, 0xde, 0xdf)
+		iterator := value.MapRange()
+		for iterator.Next() {
+			encodeMessagePackString(buffer, fmt.Sprint(iterator.Key().Interface()))
+			if err := encodeMessagePackValue(buffer, iterator.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return encodeMessagePackStruct(buffer, value)
+	default:
+		return fmt.Errorf("msgpack: unsupported type %s", value.Type())
+	}
+
+	return nil
+}
+
+// encodeJSONMarshaler handles types with custom JSON representations by
+// converting their JSON into generic values first.
+func encodeJSONMarshaler(buffer *bytes.Buffer, marshaler json.Marshaler) error {
+	data, err := marshaler.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return err
+	}
+	return encodeMessagePackValue(buffer, reflect.ValueOf(generic))
+}
+
+func encodeMessagePackInt(buffer *bytes.Buffer, number int64) {
+	switch {
+	case number >= 0:
+		encodeMessagePackUint(buffer, uint64(number))
+	case number >= -32:
+		buffer.WriteByte(byte(int8(number)))
+	case number >= math.MinInt8:
+		buffer.WriteByte(0xd0)
+		buffer.WriteByte(byte(int8(number)))
+	case number >= math.MinInt16:
+		buffer.WriteByte(0xd1)
+		binary.Write(buffer, binary.BigEndian, int16(number))
+	case number >= math.MinInt32:
+		buffer.WriteByte(0xd2)
+		binary.Write(buffer, binary.BigEndian, int32(number))
+	default:
+		buffer.WriteByte(0xd3)
+		binary.Write(buffer, binary.BigEndian, number)
+	}
+}
+
+func encodeMessagePackUint(buffer *bytes.Buffer, number uint64) {
+	switch {
+	case number <= 127:
+		buffer.WriteByte(byte(number))
+	case number <= math.MaxUint8:
+		buffer.WriteByte(0xcc)
+		buffer.WriteByte(byte(number))
+	case number <= math.MaxUint16:
+		buffer.WriteByte(0xcd)
+		binary.Write(buffer, binary.BigEndian, uint16(number))
+	case number <= math.MaxUint32:
+		buffer.WriteByte(0xce)
+		binary.Write(buffer, binary.BigEndian, uint32(number))
+	default:
+		buffer.WriteByte(0xcf)
+		binary.Write(buffer, binary.BigEndian, number)
+	}
+}
+
+func encodeMessagePackString(buffer *bytes.Buffer, text string) {
+	length := len(text)
+	switch {
+	case length < 32:
+		buffer.WriteByte(0xa0 | byte(length))
+	case length <= math.MaxUint8:
+		buffer.WriteByte(0xd9)
+		buffer.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(0xda)
+		binary.Write(buffer, binary.BigEndian, uint16(length))
+	default:
+		buffer.WriteByte(0xdb)
+		binary.Write(buffer, binary.BigEndian, uint32(length))
+	}
+	buffer.WriteString(text)
+}
+
+func encodeMessagePackBinary(buffer *bytes.Buffer, data []byte) {
+	length := len(data)
+	switch {
+	case length <= math.MaxUint8:
+		buffer.WriteByte(0xc4)
+		buffer.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(0xc5)
+		binary.Write(buffer, binary.BigEndian, uint16(length))
+	default:
+		buffer.WriteByte(0xc6)
+		binary.Write(buffer, binary.BigEndian, uint32(length))
+	}
+	buffer.Write(data)
+}
+
+// encodeMessagePackLength writes the header of an array or a map, choosing
+// the smallest possible representation.
+func encodeMessagePackLength(buffer *bytes.Buffer, length int, fixPrefix byte, fixLimit int, prefix16, prefix32 byte) {
+	switch {
+	case length < fixLimit:
+		buffer.WriteByte(fixPrefix | byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(prefix16)
+		binary.Write(buffer, binary.BigEndian, uint16(length))
+	default:
+		buffer.WriteByte(prefix32)
+		binary.Write(buffer, binary.BigEndian, uint32(length))
+	}
+}
+
+func encodeMessagePackArray(buffer *bytes.Buffer, value reflect.Value) error {
+	encodeMessagePackLength(buffer, value.Len(), 0x90, 16, 0xdc, 0xdd)
+	for i := 0; i < value.Len(); i++ {
+		if err := encodeMessagePackValue(buffer, value.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// messagePackField describes how a struct field is encoded, following the
+// rules of its json tag.
+type messagePackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+func encodeMessagePackStruct(buffer *bytes.Buffer, value reflect.Value) error {
+	fields := getMessagePackFields(value.Type())
+
+	var fieldValues []reflect.Value
+	var fieldNames []string
+	for _, field := range fields {
+		fieldValue, available := fieldByIndex(value, field.index)
+		if !available || (field.omitEmpty && isEmptyValue(fieldValue)) {
+			continue
+		}
+		fieldValues = append(fieldValues, fieldValue)
+		fieldNames = append(fieldNames, field.name)
+	}
+
+	encodeMessagePackLength(buffer, len(fieldValues), 0x80, 16, 0xde, 0xdf)
+	for index, fieldValue := range fieldValues {
+		encodeMessagePackString(buffer, fieldNames[index])
+		if err := encodeMessagePackValue(buffer, fieldValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but doesn't panic on nil
+// pointers to embedded structs.
+func fieldByIndex(value reflect.Value, index []int) (reflect.Value, bool) {
+	for i, fieldIndex := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return reflect.Value{}, false
+			}
+			value = value.Elem()
+		}
+		value = value.Field(fieldIndex)
+	}
+	return value, true
+}
+
+func getMessagePackFields(structType reflect.Type) []messagePackField {
+	cached, available := messagePackFieldCache.Load(structType)
+	if available {
+		return cached.([]messagePackField)
+	}
+
+	fields := collectMessagePackFields(structType, nil)
+	messagePackFieldCache.Store(structType, fields)
+	return fields
+}
+
+func collectMessagePackFields(structType reflect.Type, parentIndex []int) []messagePackField {
+	var fields []messagePackField
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		index := make([]int, len(parentIndex)+1)
+		copy(index, parentIndex)
+		index[len(parentIndex)] = i
+
+		tagParts := strings.Split(tag, ",")
+		if field.Anonymous && tagParts[0] == "" {
+			embeddedType := field.Type
+			if embeddedType.Kind() == reflect.Ptr {
+				embeddedType = embeddedType.Elem()
+			}
+			if embeddedType.Kind() == reflect.Struct {
+				fields = append(fields, collectMessagePackFields(embeddedType, index)...)
+				continue
+			}
+		}
+
+		//Unexported fields are never encoded.
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := tagParts[0]
+		if name == "" {
+			name = field.Name
+		}
+
+		omitEmpty := false
+		for _, option := range tagParts[1:] {
+			if option == "omitempty" {
+				omitEmpty = true
+			}
+		}
+
+		fields = append(fields, messagePackField{name: name, index: index, omitEmpty: omitEmpty})
+	}
+	return fields
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return value.IsNil()
+	}
+	return false
+}
+
+// unmarshalMessagePack decodes MessagePack data into generic values. Maps
+// become map[string]interface{}, arrays []interface{}, integers int64 or
+// uint64 and binary data []byte.
+func unmarshalMessagePack(data []byte) (interface{}, error) {
+	decoder := &messagePackDecoder{data: data}
+	value, err := decoder.decode()
+	if err != nil {
+		return nil, err
+	}
+	if decoder.offset != len(data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return value, nil
+}
+
+// unmarshalMessagePackInto decodes MessagePack data into the value the
+// target points to, following the same rules as json.Unmarshal.
+func unmarshalMessagePackInto(data []byte, target interface{}) error {
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.New("msgpack: target must be a non-nil pointer")
+	}
+
+	decoder := &messagePackDecoder{data: data}
+	if err := decoder.decodeInto(value.Elem()); err != nil {
+		return err
+	}
+	if decoder.offset != len(data) {
+		return errors.New("msgpack: trailing data")
+	}
+	return nil
+}
+
+type messagePackDecoder struct {
+	data   []byte
+	offset int
+	// depth is the amount of maps and arrays currently being decoded.
+	depth int
+}
+
+// enter has to be called before decoding the elements of a map or array
+// and leave afterwards.
+func (decoder *messagePackDecoder) enter() error {
+	decoder.depth++
+	if decoder.depth > maxMessagePackDepth {
+		return errMessagePackTooDeep
+	}
+	return nil
+}
+
+func (decoder *messagePackDecoder) leave() {
+	decoder.depth--
+}
+
+func (decoder *messagePackDecoder) read(count int) ([]byte, error) {
+	if count < 0 || decoder.offset+count > len(decoder.data) {
+		return nil, errMessagePackTruncated
+	}
+	result := decoder.data[decoder.offset : decoder.offset+count]
+	decoder.offset += count
+	return result, nil
+}
+
+func (decoder *messagePackDecoder) readUint(size int) (uint64, error) {
+	data, err := decoder.read(size)
+	if err != nil {
+		return 0, err
+	}
+
+	var result uint64
+	for _, b := range data {
+		result = result<<8 | uint64(b)
+	}
+	return result, nil
+}
+
+func (decoder *messagePackDecoder) decode() (interface{}, error) {
+	prefixBytes, err := decoder.read(1)
+	if err != nil {
+		return nil, err
+	}
+	prefix := prefixBytes[0]
+
+	switch {
+	case prefix <= 0x7f:
+		return int64(prefix), nil
+	case prefix >= 0xe0:
+		return int64(int8(prefix)), nil
+	case prefix&0xf0 == 0x80:
+		return decoder.decodeMap(int(prefix & 0x0f))
+	case prefix&0xf0 == 0x90:
+		return decoder.decodeArray(int(prefix & 0x0f))
+	case prefix&0xe0 == 0xa0:
+		return decoder.decodeString(int(prefix & 0x1f))
+	}
+
+	switch prefix {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, err := decoder.readUint(1 << (prefix - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := decoder.read(int(length))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), data...), nil
+	case 0xca:
+		bits, err := decoder.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := decoder.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return decoder.readUint(1 << (prefix - 0xcc))
+	case 0xd0:
+		number, err := decoder.readUint(1)
+		return int64(int8(number)), err
+	case 0xd1:
+		number, err := decoder.readUint(2)
+		return int64(int16(number)), err
+	case 0xd2:
+		number, err := decoder.readUint(4)
+		return int64(int32(number)), err
+	case 0xd3:
+		number, err := decoder.readUint(8)
+		return int64(number), err
+	case 0xd9, 0xda, 0xdb:
+		length, err := decoder.readUint(1 << (prefix - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.decodeString(int(length))
+	case 0xdc, 0xdd:
+		length, err := decoder.readUint(2 << (prefix - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.decodeArray(int(length))
+	case 0xde, 0xdf:
+		length, err := decoder.readUint(2 << (prefix - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.decodeMap(int(length))
+	}
+
+	return nil, fmt.Errorf("msgpack: unsupported type prefix 0x%x", prefix)
+}
+
+func (decoder *messagePackDecoder) decodeString(length int) (interface{}, error) {
+	data, err := decoder.read(length)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (decoder *messagePackDecoder) decodeArray(length int) (interface{}, error) {
+	//Every element takes at least one byte, this prevents huge allocations
+	//caused by invalid lengths.
+	if length > len(decoder.data)-decoder.offset {
+		return nil, errMessagePackTruncated
+	}
+
+	if err := decoder.enter(); err != nil {
+		return nil, err
+	}
+	defer decoder.leave()
+
+	result := make([]interface{}, 0, length)
+	for i := 0; i < length; i++ {
+		element, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, element)
+	}
+	return result, nil
+}
+
+func (decoder *messagePackDecoder) decodeMap(length int) (interface{}, error) {
+	if length > len(decoder.data)-decoder.offset {
+		return nil, errMessagePackTruncated
+	}
+
+	if err := decoder.enter(); err != nil {
+		return nil, err
+	}
+	defer decoder.leave()
+
+	result := make(map[string]interface{}, length)
+	for i := 0; i < length; i++ {
+		key, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+
+		keyAsString, isString := key.(string)
+		if !isString {
+			keyAsString = fmt.Sprint(key)
+		}
+		result[keyAsString] = value
+	}
+	return result, nil
+}
+
+// peek returns the next prefix without consuming it.
+func (decoder *messagePackDecoder) peek() (byte, error) {
+	if decoder.offset >= len(decoder.data) {
+		return 0, errMessagePackTruncated
+	}
+	return decoder.data[decoder.offset], nil
+}
+
+// readContainerLength reads the header of a map or an array and returns the
+// amount of its entries.
+func (decoder *messagePackDecoder) readContainerLength(isMap bool) (int, error) {
+	prefixBytes, err := decoder.read(1)
+	if err != nil {
+		return 0, err
+	}
+	prefix := prefixBytes[0]
+
+	var length uint64
+	switch {
+	case isMap && prefix&0xf0 == 0x80, !isMap && prefix&0xf0 == 0x90:
+		length = uint64(prefix & 0x0f)
+	case isMap && (prefix == 0xde || prefix == 0xdf):
+		length, err = decoder.readUint(2 << (prefix - 0xde))
+	case !isMap && (prefix == 0xdc || prefix == 0xdd):
+		length, err = decoder.readUint(2 << (prefix - 0xdc))
+	default:
+		if isMap {
+			return 0, fmt.Errorf("msgpack: expected map, got prefix 0x%x", prefix)
+		}
+		return 0, fmt.Errorf("msgpack: expected array, got prefix 0x%x", prefix)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	//Every entry takes at least one byte, this prevents huge allocations
+	//caused by invalid lengths.
+	if length > uint64(len(decoder.data)-decoder.offset) {
+		return 0, errMessagePackTruncated
+	}
+	return int(length), nil
+}
+
+// decodeInto decodes the next value straight into the given value, without
+// creating generic maps and arrays for structs and slices.
+func (decoder *messagePackDecoder) decodeInto(value reflect.Value) error {
+	prefix, err := decoder.peek()
+	if err != nil {
+		return err
+	}
+
+	//Just like JSON null, nil resets pointers and is ignored otherwise.
+	if prefix == 0xc0 {
+		decoder.offset++
+		switch value.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			value.Set(reflect.Zero(value.Type()))
+		}
+		return nil
+	}
+
+	if value.Kind() != reflect.Ptr && value.CanAddr() && value.Addr().Type().Implements(jsonUnmarshalerType) {
+		return decoder.decodeJSONUnmarshaler(value.Addr().Interface().(json.Unmarshaler))
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return decoder.decodeInto(value.Elem())
+	case reflect.Interface:
+		if value.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: can't decode into %s", value.Type())
+		}
+		generic, err := decoder.decode()
+		if err != nil {
+			return err
+		}
+		if generic != nil {
+			value.Set(reflect.ValueOf(generic))
+		}
+		return nil
+	case reflect.Struct:
+		return decoder.decodeStructInto(value)
+	case reflect.Slice:
+		return decoder.decodeSliceInto(value)
+	case reflect.Map:
+		return decoder.decodeMapInto(value)
+	}
+
+	generic, err := decoder.decode()
+	if err != nil {
+		return err
+	}
+	return setMessagePackScalar(value, generic)
+}
+
+// decodeJSONUnmarshaler passes the next value on to a type that decodes
+// itself from JSON, such as json.RawMessage.
+func (decoder *messagePackDecoder) decodeJSONUnmarshaler(unmarshaler json.Unmarshaler) error {
+	generic, err := decoder.decode()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return unmarshaler.UnmarshalJSON(data)
+}
+
+func (decoder *messagePackDecoder) decodeStructInto(value reflect.Value) error {
+	length, err := decoder.readContainerLength(true)
+	if err != nil {
+		return err
+	}
+	if err := decoder.enter(); err != nil {
+		return err
+	}
+	defer decoder.leave()
+
+	fields := getMessagePackFields(value.Type())
+	for i := 0; i < length; i++ {
+		var name string
+		if err := decoder.decodeInto(reflect.ValueOf(&name).Elem()); err != nil {
+			return err
+		}
+
+		field, known := findMessagePackField(fields, name)
+		if !known {
+			//Unknown fields are skipped, just like encoding/json does.
+			if _, err := decoder.decode(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := decoder.decodeInto(allocateFieldByIndex(value, field.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findMessagePackField looks up the field with the given name. Just like
+// encoding/json, an exact match is preferred, but the case is ignored
+// otherwise.
+func findMessagePackField(fields []messagePackField, name string) (messagePackField, bool) {
+	for _, field := range fields {
+		if field.name == name {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, name) {
+			return field, true
+		}
+	}
+	return messagePackField{}, false
+}
+
+// allocateFieldByIndex is like reflect.Value.FieldByIndex, but allocates
+// nil pointers to embedded structs.
+func allocateFieldByIndex(value reflect.Value, index []int) reflect.Value {
+	for i, fieldIndex := range index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				value.Set(reflect.New(value.Type().Elem()))
+			}
+			value = value.Elem()
+		}
+		value = value.Field(fieldIndex)
+	}
+	return value
+}
+
+func (decoder *messagePackDecoder) decodeSliceInto(value reflect.Value) error {
+	length, err := decoder.readContainerLength(false)
+	if err != nil {
+		return err
+	}
+	if err := decoder.enter(); err != nil {
+		return err
+	}
+	defer decoder.leave()
+
+	result := reflect.MakeSlice(value.Type(), length, length)
+	for i := 0; i < length; i++ {
+		if err := decoder.decodeInto(result.Index(i)); err != nil {
+			return err
+		}
+	}
+	value.Set(result)
+	return nil
+}
+
+func (decoder *messagePackDecoder) decodeMapInto(value reflect.Value) error {
+	if value.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("msgpack: can't decode into %s", value.Type())
+	}
+
+	length, err := decoder.readContainerLength(true)
+	if err != nil {
+		return err
+	}
+	if err := decoder.enter(); err != nil {
+		return err
+	}
+	defer decoder.leave()
+
+	if value.IsNil() {
+		value.Set(reflect.MakeMapWithSize(value.Type(), length))
+	}
+	for i := 0; i < length; i++ {
+		key := reflect.New(value.Type().Key()).Elem()
+		if err := decoder.decodeInto(key); err != nil {
+			return err
+		}
+		element := reflect.New(value.Type().Elem()).Elem()
+		if err := decoder.decodeInto(element); err != nil {
+			return err
+		}
+		value.SetMapIndex(key, element)
+	}
+	return nil
+}
+
+// setMessagePackScalar assigns a decoded string, boolean or number to the
+// given value, as long as it fits.
+func setMessagePackScalar(value reflect.Value, generic interface{}) error {
+	switch value.Kind() {
+	case reflect.String:
+		if text, isString := generic.(string); isString {
+			value.SetString(text)
+			return nil
+		}
+	case reflect.Bool:
+		if boolean, isBool := generic.(bool); isBool {
+			value.SetBool(boolean)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var number int64
+		switch typed := generic.(type) {
+		case int64:
+			number = typed
+		case uint64:
+			if typed > math.MaxInt64 {
+				return fmt.Errorf("msgpack: %d overflows %s", typed, value.Type())
+			}
+			number = int64(typed)
+		case float64:
+			if typed != math.Trunc(typed) || typed < math.MinInt64 || typed > math.MaxInt64 {
+				return fmt.Errorf("msgpack: %v isn't a valid %s", typed, value.Type())
+			}
+			number = int64(typed)
+		default:
+			return fmt.Errorf("msgpack: can't decode %T into %s", generic, value.Type())
+		}
+		if value.OverflowInt(number) {
+			return fmt.Errorf("msgpack: %d overflows %s", number, value.Type())
+		}
+		value.SetInt(number)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var number uint64
+		switch typed := generic.(type) {
+		case int64:
+			if typed < 0 {
+				return fmt.Errorf("msgpack: %d overflows %s", typed, value.Type())
+			}
+			number = uint64(typed)
+		case uint64:
+			number = typed
+		case float64:
+			if typed != math.Trunc(typed) || typed < 0 || typed > math.MaxUint64 {
+				return fmt.Errorf("msgpack: %v isn't a valid %s", typed, value.Type())
+			}
+			number = uint64(typed)
+		default:
+			return fmt.Errorf("msgpack: can't decode %T into %s", generic, value.Type())
+		}
+		if value.OverflowUint(number) {
+			return fmt.Errorf("msgpack: %d overflows %s", number, value.Type())
+		}
+		value.SetUint(number)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		var number float64
+		switch typed := generic.(type) {
+		case int64:
+			number = float64(typed)
+		case uint64:
+			number = float64(typed)
+		case float64:
+			number = typed
+		default:
+			return fmt.Errorf("msgpack: can't decode %T into %s", generic, value.Type())
+		}
+		if value.OverflowFloat(number) {
+			return fmt.Errorf("msgpack: %v overflows %s", number, value.Type())
+		}
+		value.SetFloat(number)
+		return nil
+	}
+
+	return fmt.Errorf("msgpack: can't decode %T into %s", generic, value.Type())
+}
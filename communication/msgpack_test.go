@@ -0,0 +1,255 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+func Test_marshalMessagePack(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"true", true, []byte{0xc3}},
+		{"positive fixint", 5, []byte{0x05}},
+		{"negative fixint", -1, []byte{0xff}},
+		{"uint8", 200, []byte{0xcc, 0xc8}},
+		{"int16", -300, []byte{0xd1, 0xfe, 0xd4}},
+		{"fixstr", "abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{"fixarray", []int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{"nil slice", []int(nil), []byte{0xc0}},
+		{"struct with tags", struct {
+			Type    string `json:"type"`
+			Skipped string `json:"-"`
+			Empty   string `json:"empty,omitempty"`
+			hidden  string
+		}{Type: "a", Skipped: "b", hidden: "c"}, []byte{0x81, 0xa4, 't', 'y', 'p', 'e', 0xa1, 'a'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := marshalMessagePack(tt.value)
+			if err != nil {
+				t.Fatalf("marshalMessagePack() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("marshalMessagePack() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_messagePackMatchesJSON(t *testing.T) {
+	event := &game.GameEvent{
+		Type: "update-players",
+		Data: []*game.Player{
+			{ID: "1", Name: "Marcel", Color: "#ff0000", Score: 300, Connected: true, Latency: -5},
+			{ID: "2", Name: strings.Repeat("a", 300), Score: 70000, State: game.Drawing},
+		},
+	}
+
+	packed, err := marshalMessagePack(event)
+	if err != nil {
+		t.Fatalf("marshalMessagePack() error = %v", err)
+	}
+	generic, err := unmarshalMessagePack(packed)
+	if err != nil {
+		t.Fatalf("unmarshalMessagePack() error = %v", err)
+	}
+
+	//Comparing the JSON representations makes sure that both formats
+	//have the same structure.
+	fromMessagePack, _ := json.Marshal(generic)
+	var gotJSON, wantJSON interface{}
+	json.Unmarshal(fromMessagePack, &gotJSON)
+	directJSON, _ := json.Marshal(event)
+	json.Unmarshal(directJSON, &wantJSON)
+
+	if !reflect.DeepEqual(gotJSON, wantJSON) {
+		t.Errorf("msgpack structure differs from JSON:\n%s\n%s", fromMessagePack, directJSON)
+	}
+}
+
+func Test_unmarshalMessagePackInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated string", []byte{0xa3, 'a'}},
+		{"oversized array", []byte{0xdd, 0xff, 0xff, 0xff, 0xff}},
+		{"trailing data", []byte{0x01, 0x02}},
+		{"unsupported prefix", []byte{0xc1}},
+		{"too deep", append(bytes.Repeat([]byte{0x91}, maxMessagePackDepth+1), 0x01)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := unmarshalMessagePack(tt.data); err == nil {
+				t.Errorf("unmarshalMessagePack() expected error for %x", tt.data)
+			}
+		})
+	}
+}
+
+func Test_decodeEvent(t *testing.T) {
+	packed, _ := marshalMessagePack(map[string]interface{}{
+		"type": "message",
+		"data": "hello",
+	})
+
+	event, err := decodeEvent(encodingMessagePack, websocket.BinaryMessage, packed)
+	if err != nil {
+		t.Fatalf("decodeEvent() error = %v", err)
+	}
+	var text string
+	if err := event.DecodeData(&text); err != nil || event.Type != game.EventTypeMessage || text != "hello" {
+		t.Errorf("decodeEvent() = %+v, %s (%v)", event, text, err)
+	}
+
+	packed, _ = marshalMessagePack(map[string]interface{}{"type": "start"})
+	event, err = decodeEvent(encodingMessagePack, websocket.BinaryMessage, packed)
+	if err != nil {
+		t.Fatalf("decodeEvent() error = %v", err)
+	}
+	var missing *string
+	if err := event.DecodeData(&missing); err != nil || event.Type != game.EventTypeStart || missing != nil {
+		t.Errorf("expected missing data to be decoded as nil, got %v (%v)", missing, err)
+	}
+
+	if _, err := decodeEvent(encodingJSON, websocket.BinaryMessage, packed); err == nil {
+		t.Error("decodeEvent() accepted binary message for json encoding")
+	}
+}
+
+func Test_unmarshalMessagePackInto(t *testing.T) {
+	line := &game.Line{FromX: 1.5, FromY: 2, ToX: 300, ToY: 4, Color: "#ff00ff", LineWidth: 8}
+	packed, _ := marshalMessagePack(line)
+	var decodedLine *game.Line
+	if err := unmarshalMessagePackInto(packed, &decodedLine); err != nil {
+		t.Fatalf("unmarshalMessagePackInto() error = %v", err)
+	}
+	if !reflect.DeepEqual(decodedLine, line) {
+		t.Errorf("unmarshalMessagePackInto() = %+v, want %+v", decodedLine, line)
+	}
+
+	//Payloads that are relayed as JSON are converted, unknown fields are
+	//skipped and field names are matched regardless of their case.
+	packed, _ = marshalMessagePack(map[string]interface{}{
+		"TARGET":  "a",
+		"payload": map[string]interface{}{"sdp": "v=0"},
+		"unknown": []interface{}{1, 2},
+	})
+	message := &game.SignalingMessage{}
+	if err := unmarshalMessagePackInto(packed, message); err != nil {
+		t.Fatalf("unmarshalMessagePackInto() error = %v", err)
+	}
+	if message.Target != "a" || string(message.Payload) != `{"sdp":"v=0"}` {
+		t.Errorf("unexpected signaling message %+v", message)
+	}
+
+	packed, _ = marshalMessagePack(map[string]interface{}{"fromX": "left"})
+	if err := unmarshalMessagePackInto(packed, &game.Line{}); err == nil {
+		t.Error("string has been decoded into a number")
+	}
+
+	var index int8
+	packed, _ = marshalMessagePack(300)
+	if err := unmarshalMessagePackInto(packed, &index); err == nil {
+		t.Error("overflowing number has been accepted")
+	}
+
+	var nested []interface{}
+	deep := append(bytes.Repeat([]byte{0x91}, maxMessagePackDepth+1), 0x01)
+	if err := unmarshalMessagePackInto(deep, &nested); err != errMessagePackTooDeep {
+		t.Errorf("unmarshalMessagePackInto() error = %v, want %v", err, errMessagePackTooDeep)
+	}
+}
+
+func Test_negotiateEncoding(t *testing.T) {
+	tests := []struct {
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{"", encodingJSON, false},
+		{"json", encodingJSON, false},
+		{" MsgPack ", encodingMessagePack, false},
+		{"xml", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requested, func(t *testing.T) {
+			got, err := negotiateEncoding(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("negotiateEncoding() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("negotiateEncoding() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_preparedMessageEncodesOnce(t *testing.T) {
+	message := newPreparedMessage(&game.GameEvent{Type: "line", Data: []int{1, 2, 3}})
+	if message.eventType != "line" {
+		t.Errorf("eventType = %s, want line", message.eventType)
+	}
+
+	first, _ := message.encode(encodingJSON)
+	second, _ := message.encode(encodingJSON)
+	if &first.data[0] != &second.data[0] {
+		t.Error("message was encoded twice for the same encoding")
+	}
+
+	packed, _ := message.encode(encodingMessagePack)
+	if packed.messageType != websocket.BinaryMessage || first.messageType != websocket.TextMessage {
+		t.Error("wrong message types for encodings")
+	}
+}
+
+func Test_withSequence(t *testing.T) {
+	event := &game.GameEvent{Type: "message", Data: "hi"}
+	message := newPreparedMessage(event)
+
+	encoded, _ := message.encode(encodingJSON)
+	sequenced := withSequence(encodingJSON, encoded, 42)
+	if string(sequenced.data) != `{"seq":42,"type":"message","data":"hi"}` {
+		t.Errorf("unexpected JSON %s", sequenced.data)
+	}
+
+	packed, _ := message.encode(encodingMessagePack)
+	sequenced = withSequence(encodingMessagePack, packed, 300)
+	generic, err := unmarshalMessagePack(sequenced.data)
+	if err != nil {
+		t.Fatalf("unmarshalMessagePack() error = %v", err)
+	}
+	want := map[string]interface{}{"seq": uint64(300), "type": "message", "data": "hi"}
+	if !reflect.DeepEqual(generic, want) {
+		t.Errorf("unexpected msgpack %v", generic)
+	}
+
+	notAnObject := outgoingMessage{messageType: websocket.TextMessage, data: []byte(`"text"`)}
+	if unchanged := withSequence(encodingJSON, notAnObject, 1); !bytes.Equal(unchanged.data, notAnObject.data) {
+		t.Errorf("non-object was changed to %s", unchanged.data)
+	}
+}
+
+func Test_parseResumeSequence(t *testing.T) {
+	if _, resume := parseResumeSequence(""); resume {
+		t.Error("empty value shouldn't resume")
+	}
+	if _, resume := parseResumeSequence("-1"); resume {
+		t.Error("negative value shouldn't resume")
+	}
+	if sequence, resume := parseResumeSequence("17"); !resume || sequence != 17 {
+		t.Errorf("expected 17, got %d, %v", sequence, resume)
+	}
+}
@@ -0,0 +1,193 @@
+package communication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuthProvider allows players to log in via an external account, using the
+// OAuth 2.0 authorization code flow.
+type OAuthProvider struct {
+	name         string
+	clientID     string
+	clientSecret string
+	authURL      string
+	tokenURL     string
+	userURL      string
+	scope        string
+	// parseUser decodes the response of the userURL.
+	parseUser func(response *http.Response) (*oauthUser, error)
+	client    *http.Client
+}
+
+// oauthUser is the user logged in at the provider.
+type oauthUser struct {
+	subject string
+	name    string
+	avatar  string
+}
+
+// NewOAuthProvider creates a provider for logging in. The name is either
+// "discord" or "google". The client is registered at the provider, using
+// <publicURL>/login/callback/<name> as the redirect URL.
+func NewOAuthProvider(name, clientID, clientSecret string) (*OAuthProvider, error) {
+	provider := &OAuthProvider{
+		name:         name,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+
+	switch name {
+	case "discord":
+		provider.authURL = "https://discord.com/api/oauth2/authorize"
+		provider.tokenURL = "https://discord.com/api/oauth2/token"
+		provider.userURL = "https://discord.com/api/users/@me"
+		provider.scope = "identify"
+		provider.parseUser = parseDiscordUser
+	case "google":
+		provider.authURL = "https://accounts.google.com/o/oauth2/v2/auth"
+		provider.tokenURL = "https://oauth2.googleapis.com/token"
+		provider.userURL = "https://openidconnect.googleapis.com/v1/userinfo"
+		provider.scope = "openid profile"
+		provider.parseUser = parseGoogleUser
+	default:
+		return nil, fmt.Errorf("unknown OAuth provider '%s', expected discord or google", name)
+	}
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("%s requires a client ID and a client secret", name)
+	}
+
+	return provider, nil
+}
+
+// Name returns the name the provider has been created with.
+func (provider *OAuthProvider) Name() string {
+	return provider.name
+}
+
+// authCodeURL is where the player is sent for logging in. Afterwards, the
+// provider redirects back to the redirectURL, passing the state along.
+func (provider *OAuthProvider) authCodeURL(state, redirectURL string) string {
+	query := url.Values{
+		"client_id":     {provider.clientID},
+		"redirect_uri":  {redirectURL},
+		"response_type": {"code"},
+		"scope":         {provider.scope},
+		"state":         {state},
+	}
+	return provider.authURL + "?" + query.Encode()
+}
+
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// exchange redeems the code passed to the redirectURL and asks the provider
+// who has logged in. The access token is only used once and then dropped.
+func (provider *OAuthProvider) exchange(ctx context.Context, code, redirectURL string) (*oauthUser, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {provider.clientID},
+		"client_secret": {provider.clientSecret},
+	}
+	request, requestError := http.NewRequestWithContext(ctx, http.MethodPost, provider.tokenURL, strings.NewReader(form.Encode()))
+	if requestError != nil {
+		return nil, requestError
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	response, responseError := provider.client.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered the token request with status %d", provider.name, response.StatusCode)
+	}
+
+	token := &oauthTokenResponse{}
+	if decodeError := json.NewDecoder(response.Body).Decode(token); decodeError != nil {
+		return nil, decodeError
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("%s didn't issue an access token", provider.name)
+	}
+
+	return provider.fetchUser(ctx, token.AccessToken)
+}
+
+func (provider *OAuthProvider) fetchUser(ctx context.Context, accessToken string) (*oauthUser, error) {
+	request, requestError := http.NewRequestWithContext(ctx, http.MethodGet, provider.userURL, nil)
+	if requestError != nil {
+		return nil, requestError
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+
+	response, responseError := provider.client.Do(request)
+	if responseError != nil {
+		return nil, responseError
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered the user request with status %d", provider.name, response.StatusCode)
+	}
+
+	user, parseError := provider.parseUser(response)
+	if parseError != nil {
+		return nil, parseError
+	}
+	if user.subject == "" {
+		return nil, fmt.Errorf("%s didn't tell who has logged in", provider.name)
+	}
+	return user, nil
+}
+
+type discordUser struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	GlobalName string `json:"global_name"`
+	Avatar     string `json:"avatar"`
+}
+
+func parseDiscordUser(response *http.Response) (*oauthUser, error) {
+	discord := &discordUser{}
+	if decodeError := json.NewDecoder(response.Body).Decode(discord); decodeError != nil {
+		return nil, decodeError
+	}
+
+	user := &oauthUser{subject: discord.ID, name: discord.GlobalName}
+	if user.name == "" {
+		user.name = discord.Username
+	}
+	//Discord only passes the hash of the avatar, users without one use the
+	//default avatar, which isn't worth showing.
+	if discord.Avatar != "" {
+		user.avatar = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", discord.ID, discord.Avatar)
+	}
+	return user, nil
+}
+
+type googleUser struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+}
+
+func parseGoogleUser(response *http.Response) (*oauthUser, error) {
+	google := &googleUser{}
+	if decodeError := json.NewDecoder(response.Body).Decode(google); decodeError != nil {
+		return nil, decodeError
+	}
+
+	return &oauthUser{subject: google.Subject, name: google.Name, avatar: google.Picture}, nil
+}
@@ -0,0 +1,324 @@
+package communication
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+const (
+	// pollTimeout is the maximum time a poll request waits for events,
+	// before an empty response is sent. It has to be shorter than the idle
+	// timeouts of common proxies.
+	pollTimeout = 25 * time.Second
+	// pollExpiry is the time after which a client that hasn't polled again
+	// is considered disconnected.
+	pollExpiry = 30 * time.Second
+	// maxPostedEventSize is the maximum size of a single event sent via a
+	// POST request.
+	maxPostedEventSize = 64 * 1024
+)
+
+// pollConnection is a fallback transport for environments where websockets
+// are blocked. The client receives events by repeatedly polling for them
+// and sends events via separate requests. Only JSON is supported.
+type pollConnection struct {
+	id        string
+	lobby     *game.Lobby
+	player    *game.Player
+	sendQueue *sendQueue
+
+	// inboundMutex makes sure that events sent via concurrent requests are
+	// handled one after another, just like events sent via a websocket.
+	inboundMutex *sync.Mutex
+	rateLimiter  *inboundRateLimiter
+
+	mutex *sync.Mutex
+	// polling is set while a poll request is waiting for events. Only one
+	// request may poll at a time.
+	polling  bool
+	lastPoll time.Time
+
+	disconnected   chan struct{}
+	disconnectOnce *sync.Once
+}
+
+var (
+	// pollConnections contains all open poll connections by their ID.
+	pollConnections      = make(map[string]*pollConnection)
+	pollConnectionsMutex = &sync.RWMutex{}
+)
+
+// pollResponse is the response to a poll request.
+type pollResponse struct {
+	// ConnectionID has to be passed along with all further requests.
+	ConnectionID string `json:"connectionId"`
+	// Events contains the events in the same format as they would have been
+	// sent via a websocket. It's empty if the request timed out.
+	Events []json.RawMessage `json:"events"`
+	// Close is set if the server has closed the connection. No further
+	// requests may be sent using the same connection ID.
+	Close *pollClose `json:"close,omitempty"`
+}
+
+// pollClose contains the same information as the close frame of a websocket.
+type pollClose struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// pollEndpoint is the long-polling alternative to wsEndpoint. A GET request
+// waits for events, while a POST request sends a single event. The first
+// GET request, which mustn't contain a connection_id, opens the connection.
+func pollEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	lobby, player := getConnectingPlayer(w, r)
+	if player == nil {
+		return
+	}
+
+	//Responses must never be cached, as each poll returns different events.
+	w.Header().Set("Cache-Control", "no-store")
+
+	connectionID := r.URL.Query().Get("connection_id")
+	if connectionID == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "the connection_id is missing", http.StatusBadRequest)
+			return
+		}
+
+		openPollConnection(w, r, lobby, player)
+		return
+	}
+
+	connection := getPollConnection(connectionID)
+	if connection == nil || connection.player != player {
+		http.Error(w, "the connection has been closed", http.StatusGone)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		connection.poll(w, r)
+	} else {
+		connection.receive(w, r)
+	}
+}
+
+func openPollConnection(w http.ResponseWriter, r *http.Request, lobby *game.Lobby, player *game.Player) {
+	protocolVersion, versionError := negotiateProtocolVersion(r.URL.Query().Get("protocol_version"))
+	if versionError != nil {
+		http.Error(w, versionError.Error(), http.StatusUpgradeRequired)
+		return
+	}
+
+	if !lobby.CanReconnect(player) {
+		writePollResponse(w, &pollResponse{Close: &pollClose{
+			Code:   game.CloseCodeLobbyFull,
+			Reason: "Your slot has been taken, since you have been gone for too long.",
+		}})
+		return
+	}
+
+	connection := &pollConnection{
+		id:             uuid.Must(uuid.NewV4()).String(),
+		lobby:          lobby,
+		player:         player,
+		inboundMutex:   &sync.Mutex{},
+		rateLimiter:    newInboundRateLimiter(time.Now()),
+		mutex:          &sync.Mutex{},
+		lastPoll:       time.Now(),
+		disconnected:   make(chan struct{}),
+		disconnectOnce: &sync.Once{},
+	}
+	connection.sendQueue = newSendQueue(newDrawingSnapshot(lobby, player), connection.disconnect)
+
+	pollConnectionsMutex.Lock()
+	pollConnections[connection.id] = connection
+	pollConnectionsMutex.Unlock()
+
+	lobby.PlayerLogger(player).Info("player connected via polling", "name", player.Name)
+
+	player.SetProtocolVersion(protocolVersion)
+	setMessageEncoding(player, encodingJSON)
+	attachConnection(lobby, player, connection, r.URL.Query().Get("resume_from"))
+	go connection.expire()
+
+	//The first response already contains the initial events, such as the
+	//ready event.
+	connection.poll(w, r)
+}
+
+func getPollConnection(id string) *pollConnection {
+	pollConnectionsMutex.RLock()
+	defer pollConnectionsMutex.RUnlock()
+
+	return pollConnections[id]
+}
+
+func (connection *pollConnection) queue() *sendQueue {
+	return connection.sendQueue
+}
+
+// Close queues the given code and reason, which will be delivered by the
+// next poll. Afterwards the connection is closed.
+func (connection *pollConnection) Close(code int, reason string) {
+	closeMessage := outgoingMessage{messageType: websocket.CloseMessage, data: formatCloseMessage(code, reason)}
+	if connection.sendQueue.enqueue(closeMessage, false) == errConnectionClosed {
+		//This is called while holding the players connection mutex, which
+		//is required for handling the disconnect.
+		go connection.disconnect()
+	}
+}
+
+// disconnect closes the connection and notifies the game, unless the
+// player has established another connection in the meantime.
+func (connection *pollConnection) disconnect() {
+	connection.disconnectOnce.Do(func() {
+		connection.sendQueue.close()
+		close(connection.disconnected)
+
+		pollConnectionsMutex.Lock()
+		delete(pollConnections, connection.id)
+		pollConnectionsMutex.Unlock()
+
+		handleConnectionLost(connection.lobby, connection.player, connection)
+	})
+}
+
+// expire disconnects the client as soon as it stops polling, since there's
+// no other way of noticing that it's gone.
+func (connection *pollConnection) expire() {
+	expiryTicker := time.NewTicker(pollExpiry / 3)
+	defer expiryTicker.Stop()
+
+	for {
+		select {
+		case <-connection.disconnected:
+			return
+		case <-expiryTicker.C:
+			connection.mutex.Lock()
+			expired := !connection.polling && time.Since(connection.lastPoll) > pollExpiry
+			connection.mutex.Unlock()
+
+			if expired {
+				connection.lobby.PlayerLogger(connection.player).Info("player stopped polling, closing connection", "name", connection.player.Name)
+				connection.disconnect()
+				return
+			}
+		}
+	}
+}
+
+// poll waits until events have been queued or pollTimeout has passed and
+// writes all queued events. If the request is cancelled after events have
+// been taken from the queue, they are lost. The client has to reconnect and
+// resume its session in that case.
+func (connection *pollConnection) poll(w http.ResponseWriter, r *http.Request) {
+	connection.mutex.Lock()
+	if connection.polling {
+		connection.mutex.Unlock()
+		http.Error(w, "another request is already polling", http.StatusConflict)
+		return
+	}
+	connection.polling = true
+	connection.mutex.Unlock()
+
+	defer func() {
+		connection.mutex.Lock()
+		connection.polling = false
+		connection.lastPoll = time.Now()
+		connection.mutex.Unlock()
+	}()
+
+	timeout := time.NewTimer(pollTimeout)
+	defer timeout.Stop()
+
+	var messages []outgoingMessage
+	for {
+		var open bool
+		messages, open = connection.sendQueue.take()
+		if !open {
+			http.Error(w, "the connection has been closed", http.StatusGone)
+			return
+		}
+		if len(messages) > 0 {
+			break
+		}
+
+		select {
+		case <-connection.sendQueue.signal:
+		case <-timeout.C:
+			writePollResponse(w, &pollResponse{ConnectionID: connection.id, Events: []json.RawMessage{}})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	response := &pollResponse{
+		ConnectionID: connection.id,
+		Events:       make([]json.RawMessage, 0, len(messages)),
+	}
+	for _, message := range messages {
+		//After a close message, nothing may be sent anymore.
+		if message.messageType == websocket.CloseMessage {
+			code, reason := parseCloseMessage(message.data)
+			response.Close = &pollClose{Code: code, Reason: reason}
+			break
+		}
+
+		response.Events = append(response.Events, json.RawMessage(message.data))
+	}
+
+	writePollResponse(w, response)
+	connection.sendQueue.recycle(messages)
+	if response.Close != nil {
+		connection.disconnect()
+	}
+}
+
+// receive handles a single event sent by the client. Problems with the
+// event are reported via an error event, just like for websockets.
+func (connection *pollConnection) receive(w http.ResponseWriter, r *http.Request) {
+	data, readError := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPostedEventSize))
+	if readError != nil {
+		http.Error(w, readError.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	connection.inboundMutex.Lock()
+	handleInboundMessage(connection.lobby, connection.player, connection.rateLimiter, websocket.TextMessage, data)
+	connection.inboundMutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writePollResponse(w http.ResponseWriter, response *pollResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if encodingError := json.NewEncoder(w).Encode(response); encodingError != nil {
+		logging.Debug("error writing poll response", "error", encodingError)
+	}
+}
+
+// parseCloseMessage extracts the code and reason from the payload of a
+// websocket close frame, as created by formatCloseMessage.
+func parseCloseMessage(data []byte) (int, string) {
+	if len(data) < 2 {
+		return websocket.CloseNoStatusReceived, ""
+	}
+
+	return int(binary.BigEndian.Uint16(data)), string(data[2:])
+}
@@ -0,0 +1,66 @@
+package communication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+func newTestPollConnection() *pollConnection {
+	return &pollConnection{
+		id:             "test",
+		player:         game.NewSpectator(),
+		sendQueue:      newSendQueue(nil, func() {}),
+		mutex:          &sync.Mutex{},
+		disconnected:   make(chan struct{}),
+		disconnectOnce: &sync.Once{},
+	}
+}
+
+func Test_pollConnectionDeliversEvents(t *testing.T) {
+	connection := newTestPollConnection()
+	connection.sendQueue.enqueue(outgoingMessage{messageType: websocket.TextMessage, data: []byte(`{"type":"a"}`)}, false)
+	connection.sendQueue.enqueue(outgoingMessage{messageType: websocket.TextMessage, data: []byte(`{"type":"b"}`)}, false)
+
+	recorder := httptest.NewRecorder()
+	connection.poll(recorder, httptest.NewRequest(http.MethodGet, "/v1/lobby/poll", nil))
+
+	response := &pollResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("invalid response %s: %s", recorder.Body.Bytes(), err)
+	}
+	if response.ConnectionID != "test" || len(response.Events) != 2 || response.Close != nil {
+		t.Errorf("unexpected response %s", recorder.Body.Bytes())
+	}
+}
+
+func Test_pollConnectionDeliversClose(t *testing.T) {
+	connection := newTestPollConnection()
+	connection.sendQueue.enqueue(outgoingMessage{messageType: websocket.TextMessage, data: []byte(`{"type":"a"}`)}, false)
+	connection.Close(game.CloseCodeKicked, "bye")
+
+	recorder := httptest.NewRecorder()
+	connection.poll(recorder, httptest.NewRequest(http.MethodGet, "/v1/lobby/poll", nil))
+
+	response := &pollResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("invalid response %s: %s", recorder.Body.Bytes(), err)
+	}
+	if len(response.Events) != 1 || response.Close == nil ||
+		response.Close.Code != game.CloseCodeKicked || response.Close.Reason != "bye" {
+		t.Errorf("unexpected response %s", recorder.Body.Bytes())
+	}
+
+	//The connection is unusable afterwards.
+	recorder = httptest.NewRecorder()
+	connection.poll(recorder, httptest.NewRequest(http.MethodGet, "/v1/lobby/poll", nil))
+	if recorder.Code != http.StatusGone {
+		t.Errorf("expected status %d, got %d", http.StatusGone, recorder.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package communication
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proofOfWorkValidity is how long a challenge can be solved and used.
+const proofOfWorkValidity = 10 * time.Minute
+
+var (
+	errInvalidChallenge = errors.New("invalid or expired proof-of-work challenge")
+	errChallengeUsed    = errors.New("the proof-of-work challenge has already been used")
+	errInsufficientWork = errors.New("the proof-of-work solution doesn't meet the difficulty")
+)
+
+// ProofOfWorkVerifier makes clients search for a nonce, so that the SHA-256
+// hash of the challenge and the nonce starts with a certain amount of zero
+// bits. This doesn't require a third party, but only slows down abuse.
+//
+// Challenges are signed instead of being stored, so only challenges that
+// have already been used are kept until they expire.
+type ProofOfWorkVerifier struct {
+	key        []byte
+	difficulty int
+
+	mutex *sync.Mutex
+	used  map[string]time.Time
+}
+
+// NewProofOfWorkVerifier creates a verifier that requires the given amount
+// of leading zero bits. Each additional bit doubles the average effort.
+func NewProofOfWorkVerifier(difficulty int) (*ProofOfWorkVerifier, error) {
+	if difficulty < 1 || difficulty > 32 {
+		return nil, fmt.Errorf("the proof-of-work difficulty must be between 1 and 32, but was %d", difficulty)
+	}
+
+	key := make([]byte, 32)
+	if _, randError := rand.Read(key); randError != nil {
+		return nil, randError
+	}
+
+	return &ProofOfWorkVerifier{
+		key:        key,
+		difficulty: difficulty,
+		mutex:      &sync.Mutex{},
+		used:       make(map[string]time.Time),
+	}, nil
+}
+
+// NewChallenge creates a signed challenge in the format
+// "random.expiry.difficulty.signature".
+func (verifier *ProofOfWorkVerifier) NewChallenge() (*VerificationChallenge, error) {
+	random := make([]byte, 16)
+	if _, randError := rand.Read(random); randError != nil {
+		return nil, randError
+	}
+
+	payload := fmt.Sprintf("%s.%d.%d",
+		base64.RawURLEncoding.EncodeToString(random),
+		time.Now().Add(proofOfWorkValidity).Unix(),
+		verifier.difficulty)
+	return &VerificationChallenge{
+		Type:       "pow",
+		Challenge:  payload + "." + verifier.sign(payload),
+		Difficulty: verifier.difficulty,
+	}, nil
+}
+
+func (verifier *ProofOfWorkVerifier) sign(payload string) string {
+	mac := hmac.New(sha256.New, verifier.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a solution in the format "challenge:nonce". Each challenge
+// can only be used once.
+func (verifier *ProofOfWorkVerifier) Verify(solution, address string) error {
+	separator := strings.LastIndex(solution, ":")
+	if separator == -1 {
+		return errInvalidChallenge
+	}
+	challenge := solution[:separator]
+
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 {
+		return errInvalidChallenge
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(verifier.sign(payload))) {
+		return errInvalidChallenge
+	}
+	expiry, expiryError := strconv.ParseInt(parts[1], 10, 64)
+	if expiryError != nil {
+		return errInvalidChallenge
+	}
+	now := time.Now()
+	if now.Unix() > expiry {
+		return errInvalidChallenge
+	}
+	difficulty, difficultyError := strconv.Atoi(parts[2])
+	if difficultyError != nil {
+		return errInvalidChallenge
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(solution))) < difficulty {
+		return errInsufficientWork
+	}
+
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
+	for usedChallenge, usedExpiry := range verifier.used {
+		if now.After(usedExpiry) {
+			delete(verifier.used, usedChallenge)
+		}
+	}
+	if _, used := verifier.used[challenge]; used {
+		return errChallengeUsed
+	}
+	verifier.used[challenge] = time.Unix(expiry, 0)
+
+	return nil
+}
+
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	var zeroBits int
+	for _, b := range hash {
+		if b != 0 {
+			return zeroBits + bits.LeadingZeros8(b)
+		}
+		zeroBits += 8
+	}
+	return zeroBits
+}
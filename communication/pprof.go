@@ -0,0 +1,131 @@
+package communication
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultProfileSeconds = 30
+	// maxProfileSeconds is the longest CPU profile or trace that can be
+	// captured, since each request blocks for the whole duration.
+	maxProfileSeconds = 300
+)
+
+// profilingEnabled is set to 1 if the profiling endpoints are served. It's
+// accessed atomically, since it can be changed by reloading the
+// configuration.
+var profilingEnabled int32
+
+// ConfigureProfiling decides whether profiles can be captured via
+// /v1/admin/pprof/, which additionally requires the admin API to be enabled.
+// The endpoints behave like the ones of net/http/pprof, but that package
+// isn't used, since it registers its handlers without any authentication.
+func ConfigureProfiling(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&profilingEnabled, value)
+}
+
+// profilingEndpoint serves the CPU profile via 'profile', an execution trace
+// via 'trace' and all other profiles, such as 'heap' or 'goroutine', by
+// their name. Without a name, the available profiles are listed. The
+// query parameter 'seconds' controls the duration of CPU profiles and
+// traces, while 'debug' is passed on to named profiles.
+func profilingEndpoint(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&profilingEnabled) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/admin/pprof/")
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "profile")
+		fmt.Fprintln(w, "trace")
+		for _, profile := range pprof.Profiles() {
+			fmt.Fprintln(w, profile.Name())
+		}
+	case "profile":
+		duration, ok := parseProfileDuration(w, r)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+		if err := pprof.StartCPUProfile(w); err != nil {
+			//Only one CPU profile can be captured at a time.
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		waitForProfile(r, duration)
+		pprof.StopCPUProfile()
+	case "trace":
+		duration, ok := parseProfileDuration(w, r)
+		if !ok {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+		if err := trace.Start(w); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		waitForProfile(r, duration)
+		trace.Stop()
+	default:
+		profile := pprof.Lookup(name)
+		if profile == nil {
+			http.Error(w, "unknown profile", http.StatusNotFound)
+			return
+		}
+
+		debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		}
+		if err := profile.WriteTo(w, debug); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func parseProfileDuration(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	seconds := defaultProfileSeconds
+	if value := r.URL.Query().Get("seconds"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxProfileSeconds {
+			http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", maxProfileSeconds), http.StatusBadRequest)
+			return 0, false
+		}
+		seconds = parsed
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// waitForProfile returns once the duration has passed or the client has
+// gone away.
+func waitForProfile(r *http.Request, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
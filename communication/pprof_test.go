@@ -0,0 +1,44 @@
+package communication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_profilingEndpoint(t *testing.T) {
+	defer ConfigureProfiling(false)
+
+	tests := []struct {
+		name    string
+		enabled bool
+		path    string
+		want    int
+	}{
+		{"disabled", false, "/v1/admin/pprof/heap", http.StatusNotFound},
+		{"index", true, "/v1/admin/pprof/", http.StatusOK},
+		{"heap", true, "/v1/admin/pprof/heap", http.StatusOK},
+		{"goroutines as text", true, "/v1/admin/pprof/goroutine?debug=1", http.StatusOK},
+		{"unknown profile", true, "/v1/admin/pprof/unknown", http.StatusNotFound},
+		{"cpu profile", true, "/v1/admin/pprof/profile?seconds=1", http.StatusOK},
+		{"too long", true, "/v1/admin/pprof/profile?seconds=3600", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ConfigureProfiling(tt.enabled)
+			recorder := httptest.NewRecorder()
+			profilingEndpoint(recorder, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if recorder.Code != tt.want {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.want)
+			}
+		})
+	}
+
+	ConfigureProfiling(true)
+	recorder := httptest.NewRecorder()
+	profilingEndpoint(recorder, httptest.NewRequest(http.MethodGet, "/v1/admin/pprof/", nil))
+	if !strings.Contains(recorder.Body.String(), "heap") {
+		t.Errorf("expected heap profile to be listed, got %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,374 @@
+package communication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//This file contains a minimal Protocol Buffers (https://protobuf.dev/)
+//codec, just enough for the messages of the gRPC API. Each message encodes
+//and decodes its fields by hand, as described in api/scribblers.proto.
+//Unknown fields are skipped, so that clients may use newer versions of the
+//definition.
+
+const (
+	protoWireVarint          = 0
+	protoWireFixed64         = 1
+	protoWireLengthDelimited = 2
+	protoWireFixed32         = 5
+)
+
+var errProtoTruncated = errors.New("protobuf: unexpected end of data")
+
+// protoEncoder appends fields to a message. Fields containing the default
+// value are left out, as required by proto3.
+type protoEncoder struct {
+	data []byte
+}
+
+func (encoder *protoEncoder) tag(field, wireType int) {
+	encoder.varint(uint64(field<<3 | wireType))
+}
+
+func (encoder *protoEncoder) varint(value uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	length := binary.PutUvarint(scratch[:], value)
+	encoder.data = append(encoder.data, scratch[:length]...)
+}
+
+// int writes an int32 field. Negative values are sign extended to 64 bits.
+func (encoder *protoEncoder) int(field, value int) {
+	if value == 0 {
+		return
+	}
+	encoder.tag(field, protoWireVarint)
+	encoder.varint(uint64(int64(value)))
+}
+
+func (encoder *protoEncoder) bool(field int, value bool) {
+	if value {
+		encoder.tag(field, protoWireVarint)
+		encoder.varint(1)
+	}
+}
+
+func (encoder *protoEncoder) bytes(field int, value []byte) {
+	encoder.tag(field, protoWireLengthDelimited)
+	encoder.varint(uint64(len(value)))
+	encoder.data = append(encoder.data, value...)
+}
+
+func (encoder *protoEncoder) string(field int, value string) {
+	if value != "" {
+		encoder.bytes(field, []byte(value))
+	}
+}
+
+// strings writes a repeated string field. Unlike single values, empty
+// strings have to be written, since they are part of the list.
+func (encoder *protoEncoder) strings(field int, values []string) {
+	for _, value := range values {
+		encoder.bytes(field, []byte(value))
+	}
+}
+
+func (encoder *protoEncoder) message(field int, message protoMarshaler) {
+	if message != nil {
+		encoder.bytes(field, message.marshalProto())
+	}
+}
+
+type protoMarshaler interface {
+	marshalProto() []byte
+}
+
+type protoUnmarshaler interface {
+	unmarshalProto(data []byte) error
+}
+
+// protoDecoder reads the fields of a message one after another. After next
+// has returned a field, exactly one of the reading methods has to be called.
+type protoDecoder struct {
+	data     []byte
+	wireType int
+}
+
+func (decoder *protoDecoder) done() bool {
+	return len(decoder.data) == 0
+}
+
+// next returns the number of the next field.
+func (decoder *protoDecoder) next() (int, error) {
+	key, err := decoder.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	field := int(key >> 3)
+	if field <= 0 {
+		return 0, fmt.Errorf("protobuf: invalid field number %d", field)
+	}
+	decoder.wireType = int(key & 7)
+	return field, nil
+}
+
+func (decoder *protoDecoder) readVarint() (uint64, error) {
+	value, length := binary.Uvarint(decoder.data)
+	if length <= 0 {
+		return 0, errProtoTruncated
+	}
+	decoder.data = decoder.data[length:]
+	return value, nil
+}
+
+func (decoder *protoDecoder) expect(wireType int) error {
+	if decoder.wireType != wireType {
+		return fmt.Errorf("protobuf: unexpected wire type %d", decoder.wireType)
+	}
+	return nil
+}
+
+func (decoder *protoDecoder) uint() (uint64, error) {
+	if err := decoder.expect(protoWireVarint); err != nil {
+		return 0, err
+	}
+	return decoder.readVarint()
+}
+
+// int reads an int32 field, truncating the sign extended value.
+func (decoder *protoDecoder) int() (int, error) {
+	value, err := decoder.uint()
+	return int(int32(value)), err
+}
+
+func (decoder *protoDecoder) bool() (bool, error) {
+	value, err := decoder.uint()
+	return value != 0, err
+}
+
+func (decoder *protoDecoder) bytes() ([]byte, error) {
+	if err := decoder.expect(protoWireLengthDelimited); err != nil {
+		return nil, err
+	}
+	length, err := decoder.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(decoder.data)) {
+		return nil, errProtoTruncated
+	}
+	value := decoder.data[:length]
+	decoder.data = decoder.data[length:]
+	return value, nil
+}
+
+func (decoder *protoDecoder) string() (string, error) {
+	value, err := decoder.bytes()
+	return string(value), err
+}
+
+// skip reads the current field without using it.
+func (decoder *protoDecoder) skip() error {
+	var length int
+	switch decoder.wireType {
+	case protoWireVarint:
+		_, err := decoder.readVarint()
+		return err
+	case protoWireLengthDelimited:
+		_, err := decoder.bytes()
+		return err
+	case protoWireFixed64:
+		length = 8
+	case protoWireFixed32:
+		length = 4
+	default:
+		return fmt.Errorf("protobuf: unsupported wire type %d", decoder.wireType)
+	}
+	if len(decoder.data) < length {
+		return errProtoTruncated
+	}
+	decoder.data = decoder.data[length:]
+	return nil
+}
+
+// The following types are the messages of the gRPC API.
+
+type grpcListLobbiesRequest struct {
+	Tags   []string
+	Region string
+}
+
+func (request *grpcListLobbiesRequest) unmarshalProto(data []byte) error {
+	decoder := &protoDecoder{data: data}
+	for !decoder.done() {
+		field, err := decoder.next()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 1:
+			var tag string
+			tag, err = decoder.string()
+			request.Tags = append(request.Tags, tag)
+		case 2:
+			request.Region, err = decoder.string()
+		default:
+			err = decoder.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type grpcListLobbiesResponse struct {
+	Lobbies []*LobbyEntry
+}
+
+func (response *grpcListLobbiesResponse) marshalProto() []byte {
+	encoder := &protoEncoder{}
+	for _, lobby := range response.Lobbies {
+		encoder.message(1, (*grpcLobby)(lobby))
+	}
+	return encoder.data
+}
+
+// grpcLobby is the Lobby message, which mirrors the LobbyEntry of the HTTP
+// API.
+type grpcLobby LobbyEntry
+
+func (lobby *grpcLobby) marshalProto() []byte {
+	encoder := &protoEncoder{}
+	encoder.string(1, lobby.ID)
+	encoder.int(2, lobby.PlayerCount)
+	encoder.int(3, lobby.MaxPlayers)
+	encoder.int(4, lobby.Round)
+	encoder.int(5, lobby.MaxRounds)
+	encoder.int(6, lobby.DrawingTime)
+	encoder.bool(7, lobby.CustomWords)
+	encoder.bool(8, lobby.Votekick)
+	encoder.int(9, lobby.MaxClientsPerIP)
+	encoder.string(10, lobby.Wordpack)
+	encoder.strings(11, lobby.Tags)
+	encoder.string(12, lobby.Region)
+	return encoder.data
+}
+
+type grpcCreateLobbyRequest struct {
+	OwnerName         string
+	Language          string
+	DrawingTime       int
+	Rounds            int
+	MaxPlayers        int
+	CustomWords       []string
+	CustomWordsChance int
+	ClientsPerIPLimit int
+	EnableVotekick    bool
+	Public            bool
+	StartDelay        int
+	Tags              []string
+	Region            string
+	TwitchChannel     string
+}
+
+func (request *grpcCreateLobbyRequest) unmarshalProto(data []byte) error {
+	decoder := &protoDecoder{data: data}
+	for !decoder.done() {
+		field, err := decoder.next()
+		if err != nil {
+			return err
+		}
+		var value string
+		switch field {
+		case 1:
+			request.OwnerName, err = decoder.string()
+		case 2:
+			request.Language, err = decoder.string()
+		case 3:
+			request.DrawingTime, err = decoder.int()
+		case 4:
+			request.Rounds, err = decoder.int()
+		case 5:
+			request.MaxPlayers, err = decoder.int()
+		case 6:
+			value, err = decoder.string()
+			request.CustomWords = append(request.CustomWords, value)
+		case 7:
+			request.CustomWordsChance, err = decoder.int()
+		case 8:
+			request.ClientsPerIPLimit, err = decoder.int()
+		case 9:
+			request.EnableVotekick, err = decoder.bool()
+		case 10:
+			request.Public, err = decoder.bool()
+		case 11:
+			request.StartDelay, err = decoder.int()
+		case 12:
+			value, err = decoder.string()
+			request.Tags = append(request.Tags, value)
+		case 13:
+			request.Region, err = decoder.string()
+		case 14:
+			request.TwitchChannel, err = decoder.string()
+		default:
+			err = decoder.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type grpcCreateLobbyResponse struct {
+	Lobby             *LobbyEntry
+	OwnerID           string
+	OwnerSessionToken string
+}
+
+func (response *grpcCreateLobbyResponse) marshalProto() []byte {
+	encoder := &protoEncoder{}
+	encoder.message(1, (*grpcLobby)(response.Lobby))
+	encoder.string(2, response.OwnerID)
+	encoder.string(3, response.OwnerSessionToken)
+	return encoder.data
+}
+
+type grpcStreamEventsRequest struct {
+	LobbyID string
+}
+
+func (request *grpcStreamEventsRequest) unmarshalProto(data []byte) error {
+	decoder := &protoDecoder{data: data}
+	for !decoder.done() {
+		field, err := decoder.next()
+		if err != nil {
+			return err
+		}
+		if field == 1 {
+			request.LobbyID, err = decoder.string()
+		} else {
+			err = decoder.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcEvent carries a websocket event, with the data being encoded as JSON.
+type grpcEvent struct {
+	Type string
+	Data []byte
+}
+
+func (event *grpcEvent) marshalProto() []byte {
+	encoder := &protoEncoder{}
+	encoder.string(1, event.Type)
+	if len(event.Data) > 0 {
+		encoder.bytes(2, event.Data)
+	}
+	return encoder.data
+}
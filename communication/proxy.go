@@ -0,0 +1,178 @@
+package communication
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// trustedProxies are the networks whose forwarding headers we accept.
+	// If this is empty, no forwarding headers are accepted at all.
+	trustedProxies []*net.IPNet
+	// strictProxyHeaders causes requests with unparsable forwarding headers
+	// to be refused, instead of falling back to the proxies address.
+	strictProxyHeaders bool
+	// clientIPHeader is a header containing only the clients address, as
+	// set by some CDNs. If it's set, it's used instead of X-Forwarded-For
+	// and X-Real-IP.
+	clientIPHeader string
+
+	errInvalidForwardingHeader = errors.New("the request contains an invalid forwarding header")
+)
+
+// ConfigureTrustedProxies sets the proxies that are allowed to tell us the
+// clients real address via the X-Forwarded-For or X-Real-IP header. The
+// proxies are passed as a comma separated list of IPs or CIDR ranges. If
+// strict is set, requests with unparsable headers are refused.
+func ConfigureTrustedProxies(proxies string, strict bool) error {
+	var networks []*net.IPNet
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy '%s'", proxy)
+			}
+
+			if ip.To4() != nil {
+				proxy = proxy + "/32"
+			} else {
+				proxy = proxy + "/128"
+			}
+		}
+
+		_, network, parseError := net.ParseCIDR(proxy)
+		if parseError != nil {
+			return fmt.Errorf("invalid trusted proxy '%s': %s", proxy, parseError)
+		}
+		networks = append(networks, network)
+	}
+
+	trustedProxies = networks
+	strictProxyHeaders = strict
+	return nil
+}
+
+// ConfigureClientIPHeader sets a header that trusted proxies use for passing
+// the clients address, such as CF-Connecting-IP or True-Client-IP. Since it
+// replaces the standard headers, X-Forwarded-For and X-Real-IP are ignored.
+func ConfigureClientIPHeader(header string) error {
+	header = strings.TrimSpace(header)
+	if header != "" && len(trustedProxies) == 0 {
+		return errors.New("a client IP header can only be used with trusted proxies")
+	}
+
+	clientIPHeader = http.CanonicalHeaderKey(header)
+	return nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseForwardedIP parses a single address from a forwarding header. These
+// addresses can optionally contain a port and brackets around IPv6
+// addresses. If the address is invalid, nil is returned.
+func parseForwardedIP(value string) net.IP {
+	address := strings.Trim(strings.TrimSpace(value), "\"'`")
+	if ip := net.ParseIP(address); ip != nil {
+		return ip
+	}
+
+	host, _, splitError := net.SplitHostPort(address)
+	if splitError == nil {
+		return net.ParseIP(host)
+	}
+
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"))
+}
+
+// getTrustedClientIP determines the clients address using the headers set by
+// trusted proxies. X-Forwarded-For is read from right to left, skipping all
+// trusted proxies, since all addresses left of the first untrusted one could
+// have been made up by the client.
+func getTrustedClientIP(r *http.Request) (string, error) {
+	remoteIP := parseForwardedIP(r.RemoteAddr)
+	if remoteIP == nil || !isTrustedProxy(remoteIP) {
+		return remoteAddressToSimpleIP(r.RemoteAddr), nil
+	}
+
+	if clientIPHeader != "" {
+		return parseClientIPHeader(r, remoteIP)
+	}
+
+	var forwardedAddresses []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		forwardedAddresses = append(forwardedAddresses, strings.Split(header, ",")...)
+	}
+
+	if len(forwardedAddresses) > 0 {
+		lastHop := remoteIP
+		for index := len(forwardedAddresses) - 1; index >= 0; index-- {
+			ip := parseForwardedIP(forwardedAddresses[index])
+			if ip == nil {
+				if strictProxyHeaders {
+					return "", errInvalidForwardingHeader
+				}
+				//Everything left of this is untrustworthy, so we use
+				//the last address we could still trust.
+				return lastHop.String(), nil
+			}
+
+			if !isTrustedProxy(ip) {
+				return ip.String(), nil
+			}
+			lastHop = ip
+		}
+
+		//All addresses are trusted proxies, therefore the leftmost one
+		//is the closest thing to the client that we know.
+		return lastHop.String(), nil
+	}
+
+	realIP := r.Header.Get("X-Real-IP")
+	if realIP != "" {
+		ip := parseForwardedIP(realIP)
+		if ip != nil {
+			return ip.String(), nil
+		}
+
+		if strictProxyHeaders {
+			return "", errInvalidForwardingHeader
+		}
+	}
+
+	return remoteIP.String(), nil
+}
+
+// parseClientIPHeader reads the configured client IP header. If it's
+// missing, the request is attributed to the proxy.
+func parseClientIPHeader(r *http.Request, remoteIP net.IP) (string, error) {
+	value := r.Header.Get(clientIPHeader)
+	if value == "" {
+		return remoteIP.String(), nil
+	}
+
+	ip := parseForwardedIP(value)
+	if ip == nil {
+		if strictProxyHeaders {
+			return "", errInvalidForwardingHeader
+		}
+		return remoteIP.String(), nil
+	}
+
+	return ip.String(), nil
+}
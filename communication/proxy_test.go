@@ -0,0 +1,125 @@
+package communication
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_getIPAddressFromRequest(t *testing.T) {
+	defer ConfigureTrustedProxies("", false)
+
+	tests := []struct {
+		name       string
+		proxies    string
+		strict     bool
+		remoteAddr string
+		headers    map[string]string
+		want       string
+		wantErr    bool
+	}{
+		{"no proxies configured ignores header", "", false, "10.0.0.1:1234",
+			map[string]string{"X-Forwarded-For": "1.2.3.4"}, "10.0.0.1", false},
+		{"no proxies configured ignores forwarded", "", false, "10.0.0.1:1234",
+			map[string]string{"Forwarded": "for=1.2.3.4"}, "10.0.0.1", false},
+		{"no proxies configured ignores real ip", "", false, "10.0.0.1:1234",
+			map[string]string{"X-Real-IP": "1.2.3.4"}, "10.0.0.1", false},
+		{"untrusted remote ignores header", "10.0.0.1", false, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-For": "1.2.3.4"}, "10.0.0.2", false},
+		{"trusted remote uses header", "10.0.0.0/8", false, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-For": "1.2.3.4"}, "1.2.3.4", false},
+		{"spoofed addresses are skipped", "10.0.0.0/8", false, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.3"}, "1.2.3.4", false},
+		{"ipv6 with port", "10.0.0.0/8", false, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-For": "[2001:db8::1]:4711"}, "2001:db8::1", false},
+		{"real ip header", "10.0.0.0/8", false, "10.0.0.2:1234",
+			map[string]string{"X-Real-IP": "1.2.3.4"}, "1.2.3.4", false},
+		{"no header", "10.0.0.0/8", true, "10.0.0.2:1234",
+			nil, "10.0.0.2", false},
+		{"invalid header falls back to proxy", "10.0.0.0/8", false, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-For": "garbage"}, "10.0.0.2", false},
+		{"invalid header in strict mode", "10.0.0.0/8", true, "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-For": "garbage"}, "", true},
+		{"invalid real ip in strict mode", "10.0.0.0/8", true, "10.0.0.2:1234",
+			map[string]string{"X-Real-IP": "garbage"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ConfigureTrustedProxies(tt.proxies, tt.strict); err != nil {
+				t.Fatalf("ConfigureTrustedProxies() error = %v", err)
+			}
+
+			request := httptest.NewRequest("GET", "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+
+			got, err := getIPAddressFromRequest(request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getIPAddressFromRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getIPAddressFromRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigureTrustedProxiesInvalid(t *testing.T) {
+	defer ConfigureTrustedProxies("", false)
+
+	if err := ConfigureTrustedProxies("10.0.0.0/8, nonsense", false); err == nil {
+		t.Error("Invalid proxy should've caused an error")
+	}
+}
+
+func Test_getIPAddressFromRequest_clientIPHeader(t *testing.T) {
+	defer ConfigureTrustedProxies("", false)
+	defer func() { clientIPHeader = "" }()
+
+	if err := ConfigureClientIPHeader("CF-Connecting-IP"); err == nil {
+		t.Errorf("client IP header has been accepted without trusted proxies")
+	}
+	if err := ConfigureTrustedProxies("10.0.0.0/8", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfigureClientIPHeader("cf-connecting-ip"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+		wantErr    bool
+	}{
+		{"header is used", "10.0.0.2:1234",
+			map[string]string{"CF-Connecting-IP": "1.2.3.4", "X-Forwarded-For": "5.6.7.8"}, "1.2.3.4", false},
+		{"standard headers are ignored", "10.0.0.2:1234",
+			map[string]string{"X-Forwarded-For": "5.6.7.8"}, "10.0.0.2", false},
+		{"untrusted remote ignores header", "192.168.0.1:1234",
+			map[string]string{"CF-Connecting-IP": "1.2.3.4"}, "192.168.0.1", false},
+		{"invalid header in strict mode", "10.0.0.2:1234",
+			map[string]string{"CF-Connecting-IP": "garbage"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				request.Header.Set(key, value)
+			}
+
+			got, err := getIPAddressFromRequest(request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getIPAddressFromRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getIPAddressFromRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
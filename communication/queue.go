@@ -0,0 +1,202 @@
+package communication
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+const (
+	// sendQueueSize is the maximum amount of messages waiting to be sent to
+	// a single connection. Clients that fall this far behind are considered
+	// hopeless and get disconnected.
+	sendQueueSize = 512
+	// drawingCoalesceThreshold is the amount of queued messages at which
+	// drawing events aren't queued anymore. Instead, the full drawing is
+	// sent once the client has caught up.
+	drawingCoalesceThreshold = 128
+)
+
+var (
+	errConnectionClosed = errors.New("connection closed")
+	errClientTooSlow    = errors.New("client too slow, connection closed")
+)
+
+type outgoingMessage struct {
+	messageType int
+	data        []byte
+	// buffer is set if data has been taken from sequencedBufferPool. It's
+	// returned to the pool by release.
+	buffer *sequencedBuffer
+}
+
+// release returns the messages buffer to the pool. Afterwards, the data of
+// the message mustn't be used anymore.
+func (message *outgoingMessage) release() {
+	if message.buffer != nil {
+		releaseSequencedBuffer(message.buffer)
+		message.buffer = nil
+		message.data = nil
+	}
+}
+
+// sendQueue buffers the outgoing messages of a single connection, so that
+// broadcasting never has to wait for a slow client. The messages are taken
+// from the queue by the connections transport, see take.
+type sendQueue struct {
+	mutex    *sync.Mutex
+	messages []outgoingMessage
+	// spare is the slice previously returned by take, which is reused for
+	// queueing messages once it has been passed to recycle.
+	spare []outgoingMessage
+	// signal is notified whenever messages have been queued or the queue
+	// has been closed.
+	signal chan struct{}
+	closed bool
+	// drawingStale is set as soon as a drawing event has been skipped. The
+	// full drawing will be sent once the queue is empty again.
+	drawingStale bool
+	// drawingSnapshot creates the message containing the full drawing.
+	drawingSnapshot func() (outgoingMessage, error)
+	// overflow is called in a separate goroutine, if the client is too slow
+	// and the queue has been closed because of that.
+	overflow func()
+}
+
+func newSendQueue(drawingSnapshot func() (outgoingMessage, error), overflow func()) *sendQueue {
+	return &sendQueue{
+		mutex:           &sync.Mutex{},
+		signal:          make(chan struct{}, 1),
+		drawingSnapshot: drawingSnapshot,
+		overflow:        overflow,
+	}
+}
+
+// newDrawingSnapshot creates a function returning the current drawing of the
+// lobby, encoded in the wire format negotiated with the player. Since it's
+// called by the connections writer, the drawing is copied on the lobbies loop.
+func newDrawingSnapshot(lobby *game.Lobby, player *game.Player) func() (outgoingMessage, error) {
+	return func() (outgoingMessage, error) {
+		drawing := lobby.SnapshotCurrentDrawing()
+		if drawing == nil {
+			return outgoingMessage{}, game.ErrLobbyClosed
+		}
+
+		messageType, data, err := encodeMessage(getMessageEncoding(player),
+			&game.GameEvent{Type: game.EventTypeDrawing, Data: drawing})
+		return outgoingMessage{messageType: messageType, data: data}, err
+	}
+}
+
+// isDrawingEvent decides whether an event only changes the drawing, meaning
+// it can be replaced by sending the full drawing later on.
+func isDrawingEvent(eventType string) bool {
+	return eventType == game.EventTypeLine || eventType == game.EventTypeFill ||
+		eventType == game.EventTypeClearDrawingBoard || eventType == game.EventTypeDrawing
+}
+
+// enqueue adds a message to the queue. If the client is lagging behind,
+// drawing events are skipped. If the queue is full, the connection is closed.
+func (queue *sendQueue) enqueue(message outgoingMessage, isDrawing bool) error {
+	queue.mutex.Lock()
+
+	if queue.closed {
+		queue.mutex.Unlock()
+		return errConnectionClosed
+	}
+
+	if isDrawing && (queue.drawingStale || len(queue.messages) >= drawingCoalesceThreshold) {
+		queue.drawingStale = true
+		queue.mutex.Unlock()
+		return nil
+	}
+
+	if len(queue.messages) >= sendQueueSize {
+		queue.closed = true
+		queue.mutex.Unlock()
+		queue.notify()
+		//Since the client is slow, this mustn't block the caller.
+		go queue.overflow()
+		return errClientTooSlow
+	}
+
+	queue.messages = append(queue.messages, message)
+	queue.mutex.Unlock()
+	queue.notify()
+	return nil
+}
+
+func (queue *sendQueue) notify() {
+	select {
+	case queue.signal <- struct{}{}:
+	default:
+		//A notification is already pending.
+	}
+}
+
+func (queue *sendQueue) close() {
+	queue.mutex.Lock()
+	queue.closed = true
+	queue.messages = nil
+	queue.spare = nil
+	queue.mutex.Unlock()
+	queue.notify()
+}
+
+// take removes all queued messages from the queue. If drawing events have
+// been skipped and the client has caught up, the full drawing is returned
+// instead. If the queue has been closed, false is returned.
+func (queue *sendQueue) take() ([]outgoingMessage, bool) {
+	queue.mutex.Lock()
+	if queue.closed {
+		queue.mutex.Unlock()
+		return nil, false
+	}
+
+	messages := queue.messages
+	queue.messages = queue.spare
+	queue.spare = nil
+	//The stale flag has to be reset before creating the snapshot.
+	//Otherwise drawing events arriving in between could get lost.
+	sendSnapshot := len(messages) == 0 && queue.drawingStale
+	if sendSnapshot {
+		queue.drawingStale = false
+	}
+	queue.mutex.Unlock()
+
+	if sendSnapshot {
+		snapshot, snapshotError := queue.drawingSnapshot()
+		if snapshotError != nil {
+			logging.Error("error creating drawing snapshot", "error", snapshotError)
+			return nil, true
+		}
+		messages = append(messages, snapshot)
+	}
+
+	return messages, true
+}
+
+// recycle has to be called with the messages returned by take, once they
+// have been sent. The messages buffers are released and the slice is
+// reused for queueing messages, so that a busy connection doesn't allocate
+// a new slice for every batch of messages.
+func (queue *sendQueue) recycle(messages []outgoingMessage) {
+	for index := range messages {
+		messages[index].release()
+		messages[index] = outgoingMessage{}
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.closed || cap(messages) > sendQueueSize {
+		return
+	}
+	if queue.messages == nil {
+		queue.messages = messages[:0]
+	} else if queue.spare == nil {
+		queue.spare = messages[:0]
+	}
+}
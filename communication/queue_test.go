@@ -0,0 +1,146 @@
+package communication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+func Test_isDrawingEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		object interface{}
+		want   bool
+	}{
+		{"line", &game.GameEvent{Type: "line"}, true},
+		{"fill by value", game.GameEvent{Type: "fill"}, true},
+		{"message", &game.GameEvent{Type: "message"}, false},
+		{"no event", "line", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDrawingEvent(eventTypeOf(tt.object)); got != tt.want {
+				t.Errorf("isDrawingEvent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// Test_sendQueueCoalescesDrawing makes sure that drawing events are replaced
+// by a single drawing snapshot as soon as a client lags behind.
+func Test_sendQueueCoalescesDrawing(t *testing.T) {
+	connections := make(chan *websocketConnection, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		connections <- &websocketConnection{
+			socket: socket,
+			sendQueue: newSendQueue(func() (outgoingMessage, error) {
+				return outgoingMessage{messageType: websocket.TextMessage, data: []byte("snapshot")}, nil
+			}, func() {}),
+		}
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	connection := <-connections
+	queue := connection.sendQueue
+	//The queue isn't running yet, so all messages stay queued.
+	for i := 0; i < drawingCoalesceThreshold; i++ {
+		queue.enqueue(outgoingMessage{messageType: websocket.TextMessage, data: []byte("message")}, false)
+	}
+	for i := 0; i < 10; i++ {
+		if err := queue.enqueue(outgoingMessage{messageType: websocket.TextMessage, data: []byte("line")}, true); err != nil {
+			t.Fatalf("enqueue() error = %v", err)
+		}
+	}
+	go connection.run()
+
+	for i := 0; i < drawingCoalesceThreshold; i++ {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "message" {
+			t.Fatalf("expected message, got %s", data)
+		}
+	}
+
+	_, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "snapshot" {
+		t.Errorf("expected snapshot, got %s", data)
+	}
+
+	queue.close()
+}
+
+func Test_sendQueueClosesWhenFull(t *testing.T) {
+	overflown := make(chan struct{})
+	queue := newSendQueue(nil, func() { close(overflown) })
+
+	message := outgoingMessage{messageType: websocket.TextMessage, data: []byte("message")}
+	for i := 0; i < sendQueueSize; i++ {
+		if err := queue.enqueue(message, false); err != nil {
+			t.Fatalf("enqueue() error = %v", err)
+		}
+	}
+
+	if err := queue.enqueue(message, false); err != errClientTooSlow {
+		t.Errorf("enqueue() error = %v, want %v", err, errClientTooSlow)
+	}
+	if err := queue.enqueue(message, false); err != errConnectionClosed {
+		t.Errorf("enqueue() error = %v, want %v", err, errConnectionClosed)
+	}
+
+	select {
+	case <-overflown:
+	case <-time.After(time.Second):
+		t.Error("overflow handler wasn't called")
+	}
+	if _, open := queue.take(); open {
+		t.Error("take() reported open queue after overflow")
+	}
+}
+
+func Test_sendQueueRecycle(t *testing.T) {
+	queue := newSendQueue(nil, func() {})
+	encoded := outgoingMessage{messageType: websocket.TextMessage, data: []byte(`{"type":"line"}`)}
+	for i := uint64(1); i <= 3; i++ {
+		queue.enqueue(withSequence(encodingJSON, encoded, i), true)
+	}
+
+	messages, _ := queue.take()
+	if len(messages) != 3 || string(messages[2].data) != `{"seq":3,"type":"line"}` {
+		t.Fatalf("unexpected messages %v", messages)
+	}
+	queue.recycle(messages)
+	if messages[0].buffer != nil || messages[0].data != nil {
+		t.Error("recycled messages weren't released")
+	}
+
+	queue.enqueue(withSequence(encodingJSON, encoded, 4), true)
+	reused, _ := queue.take()
+	if len(reused) != 1 || &reused[:1][0] != &messages[:1][0] {
+		t.Error("expected the recycled slice to be reused")
+	}
+	if string(reused[0].data) != `{"seq":4,"type":"line"}` {
+		t.Errorf("unexpected message %s", reused[0].data)
+	}
+}
@@ -0,0 +1,82 @@
+package communication
+
+import (
+	"time"
+)
+
+const (
+	// inboundEventRate is the amount of events per second a single
+	// connection may send in the long run. Drawing sends an event for each
+	// mouse movement, so this has to be rather generous.
+	inboundEventRate = 150
+	// inboundEventBurst is the amount of events a connection may send at
+	// once, before being throttled.
+	inboundEventBurst = 300
+	// abuseWindow is the timeframe in which dropped events are counted.
+	abuseWindow = 10 * time.Second
+	// maxDroppedEventsPerWindow is the amount of events that may be dropped
+	// within abuseWindow, before the connection is closed.
+	maxDroppedEventsPerWindow = 1000
+)
+
+// rateLimitResult tells the caller how to treat an inbound event.
+type rateLimitResult int
+
+const (
+	// eventAllowed means the event may be handled.
+	eventAllowed rateLimitResult = iota
+	// eventThrottled means the event has to be dropped, but the client is
+	// still allowed to keep sending events.
+	eventThrottled
+	// eventAbusive means the client keeps exceeding the limit and has to
+	// be disconnected.
+	eventAbusive
+)
+
+// inboundRateLimiter is a token bucket limiting the amount of events a
+// single connection may send. Clients that keep exceeding the limit are
+// considered abusive. It isn't safe for concurrent use, as each connection
+// is read by a single goroutine.
+type inboundRateLimiter struct {
+	tokens     float64
+	lastRefill time.Time
+
+	windowStart   time.Time
+	droppedEvents int
+}
+
+func newInboundRateLimiter(now time.Time) *inboundRateLimiter {
+	return &inboundRateLimiter{
+		tokens:      inboundEventBurst,
+		lastRefill:  now,
+		windowStart: now,
+	}
+}
+
+// allow decides what to do with an event received at the given time. The
+// boolean is true if the event is the first to be throttled in the current
+// window, meaning the client should be notified.
+func (limiter *inboundRateLimiter) allow(now time.Time) (rateLimitResult, bool) {
+	limiter.tokens += now.Sub(limiter.lastRefill).Seconds() * inboundEventRate
+	if limiter.tokens > inboundEventBurst {
+		limiter.tokens = inboundEventBurst
+	}
+	limiter.lastRefill = now
+
+	if now.Sub(limiter.windowStart) >= abuseWindow {
+		limiter.windowStart = now
+		limiter.droppedEvents = 0
+	}
+
+	if limiter.tokens >= 1 {
+		limiter.tokens--
+		return eventAllowed, false
+	}
+
+	limiter.droppedEvents++
+	if limiter.droppedEvents > maxDroppedEventsPerWindow {
+		return eventAbusive, false
+	}
+
+	return eventThrottled, limiter.droppedEvents == 1
+}
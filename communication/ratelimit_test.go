@@ -0,0 +1,46 @@
+package communication
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_inboundRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := newInboundRateLimiter(now)
+
+	for i := 0; i < inboundEventBurst; i++ {
+		if result, _ := limiter.allow(now); result != eventAllowed {
+			t.Fatalf("event %d within burst wasn't allowed", i)
+		}
+	}
+
+	result, notify := limiter.allow(now)
+	if result != eventThrottled || !notify {
+		t.Errorf("expected first throttled event with notification, got %v, %v", result, notify)
+	}
+	if result, notify := limiter.allow(now); result != eventThrottled || notify {
+		t.Errorf("expected throttled event without notification, got %v, %v", result, notify)
+	}
+
+	//After a second, the bucket has been partially refilled.
+	now = now.Add(time.Second)
+	for i := 0; i < inboundEventRate; i++ {
+		if result, _ := limiter.allow(now); result != eventAllowed {
+			t.Fatalf("event %d after refill wasn't allowed", i)
+		}
+	}
+
+	for i := 0; i < maxDroppedEventsPerWindow; i++ {
+		limiter.allow(now)
+	}
+	if result, _ := limiter.allow(now); result != eventAbusive {
+		t.Errorf("expected abusive client to be detected, got %v", result)
+	}
+
+	//A new window forgives previously dropped events.
+	now = now.Add(abuseWindow)
+	if result, _ := limiter.allow(now); result != eventAllowed {
+		t.Errorf("expected event in new window to be allowed, got %v", result)
+	}
+}
@@ -0,0 +1,204 @@
+package communication
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// recordingFlushInterval is the interval in which recorded events are
+// written to the storage. Recordings of removed lobbies are closed as well.
+const recordingFlushInterval = 5 * time.Second
+
+// unrecordedEvents contains events that only transfer state that's already
+// part of the recording, or that don't change anything at all.
+var unrecordedEvents = map[string]bool{
+	game.EventTypeReady:    true,
+	game.EventTypeDrawing:  true,
+	game.EventTypeTimeSync: true,
+}
+
+// RecordingStorage creates the destination for the recording of a lobby.
+// Each recording consists of one JSON object per line, see recordedEvent.
+type RecordingStorage interface {
+	Create(lobbyID string, startTime time.Time) (io.WriteCloser, error)
+	// Open opens an existing recording for replaying it.
+	Open(name string) (io.ReadCloser, error)
+}
+
+// FileRecordingStorage stores each recording as a separate JSONL file in
+// the given directory.
+type FileRecordingStorage struct {
+	Directory string
+}
+
+// Create creates a new file named after the start time and the lobby.
+func (storage *FileRecordingStorage) Create(lobbyID string, startTime time.Time) (io.WriteCloser, error) {
+	name := fmt.Sprintf("%s-%s.jsonl", startTime.UTC().Format("20060102T150405Z"), lobbyID)
+	return os.OpenFile(filepath.Join(storage.Directory, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+}
+
+// Open opens the file with the given name. Only recordings directly inside
+// of the directory can be opened.
+func (storage *FileRecordingStorage) Open(name string) (io.ReadCloser, error) {
+	if name == "" || filepath.Base(name) != name || !strings.HasSuffix(name, ".jsonl") {
+		return nil, errors.New("invalid recording name")
+	}
+
+	return os.Open(filepath.Join(storage.Directory, name))
+}
+
+// recordedEvent is a single line of a recording.
+type recordedEvent struct {
+	// Time is the unix timestamp in milliseconds at which the event was
+	// sent.
+	Time  int64           `json:"time"`
+	Event json.RawMessage `json:"event"`
+}
+
+type recording struct {
+	destination io.WriteCloser
+	writer      *bufio.Writer
+}
+
+var (
+	recordingStorage RecordingStorage
+	// recordings contains the open recordings by lobby ID.
+	recordings      = make(map[string]*recording)
+	recordingsMutex = &sync.Mutex{}
+)
+
+// ConfigureRecording enables recording all public events of all lobbies,
+// such as strokes, chat messages, word hints and scores. Each recording
+// starts with a "ready" event containing the state of the lobby at the time
+// the recording was started.
+func ConfigureRecording(storage RecordingStorage) {
+	recordingStorage = storage
+	go flushRecordings()
+}
+
+// recordEvent appends the given message to the recording of the lobby,
+// starting the recording if necessary.
+func recordEvent(lobby *game.Lobby, message *preparedMessage) {
+	if recordingStorage == nil || unrecordedEvents[message.eventType] {
+		return
+	}
+
+	encoded, encodeError := message.encode(encodingJSON)
+	if encodeError != nil {
+		lobby.Logger().Error("error marshalling event for recording", "error", encodeError)
+		return
+	}
+
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
+
+	lobbyRecording, available := recordings[lobby.ID]
+	if !available {
+		lobbyRecording = startRecording(lobby)
+		if lobbyRecording == nil {
+			return
+		}
+	}
+
+	lobbyRecording.write(encoded.data)
+
+	if message.eventType == game.EventTypeLobbyClosed {
+		stopRecording(lobby.ID, lobbyRecording)
+	}
+}
+
+// startRecording creates a new recording for the lobby. This has to be
+// called while holding the recordings mutex and from within the lobbies
+// loop, as events are only ever recorded by the transport.
+func startRecording(lobby *game.Lobby) *recording {
+	destination, createError := recordingStorage.Create(lobby.ID, time.Now())
+	if createError != nil {
+		lobby.Logger().Error("error creating recording", "error", createError)
+		return nil
+	}
+
+	lobbyRecording := &recording{
+		destination: destination,
+		writer:      bufio.NewWriter(destination),
+	}
+	recordings[lobby.ID] = lobbyRecording
+
+	initialState, marshalError := json.Marshal(&game.GameEvent{Type: game.EventTypeReady, Data: game.GenerateSpectatorReadyData(lobby)})
+	if marshalError != nil {
+		lobby.Logger().Error("error marshalling initial state for recording", "error", marshalError)
+	} else {
+		lobbyRecording.write(initialState)
+	}
+
+	return lobbyRecording
+}
+
+// stopRecording writes all remaining events and closes the recording. This
+// has to be called while holding the recordings mutex.
+func stopRecording(lobbyID string, lobbyRecording *recording) {
+	delete(recordings, lobbyID)
+
+	if flushError := lobbyRecording.writer.Flush(); flushError != nil {
+		logging.Error("error writing recording", "lobby", lobbyID, "error", flushError)
+	}
+	if closeError := lobbyRecording.destination.Close(); closeError != nil {
+		logging.Error("error closing recording", "lobby", lobbyID, "error", closeError)
+	}
+}
+
+func (lobbyRecording *recording) write(event []byte) {
+	line, marshalError := json.Marshal(&recordedEvent{
+		Time:  time.Now().UnixNano() / int64(time.Millisecond),
+		Event: event,
+	})
+	if marshalError != nil {
+		logging.Error("error marshalling recorded event", "error", marshalError)
+		return
+	}
+
+	//Errors are reported when flushing.
+	lobbyRecording.writer.Write(line)
+	lobbyRecording.writer.WriteByte('\n')
+}
+
+// flushRecordings regularly writes all buffered events. Since lobbies can
+// also be removed without being closed, for example when they have been
+// empty for too long, their recordings are stopped here.
+func flushRecordings() {
+	flushTicker := time.NewTicker(recordingFlushInterval)
+	for {
+		<-flushTicker.C
+
+		recordingsMutex.Lock()
+		for lobbyID, lobbyRecording := range recordings {
+			if _, err := state.GetLobby(lobbyID); err != nil {
+				stopRecording(lobbyID, lobbyRecording)
+			} else if flushError := lobbyRecording.writer.Flush(); flushError != nil {
+				logging.Error("error writing recording", "lobby", lobbyID, "error", flushError)
+			}
+		}
+		recordingsMutex.Unlock()
+	}
+}
+
+// stopAllRecordings writes all remaining events and closes all recordings.
+func stopAllRecordings() {
+	recordingsMutex.Lock()
+	defer recordingsMutex.Unlock()
+
+	for lobbyID, lobbyRecording := range recordings {
+		stopRecording(lobbyID, lobbyRecording)
+	}
+}
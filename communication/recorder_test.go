@@ -0,0 +1,88 @@
+package communication
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (buffer *bufferCloser) Close() error {
+	buffer.closed = true
+	return nil
+}
+
+type memoryRecordingStorage struct {
+	recordings map[string]*bufferCloser
+}
+
+func (storage *memoryRecordingStorage) Create(lobbyID string, startTime time.Time) (io.WriteCloser, error) {
+	buffer := &bufferCloser{}
+	storage.recordings[lobbyID] = buffer
+	return buffer, nil
+}
+
+func (storage *memoryRecordingStorage) Open(name string) (io.ReadCloser, error) {
+	buffer, available := storage.recordings[name]
+	if !available {
+		return nil, errors.New("recording doesn't exist")
+	}
+	return ioutil.NopCloser(bytes.NewReader(buffer.Bytes())), nil
+}
+
+func Test_recordEvent(t *testing.T) {
+	storage := &memoryRecordingStorage{recordings: make(map[string]*bufferCloser)}
+	recordingStorage = storage
+	defer func() { recordingStorage = nil }()
+
+	_, lobby, err := gameServer.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recordEvent(lobby, newPreparedMessage(&game.GameEvent{Type: "message", Data: "hi"}))
+	recordEvent(lobby, newPreparedMessage(&game.GameEvent{Type: "time-sync"}))
+	recordEvent(lobby, newPreparedMessage(&game.GameEvent{Type: "lobby-closed", Data: "bye"}))
+
+	buffer := storage.recordings[lobby.ID]
+	if buffer == nil || !buffer.closed {
+		t.Fatal("recording wasn't stopped after the lobby was closed")
+	}
+
+	var eventTypes []string
+	scanner := bufio.NewScanner(&buffer.Buffer)
+	for scanner.Scan() {
+		line := &recordedEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), line); err != nil {
+			t.Fatalf("invalid line %s: %s", scanner.Bytes(), err)
+		}
+		if line.Time == 0 {
+			t.Errorf("line without timestamp: %s", scanner.Bytes())
+		}
+
+		event := &game.GameEvent{}
+		json.Unmarshal(line.Event, event)
+		eventTypes = append(eventTypes, event.Type)
+	}
+
+	want := []string{"ready", "message", "lobby-closed"}
+	if len(eventTypes) != len(want) {
+		t.Fatalf("recorded %v, want %v", eventTypes, want)
+	}
+	for index := range want {
+		if eventTypes[index] != want[index] {
+			t.Errorf("recorded %v, want %v", eventTypes, want)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package communication
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+const (
+	// maxRecordingSize is the maximum size of a recording that can be
+	// replayed, since recordings are kept in memory during playback.
+	maxRecordingSize = 64 * 1024 * 1024
+	// maxReplays is the maximum amount of replays existing at once, since
+	// each of them keeps its recording in memory.
+	maxReplays = 8
+	// replayIdleTimeout is the time after which replays without viewers
+	// are removed.
+	replayIdleTimeout = 10 * time.Minute
+	minReplaySpeed    = 0.25
+	maxReplaySpeed    = 8
+)
+
+var (
+	errEmptyRecording = errors.New("the recording doesn't contain any events")
+	errTooManyReplays = errors.New("too many replays exist at the moment, please try again later")
+)
+
+// replayEvent is a single recorded event, ready to be sent.
+type replayEvent struct {
+	// offset is the time passed since the start of the recording.
+	offset time.Duration
+	data   []byte
+}
+
+// replayState is sent to all viewers as "replay-state" event, whenever the
+// playback has been changed by one of the viewers.
+type replayState struct {
+	// Position is the current playback position in milliseconds.
+	Position int64 `json:"position"`
+	// Duration is the length of the recording in milliseconds.
+	Duration int64   `json:"duration"`
+	Playing  bool    `json:"playing"`
+	Speed    float64 `json:"speed"`
+}
+
+// replay is a recorded game, played back to a group of viewers at the
+// original speed. All viewers share the same playback and each of them can
+// pause, seek or change the speed.
+type replay struct {
+	id string
+	// events contains all recorded events in order. The first one is
+	// always the "ready" event containing the initial state.
+	events   []replayEvent
+	duration time.Duration
+
+	mutex   *sync.Mutex
+	viewers map[*websocketConnection]struct{}
+	// position is the index of the next event to be sent.
+	position int
+	playing  bool
+	speed    float64
+	// offsetBase is the playback position at clockBase. While playing, the
+	// position advances with the time passed since clockBase.
+	offsetBase   time.Duration
+	clockBase    time.Time
+	lastActivity time.Time
+	// control is notified whenever the playback has been changed.
+	control chan struct{}
+}
+
+var (
+	replays      = make(map[string]*replay)
+	replaysMutex = &sync.Mutex{}
+)
+
+// loadReplay parses a recording as written by the recorder.
+func loadReplay(recording io.Reader) (*replay, error) {
+	var events []replayEvent
+	var startTime int64
+	reader := bufio.NewReader(io.LimitReader(recording, maxRecordingSize))
+	for {
+		line, readError := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			recorded := &recordedEvent{}
+			if err := json.Unmarshal(line, recorded); err != nil {
+				return nil, fmt.Errorf("invalid recording: %s", err)
+			}
+
+			if len(events) == 0 {
+				startTime = recorded.Time
+			}
+			offset := time.Duration(recorded.Time-startTime) * time.Millisecond
+			//The clock might have been adjusted while recording, but the
+			//order of the events must be kept.
+			if len(events) > 0 && offset < events[len(events)-1].offset {
+				offset = events[len(events)-1].offset
+			}
+			events = append(events, replayEvent{offset: offset, data: recorded.Event})
+		}
+
+		if readError == io.EOF {
+			break
+		}
+		if readError != nil {
+			return nil, readError
+		}
+	}
+
+	if len(events) == 0 {
+		return nil, errEmptyRecording
+	}
+
+	//The initial state is always sent, even when seeking to the start.
+	return &replay{
+		id:           uuid.Must(uuid.NewV4()).String(),
+		events:       events,
+		duration:     events[len(events)-1].offset,
+		mutex:        &sync.Mutex{},
+		viewers:      make(map[*websocketConnection]struct{}),
+		position:     1,
+		speed:        1,
+		lastActivity: time.Now(),
+		control:      make(chan struct{}, 1),
+	}, nil
+}
+
+func getReplay(id string) *replay {
+	replaysMutex.Lock()
+	defer replaysMutex.Unlock()
+
+	return replays[id]
+}
+
+// getReplayCount returns the amount of replays that currently exist.
+func getReplayCount() int {
+	replaysMutex.Lock()
+	defer replaysMutex.Unlock()
+
+	return len(replays)
+}
+
+// createReplayEndpoint loads the recording with the given name from the
+// recording storage and starts a replay for it. The replay is paused until
+// one of the viewers starts it. This requires the admin token, see
+// withAdminAuth.
+func createReplayEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if recordingStorage == nil {
+		http.Error(w, "recording is disabled", http.StatusNotFound)
+		return
+	}
+
+	//Checked before loading the recording as well, so that no memory is
+	//wasted on replays that can't be started anyway.
+	if getReplayCount() >= maxReplays {
+		http.Error(w, errTooManyReplays.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	recording, openError := recordingStorage.Open(r.URL.Query().Get("recording"))
+	if openError != nil {
+		http.Error(w, "the recording couldn't be found", http.StatusNotFound)
+		return
+	}
+	defer recording.Close()
+
+	newReplay, loadError := loadReplay(recording)
+	if loadError != nil {
+		http.Error(w, loadError.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	replaysMutex.Lock()
+	if len(replays) >= maxReplays {
+		replaysMutex.Unlock()
+		http.Error(w, errTooManyReplays.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	replays[newReplay.id] = newReplay
+	replaysMutex.Unlock()
+	go newReplay.run()
+
+	logging.Info("created replay", "replay", newReplay.id, "recording", r.URL.Query().Get("recording"), "admin", getAdminName(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"replayId": newReplay.id})
+}
+
+// replayWebsocketEndpoint connects a viewer to a replay. The viewer first
+// receives all events up to the current position as a single
+// "replay-batch" event, followed by a "replay-state" event.
+func replayWebsocketEndpoint(w http.ResponseWriter, r *http.Request) {
+	viewedReplay := getReplay(r.URL.Query().Get("replay_id"))
+	if viewedReplay == nil {
+		http.Error(w, "the replay doesn't exist", http.StatusNotFound)
+		return
+	}
+
+	socket, upgradeError := upgrader.Upgrade(w, r, nil)
+	if upgradeError != nil {
+		http.Error(w, upgradeError.Error(), http.StatusInternalServerError)
+		return
+	}
+	socket.SetReadLimit(maxInboundMessageSize)
+
+	viewer := &websocketConnection{socket: socket}
+	//Replays don't have a drawing to fall back on, therefore drawing events
+	//are never skipped.
+	viewer.sendQueue = newSendQueue(nil, func() {
+		closeWithCode(socket, game.CloseCodeTooSlow, "The connection is too slow.")
+	})
+	go viewer.run()
+
+	viewedReplay.mutex.Lock()
+	viewedReplay.viewers[viewer] = struct{}{}
+	viewedReplay.lastActivity = time.Now()
+	viewer.sendQueue.enqueue(viewedReplay.batchUntilPosition(), false)
+	viewer.sendQueue.enqueue(viewedReplay.stateMessage(), false)
+	viewedReplay.mutex.Unlock()
+
+	go viewedReplay.listen(viewer)
+}
+
+// listen handles the control events of a single viewer.
+func (replay *replay) listen(viewer *websocketConnection) {
+	defer func() {
+		viewer.sendQueue.close()
+		viewer.socket.Close()
+
+		replay.mutex.Lock()
+		delete(replay.viewers, viewer)
+		replay.lastActivity = time.Now()
+		replay.mutex.Unlock()
+	}()
+
+	rateLimiter := newInboundRateLimiter(time.Now())
+	for {
+		messageType, data, readError := viewer.socket.ReadMessage()
+		if readError != nil {
+			return
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		if limitResult, _ := rateLimiter.allow(time.Now()); limitResult != eventAllowed {
+			continue
+		}
+
+		received := &game.GameEvent{}
+		if err := json.Unmarshal(data, received); err != nil {
+			continue
+		}
+
+		if controlError := replay.handleControlEvent(received); controlError != nil {
+			controlError.Event = received.Type
+			if encoded, err := json.Marshal(&game.GameEvent{Type: game.EventTypeError, Data: controlError}); err == nil {
+				viewer.sendQueue.enqueue(outgoingMessage{messageType: websocket.TextMessage, data: encoded}, false)
+			}
+		}
+	}
+}
+
+func (replay *replay) handleControlEvent(received *game.GameEvent) *game.EventError {
+	replay.mutex.Lock()
+	defer replay.mutex.Unlock()
+
+	now := time.Now()
+	replay.lastActivity = now
+
+	switch received.Type {
+	case "replay-play":
+		if replay.position >= len(replay.events) {
+			replay.seek(0, now)
+		}
+		replay.offsetBase = replay.currentOffset(now)
+		replay.clockBase = now
+		replay.playing = true
+	case "replay-pause":
+		replay.offsetBase = replay.currentOffset(now)
+		replay.playing = false
+	case "replay-seek":
+		position, isNumber := received.Data.(float64)
+		if !isNumber {
+			return &game.EventError{Code: game.ErrorCodeInvalidPayload, Message: "data must be the position in milliseconds"}
+		}
+		replay.seek(time.Duration(position)*time.Millisecond, now)
+	case "replay-speed":
+		speed, isNumber := received.Data.(float64)
+		if !isNumber || speed < minReplaySpeed || speed > maxReplaySpeed {
+			return &game.EventError{
+				Code:    game.ErrorCodeInvalidPayload,
+				Message: fmt.Sprintf("speed must be between %v and %v", minReplaySpeed, maxReplaySpeed),
+			}
+		}
+		replay.offsetBase = replay.currentOffset(now)
+		replay.clockBase = now
+		replay.speed = speed
+	default:
+		return &game.EventError{Code: game.ErrorCodeUnknownEvent, Message: fmt.Sprintf("unknown event type '%s'", received.Type)}
+	}
+
+	replay.broadcast(replay.stateMessage())
+	select {
+	case replay.control <- struct{}{}:
+	default:
+		//A notification is already pending.
+	}
+	return nil
+}
+
+// seek jumps to the given position and sends the state at that position to
+// all viewers. This has to be called while holding the replays mutex.
+func (replay *replay) seek(offset time.Duration, now time.Time) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > replay.duration {
+		offset = replay.duration
+	}
+
+	replay.offsetBase = offset
+	replay.clockBase = now
+	replay.position = sort.Search(len(replay.events), func(index int) bool {
+		return index > 0 && replay.events[index].offset > offset
+	})
+	replay.broadcast(replay.batchUntilPosition())
+}
+
+// currentOffset returns the current playback position. This has to be
+// called while holding the replays mutex.
+func (replay *replay) currentOffset(now time.Time) time.Duration {
+	if !replay.playing {
+		return replay.offsetBase
+	}
+
+	return replay.offsetBase + time.Duration(float64(now.Sub(replay.clockBase))*replay.speed)
+}
+
+// batchUntilPosition creates a message containing all events up to the
+// current position, allowing viewers to restore the state at that position.
+// This has to be called while holding the replays mutex.
+func (replay *replay) batchUntilPosition() outgoingMessage {
+	var buffer bytes.Buffer
+	buffer.WriteString(`{"type":"replay-batch","data":[`)
+	for index, event := range replay.events[:replay.position] {
+		if index > 0 {
+			buffer.WriteByte(',')
+		}
+		buffer.Write(event.data)
+	}
+	buffer.WriteString(`]}`)
+
+	return outgoingMessage{messageType: websocket.TextMessage, data: buffer.Bytes()}
+}
+
+// stateMessage creates a "replay-state" event. This has to be called while
+// holding the replays mutex.
+func (replay *replay) stateMessage() outgoingMessage {
+	data, _ := json.Marshal(&game.GameEvent{Type: "replay-state", Data: &replayState{
+		Position: int64(replay.currentOffset(time.Now()) / time.Millisecond),
+		Duration: int64(replay.duration / time.Millisecond),
+		Playing:  replay.playing,
+		Speed:    replay.speed,
+	}})
+	return outgoingMessage{messageType: websocket.TextMessage, data: data}
+}
+
+// broadcast sends a message to all viewers. This has to be called while
+// holding the replays mutex.
+func (replay *replay) broadcast(message outgoingMessage) {
+	for viewer := range replay.viewers {
+		viewer.sendQueue.enqueue(message, false)
+	}
+}
+
+// advance sends all events that are due and returns the time until the
+// next event is due. If no event is due in the future, false is returned.
+// This has to be called while holding the replays mutex.
+func (replay *replay) advance(now time.Time) (time.Duration, bool) {
+	if !replay.playing {
+		return 0, false
+	}
+
+	offset := replay.currentOffset(now)
+	for replay.position < len(replay.events) && replay.events[replay.position].offset <= offset {
+		event := replay.events[replay.position]
+		replay.broadcast(outgoingMessage{messageType: websocket.TextMessage, data: event.data})
+		replay.position++
+	}
+
+	if replay.position >= len(replay.events) {
+		replay.offsetBase = replay.duration
+		replay.playing = false
+		replay.broadcast(replay.stateMessage())
+		return 0, false
+	}
+
+	return time.Duration(float64(replay.events[replay.position].offset-offset) / replay.speed), true
+}
+
+// run plays back the events until the replay has been without viewers for
+// too long.
+func (replay *replay) run() {
+	idleTicker := time.NewTicker(time.Minute)
+	defer idleTicker.Stop()
+
+	for {
+		replay.mutex.Lock()
+		now := time.Now()
+		untilNextEvent, waitForEvent := replay.advance(now)
+		idle := len(replay.viewers) == 0 && now.Sub(replay.lastActivity) > replayIdleTimeout
+		replay.mutex.Unlock()
+
+		if idle {
+			replaysMutex.Lock()
+			delete(replays, replay.id)
+			replaysMutex.Unlock()
+			return
+		}
+
+		var nextEvent <-chan time.Time
+		var nextEventTimer *time.Timer
+		if waitForEvent {
+			nextEventTimer = time.NewTimer(untilNextEvent)
+			nextEvent = nextEventTimer.C
+		}
+
+		select {
+		case <-replay.control:
+		case <-nextEvent:
+		case <-idleTicker.C:
+		}
+
+		if nextEventTimer != nil {
+			nextEventTimer.Stop()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package communication
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+const testRecording = `{"time":1000,"event":{"type":"ready","data":{}}}
+{"time":2000,"event":{"type":"line","data":1}}
+{"time":1500,"event":{"type":"fill","data":2}}
+
+{"time":5000,"event":{"type":"message","data":"hi"}}
+`
+
+func Test_loadReplay(t *testing.T) {
+	loaded, err := loadReplay(strings.NewReader(testRecording))
+	if err != nil {
+		t.Fatalf("loadReplay() error = %v", err)
+	}
+
+	if len(loaded.events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(loaded.events))
+	}
+	//Events recorded with a clock that went backwards are kept in order.
+	if loaded.events[2].offset != time.Second {
+		t.Errorf("unexpected offset %s", loaded.events[2].offset)
+	}
+	if loaded.duration != 4*time.Second {
+		t.Errorf("unexpected duration %s", loaded.duration)
+	}
+
+	if _, err := loadReplay(strings.NewReader("")); err != errEmptyRecording {
+		t.Errorf("expected errEmptyRecording, got %v", err)
+	}
+	if _, err := loadReplay(strings.NewReader("{")); err == nil {
+		t.Error("expected error for invalid recording")
+	}
+}
+
+func Test_createReplayEndpoint(t *testing.T) {
+	directory, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	if err := ioutil.WriteFile(filepath.Join(directory, "game.jsonl"), []byte(testRecording), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	previousStorage := recordingStorage
+	recordingStorage = &FileRecordingStorage{Directory: directory}
+	defer func() {
+		recordingStorage = previousStorage
+		replaysMutex.Lock()
+		replays = make(map[string]*replay)
+		replaysMutex.Unlock()
+	}()
+
+	createReplay := func() int {
+		recorder := httptest.NewRecorder()
+		createReplayEndpoint(recorder, httptest.NewRequest(http.MethodPost, "/v1/replay?recording=game.jsonl", nil))
+		return recorder.Code
+	}
+	for i := 0; i < maxReplays; i++ {
+		if code := createReplay(); code != http.StatusOK {
+			t.Fatalf("creating replay %d returned %d", i, code)
+		}
+	}
+	//Each replay keeps its recording in memory, so their amount is limited.
+	if code := createReplay(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected too many replays to be rejected, got %d", code)
+	}
+}
+
+func Test_replaySeek(t *testing.T) {
+	loaded, _ := loadReplay(strings.NewReader(testRecording))
+
+	if err := loaded.handleControlEvent(&game.GameEvent{Type: "replay-seek", Data: float64(1200)}); err != nil {
+		t.Fatalf("handleControlEvent() error = %v", err)
+	}
+	if loaded.position != 3 {
+		t.Errorf("expected position 3, got %d", loaded.position)
+	}
+
+	batch := &struct {
+		Type string            `json:"type"`
+		Data []json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(loaded.batchUntilPosition().data, batch); err != nil {
+		t.Fatalf("invalid batch: %s", err)
+	}
+	if batch.Type != "replay-batch" || len(batch.Data) != 3 {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+
+	//Seeking to the start still keeps the initial state.
+	loaded.handleControlEvent(&game.GameEvent{Type: "replay-seek", Data: float64(-50)})
+	if loaded.position != 1 {
+		t.Errorf("expected position 1, got %d", loaded.position)
+	}
+
+	if err := loaded.handleControlEvent(&game.GameEvent{Type: "replay-speed", Data: float64(100)}); err == nil {
+		t.Error("expected error for invalid speed")
+	}
+	if err := loaded.handleControlEvent(&game.GameEvent{Type: "line"}); err == nil {
+		t.Error("expected error for unknown event")
+	}
+}
+
+func Test_replayAdvance(t *testing.T) {
+	loaded, _ := loadReplay(strings.NewReader(testRecording))
+
+	start := time.Now()
+	loaded.playing = true
+	loaded.speed = 2
+	loaded.clockBase = start
+
+	untilNextEvent, wait := loaded.advance(start.Add(600 * time.Millisecond))
+	if !wait || loaded.position != 3 {
+		t.Fatalf("expected position 3 and further events, got %d, %v", loaded.position, wait)
+	}
+	//At double speed, the remaining 2.8 seconds take 1.4 seconds.
+	if untilNextEvent != 1400*time.Millisecond {
+		t.Errorf("unexpected wait time %s", untilNextEvent)
+	}
+
+	if _, wait := loaded.advance(start.Add(2 * time.Second)); wait || loaded.playing {
+		t.Error("expected replay to stop at the end")
+	}
+}
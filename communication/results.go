@@ -0,0 +1,164 @@
+package communication
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+// resultsLifetime is how long the results of a game can be downloaded after
+// it has finished. Lobbies only keep the results of their latest game.
+const resultsLifetime = time.Hour
+
+// gameResultsLinks is sent to the players of a lobby as
+// game.EventTypeGameResults event once a game has finished. The links are only valid until Expires,
+// which is a UTC unix-timestamp in milliseconds.
+type gameResultsLinks struct {
+	JSON    string `json:"json"`
+	CSV     string `json:"csv"`
+	Expires int64  `json:"expires"`
+}
+
+// resultsExport makes the results of a game downloadable for anyone
+// knowing the token.
+type resultsExport struct {
+	token   string
+	results *game.GameResults
+	expires time.Time
+}
+
+var (
+	// resultsExports maps lobby IDs to the results of their latest game.
+	resultsExports      = make(map[string]*resultsExport)
+	resultsExportsMutex = &sync.Mutex{}
+)
+
+// publishResults makes the results of the game that has just finished
+// downloadable and sends the links to all players. This is called by the
+// lobby notifier on the lobbies loop, so the results can be read safely.
+func publishResults(lobby *game.Lobby) {
+	export := &resultsExport{
+		token:   uuid.Must(uuid.NewV4()).String(),
+		results: lobby.Results(),
+		expires: time.Now().Add(resultsLifetime),
+	}
+
+	resultsExportsMutex.Lock()
+	removeExpiredResults()
+	resultsExports[lobby.ID] = export
+	resultsExportsMutex.Unlock()
+
+	query := url.Values{"lobby_id": {lobby.ID}, "token": {export.token}}
+	links := &gameResultsLinks{
+		JSON:    "/v1/lobby/results?" + query.Encode() + "&format=json",
+		CSV:     "/v1/lobby/results?" + query.Encode() + "&format=csv",
+		Expires: export.expires.UnixNano() / int64(time.Millisecond),
+	}
+	//The links aren't meant for spectators, so they aren't broadcast.
+	for _, player := range lobby.GetPlayers() {
+		WriteAsJSON(player, &game.GameEvent{Type: game.EventTypeGameResults, Data: links})
+	}
+}
+
+// removeExpiredResults must only be called while holding the mutex.
+func removeExpiredResults() {
+	now := time.Now()
+	for lobbyID, export := range resultsExports {
+		if now.After(export.expires) {
+			delete(resultsExports, lobbyID)
+		}
+	}
+}
+
+func getResults(lobbyID, token string) *game.GameResults {
+	resultsExportsMutex.Lock()
+	defer resultsExportsMutex.Unlock()
+
+	export, found := resultsExports[lobbyID]
+	if !found || time.Now().After(export.expires) ||
+		subtle.ConstantTimeCompare([]byte(export.token), []byte(token)) != 1 {
+		return nil
+	}
+	return export.results
+}
+
+// resultsEndpoint serves the results of the latest game of the lobby given
+// via 'lobby_id'. The 'token' is part of the links sent to the players once
+// the game has finished. Passing 'format=csv' returns a spreadsheet instead
+// of JSON.
+func resultsEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	results := getResults(query.Get("lobby_id"), query.Get("token"))
+	if results == nil {
+		http.Error(w, "the results don't exist or have expired", http.StatusNotFound)
+		return
+	}
+
+	fileName := "scribblers-" + results.LobbyID + "-" + results.Finished.UTC().Format("20060102-150405")
+	switch query.Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+".json\"")
+		writeJSON(w, results)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+fileName+".csv\"")
+		if err := writeResultsCSV(w, results); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.Error(w, "the format must be either 'json' or 'csv'", http.StatusBadRequest)
+	}
+}
+
+// writeResultsCSV writes one row per player, containing the final score and
+// the points earned in each turn. The header of each turn names the round,
+// the drawer and the word.
+func writeResultsCSV(writer io.Writer, results *game.GameResults) error {
+	header := []string{"rank", "player", "score"}
+	for _, turn := range results.Turns {
+		header = append(header, fmt.Sprintf("round %d: %s (%s)", turn.Round, turn.Drawer, turn.Word))
+	}
+
+	output := csv.NewWriter(writer)
+	if err := output.Write(header); err != nil {
+		return err
+	}
+	for _, player := range results.Players {
+		row := []string{strconv.Itoa(player.Rank), csvText(player.Name), strconv.Itoa(player.Score)}
+		for _, turn := range results.Turns {
+			row = append(row, strconv.Itoa(turn.Points[player.ID]))
+		}
+		if err := output.Write(row); err != nil {
+			return err
+		}
+	}
+	output.Flush()
+	return output.Error()
+}
+
+// csvText prevents player names from being interpreted as formulas once the
+// file has been opened in a spreadsheet application. Besides the formula
+// characters, some applications also treat a leading tab or carriage return
+// as the start of a formula.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
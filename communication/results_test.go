@@ -0,0 +1,100 @@
+package communication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+func Test_resultsEndpoint(t *testing.T) {
+	results := &game.GameResults{
+		LobbyID:  "lobby",
+		Wordpack: "english",
+		Rounds:   1,
+		Players: []*game.PlayerResult{
+			{ID: "a", Name: "=marcel", Score: 200, Rank: 1},
+			{ID: "b", Name: "dekvall, the second", Score: 0, Rank: 2},
+		},
+		Turns: []*game.TurnResult{
+			{Round: 1, DrawerID: "b", Drawer: "dekvall, the second", Word: "apple", Points: map[string]int{"a": 100, "b": 100}},
+			{Round: 1, DrawerID: "a", Drawer: "marcel", Word: "pear", Points: map[string]int{}},
+		},
+	}
+	resultsExportsMutex.Lock()
+	resultsExports["lobby"] = &resultsExport{token: "secret", results: results, expires: time.Now().Add(time.Minute)}
+	resultsExports["expired"] = &resultsExport{token: "secret", results: results, expires: time.Now().Add(-time.Minute)}
+	resultsExportsMutex.Unlock()
+	defer func() {
+		resultsExportsMutex.Lock()
+		delete(resultsExports, "lobby")
+		delete(resultsExports, "expired")
+		resultsExportsMutex.Unlock()
+	}()
+
+	request := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		resultsEndpoint(recorder, httptest.NewRequest(http.MethodGet, "/v1/lobby/results?"+query, nil))
+		return recorder
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{name: "wrong token", query: "lobby_id=lobby&token=guessed", code: http.StatusNotFound},
+		{name: "expired", query: "lobby_id=expired&token=secret", code: http.StatusNotFound},
+		{name: "unknown lobby", query: "lobby_id=unknown&token=secret", code: http.StatusNotFound},
+		{name: "unknown format", query: "lobby_id=lobby&token=secret&format=xml", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := request(tt.query).Code; code != tt.code {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.code, code)
+		}
+	}
+
+	decoded := &game.GameResults{}
+	if err := json.NewDecoder(request("lobby_id=lobby&token=secret&format=json").Body).Decode(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Turns) != 2 || decoded.Turns[0].Points["a"] != 100 {
+		t.Errorf("unexpected results %+v", decoded)
+	}
+
+	csv := request("lobby_id=lobby&token=secret&format=csv")
+	expected := "rank,player,score,\"round 1: dekvall, the second (apple)\",round 1: marcel (pear)\n" +
+		"1,'=marcel,200,100,0\n" +
+		"2,\"dekvall, the second\",0,100,0\n"
+	if body := csv.Body.String(); body != expected {
+		t.Errorf("unexpected CSV:\n%s", body)
+	}
+	if contentType := csv.Header().Get("Content-Type"); contentType != "text/csv; charset=utf-8" {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+}
+
+func Test_csvText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"marcel", "marcel"},
+		{"", ""},
+		{"=1+1", "'=1+1"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+		{"mar=cel", "mar=cel"},
+	}
+	for _, tt := range tests {
+		if got := csvText(tt.text); got != tt.want {
+			t.Errorf("csvText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
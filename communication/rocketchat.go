@@ -1,14 +1,17 @@
-package game
+package communication
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
 	"os"
 	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
 )
 
 type rocketChatPayload struct {
@@ -47,7 +50,10 @@ func init() {
 	}
 }
 
-func updateRocketChat(lobby *Lobby, player *Player) {
+// updateRocketChat announces a player joining or leaving a lobby. It's
+// called on the lobbies loop, as it reads the players, therefore the message
+// is sent asynchronously.
+func updateRocketChat(lobby *game.Lobby, player *game.Player) {
 	//This means scribble wasn't set up correctly for use with rocket chat.
 	if rocketchatWebhook == "" || scribbleURL == "" {
 		return
@@ -70,14 +76,21 @@ func updateRocketChat(lobby *Lobby, player *Player) {
 
 	//FIXME Technically not correct anymore, as the lobby is only
 	//closed if no player reconnects within a certain time.
+	var message string
 	if count == 0 {
-		sendRocketChatMessage(fmt.Sprintf("%v has %v. The game has ended.", player.Name, action))
+		message = fmt.Sprintf("%v has %v. The game has ended.", player.Name, action)
 	} else {
-		sendRocketChatMessage(fmt.Sprintf("%v has %v. There are %v players in the game. Join [here](%v/ssrEnterLobby?lobby_id=%v)", player.Name, action, count, scribbleURL, lobby.ID))
+		message = fmt.Sprintf("%v has %v. There are %v players in the game. Join [here](%v/ssrEnterLobby?lobby_id=%v)", player.Name, action, count, scribbleURL, lobby.ID)
 	}
+
+	//Messages that are still being sent once the lobby is closed are
+	//dropped, so that the lobby doesn't wait for a slow webhook.
+	lobby.Go(func(ctx context.Context) {
+		sendRocketChatMessage(ctx, message)
+	})
 }
 
-func sendRocketChatMessage(msg string) {
+func sendRocketChatMessage(ctx context.Context, msg string) {
 	payload := rocketChatPayload{
 		Alias: "Scribble Bot",
 		Text:  msg,
@@ -85,11 +98,20 @@ func sendRocketChatMessage(msg string) {
 
 	payloadByte, err := json.Marshal(payload)
 	if err != nil {
-		log.Println(err)
+		logging.Error("error marshalling rocket chat message", "error", err)
 	}
 
-	_, err = netClient.Post(rocketchatWebhook, "application/json", bytes.NewReader(payloadByte))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, rocketchatWebhook, bytes.NewReader(payloadByte))
 	if err != nil {
-		log.Println(err)
+		logging.Error("error creating rocket chat request", "error", err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := netClient.Do(request)
+	if err != nil {
+		logging.Warn("error sending rocket chat message", "error", err)
+		return
 	}
+	response.Body.Close()
 }
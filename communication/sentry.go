@@ -0,0 +1,140 @@
+package communication
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+// SentryReporter reports crashes to Sentry, using the envelope endpoint of
+// the project identified by the DSN.
+type SentryReporter struct {
+	dsn       string
+	endpoint  string
+	publicKey string
+	client    *http.Client
+}
+
+// NewSentryReporter parses the given DSN, which has the form
+// "https://<key>@<host>/<project>".
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+	parsed, parseError := url.Parse(dsn)
+	if parseError != nil {
+		return nil, parseError
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return nil, errors.New("the sentry DSN doesn't contain a public key")
+	}
+
+	projectPath, projectID := path.Split(strings.TrimSuffix(parsed.Path, "/"))
+	if projectID == "" {
+		return nil, errors.New("the sentry DSN doesn't contain a project ID")
+	}
+
+	endpoint := url.URL{
+		Scheme: parsed.Scheme,
+		Host:   parsed.Host,
+		Path:   path.Join(projectPath, "api", projectID, "envelope") + "/",
+	}
+
+	return &SentryReporter{
+		dsn:       dsn,
+		endpoint:  endpoint.String(),
+		publicKey: parsed.User.Username(),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID   string            `json:"event_id"`
+	Timestamp float64           `json:"timestamp"`
+	Platform  string            `json:"platform"`
+	Level     string            `json:"level"`
+	Tags      map[string]string `json:"tags"`
+	Exception struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+	Extra map[string]string `json:"extra"`
+}
+
+type sentryException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// ReportCrash sends the crash to Sentry in the background.
+func (reporter *SentryReporter) ReportCrash(lobbyID string, recovered interface{}, stack []byte) {
+	envelope, envelopeError := reporter.newEnvelope(lobbyID, recovered, stack, time.Now())
+	if envelopeError != nil {
+		logging.Error("error creating sentry event", "lobby", lobbyID, "error", envelopeError)
+		return
+	}
+
+	go reporter.send(lobbyID, envelope)
+}
+
+func (reporter *SentryReporter) newEnvelope(lobbyID string, recovered interface{}, stack []byte, now time.Time) ([]byte, error) {
+	eventID := make([]byte, 16)
+	if _, err := rand.Read(eventID); err != nil {
+		return nil, err
+	}
+
+	event := &sentryEvent{
+		EventID:   hex.EncodeToString(eventID),
+		Timestamp: float64(now.UnixNano()) / float64(time.Second),
+		Platform:  "go",
+		Level:     "fatal",
+		Tags:      map[string]string{"lobby": lobbyID},
+		Extra:     map[string]string{"stack": string(stack)},
+	}
+	event.Exception.Values = []sentryException{{Type: "panic", Value: fmt.Sprint(recovered)}}
+
+	eventData, marshalError := json.Marshal(event)
+	if marshalError != nil {
+		return nil, marshalError
+	}
+	header, marshalError := json.Marshal(map[string]string{"event_id": event.EventID, "dsn": reporter.dsn})
+	if marshalError != nil {
+		return nil, marshalError
+	}
+
+	//An envelope consists of a header, followed by items, each consisting
+	//of an item header and the payload, all separated by newlines.
+	envelope := &bytes.Buffer{}
+	envelope.Write(header)
+	envelope.WriteString("\n{\"type\":\"event\"}\n")
+	envelope.Write(eventData)
+	envelope.WriteByte('\n')
+	return envelope.Bytes(), nil
+}
+
+func (reporter *SentryReporter) send(lobbyID string, envelope []byte) {
+	request, requestError := http.NewRequest(http.MethodPost, reporter.endpoint, bytes.NewReader(envelope))
+	if requestError != nil {
+		logging.Error("error creating sentry request", "lobby", lobbyID, "error", requestError)
+		return
+	}
+	request.Header.Set("Content-Type", "application/x-sentry-envelope")
+	request.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=scribble.rs, sentry_key="+reporter.publicKey)
+
+	response, sendError := reporter.client.Do(request)
+	if sendError != nil {
+		logging.Warn("error reporting crash to sentry", "lobby", lobbyID, "error", sendError)
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		logging.Warn("sentry rejected crash report", "lobby", lobbyID, "status", response.StatusCode)
+	}
+}
@@ -0,0 +1,51 @@
+package communication
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_NewSentryReporter(t *testing.T) {
+	tests := []struct {
+		dsn          string
+		wantEndpoint string
+		wantError    bool
+	}{
+		{"https://key@sentry.example.com/42", "https://sentry.example.com/api/42/envelope/", false},
+		{"https://key@example.com/sentry/42/", "https://example.com/sentry/api/42/envelope/", false},
+		{"https://sentry.example.com/42", "", true},
+		{"https://key@sentry.example.com/", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			reporter, err := NewSentryReporter(tt.dsn)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("NewSentryReporter() error = %v, wantError %v", err, tt.wantError)
+			}
+			if err == nil && reporter.endpoint != tt.wantEndpoint {
+				t.Errorf("NewSentryReporter() endpoint = %s, want %s", reporter.endpoint, tt.wantEndpoint)
+			}
+		})
+	}
+}
+
+func Test_SentryReporter_newEnvelope(t *testing.T) {
+	reporter, err := NewSentryReporter("https://key@sentry.example.com/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := reporter.newEnvelope("lobby", "broken", []byte("stack"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(envelope), "\n"), "\n")
+	if len(lines) != 3 || lines[1] != `{"type":"event"}` {
+		t.Fatalf("unexpected envelope structure:\n%s", envelope)
+	}
+	if !strings.Contains(lines[2], `"value":"broken"`) || !strings.Contains(lines[2], `"lobby":"lobby"`) {
+		t.Errorf("event doesn't contain the panic and lobby: %s", lines[2])
+	}
+}
@@ -0,0 +1,134 @@
+package communication
+
+import (
+	"sync"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+// maxEventHistory is the amount of events remembered per player for
+// resending them after a reconnect.
+const maxEventHistory = 512
+
+// playerSession is the transport related state of a player. It's attached
+// to the player via game.Player.SetTransportState, since the game doesn't
+// know how players are connected.
+type playerSession struct {
+	// mutex has to be held for all accesses to the session. Since gorilla
+	// websockets shits it self when two calls happen at the same time, we
+	// need a mutex per player, since each player has their own connection.
+	mutex      sync.Mutex
+	connection transport
+	// messageEncoding is the wire format negotiated with the players client.
+	// An empty string means the default format, JSON, is used.
+	messageEncoding string
+	// eventSequence is the sequence number of the last event sent to the
+	// player. It's incremented for every event.
+	eventSequence uint64
+	// eventHistory contains the most recent events sent to the player,
+	// indexed by their sequence number modulo maxEventHistory.
+	eventHistory []interface{}
+}
+
+// sessionsMutex guards the creation of sessions, as players are created by
+// the game, which doesn't know about sessions.
+var sessionsMutex = &sync.Mutex{}
+
+// getSession returns the session of the player, creating it on first use.
+func getSession(player *game.Player) *playerSession {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+
+	session, available := player.GetTransportState().(*playerSession)
+	if !available {
+		session = &playerSession{}
+		player.SetTransportState(session)
+	}
+	return session
+}
+
+// getConnection returns the players current connection or nil if the player
+// isn't connected.
+func getConnection(player *game.Player) transport {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.connection
+}
+
+// getMessageEncoding returns the wire format negotiated with the players
+// client.
+func getMessageEncoding(player *game.Player) string {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.messageEncoding
+}
+
+// setMessageEncoding sets the wire format negotiated with the players client.
+func setMessageEncoding(player *game.Player, encoding string) {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.messageEncoding = encoding
+}
+
+// setConnection makes the given connection the players current one.
+func setConnection(player *game.Player, connection transport) {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.connection = connection
+}
+
+// detachConnection removes the given connection from the player, unless the
+// player has established another connection in the meantime. Only if true
+// is returned, the game has to be told about the disconnect. This also
+// prevents telling the game twice.
+func detachConnection(player *game.Player, connection transport) bool {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.connection != connection {
+		return false
+	}
+
+	session.connection = nil
+	return true
+}
+
+// recordSentEvent assigns the next sequence number to an event sent to the
+// player and remembers the event, so that it can be resent in case the
+// client reconnects. The event itself is treated as opaque. This must only
+// be called while holding the sessions mutex.
+func (session *playerSession) recordSentEvent(event interface{}) uint64 {
+	if session.eventHistory == nil {
+		session.eventHistory = make([]interface{}, maxEventHistory)
+	}
+
+	session.eventSequence++
+	session.eventHistory[session.eventSequence%maxEventHistory] = event
+	return session.eventSequence
+}
+
+// getSentEventsSince returns all events sent to the player after the event
+// with the given sequence number. The sequence number of the first returned
+// event is sequence+1. If not all of these events are remembered anymore,
+// false is returned. This must only be called while holding the sessions
+// mutex.
+func (session *playerSession) getSentEventsSince(sequence uint64) ([]interface{}, bool) {
+	if sequence > session.eventSequence || session.eventSequence-sequence > maxEventHistory {
+		return nil, false
+	}
+
+	events := make([]interface{}, 0, session.eventSequence-sequence)
+	for missed := sequence + 1; missed <= session.eventSequence; missed++ {
+		events = append(events, session.eventHistory[missed%maxEventHistory])
+	}
+	return events, true
+}
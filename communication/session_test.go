@@ -0,0 +1,32 @@
+package communication
+
+import (
+	"testing"
+)
+
+func Test_playerSession_sentEventHistory(t *testing.T) {
+	session := &playerSession{}
+
+	if events, available := session.getSentEventsSince(0); !available || len(events) != 0 {
+		t.Errorf("expected empty history to be resumable, got %v, %v", events, available)
+	}
+
+	for i := 1; i <= maxEventHistory+10; i++ {
+		if sequence := session.recordSentEvent(i); sequence != uint64(i) {
+			t.Fatalf("expected sequence %d, got %d", i, sequence)
+		}
+	}
+
+	events, available := session.getSentEventsSince(maxEventHistory + 5)
+	if !available || len(events) != 5 || events[0] != maxEventHistory+6 || events[4] != maxEventHistory+10 {
+		t.Errorf("unexpected events %v, %v", events, available)
+	}
+
+	if _, available := session.getSentEventsSince(5); available {
+		t.Error("events that have been forgotten were reported as available")
+	}
+
+	if _, available := session.getSentEventsSince(maxEventHistory + 11); available {
+		t.Error("events from the future were reported as available")
+	}
+}
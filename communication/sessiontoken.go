@@ -0,0 +1,173 @@
+package communication
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+// sessionTokenVersion is the first field of every session token, so that the
+// format can be changed without misinterpreting older tokens.
+const sessionTokenVersion = "1"
+
+var (
+	errSessionTokenInvalid = errors.New("the session token is invalid")
+	errSessionTokenExpired = errors.New("the session token has expired")
+)
+
+var (
+	// sessionSecret is the key session tokens are signed with. Unless
+	// configured, it's random, so tokens don't survive a restart.
+	sessionSecret []byte
+	// sessionLifetime is how long a session token is valid after being
+	// issued.
+	sessionLifetime = 24 * time.Hour
+)
+
+func init() {
+	sessionSecret = make([]byte, 32)
+	if _, err := rand.Read(sessionSecret); err != nil {
+		panic(err)
+	}
+}
+
+// ConfigureSessionTokens sets the secret session tokens are signed with and
+// how long they are valid. The secret has to be the same on all instances
+// restoring each others lobbies, otherwise the players have to rejoin.
+func ConfigureSessionTokens(secret string, lifetime time.Duration) {
+	if secret != "" {
+		sessionSecret = []byte(secret)
+	}
+	if lifetime > 0 {
+		sessionLifetime = lifetime
+	}
+}
+
+// sessionToken identifies a player of a lobby. It's passed to the client
+// as the usersession cookie. Since it's signed, it can't be forged, and since
+// it contains the players user session, which is replaced on every reentry,
+// leaked tokens stop working once the player returns.
+type sessionToken struct {
+	lobbyID     string
+	playerID    string
+	userSession string
+	expiry      time.Time
+}
+
+// SessionToken issues a signed token for the player, which allows the client
+// to connect to the lobby as this player. The token can be passed via the
+// usersession cookie or header.
+func SessionToken(lobby *game.Lobby, player *game.Player) string {
+	return encodeSessionToken(&sessionToken{
+		lobbyID:     lobby.ID,
+		playerID:    player.ID,
+		userSession: player.GetUserSession(),
+		expiry:      time.Now().Add(sessionLifetime),
+	})
+}
+
+func encodeSessionToken(token *sessionToken) string {
+	payload := strings.Join([]string{
+		sessionTokenVersion,
+		token.lobbyID,
+		token.playerID,
+		strconv.FormatInt(token.expiry.Unix(), 10),
+		token.userSession,
+	}, ".")
+	return payload + "." + signSessionPayload(payload)
+}
+
+func signSessionPayload(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseSessionToken verifies the signature and expiry of the token. It
+// doesn't check whether the token still matches the players user session.
+func parseSessionToken(encoded string, now time.Time) (*sessionToken, error) {
+	separator := strings.LastIndexByte(encoded, '.')
+	if separator == -1 {
+		return nil, errSessionTokenInvalid
+	}
+
+	payload, signature := encoded[:separator], encoded[separator+1:]
+	if !hmac.Equal([]byte(signature), []byte(signSessionPayload(payload))) {
+		return nil, errSessionTokenInvalid
+	}
+
+	fields := strings.Split(payload, ".")
+	if len(fields) != 5 || fields[0] != sessionTokenVersion {
+		return nil, errSessionTokenInvalid
+	}
+	expiry, parseError := strconv.ParseInt(fields[3], 10, 64)
+	if parseError != nil {
+		return nil, errSessionTokenInvalid
+	}
+
+	token := &sessionToken{
+		lobbyID:     fields[1],
+		playerID:    fields[2],
+		expiry:      time.Unix(expiry, 0),
+		userSession: fields[4],
+	}
+	if !now.Before(token.expiry) {
+		return nil, errSessionTokenExpired
+	}
+	return token, nil
+}
+
+// findSessionPlayer returns the player the token belongs to. If the token
+// is invalid, expired, meant for another lobby or has been replaced by a
+// newer one, an error is returned.
+func findSessionPlayer(lobby *game.Lobby, encoded string) (*game.Player, error) {
+	token, parseError := parseSessionToken(encoded, time.Now())
+	if parseError != nil {
+		return nil, parseError
+	}
+	if token.lobbyID != lobby.ID {
+		return nil, errSessionTokenInvalid
+	}
+
+	player := lobby.GetPlayer(token.userSession)
+	if player == nil || player.ID != token.playerID {
+		return nil, errSessionTokenInvalid
+	}
+	return player, nil
+}
+
+// getPreviousUserSession returns the user session of the token sent along
+// with the request, regardless of which lobby it has been issued for. It's
+// used for recognizing banned players.
+func getPreviousUserSession(r *http.Request) string {
+	token, parseError := parseSessionToken(getUserSession(r), time.Now())
+	if parseError != nil {
+		return ""
+	}
+	return token.userSession
+}
+
+// setSessionCookie issues a new session token for the player and passes it
+// to the client as a cookie. If the player has been part of the lobby
+// before, the previous tokens are invalidated.
+func setSessionCookie(w http.ResponseWriter, lobby *game.Lobby, player *game.Player, rotate bool) {
+	if rotate {
+		player.RotateUserSession()
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "usersession",
+		Value:    SessionToken(lobby, player),
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+	})
+}
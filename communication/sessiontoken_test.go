@@ -0,0 +1,82 @@
+package communication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_findSessionPlayer(t *testing.T) {
+	owner, lobby, err := gameServer.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherLobby, err := gameServer.CreateLobby("other", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := SessionToken(lobby, owner)
+	separator := strings.LastIndexByte(valid, '.')
+	expired := encodeSessionToken(&sessionToken{
+		lobbyID:     lobby.ID,
+		playerID:    owner.ID,
+		userSession: owner.GetUserSession(),
+		expiry:      time.Now().Add(-time.Minute),
+	})
+	forged := strings.Replace(valid, owner.GetUserSession(), "guessed", 1)
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "valid", token: valid},
+		{name: "empty", token: "", wantErr: errSessionTokenInvalid},
+		{name: "bare user session", token: owner.GetUserSession(), wantErr: errSessionTokenInvalid},
+		{name: "forged payload", token: forged, wantErr: errSessionTokenInvalid},
+		{name: "tampered signature", token: valid[:separator+1] + "AAAA" + valid[separator+5:], wantErr: errSessionTokenInvalid},
+		{name: "expired", token: expired, wantErr: errSessionTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			player, err := findSessionPlayer(lobby, tt.token)
+			if err != tt.wantErr {
+				t.Fatalf("findSessionPlayer() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && player != owner {
+				t.Errorf("findSessionPlayer() returned the wrong player")
+			}
+		})
+	}
+
+	if _, err := findSessionPlayer(otherLobby, valid); err != errSessionTokenInvalid {
+		t.Errorf("token was accepted by another lobby, error = %v", err)
+	}
+}
+
+func Test_setSessionCookieRotates(t *testing.T) {
+	owner, lobby, err := gameServer.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := SessionToken(lobby, owner)
+
+	recorder := httptest.NewRecorder()
+	setSessionCookie(recorder, lobby, owner, true)
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "usersession" || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+
+	if _, err := findSessionPlayer(lobby, previous); err != errSessionTokenInvalid {
+		t.Errorf("previous token is still valid, error = %v", err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/v1/lobby/ws?lobby_id="+lobby.ID, nil)
+	request.AddCookie(cookies[0])
+	if getPlayer(lobby, request) != owner {
+		t.Errorf("new token doesn't identify the player")
+	}
+}
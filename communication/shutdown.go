@@ -0,0 +1,88 @@
+package communication
+
+import (
+	"context"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// requestShutdownTimeout is the time granted to HTTP requests that are still
+// in progress once all lobbies have been closed.
+const requestShutdownTimeout = 5 * time.Second
+
+// lobbyStore keeps the lobbies while restarting. If it's nil, lobbies are
+// closed when shutting down.
+var lobbyStore state.LobbyStore
+
+// ConfigureLobbyStore makes Shutdown save all lobbies to the given store,
+// instead of closing them. They have to be restored via
+// state.RestoreLobbies on startup.
+func ConfigureLobbyStore(store state.LobbyStore) {
+	lobbyStore = store
+}
+
+// Shutdown drains all lobbies and then stops the server. New lobbies can't
+// be created and new players can't join anymore, while /readyz reports the
+// instance as not ready. If a lobby store has been configured, all lobbies
+// are saved and their players are asked to reconnect. Otherwise, lobbies
+// without a turn in progress are closed right away. All other lobbies are
+// closed as soon as their turn is over, but after the grace period at the
+// latest. Until then, players are regularly told how much time is left.
+func Shutdown(gracePeriod time.Duration) error {
+	game.BeginShutdown()
+	logging.Info("shutting down", "lobbies", state.GetActiveLobbyCount(), "gracePeriod", gracePeriod.String())
+
+	if lobbyStore != nil {
+		saved, err := state.SaveLobbies(lobbyStore)
+		if err != nil {
+			//The lobbies are lost either way, so we still shut down.
+			logging.Error("error saving lobbies", "error", err)
+		} else {
+			logging.Info("saved lobbies", "lobbies", saved)
+		}
+	} else {
+		drainLobbies(time.Now().Add(gracePeriod))
+	}
+	//Closing a lobby stops its recording, but lobbies that have been
+	//removed before, for example due to a crash, might still be recording.
+	stopAllRecordings()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestShutdownTimeout)
+	defer cancel()
+	if httpsPort != 0 {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if grpcPort != 0 {
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	return server.Shutdown(ctx)
+}
+
+func drainLobbies(deadline time.Time) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		lobbies := state.GetLobbies()
+		secondsLeft := int64((time.Until(deadline) + time.Second - 1) / time.Second)
+		for _, lobby := range lobbies {
+			if secondsLeft <= 0 || !lobby.IsTurnInProgress() {
+				game.CloseForShutdown(lobby)
+			} else {
+				game.AnnounceShutdown(lobby, secondsLeft)
+			}
+		}
+
+		if secondsLeft <= 0 || state.GetActiveLobbyCount() == 0 {
+			return
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,236 @@
+package communication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+const (
+	// spectatorBufferSize is the amount of events that are buffered for
+	// each spectator. If a spectator can't keep up, further events are
+	// dropped instead of slowing down the game.
+	spectatorBufferSize = 256
+	// spectatorKeepAliveInterval is the interval in which comments are
+	// sent to spectators, preventing proxies from closing idle streams.
+	spectatorKeepAliveInterval = 15 * time.Second
+	// maxSpectatorsPerLobby limits the streams of a single lobby, since
+	// each of them holds a buffer and is written to on every event.
+	maxSpectatorsPerLobby = 50
+)
+
+var (
+	errSpectatingNotAllowed = errors.New("only public lobbies can be spectated by anyone but their players")
+	errTooManySpectators    = errors.New("the lobby already has too many spectators, please try again later")
+)
+
+// spectator is a read-only observer of a lobby, receiving events via a
+// Server-Sent Events stream.
+type spectator struct {
+	events chan []byte
+}
+
+var (
+	// spectators contains all spectators, grouped by the ID of the lobby
+	// they are observing.
+	spectators      = make(map[string]map[*spectator]struct{})
+	spectatorsMutex = &sync.RWMutex{}
+)
+
+// addSpectator registers a new spectator for the lobby. If the lobby
+// already has maxSpectatorsPerLobby spectators, errTooManySpectators is
+// returned.
+func addSpectator(lobbyID string) (*spectator, error) {
+	spectatorsMutex.Lock()
+	defer spectatorsMutex.Unlock()
+
+	lobbySpectators, available := spectators[lobbyID]
+	if !available {
+		lobbySpectators = make(map[*spectator]struct{})
+		spectators[lobbyID] = lobbySpectators
+	} else if len(lobbySpectators) >= maxSpectatorsPerLobby {
+		return nil, errTooManySpectators
+	}
+	newSpectator := &spectator{events: make(chan []byte, spectatorBufferSize)}
+	lobbySpectators[newSpectator] = struct{}{}
+
+	return newSpectator, nil
+}
+
+func removeSpectator(lobbyID string, oldSpectator *spectator) {
+	spectatorsMutex.Lock()
+	defer spectatorsMutex.Unlock()
+
+	lobbySpectators, available := spectators[lobbyID]
+	if !available {
+		return
+	}
+
+	delete(lobbySpectators, oldSpectator)
+	if len(lobbySpectators) == 0 {
+		delete(spectators, lobbyID)
+	}
+}
+
+// getSpectatorCount returns the amount of spectators observing the lobby.
+func getSpectatorCount(lobbyID string) int {
+	spectatorsMutex.RLock()
+	defer spectatorsMutex.RUnlock()
+
+	return len(spectators[lobbyID])
+}
+
+func hasSpectators(lobbyID string) bool {
+	spectatorsMutex.RLock()
+	defer spectatorsMutex.RUnlock()
+
+	return len(spectators[lobbyID]) > 0
+}
+
+// publishToSpectators sends the given message to all spectators of the
+// lobby. If the lobby has been closed, all spectator streams are ended
+// afterwards.
+func publishToSpectators(lobbyID string, message *preparedMessage) {
+	if !hasSpectators(lobbyID) {
+		return
+	}
+
+	encoded, encodeError := message.encode(encodingJSON)
+	if encodeError != nil {
+		logging.Error("error marshalling event for spectators", "lobby", lobbyID, "error", encodeError)
+		return
+	}
+
+	lobbyClosed := message.eventType == game.EventTypeLobbyClosed
+	if lobbyClosed {
+		spectatorsMutex.Lock()
+		defer spectatorsMutex.Unlock()
+	} else {
+		spectatorsMutex.RLock()
+		defer spectatorsMutex.RUnlock()
+	}
+
+	for lobbySpectator := range spectators[lobbyID] {
+		select {
+		case lobbySpectator.events <- encoded.data:
+		default:
+			//Spectator is too slow, we don't want to block the game.
+		}
+
+		if lobbyClosed {
+			close(lobbySpectator.events)
+		}
+	}
+
+	if lobbyClosed {
+		delete(spectators, lobbyID)
+	}
+}
+
+// spectateEndpoint streams the public events of a lobby, such as strokes,
+// word hints and scores, via Server-Sent Events. This allows stream
+// overlays and other external viewers to observe a game without joining
+// it. Each event is sent as JSON with the same structure as the websocket
+// events. The first event is always a "ready" event containing the current
+// state of the lobby. Private lobbies can only be spectated by their own
+// players, as their IDs would otherwise be enough for following the game.
+func spectateEndpoint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	lobby, lobbyError := getLobby(r)
+	if lobbyError == state.ErrLobbyNotExistent && redirectToLobbyInstance(w, r) {
+		return
+	}
+	if lobbyError != nil {
+		http.Error(w, lobbyError.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !lobby.IsPublic() {
+		if _, sessionError := findSessionPlayer(lobby, getUserSession(r)); sessionError != nil {
+			http.Error(w, errSpectatingNotAllowed.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	flusher, canFlush := w.(http.Flusher)
+	if !canFlush {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+
+	//The spectator has to be registered before generating the initial
+	//state, otherwise events could get lost in between.
+	lobbySpectator, spectatorError := addSpectator(lobby.ID)
+	if spectatorError != nil {
+		http.Error(w, spectatorError.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer removeSpectator(lobby.ID, lobbySpectator)
+	initialState := game.SnapshotSpectatorReadyData(lobby)
+	if initialState == nil {
+		http.Error(w, game.ErrLobbyClosed.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	//Prevents nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	readyData, marshalError := json.Marshal(&game.GameEvent{Type: game.EventTypeReady, Data: initialState})
+	if marshalError != nil {
+		lobby.Logger().Error("error marshalling ready event for spectator", "error", marshalError)
+		return
+	}
+	if writeError := writeServerSentEvent(w, readyData); writeError != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAliveTicker := time.NewTicker(spectatorKeepAliveInterval)
+	defer keepAliveTicker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data, open := <-lobbySpectator.events:
+			if !open {
+				return
+			}
+			if writeError := writeServerSentEvent(w, data); writeError != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAliveTicker.C:
+			//Lobbies can also be removed without being closed, for
+			//example when they have been suspended for a restart.
+			if _, err := state.GetLobby(lobby.ID); err != nil {
+				return
+			}
+			if _, writeError := fmt.Fprint(w, ": keep-alive\n\n"); writeError != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeServerSentEvent writes a single message event. The data mustn't
+// contain any newlines, which is guaranteed for marshalled JSON.
+func writeServerSentEvent(w http.ResponseWriter, data []byte) error {
+	_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
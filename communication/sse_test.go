@@ -0,0 +1,103 @@
+package communication
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+func Test_publishToSpectators(t *testing.T) {
+	lobbySpectator, _ := addSpectator("spectated")
+	defer removeSpectator("spectated", lobbySpectator)
+	otherSpectator, _ := addSpectator("other")
+	defer removeSpectator("other", otherSpectator)
+
+	publishToSpectators("spectated", newPreparedMessage(&game.GameEvent{Type: "clear-drawing-board"}))
+
+	select {
+	case data := <-lobbySpectator.events:
+		event := &game.GameEvent{}
+		if err := json.Unmarshal(data, event); err != nil || event.Type != "clear-drawing-board" {
+			t.Errorf("unexpected event %s (%v)", data, err)
+		}
+	default:
+		t.Error("spectator didn't receive event")
+	}
+
+	if len(otherSpectator.events) != 0 {
+		t.Error("spectator of other lobby received event")
+	}
+
+	publishToSpectators("spectated", newPreparedMessage(&game.GameEvent{Type: "lobby-closed"}))
+	<-lobbySpectator.events
+	if _, open := <-lobbySpectator.events; open {
+		t.Error("spectator stream wasn't ended after the lobby was closed")
+	}
+	if hasSpectators("spectated") {
+		t.Error("spectators of closed lobby weren't removed")
+	}
+}
+
+func Test_publishToSpectatorsDoesntBlock(t *testing.T) {
+	lobbySpectator, _ := addSpectator("slow")
+	defer removeSpectator("slow", lobbySpectator)
+
+	for i := 0; i < spectatorBufferSize*2; i++ {
+		publishToSpectators("slow", newPreparedMessage(&game.GameEvent{Type: "line"}))
+	}
+
+	if len(lobbySpectator.events) != spectatorBufferSize {
+		t.Errorf("expected %d buffered events, got %d", spectatorBufferSize, len(lobbySpectator.events))
+	}
+}
+
+func Test_addSpectatorLimit(t *testing.T) {
+	for i := 0; i < maxSpectatorsPerLobby; i++ {
+		lobbySpectator, err := addSpectator("crowded")
+		if err != nil {
+			t.Fatalf("addSpectator() error = %v", err)
+		}
+		defer removeSpectator("crowded", lobbySpectator)
+	}
+
+	if _, err := addSpectator("crowded"); err != errTooManySpectators {
+		t.Errorf("addSpectator() error = %v, want %v", err, errTooManySpectators)
+	}
+	if getSpectatorCount("crowded") != maxSpectatorsPerLobby {
+		t.Errorf("rejected spectator has been added")
+	}
+}
+
+func Test_spectateEndpointPrivateLobby(t *testing.T) {
+	owner, lobby, err := GameServer().CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.AddLobby(lobby)
+	defer state.RemoveLobby(lobby.ID)
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/lobby/events?lobby_id="+lobby.ID, nil)
+	recorder := httptest.NewRecorder()
+	spectateEndpoint(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected strangers to be refused, got status %d", recorder.Code)
+	}
+
+	//The stream ends right after the initial state, since the request has
+	//already been cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request = httptest.NewRequest(http.MethodGet, "/v1/lobby/events?lobby_id="+lobby.ID, nil).WithContext(ctx)
+	request.Header.Set("Usersession", SessionToken(lobby, owner))
+	recorder = httptest.NewRecorder()
+	spectateEndpoint(recorder, request)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"type":"ready"`) {
+		t.Errorf("expected players to be able to spectate, got status %d: %s", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,295 @@
+package communication
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+	"github.com/scribble-rs/scribble.rs/storage"
+)
+
+const (
+	// accountGamesLimit is the amount of games returned for an account.
+	accountGamesLimit = 50
+	// maxPresets is the amount of presets each account may save.
+	maxPresets = 20
+	// maxPresetValueLength limits the size of each setting of a preset. The
+	// custom words are the only setting that gets anywhere near it.
+	maxPresetValueLength = 10000
+)
+
+// dataStore keeps the game history, instance bans and presets. Unless
+// another store has been configured, everything is kept in memory.
+var dataStore storage.Store = storage.NewMemory(&state.MemoryAccountStore{})
+
+// presetSettings are the fields of the lobby creation form that can be
+// saved as part of a preset.
+var presetSettings = []string{
+	"language", "drawing_time", "rounds", "max_players", "custom_words",
+	"custom_words_chance", "clients_per_ip_limit", "enable_votekick",
+	"public", "start_delay", "tags", "region",
+}
+
+// ConfigureStorage replaces the store used for the game history, bans
+// covering the whole instance and the presets of players.
+func ConfigureStorage(store storage.Store) {
+	dataStore = store
+}
+
+// recordGame adds the results of the game that has just finished to the
+// history. This is called by the lobby notifier on the lobbies loop, so the
+// players can be read safely, but saving happens in the background.
+func recordGame(lobby *game.Lobby) {
+	var players []*storage.GameRecordPlayer
+	for _, player := range lobby.GetPlayers() {
+		players = append(players, &storage.GameRecordPlayer{
+			AccountID: player.GetAccountID(),
+			Name:      player.Name,
+			Score:     player.Score,
+			Rank:      player.Rank,
+		})
+	}
+	record := storage.NewGameRecord(lobby.ID, lobby.Wordpack, lobby.MaxRounds, players)
+
+	lobby.Go(func(_ context.Context) {
+		if err := dataStore.SaveGame(record); err != nil {
+			lobby.Logger().Error("error saving game", "error", err)
+		}
+	})
+}
+
+// findInstanceBan returns the ban preventing the client from creating or
+// joining lobbies, if there is one.
+func findInstanceBan(r *http.Request, address string) *storage.Ban {
+	var accountID string
+	if account := getAccount(r); account != nil {
+		accountID = account.ID
+	}
+
+	ban, err := dataStore.FindBan(accountID, address)
+	if err != nil && err != storage.ErrBanNotExistent {
+		logging.Error("error looking up bans", "error", err)
+	}
+	return ban
+}
+
+// instanceBanMessage is shown to banned clients.
+const instanceBanMessage = "You have been banned from this site."
+
+// accountGamesEndpoint returns the latest games of the players account.
+func accountGamesEndpoint(w http.ResponseWriter, r *http.Request) {
+	account := getAccount(r)
+	if account == nil {
+		http.Error(w, "you aren't logged in", http.StatusUnauthorized)
+		return
+	}
+
+	games, err := dataStore.GamesOfAccount(account.ID, accountGamesLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if games == nil {
+		games = []*storage.GameRecord{}
+	}
+	writeJSON(w, games)
+}
+
+// presetsEndpoint lists the presets of the players account on GET, saves
+// the lobby settings passed as form values under the given 'name' on POST
+// and removes the preset given via 'id' on DELETE.
+func presetsEndpoint(w http.ResponseWriter, r *http.Request) {
+	account := getAccount(r)
+	if account == nil {
+		http.Error(w, "you aren't logged in", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		presets, err := dataStore.Presets(account.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if presets == nil {
+			presets = []*storage.Preset{}
+		}
+		writeJSON(w, presets)
+	case http.MethodPost:
+		savePreset(w, r, account)
+	case http.MethodDelete:
+		err := dataStore.RemovePreset(account.ID, r.URL.Query().Get("id"))
+		if err == storage.ErrPresetNotExistent {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func savePreset(w http.ResponseWriter, r *http.Request, account *state.Account) {
+	if parseError := r.ParseForm(); parseError != nil {
+		http.Error(w, parseError.Error(), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.Form.Get("name"))
+	if name == "" || len(name) > game.MaxPlayerNameLength {
+		http.Error(w, "the name must be between 1 and 30 characters long", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := dataStore.Presets(account.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	preset := storage.NewPreset(account.ID, name, make(map[string]string))
+	//Saving under an existing name replaces the preset.
+	var replacing bool
+	for _, other := range existing {
+		if other.Name == name {
+			preset.ID = other.ID
+			replacing = true
+		}
+	}
+	if !replacing && len(existing) >= maxPresets {
+		http.Error(w, "too many presets, please remove one first", http.StatusBadRequest)
+		return
+	}
+
+	for _, setting := range presetSettings {
+		value := r.Form.Get(setting)
+		if len(value) > maxPresetValueLength {
+			http.Error(w, "the setting '"+setting+"' is too long", http.StatusBadRequest)
+			return
+		}
+		if value != "" {
+			preset.Settings[setting] = value
+		}
+	}
+
+	if err := dataStore.SavePreset(preset); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, preset)
+}
+
+// getPresets returns the presets of the account, if there is one.
+func getPresets(account *state.Account) []*storage.Preset {
+	if account == nil {
+		return nil
+	}
+	presets, err := dataStore.Presets(account.ID)
+	if err != nil {
+		logging.Error("error loading presets", "error", err)
+	}
+	return presets
+}
+
+// applyPreset fills the lobby creation form with the preset given via the
+// 'preset' query parameter, if it's one of the presets of the players
+// account.
+func applyPreset(pageData *CreatePageData, r *http.Request) {
+	presetID := r.URL.Query().Get("preset")
+	if presetID == "" {
+		return
+	}
+
+	for _, preset := range pageData.Presets {
+		if preset.ID != presetID {
+			continue
+		}
+		fields := map[string]*string{
+			"language":             &pageData.Language,
+			"drawing_time":         &pageData.DrawingTime,
+			"rounds":               &pageData.Rounds,
+			"max_players":          &pageData.MaxPlayers,
+			"custom_words":         &pageData.CustomWords,
+			"custom_words_chance":  &pageData.CustomWordsChance,
+			"clients_per_ip_limit": &pageData.ClientsPerIPLimit,
+			"enable_votekick":      &pageData.EnableVotekick,
+			"public":               &pageData.Public,
+			"start_delay":          &pageData.StartDelay,
+			"tags":                 &pageData.Tags,
+			"region":               &pageData.Region,
+		}
+		for setting, value := range preset.Settings {
+			if field, known := fields[setting]; known {
+				*field = value
+			}
+		}
+		return
+	}
+}
+
+// adminBansEndpoint lists all bans covering the whole instance.
+func adminBansEndpoint(w http.ResponseWriter, r *http.Request) {
+	bans, err := dataStore.Bans()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if bans == nil {
+		bans = []*storage.Ban{}
+	}
+	writeJSON(w, bans)
+}
+
+// adminBanEndpoint prevents the account given via 'account_id' or the
+// address given via 'address' from creating or joining any lobby. Existing
+// connections aren't affected, the client has to be kicked from the lobbies
+// separately.
+func adminBanEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	accountID, address := query.Get("account_id"), query.Get("address")
+	if accountID == "" && address == "" {
+		http.Error(w, "please supply an 'account_id' or an 'address'", http.StatusBadRequest)
+		return
+	}
+
+	ban := storage.NewBan(accountID, address, query.Get("reason"))
+	if err := dataStore.SaveBan(ban); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAdminAudit(getAdminName(r), "", "instance-ban", ban.ID, strings.TrimSpace(accountID+" "+address), ban.Reason)
+
+	logging.Info("banned client via admin API", "admin", getAdminName(r), "ban", ban.ID)
+	writeJSON(w, ban)
+}
+
+// adminUnbanEndpoint lifts the ban given via 'id'.
+func adminUnbanEndpoint(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	err := dataStore.RemoveBan(id)
+	if err == storage.ErrBanNotExistent {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAdminAudit(getAdminName(r), "", "instance-unban", id, "", "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
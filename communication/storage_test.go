@@ -0,0 +1,108 @@
+package communication
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/state"
+	"github.com/scribble-rs/scribble.rs/storage"
+)
+
+func Test_instanceBans(t *testing.T) {
+	previous := dataStore
+	ConfigureStorage(storage.NewMemory(&state.MemoryAccountStore{}))
+	defer ConfigureStorage(previous)
+
+	_, lobby, err := gameServer.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.AddLobby(lobby)
+	defer state.RemoveLobby(lobby.ID)
+
+	recorder := httptest.NewRecorder()
+	adminBanEndpoint(recorder, httptest.NewRequest(http.MethodPost, "/v1/admin/ban?address=192.0.2.1&reason=spam", nil))
+	ban := &storage.Ban{}
+	if err := json.NewDecoder(recorder.Body).Decode(ban); err != nil {
+		t.Fatal(err)
+	}
+
+	join := func() int {
+		request := httptest.NewRequest(http.MethodPost, "/v1/lobby/player?lobby_id="+lobby.ID, nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		recorder := httptest.NewRecorder()
+		enterLobby(recorder, request)
+		return recorder.Code
+	}
+	if code := join(); code != http.StatusForbidden {
+		t.Errorf("banned address could join, status %d", code)
+	}
+
+	recorder = httptest.NewRecorder()
+	adminUnbanEndpoint(recorder, httptest.NewRequest(http.MethodPost, "/v1/admin/unban?id="+ban.ID, nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("unbanning returned %d", recorder.Code)
+	}
+	if code := join(); code != http.StatusOK {
+		t.Errorf("unbanned address couldn't join, status %d", code)
+	}
+}
+
+func Test_presets(t *testing.T) {
+	previous := dataStore
+	store := storage.NewMemory(&state.MemoryAccountStore{})
+	ConfigureStorage(store)
+	defer ConfigureStorage(previous)
+	ConfigureAccounts(store, nil, "")
+	defer ConfigureAccounts(nil, nil, "")
+
+	account := state.NewAccount("google", "1234", "marcel", "")
+	if err := store.Save(account); err != nil {
+		t.Fatal(err)
+	}
+	accountCookie := &http.Cookie{Name: "account", Value: encodeAccountToken(account.ID, time.Now().Add(time.Hour))}
+
+	save := func(form string) int {
+		request := httptest.NewRequest(http.MethodPost, "/v1/presets", strings.NewReader(form))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(accountCookie)
+		recorder := httptest.NewRecorder()
+		presetsEndpoint(recorder, request)
+		return recorder.Code
+	}
+	if code := save("name=quick&rounds=2&unknown=1"); code != http.StatusOK {
+		t.Fatalf("saving returned %d", code)
+	}
+	//Saving under the same name replaces the preset.
+	if code := save("name=quick&rounds=3"); code != http.StatusOK {
+		t.Fatalf("saving again returned %d", code)
+	}
+	if code := save("rounds=3"); code != http.StatusBadRequest {
+		t.Errorf("preset without name has been saved, status %d", code)
+	}
+
+	presets, _ := store.Presets(account.ID)
+	if len(presets) != 1 || presets[0].Settings["rounds"] != "3" || presets[0].Settings["unknown"] != "" {
+		t.Fatalf("unexpected presets %+v", presets)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/?preset="+presets[0].ID, nil)
+	request.AddCookie(accountCookie)
+	pageData := createDefaultLobbyCreatePageData()
+	pageData.Account = getAccount(request)
+	pageData.Presets = getPresets(pageData.Account)
+	applyPreset(pageData, request)
+	if pageData.Rounds != "3" || pageData.DrawingTime != "120" {
+		t.Errorf("preset hasn't been applied: %+v", pageData)
+	}
+
+	anonymous := httptest.NewRecorder()
+	presetsEndpoint(anonymous, httptest.NewRequest(http.MethodGet, "/v1/presets", nil))
+	if anonymous.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous players can access presets, status %d", anonymous.Code)
+	}
+}
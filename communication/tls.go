@@ -0,0 +1,131 @@
+package communication
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSOptions configure serving HTTPS. Either a certificate and key file or
+// a list of domains to obtain certificates for via ACME have to be given.
+type TLSOptions struct {
+	// Port is the port to serve HTTPS on.
+	Port     int
+	CertFile string
+	KeyFile  string
+	// AutocertDomains is a comma separated list of the domains that
+	// certificates are requested for from Let's Encrypt. Requests for any
+	// other domain are refused.
+	AutocertDomains string
+	// AutocertCacheDirectory stores the obtained certificates, so they
+	// survive restarts without running into rate limits.
+	AutocertCacheDirectory string
+	// AutocertEmail is optionally passed to Let's Encrypt, so they can
+	// notify about problems with the certificates.
+	AutocertEmail string
+}
+
+var (
+	// httpsPort is the port HTTPS is served on. 0 means that TLS is
+	// disabled.
+	httpsPort int
+	// redirectServer serves the plain HTTP port if TLS is enabled. It
+	// answers ACME challenges and redirects everything else to HTTPS.
+	redirectServer = &http.Server{}
+)
+
+// ConfigureTLS enables serving HTTPS. The HTTP port passed to Serve will
+// then only redirect to HTTPS, answer ACME HTTP-01 challenges and serve
+// /healthz and /readyz for load balancers.
+func ConfigureTLS(options TLSOptions) error {
+	if options.Port <= 0 {
+		return errors.New("the HTTPS port must be positive")
+	}
+
+	var challengeHandler func(http.Handler) http.Handler
+	if options.AutocertDomains != "" {
+		if options.CertFile != "" || options.KeyFile != "" {
+			return errors.New("autocert can't be used together with a certificate file")
+		}
+		if options.AutocertCacheDirectory == "" {
+			return errors.New("autocert requires a cache directory")
+		}
+
+		domains, domainError := parseDomains(options.AutocertDomains)
+		if domainError != nil {
+			return domainError
+		}
+
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(options.AutocertCacheDirectory),
+			Email:      options.AutocertEmail,
+		}
+		server.TLSConfig = manager.TLSConfig()
+		challengeHandler = manager.HTTPHandler
+	} else {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return errors.New("TLS requires either a certificate and key file or autocert domains")
+		}
+
+		certificate, loadError := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if loadError != nil {
+			return fmt.Errorf("error loading certificate: %s", loadError)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+		challengeHandler = func(fallback http.Handler) http.Handler { return fallback }
+	}
+
+	httpsPort = options.Port
+	redirectMux := http.NewServeMux()
+	redirectMux.HandleFunc("/healthz", healthEndpoint)
+	redirectMux.HandleFunc("/readyz", readinessEndpoint)
+	redirectMux.HandleFunc("/", redirectToHTTPS)
+	redirectServer.Handler = challengeHandler(redirectMux)
+	return nil
+}
+
+// parseDomains splits the comma separated list of domains, refusing
+// anything that isn't a plain host name.
+func parseDomains(domains string) ([]string, error) {
+	var result []string
+	for _, domain := range strings.Split(domains, ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+
+		if strings.ContainsAny(domain, ":/*") || net.ParseIP(domain) != nil {
+			return nil, fmt.Errorf("invalid autocert domain '%s'", domain)
+		}
+		result = append(result, domain)
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no autocert domains given")
+	}
+	return result, nil
+}
+
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Use HTTPS", http.StatusBadRequest)
+		return
+	}
+
+	host := r.Host
+	if hostWithoutPort, _, splitError := net.SplitHostPort(host); splitError == nil {
+		host = hostWithoutPort
+	}
+	if httpsPort != 443 {
+		host = net.JoinHostPort(host, fmt.Sprint(httpsPort))
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
@@ -0,0 +1,84 @@
+package communication
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parseDomains(t *testing.T) {
+	tests := []struct {
+		domains   string
+		want      int
+		wantError bool
+	}{
+		{"scribble.example.com", 1, false},
+		{" Scribble.example.com, www.example.com ,", 2, false},
+		{"", 0, true},
+		{"127.0.0.1", 0, true},
+		{"example.com:443", 0, true},
+		{"*.example.com", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domains, func(t *testing.T) {
+			got, err := parseDomains(tt.domains)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("parseDomains() error = %v, wantError %v", err, tt.wantError)
+			}
+			if len(got) != tt.want {
+				t.Errorf("parseDomains() = %v, want %d domains", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ConfigureTLS_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		options TLSOptions
+	}{
+		{"no port", TLSOptions{AutocertDomains: "example.com", AutocertCacheDirectory: "certs"}},
+		{"no certificate", TLSOptions{Port: 443}},
+		{"no key", TLSOptions{Port: 443, CertFile: "cert.pem"}},
+		{"no cache", TLSOptions{Port: 443, AutocertDomains: "example.com"}},
+		{"both", TLSOptions{Port: 443, CertFile: "cert.pem", KeyFile: "key.pem", AutocertDomains: "example.com", AutocertCacheDirectory: "certs"}},
+		{"missing files", TLSOptions{Port: 443, CertFile: "missing.pem", KeyFile: "missing.pem"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ConfigureTLS(tt.options); err == nil {
+				t.Errorf("invalid options have been accepted")
+			}
+		})
+	}
+	if httpsPort != 0 {
+		t.Errorf("TLS has been enabled despite invalid options")
+	}
+}
+
+func Test_redirectToHTTPS(t *testing.T) {
+	defer func(original int) { httpsPort = original }(httpsPort)
+
+	tests := []struct {
+		port   int
+		method string
+		url    string
+		want   string
+	}{
+		{443, http.MethodGet, "http://example.com/ssrEnterLobby?lobby_id=a", "https://example.com/ssrEnterLobby?lobby_id=a"},
+		{443, http.MethodGet, "http://example.com:8080/", "https://example.com/"},
+		{8443, http.MethodGet, "http://example.com:8080/", "https://example.com:8443/"},
+		{443, http.MethodPost, "http://example.com/v1/lobby", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			httpsPort = tt.port
+			recorder := httptest.NewRecorder()
+			redirectToHTTPS(recorder, httptest.NewRequest(tt.method, tt.url, nil))
+
+			if got := recorder.Header().Get("Location"); got != tt.want {
+				t.Errorf("redirectToHTTPS() location = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package communication
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+// transport is implemented by all kinds of connections a player can use for
+// exchanging events, such as websockets or long-polling. All transports share
+// the same event pipeline. Outgoing events are buffered in the connections
+// sendQueue, while incoming events are passed to handleInboundMessage.
+type transport interface {
+	// Close sends the given close code and reason to the client, after all
+	// previously sent events, and closes the connection afterwards.
+	Close(code int, reason string)
+	// queue returns the send queue of the connection.
+	queue() *sendQueue
+}
+
+// getConnectingPlayer finds the lobby and player a request for opening a
+// connection belongs to. If either can't be found, an error is written and
+// nil is returned.
+func getConnectingPlayer(w http.ResponseWriter, r *http.Request) (*game.Lobby, *game.Player) {
+	lobby, lobbyError := getLobby(r)
+	if lobbyError != nil {
+		http.Error(w, lobbyError.Error(), http.StatusNotFound)
+		return nil, nil
+	}
+
+	//This issue can happen if you illegally request a websocket connection without ever having had
+	//a usersession or your client having deleted the usersession cookie.
+	sessionCookie := getUserSession(r)
+	if sessionCookie == "" {
+		http.Error(w, "you don't have access to this lobby;usersession not set", http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	player, sessionError := findSessionPlayer(lobby, sessionCookie)
+	if sessionError == errSessionTokenExpired {
+		http.Error(w, "you don't have access to this lobby;usersession expired", http.StatusUnauthorized)
+		return nil, nil
+	}
+	if sessionError != nil {
+		http.Error(w, "you don't have access to this lobby;usersession invalid", http.StatusUnauthorized)
+		return nil, nil
+	}
+
+	return lobby, player
+}
+
+// attachConnection makes the given connection the players current one. If
+// possible, the players previous session is resumed, otherwise the client
+// receives the full state of the lobby.
+func attachConnection(lobby *game.Lobby, player *game.Player, connection transport, resumeFrom string) {
+	setConnection(player, connection)
+
+	sequence, resume := parseResumeSequence(resumeFrom)
+	if resume && resumeSession(player, connection, sequence) {
+		lobby.PlayerLogger(player).Info("player resumed their session", "name", player.Name)
+		game.OnResumed(lobby, player)
+	} else {
+		game.OnConnected(lobby, player)
+	}
+}
+
+// handleConnectionLost tells the game about the player having disconnected,
+// unless the player has established another connection in the meantime or
+// the game has already been told.
+func handleConnectionLost(lobby *game.Lobby, player *game.Player, connection transport) {
+	if detachConnection(player, connection) {
+		game.OnDisconnected(lobby, player)
+	}
+}
+
+// handleInboundMessage rate limits, decodes and handles a single message
+// sent by the player.
+func handleInboundMessage(lobby *game.Lobby, player *game.Player, rateLimiter *inboundRateLimiter, messageType int, data []byte) {
+	logger := lobby.PlayerLogger(player)
+	limitResult, notify := rateLimiter.allow(time.Now())
+	if limitResult == eventAbusive {
+		logger.Warn("player exceeded the event rate limit, closing connection", "name", player.Name)
+		CloseConnection(player, game.CloseCodeRateLimited, "You have sent too many events.")
+		return
+	}
+	if limitResult == eventThrottled {
+		if notify {
+			WriteAsJSON(player, game.GameEvent{Type: game.EventTypeError, Data: &game.EventError{
+				Code:    game.ErrorCodeRateLimited,
+				Message: "you are sending too many events, some of them have been dropped",
+			}})
+		}
+		return
+	}
+
+	received, err := decodeEvent(getMessageEncoding(player), messageType, data)
+	if err != nil {
+		logger.Warn("error unmarshalling message", "error", err)
+		sendError := WriteAsJSON(player, game.GameEvent{Type: game.EventTypeError, Data: &game.EventError{
+			Code:    game.ErrorCodeMalformedEvent,
+			Message: fmt.Sprintf("the event couldn't be parsed: %s", err),
+		}})
+		if sendError != nil {
+			logger.Debug("error sending error message", "error", sendError)
+		}
+		return
+	}
+
+	countInboundEvent(received.Type)
+	handleError := game.HandleEvent(received, lobby, player)
+	if eventError, rejected := handleError.(*game.EventError); rejected {
+		//The client has been told about the problem already.
+		logger.Debug("event rejected", "type", received.Type, "code", eventError.Code, "error", eventError.Message)
+	} else if handleError == game.ErrLobbyClosed {
+		logger.Debug("event sent to closed lobby", "type", received.Type)
+	} else if handleError != nil {
+		logger.Warn("error handling event", "type", received.Type, "error", handleError)
+	}
+}
+
+// WriteAsJSON marshals the given input using the wire format negotiated with
+// the player, JSON by default, and queues it for sending to the player using
+// the currently established connection. This never blocks on the network,
+// see sendQueue.
+func WriteAsJSON(player *game.Player, object interface{}) error {
+	return writePrepared(player, newPreparedMessage(object))
+}
+
+// writePrepared queues the given message for sending to the player. The
+// message is only encoded if no other player using the same wire format
+// has received it before. Each message is assigned a sequence number and
+// remembered, even if the player isn't connected, so that it can be resent
+// after a reconnect.
+func writePrepared(player *game.Player, message *preparedMessage) error {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	sequence := session.recordSentEvent(message)
+
+	if session.connection == nil || !player.Connected {
+		return errors.New("player not connected")
+	}
+
+	countOutboundEvent(message.eventType)
+	return enqueuePrepared(session.connection.queue(), session.messageEncoding, message, sequence)
+}
+
+func enqueuePrepared(queue *sendQueue, encoding string, message *preparedMessage, sequence uint64) error {
+	encoded, encodeError := message.encode(encoding)
+	if encodeError != nil {
+		return encodeError
+	}
+
+	return queue.enqueue(withSequence(encoding, encoded, sequence), isDrawingEvent(message.eventType))
+}
+
+// resumeSession resends all events the player has missed since the event
+// with the given sequence number and marks the player as connected. If the
+// missed events aren't available anymore, false is returned and the client
+// has to receive the full state instead.
+func resumeSession(player *game.Player, connection transport, sequence uint64) bool {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	missedEvents, available := session.getSentEventsSince(sequence)
+	if !available {
+		return false
+	}
+
+	for index, missedEvent := range missedEvents {
+		missedSequence := sequence + uint64(index) + 1
+		if err := enqueuePrepared(connection.queue(), session.messageEncoding, missedEvent.(*preparedMessage), missedSequence); err != nil {
+			logging.Error("error resending event", "player", player.ID, "error", err)
+		}
+	}
+
+	//This has to happen while holding the lock, otherwise events sent in
+	//between wouldn't reach the player.
+	player.Connected = true
+	return true
+}
+
+// parseResumeSequence parses the sequence number of the last event a
+// reconnecting client has received. If it hasn't been declared or is
+// invalid, the client can't resume its session.
+func parseResumeSequence(value string) (uint64, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	sequence, parseError := strconv.ParseUint(value, 10, 64)
+	return sequence, parseError == nil
+}
+
+// CloseConnection sends the given close code and reason to the player and
+// closes their connection afterwards. Messages that have been queued before
+// are sent first.
+func CloseConnection(player *game.Player, code int, reason string) {
+	session := getSession(player)
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.connection != nil {
+		session.connection.Close(code, reason)
+	}
+}
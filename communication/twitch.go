@@ -0,0 +1,207 @@
+package communication
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+//This file links lobbies to the chat of a Twitch channel, so that streamers
+//can play against their viewers. The chat is read anonymously via IRC, see
+//https://dev.twitch.tv/docs/irc/, which doesn't require any credentials.
+//All viewers share a single audience player, see game.Lobby.AddAudience.
+
+const (
+	twitchChatHost = "irc.chat.twitch.tv"
+	// twitchSourcePrefix is the prefix of the audience source of lobbies
+	// linked to a Twitch channel. It's followed by the channel name.
+	twitchSourcePrefix = "twitch.tv/"
+	// twitchReadTimeout is a bit longer than the interval in which Twitch
+	// pings its clients, so that broken connections are noticed.
+	twitchReadTimeout = 6 * time.Minute
+	// twitchReconnectDelay is the time to wait before reconnecting after
+	// the connection to the chat has been lost.
+	twitchReconnectDelay = 10 * time.Second
+)
+
+var (
+	// twitchEnabled allows linking lobbies to Twitch channels.
+	twitchEnabled bool
+
+	twitchChannelPattern = regexp.MustCompile("^[a-z0-9_]{3,25}$")
+	errAudienceRemoved   = errors.New("the audience has been removed")
+
+	// dialTwitchChat connects to the chat. It's replaced in tests.
+	dialTwitchChat = func(ctx context.Context) (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: 10 * time.Second}
+		connection, err := dialer.DialContext(ctx, "tcp", twitchChatHost+":6697")
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConnection := tls.Client(connection, &tls.Config{ServerName: twitchChatHost})
+		connection.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := tlsConnection.Handshake(); err != nil {
+			connection.Close()
+			return nil, err
+		}
+		connection.SetDeadline(time.Time{})
+		return tlsConnection, nil
+	}
+)
+
+// ConfigureTwitch allows lobbies to be linked to a Twitch channel when
+// they are created. Restored lobbies that have been linked before are linked
+// again, so this has to be called after restoring the lobbies.
+func ConfigureTwitch(enabled bool) {
+	twitchEnabled = enabled
+	if !enabled {
+		return
+	}
+
+	for _, lobby := range state.GetLobbies() {
+		lobby := lobby
+		if source := lobby.AudienceSource(); strings.HasPrefix(source, twitchSourcePrefix) {
+			channel := strings.TrimPrefix(source, twitchSourcePrefix)
+			lobby.Go(func(ctx context.Context) {
+				watchTwitchChat(ctx, lobby, channel)
+			})
+		}
+	}
+}
+
+// parseTwitchChannel accepts the name of a channel, optionally prefixed by
+// '#' or as a link to the channel. An empty value means that the lobby isn't
+// linked.
+func parseTwitchChannel(value string) (string, error) {
+	channel := strings.ToLower(strings.TrimSpace(value))
+	if index := strings.LastIndex(channel, twitchSourcePrefix); index != -1 {
+		channel = strings.TrimSuffix(channel[index+len(twitchSourcePrefix):], "/")
+	}
+	channel = strings.TrimPrefix(channel, "#")
+	if channel == "" {
+		return "", nil
+	}
+
+	if !twitchEnabled {
+		return "", errors.New("linking lobbies to Twitch channels is disabled")
+	}
+	if !twitchChannelPattern.MatchString(channel) {
+		return "", errors.New("the Twitch channel must consist of 3 to 25 letters, digits or underscores")
+	}
+	return channel, nil
+}
+
+// linkTwitchChannel adds the audience to the lobby and passes the messages
+// of the channels chat to it, until either the lobby has been closed or the
+// owner has removed the audience.
+func linkTwitchChannel(lobby *game.Lobby, channel string) error {
+	if _, err := lobby.AddAudience("#"+channel, twitchSourcePrefix+channel); err != nil {
+		return err
+	}
+
+	lobby.Go(func(ctx context.Context) {
+		watchTwitchChat(ctx, lobby, channel)
+	})
+	lobby.Logger().Info("linked lobby to twitch channel", "channel", channel)
+	return nil
+}
+
+// watchTwitchChat reads the chat and reconnects whenever the connection has
+// been lost.
+func watchTwitchChat(ctx context.Context, lobby *game.Lobby, channel string) {
+	for {
+		err := readTwitchChat(ctx, channel, lobby.GuessAsAudience)
+		if ctx.Err() != nil || err == errAudienceRemoved {
+			return
+		}
+		lobby.Logger().Warn("lost connection to twitch chat", "channel", channel, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(twitchReconnectDelay):
+		}
+	}
+}
+
+// readTwitchChat joins the chat of the channel and passes each message to
+// guess. If guess returns false, the chat is left and errAudienceRemoved
+// is returned.
+func readTwitchChat(ctx context.Context, channel string, guess func(viewer, message string) bool) error {
+	connection, err := dialTwitchChat(ctx)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	//Blocking reads and writes are ended by closing the connection.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			connection.Close()
+		case <-stop:
+		}
+	}()
+
+	//Anonymous users are called justinfan followed by any number.
+	if _, err := fmt.Fprintf(connection, "NICK justinfan%d\r\nJOIN #%s\r\n", 10000+rand.Intn(90000), channel); err != nil {
+		return err
+	}
+
+	reader := bufio.NewScanner(connection)
+	for {
+		connection.SetReadDeadline(time.Now().Add(twitchReadTimeout))
+		if !reader.Scan() {
+			if reader.Err() == nil {
+				return errors.New("twitch closed the connection")
+			}
+			return reader.Err()
+		}
+
+		line := reader.Text()
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			if _, err := fmt.Fprintf(connection, "PONG%s\r\n", line[len("PING"):]); err != nil {
+				return err
+			}
+		case strings.HasSuffix(line, " RECONNECT"):
+			return errors.New("twitch asked for reconnecting")
+		default:
+			if viewer, message, isMessage := parseTwitchMessage(line); isMessage && !guess(viewer, message) {
+				return errAudienceRemoved
+			}
+		}
+	}
+}
+
+// parseTwitchMessage returns the viewer and the text of a chat message,
+// which looks like ":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #channel
+// :text".
+func parseTwitchMessage(line string) (string, string, bool) {
+	if !strings.HasPrefix(line, ":") {
+		return "", "", false
+	}
+	parts := strings.SplitN(line[1:], " ", 4)
+	if len(parts) != 4 || parts[1] != "PRIVMSG" || !strings.HasPrefix(parts[3], ":") {
+		return "", "", false
+	}
+
+	viewer := parts[0]
+	if index := strings.IndexByte(viewer, '!'); index != -1 {
+		viewer = viewer[:index]
+	}
+	return viewer, parts[3][1:], true
+}
@@ -0,0 +1,116 @@
+package communication
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+func Test_parseTwitchChannel(t *testing.T) {
+	twitchEnabled = true
+	defer func() { twitchEnabled = false }()
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: " Streamer ", want: "streamer"},
+		{value: "#streamer_42", want: "streamer_42"},
+		{value: "https://www.twitch.tv/streamer/", want: "streamer"},
+		{value: "ab", wantErr: true},
+		{value: "stream er", wantErr: true},
+		{value: "streamer!", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTwitchChannel(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTwitchChannel(%q) = %q, %v", tt.value, got, err)
+		}
+	}
+
+	twitchEnabled = false
+	if _, err := parseTwitchChannel("streamer"); err == nil {
+		t.Error("expected linking to fail while disabled")
+	}
+}
+
+func Test_parseTwitchMessage(t *testing.T) {
+	tests := []struct {
+		line    string
+		viewer  string
+		message string
+		ok      bool
+	}{
+		{":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #streamer :an apple", "viewer", "an apple", true},
+		{":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #streamer ::)", "viewer", ":)", true},
+		{":viewer!viewer@viewer.tmi.twitch.tv JOIN #streamer", "", "", false},
+		{":tmi.twitch.tv 001 justinfan12345 :Welcome, GLHF!", "", "", false},
+		{"PING :tmi.twitch.tv", "", "", false},
+	}
+	for _, tt := range tests {
+		viewer, message, ok := parseTwitchMessage(tt.line)
+		if viewer != tt.viewer || message != tt.message || ok != tt.ok {
+			t.Errorf("parseTwitchMessage(%q) = %q, %q, %v", tt.line, viewer, message, ok)
+		}
+	}
+}
+
+func Test_linkTwitchChannel(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer func(dial func(context.Context) (net.Conn, error)) { dialTwitchChat = dial }(dialTwitchChat)
+	dialTwitchChat = func(context.Context) (net.Conn, error) {
+		return client, nil
+	}
+
+	_, lobby, err := gameServer.CreateLobby("streamer", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer game.CloseLobby(lobby, "")
+	lobby.CurrentWord = "apple"
+	lobby.RoundEndTime = time.Now().Add(time.Minute).UnixNano() / int64(time.Millisecond)
+	if err := linkTwitchChannel(lobby, "streamer"); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(server)
+	readLine := func() string {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(line)
+	}
+	if nick := readLine(); !strings.HasPrefix(nick, "NICK justinfan") {
+		t.Errorf("expected anonymous login, got %q", nick)
+	}
+	if join := readLine(); join != "JOIN #streamer" {
+		t.Errorf("expected channel to be joined, got %q", join)
+	}
+
+	//The ping is only answered once the messages before have been handled.
+	if _, err := server.Write([]byte(":viewer!viewer@viewer.tmi.twitch.tv PRIVMSG #streamer :apple\r\nPING :tmi.twitch.tv\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if pong := readLine(); pong != "PONG :tmi.twitch.tv" {
+		t.Errorf("expected ping to be answered, got %q", pong)
+	}
+
+	var audience *game.Player
+	for _, player := range lobby.SnapshotPlayers() {
+		if player.Audience {
+			audience = player
+		}
+	}
+	if audience == nil || audience.Name != "#streamer" || audience.Score == 0 {
+		t.Errorf("expected the audience to have guessed the word, got %+v", audience)
+	}
+}
@@ -3,7 +3,10 @@ package communication
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/scribble-rs/scribble.rs/game"
 	"github.com/scribble-rs/scribble.rs/state"
@@ -13,39 +16,94 @@ import (
 
 // LobbyEntry is an API object for representing a join-able public lobby.
 type LobbyEntry struct {
-	ID              string `json:"id"`
-	PlayerCount     int    `json:"playerCount"`
-	MaxPlayers      int    `json:"maxPlayers"`
-	Round           int    `json:"round"`
-	MaxRounds       int    `json:"maxRounds"`
-	DrawingTime     int    `json:"drawingTime"`
-	CustomWords     bool   `json:"customWords"`
-	Votekick        bool   `json:"votekick"`
-	MaxClientsPerIP int    `json:"maxClientsPerIp"`
-	Wordpack        string `json:"wordpack"`
+	ID              string   `json:"id"`
+	PlayerCount     int      `json:"playerCount"`
+	MaxPlayers      int      `json:"maxPlayers"`
+	Round           int      `json:"round"`
+	MaxRounds       int      `json:"maxRounds"`
+	DrawingTime     int      `json:"drawingTime"`
+	CustomWords     bool     `json:"customWords"`
+	Votekick        bool     `json:"votekick"`
+	MaxClientsPerIP int      `json:"maxClientsPerIp"`
+	Wordpack        string   `json:"wordpack"`
+	Tags            []string `json:"tags"`
+	Region          string   `json:"region"`
 }
 
+// publicLobbies lists all public lobbies. The result can be filtered using
+// the query parameters 'tag', which can be passed multiple times, and
+// 'region'.
 func publicLobbies(w http.ResponseWriter, r *http.Request) {
+	lobbyEntries := listPublicLobbies(r.URL.Query()["tag"], r.URL.Query().Get("region"))
+
+	encodingError := json.NewEncoder(w).Encode(lobbyEntries)
+	if encodingError != nil {
+		http.Error(w, encodingError.Error(), http.StatusInternalServerError)
+	}
+}
+
+// listPublicLobbies returns the public lobbies of all instances that match
+// the filter, see matchesFilter.
+func listPublicLobbies(tagFilter []string, regionFilter string) []*LobbyEntry {
 	lobbies := state.GetPublicLobbies()
 	lobbyEntries := make([]*LobbyEntry, 0, len(lobbies))
 	for _, lobby := range lobbies {
-		lobbyEntries = append(lobbyEntries, &LobbyEntry{
-			ID:              lobby.ID,
-			PlayerCount:     lobby.GetOccupiedPlayerSlots(),
-			MaxPlayers:      lobby.MaxPlayers,
-			Round:           lobby.Round,
-			MaxRounds:       lobby.MaxRounds,
-			DrawingTime:     lobby.DrawingTime,
-			CustomWords:     len(lobby.CustomWords) > 0,
-			Votekick:        lobby.EnableVotekick,
-			MaxClientsPerIP: lobby.ClientsPerIPLimit,
-			Wordpack:        lobby.Wordpack,
-		})
+		if matchesLobbyFilter(lobby, tagFilter, regionFilter) {
+			lobbyEntries = append(lobbyEntries, newLobbyEntry(lobby))
+		}
 	}
-	encodingError := json.NewEncoder(w).Encode(lobbyEntries)
-	if encodingError != nil {
-		http.Error(w, encodingError.Error(), http.StatusInternalServerError)
+	return append(lobbyEntries, remoteLobbyEntries(tagFilter, regionFilter)...)
+}
+
+func newLobbyEntry(lobby *game.Lobby) *LobbyEntry {
+	return &LobbyEntry{
+		ID:              lobby.ID,
+		PlayerCount:     lobby.GetOccupiedPlayerSlots(),
+		MaxPlayers:      lobby.MaxPlayers,
+		Round:           lobby.Round,
+		MaxRounds:       lobby.MaxRounds,
+		DrawingTime:     lobby.DrawingTime,
+		CustomWords:     len(lobby.CustomWords) > 0,
+		Votekick:        lobby.EnableVotekick,
+		MaxClientsPerIP: lobby.ClientsPerIPLimit,
+		Wordpack:        lobby.Wordpack,
+		Tags:            lobby.Tags,
+		Region:          lobby.Region,
+	}
+}
+
+// matchesLobbyFilter checks whether the lobby has all of the given tags and
+// the given region. Empty filters match every lobby. The comparison is
+// case-insensitive.
+func matchesLobbyFilter(lobby *game.Lobby, tags []string, region string) bool {
+	return matchesFilter(lobby.Tags, lobby.Region, tags, region)
+}
+
+func matchesFilter(lobbyTags []string, lobbyRegion string, tags []string, region string) bool {
+	trimmedRegion := strings.TrimSpace(region)
+	if trimmedRegion != "" && !strings.EqualFold(trimmedRegion, lobbyRegion) {
+		return false
+	}
+
+	for _, tag := range tags {
+		trimmedTag := strings.TrimSpace(tag)
+		if trimmedTag == "" {
+			continue
+		}
+
+		found := false
+		for _, lobbyTag := range lobbyTags {
+			if strings.EqualFold(trimmedTag, lobbyTag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
+
+	return true
 }
 
 func createLobby(w http.ResponseWriter, r *http.Request) {
@@ -55,60 +113,47 @@ func createLobby(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	language, languageInvalid := parseLanguage(r.Form.Get("language"))
-	drawingTime, drawingTimeInvalid := parseDrawingTime(r.Form.Get("drawing_time"))
-	rounds, roundsInvalid := parseRounds(r.Form.Get("rounds"))
-	maxPlayers, maxPlayersInvalid := parseMaxPlayers(r.Form.Get("max_players"))
-	customWords, customWordsInvalid := parseCustomWords(r.Form.Get("custom_words"))
-	customWordChance, customWordChanceInvalid := parseCustomWordsChance(r.Form.Get("custom_words_chance"))
-	clientsPerIPLimit, clientsPerIPLimitInvalid := parseClientsPerIPLimit(r.Form.Get("clients_per_ip_limit"))
-	enableVotekick := r.Form.Get("enable_votekick") == "true"
-	publicLobby := r.Form.Get("public") == "true"
-
-	var errors []string
-	if languageInvalid != nil {
-		errors = append(errors, languageInvalid.Error())
-	}
-	if drawingTimeInvalid != nil {
-		errors = append(errors, drawingTimeInvalid.Error())
-	}
-	if roundsInvalid != nil {
-		errors = append(errors, roundsInvalid.Error())
-	}
-	if maxPlayersInvalid != nil {
-		errors = append(errors, maxPlayersInvalid.Error())
+	settings, errors := parseLobbySettings(r.Form)
+	if len(errors) != 0 {
+		http.Error(w, strings.Join(errors, ";"), http.StatusBadRequest)
+		return
 	}
-	if customWordsInvalid != nil {
-		errors = append(errors, customWordsInvalid.Error())
+
+	requestAddress, addressError := getIPAddressFromRequest(r)
+	if addressError != nil {
+		http.Error(w, addressError.Error(), http.StatusBadRequest)
+		return
 	}
-	if customWordChanceInvalid != nil {
-		errors = append(errors, customWordChanceInvalid.Error())
+	if findInstanceBan(r, requestAddress) != nil {
+		http.Error(w, instanceBanMessage, http.StatusForbidden)
+		return
 	}
-	if clientsPerIPLimitInvalid != nil {
-		errors = append(errors, clientsPerIPLimitInvalid.Error())
+
+	if !checkAPIVerification(w, r, requestAddress) {
+		return
 	}
 
-	if len(errors) != 0 {
-		http.Error(w, strings.Join(errors, ";"), http.StatusBadRequest)
+	if allowed, retryAfter := lobbyCreationLimit.reserve(requestAddress, time.Now()); !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+		http.Error(w, lobbyCreationLimitError(retryAfter), http.StatusTooManyRequests)
 		return
 	}
 
 	var playerName = getPlayername(r)
-	player, lobby, createError := game.CreateLobby(playerName, language, publicLobby, drawingTime, rounds, maxPlayers, customWordChance, clientsPerIPLimit, customWords, enableVotekick)
+	player, lobby, createError := settings.create(playerName)
+	if createError == game.ErrShuttingDown {
+		http.Error(w, createError.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if createError != nil {
 		http.Error(w, createError.Error(), http.StatusBadRequest)
 		return
 	}
 
-	player.SetLastKnownAddress(getIPAddressFromRequest(r))
+	player.SetLastKnownAddress(requestAddress)
 
-	// Use the players generated usersession and pass it as a cookie.
-	http.SetCookie(w, &http.Cookie{
-		Name:     "usersession",
-		Value:    player.GetUserSession(),
-		Path:     "/",
-		SameSite: http.SameSiteStrictMode,
-	})
+	linkAccount(lobby, player, r)
+	setSessionCookie(w, lobby, player, false)
 
 	lobbyData := createLobbyData(lobby.ID)
 
@@ -117,16 +162,109 @@ func createLobby(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, encodingError.Error(), http.StatusInternalServerError)
 	}
 
+	settings.open(lobby)
+}
+
+// lobbySettings are the validated settings for creating a lobby via the
+// API.
+type lobbySettings struct {
+	language          string
+	drawingTime       int
+	rounds            int
+	maxPlayers        int
+	customWords       []string
+	customWordChance  int
+	clientsPerIPLimit int
+	startDelay        int
+	tags              []string
+	region            string
+	twitchChannel     string
+	enableVotekick    bool
+	public            bool
+}
+
+// parseLobbySettings validates the settings passed as form values. All
+// problems are returned at once.
+func parseLobbySettings(form url.Values) (*lobbySettings, []string) {
+	settings := &lobbySettings{
+		enableVotekick: form.Get("enable_votekick") == "true",
+		public:         form.Get("public") == "true",
+	}
+
+	var errors []string
+	collect := func(err error) {
+		if err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+	var err error
+	settings.language, err = parseLanguage(form.Get("language"))
+	collect(err)
+	settings.drawingTime, err = parseDrawingTime(form.Get("drawing_time"))
+	collect(err)
+	settings.rounds, err = parseRounds(form.Get("rounds"))
+	collect(err)
+	settings.maxPlayers, err = parseMaxPlayers(form.Get("max_players"))
+	collect(err)
+	settings.customWords, err = parseCustomWords(form.Get("custom_words"))
+	collect(err)
+	settings.customWordChance, err = parseCustomWordsChance(form.Get("custom_words_chance"))
+	collect(err)
+	settings.clientsPerIPLimit, err = parseClientsPerIPLimit(form.Get("clients_per_ip_limit"))
+	collect(err)
+	settings.startDelay, err = parseStartDelay(form.Get("start_delay"))
+	collect(err)
+	settings.tags, err = parseTags(form.Get("tags"))
+	collect(err)
+	settings.region, err = parseRegion(form.Get("region"))
+	collect(err)
+	settings.twitchChannel, err = parseTwitchChannel(form.Get("twitch_channel"))
+	collect(err)
+
+	return settings, errors
+}
+
+// create creates the lobby, but doesn't make it available yet, see open.
+func (settings *lobbySettings) create(playerName string) (*game.Player, *game.Lobby, error) {
+	player, lobby, err := gameServer.CreateLobby(playerName, settings.language, settings.public, settings.drawingTime, settings.rounds,
+		settings.maxPlayers, settings.customWordChance, settings.clientsPerIPLimit, settings.customWords, settings.enableVotekick)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lobby.Tags = settings.tags
+	lobby.Region = settings.region
+	return player, lobby, nil
+}
+
+// open makes the lobby available, schedules its start and links it to the
+// Twitch channel.
+func (settings *lobbySettings) open(lobby *game.Lobby) {
+	if settings.startDelay > 0 {
+		lobby.ScheduleStart(time.Now().Add(time.Duration(settings.startDelay) * time.Minute))
+	}
+	if settings.twitchChannel != "" {
+		if err := linkTwitchChannel(lobby, settings.twitchChannel); err != nil {
+			lobby.Logger().Error("error linking twitch channel", "channel", settings.twitchChannel, "error", err)
+		}
+	}
+
+	//Nobody can join before the lobby has been added, so the players can
+	//safely be read for the notification.
+	notifyWebhooks("lobby-created", lobby)
 	//We only add the lobby if everything else was successful.
 	state.AddLobby(lobby)
 }
 
 func enterLobby(w http.ResponseWriter, r *http.Request) {
 	lobby, err := getLobby(r)
+	if err == state.ErrLobbyNotExistent && redirectToLobbyInstance(w, r) {
+		return
+	}
 	if err != nil {
 		if err == errNoLobbyIDSupplied {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-		} else if err == errLobbyNotExistent {
+		} else if err == state.ErrLobbyNotExistent || err == errInviteInvalid {
 			http.Error(w, err.Error(), http.StatusNotFound)
 		} else {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -134,6 +272,16 @@ func enterLobby(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	requestAddress, addressError := getIPAddressFromRequest(r)
+	if addressError != nil {
+		http.Error(w, addressError.Error(), http.StatusBadRequest)
+		return
+	}
+	if findInstanceBan(r, requestAddress) != nil {
+		http.Error(w, instanceBanMessage, http.StatusForbidden)
+		return
+	}
+
 	player := getPlayer(lobby, r)
 
 	if player == nil {
@@ -143,8 +291,7 @@ func enterLobby(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var clientsWithSameIP int
-		requestAddress := getIPAddressFromRequest(r)
-		for _, otherPlayer := range lobby.GetPlayers() {
+		for _, otherPlayer := range lobby.SnapshotPlayers() {
 			if otherPlayer.GetLastKnownAddress() == requestAddress {
 				clientsWithSameIP++
 				if clientsWithSameIP >= lobby.ClientsPerIPLimit {
@@ -154,21 +301,40 @@ func enterLobby(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		newPlayer := lobby.JoinPlayer(getPlayername(r))
-		newPlayer.SetLastKnownAddress(getIPAddressFromRequest(r))
+		if !checkAPIVerification(w, r, requestAddress) {
+			return
+		}
+
+		inviteToken := getInviteToken(r)
+		//The use is consumed before joining, so that concurrent joins can't
+		//exceed the tokens uses.
+		if inviteToken != "" && !lobby.UseInviteToken(inviteToken) {
+			http.Error(w, errInviteInvalid.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		newPlayer, joinError := lobby.JoinPlayer(getPlayername(r), requestAddress, getPreviousUserSession(r))
+		if joinError != nil && inviteToken != "" {
+			lobby.ReturnInviteToken(inviteToken)
+		}
+		if joinError == game.ErrShuttingDown {
+			http.Error(w, joinError.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if joinError != nil {
+			http.Error(w, joinError.Error(), http.StatusForbidden)
+			return
+		}
 
-		// Use the players generated usersession and pass it as a cookie.
-		http.SetCookie(w, &http.Cookie{
-			Name:     "usersession",
-			Value:    newPlayer.GetUserSession(),
-			Path:     "/",
-			SameSite: http.SameSiteStrictMode,
-		})
+		linkAccount(lobby, newPlayer, r)
+		setSessionCookie(w, lobby, newPlayer, false)
 	} else {
-		player.SetLastKnownAddress(getIPAddressFromRequest(r))
+		player.SetLastKnownAddress(requestAddress)
+		setSessionCookie(w, lobby, player, true)
 	}
 
-	lobbyData := createLobbyData(lobby.ID)
+	//Players that have joined without the ID are identified by their session.
+	lobbyData := createLobbyData(r.URL.Query().Get("lobby_id"))
 
 	encodingError := json.NewEncoder(w).Encode(lobbyData)
 	if encodingError != nil {
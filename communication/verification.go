@@ -0,0 +1,201 @@
+package communication
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+// Verifier makes sure that a request has been made by a human, for example
+// via a CAPTCHA or a proof-of-work challenge.
+type Verifier interface {
+	// NewChallenge returns what the client needs in order to pass the
+	// verification.
+	NewChallenge() (*VerificationChallenge, error)
+	// Verify checks the solution the client has sent via the
+	// 'verification' parameter.
+	Verify(solution, address string) error
+}
+
+// VerificationChallenge tells the client how to pass the verification.
+type VerificationChallenge struct {
+	// Type is either "hcaptcha", "recaptcha" or "pow".
+	Type string `json:"type"`
+	// SiteKey identifies the site for CAPTCHA widgets.
+	SiteKey string `json:"siteKey,omitempty"`
+	// Challenge is the proof-of-work challenge. A solution consists of
+	// the challenge, a colon and a nonce, whose SHA-256 hash starts with
+	// the given amount of zero bits.
+	Challenge  string `json:"challenge,omitempty"`
+	Difficulty int    `json:"difficulty,omitempty"`
+}
+
+var (
+	verifier Verifier
+	// verificationThreshold is the amount of lobbies an address may create
+	// or join within the window, before it has to pass the verification.
+	// 0 means that every request has to be verified.
+	verificationThreshold int
+	verificationTracker   = newAddressLimiter()
+
+	errVerificationRequired = errors.New("please verify that you are human, a challenge can be requested via /v1/verification")
+)
+
+// ConfigureVerification requires clients to pass the verifier before
+// creating or joining a lobby, once they have done so threshold times within
+// the window. A threshold of 0 requires the verification for every request.
+// If the verifier is nil, no verification is required.
+func ConfigureVerification(newVerifier Verifier, threshold int, window time.Duration) {
+	verificationTracker.mutex.Lock()
+	verificationTracker.limit = threshold
+	verificationTracker.window = window
+	verificationTracker.mutex.Unlock()
+
+	verificationThreshold = threshold
+	verifier = newVerifier
+}
+
+// requireVerification checks whether the request has to be verified and if
+// so, whether it contains a valid solution. If not, an error and a new
+// challenge for the client are returned.
+func requireVerification(r *http.Request, address string) (*VerificationChallenge, error) {
+	if verifier == nil {
+		return nil, nil
+	}
+	if verificationThreshold > 0 {
+		if belowThreshold, _ := verificationTracker.reserve(address, time.Now()); belowThreshold {
+			return nil, nil
+		}
+	}
+
+	verificationError := errVerificationRequired
+	if solution := r.FormValue("verification"); solution != "" {
+		if verificationError = verifier.Verify(solution, address); verificationError == nil {
+			return nil, nil
+		}
+		logging.Debug("verification failed", "address", address, "error", verificationError)
+	}
+
+	challenge, challengeError := verifier.NewChallenge()
+	if challengeError != nil {
+		return nil, challengeError
+	}
+	return challenge, verificationError
+}
+
+// verificationEndpoint returns a new challenge, so that API clients can
+// solve it upfront.
+func verificationEndpoint(w http.ResponseWriter, r *http.Request) {
+	if verifier == nil {
+		http.Error(w, "verification is disabled", http.StatusNotFound)
+		return
+	}
+
+	challenge, challengeError := verifier.NewChallenge()
+	if challengeError != nil {
+		http.Error(w, challengeError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	if encodingError := json.NewEncoder(w).Encode(challenge); encodingError != nil {
+		http.Error(w, encodingError.Error(), http.StatusInternalServerError)
+	}
+}
+
+// initialChallenge returns a challenge for pages that are shown before the
+// first request is made, if every request has to be verified anyway. This
+// saves users from having their first attempt refused.
+func initialChallenge() *VerificationChallenge {
+	if verifier == nil || verificationThreshold > 0 {
+		return nil
+	}
+
+	challenge, challengeError := verifier.NewChallenge()
+	if challengeError != nil {
+		logging.Error("error creating verification challenge", "error", challengeError)
+		return nil
+	}
+	return challenge
+}
+
+// verificationRequired is the API response for requests that have to be
+// verified first.
+type verificationRequired struct {
+	Error     string                 `json:"error"`
+	Challenge *VerificationChallenge `json:"challenge"`
+}
+
+// checkAPIVerification answers with 403 and a new challenge if the request
+// has to be verified, but isn't. False is returned in that case.
+func checkAPIVerification(w http.ResponseWriter, r *http.Request, address string) bool {
+	challenge, verificationError := requireVerification(r, address)
+	if verificationError == nil {
+		return true
+	}
+	if challenge == nil {
+		http.Error(w, verificationError.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	if encodingError := json.NewEncoder(w).Encode(verificationRequired{
+		Error:     verificationError.Error(),
+		Challenge: challenge,
+	}); encodingError != nil {
+		logging.Error("error writing verification challenge", "error", encodingError)
+	}
+	return false
+}
+
+// VerificationPageData defines all non-static data for the verification
+// page, which is shown before joining a lobby.
+type VerificationPageData struct {
+	Challenge *VerificationChallenge
+	Error     string
+	// Action is the page to submit the solution to, along with the
+	// original parameters.
+	Action     string
+	Parameters url.Values
+}
+
+// ssrCheckVerification shows the verification page if the request has to be
+// verified, but isn't. False is returned in that case.
+func ssrCheckVerification(w http.ResponseWriter, r *http.Request, address string) bool {
+	challenge, verificationError := requireVerification(r, address)
+	if verificationError == nil {
+		return true
+	}
+	if challenge == nil {
+		userFacingError(w, verificationError.Error())
+		return false
+	}
+
+	parameters := url.Values{}
+	for key, values := range r.URL.Query() {
+		if key != "verification" {
+			parameters[key] = values
+		}
+	}
+	pageData := &VerificationPageData{
+		Challenge:  challenge,
+		Action:     r.URL.Path,
+		Parameters: parameters,
+	}
+	//The first attempt shouldn't greet the user with an error.
+	if verificationError != errVerificationRequired {
+		pageData.Error = "The verification failed, please try again."
+	}
+
+	w.WriteHeader(http.StatusForbidden)
+	if templateError := verificationPage.ExecuteTemplate(w, "verification.html", pageData); templateError != nil {
+		logging.Error("error rendering verification page", "error", templateError)
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package communication
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func solveProofOfWork(challenge *VerificationChallenge) string {
+	for nonce := 0; ; nonce++ {
+		solution := challenge.Challenge + ":" + strconv.Itoa(nonce)
+		if leadingZeroBits(sha256.Sum256([]byte(solution))) >= challenge.Difficulty {
+			return solution
+		}
+	}
+}
+
+func Test_ProofOfWorkVerifier(t *testing.T) {
+	verifier, err := NewProofOfWorkVerifier(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := verifier.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	solution := solveProofOfWork(challenge)
+	if err := verifier.Verify(solution, "1.2.3.4"); err != nil {
+		t.Errorf("valid solution has been refused: %s", err)
+	}
+	if err := verifier.Verify(solution, "1.2.3.4"); err != errChallengeUsed {
+		t.Errorf("challenge could be used twice: %v", err)
+	}
+
+	other, _ := verifier.NewChallenge()
+	other.Challenge = strings.Replace(other.Challenge, ".8.", ".1.", 1)
+	if err := verifier.Verify(solveProofOfWork(other), "1.2.3.4"); err != errInvalidChallenge {
+		t.Errorf("challenge with lowered difficulty has been accepted: %v", err)
+	}
+	if err := verifier.Verify("garbage", "1.2.3.4"); err != errInvalidChallenge {
+		t.Errorf("garbage has been accepted: %v", err)
+	}
+}
+
+func Test_CaptchaVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("secret") == "secret" && r.FormValue("response") == "valid" {
+			w.Write([]byte(`{"success":true}`))
+		} else {
+			w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+		}
+	}))
+	defer server.Close()
+
+	verifier, err := NewCaptchaVerifier("hcaptcha", "sitekey", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.verifyURL = server.URL
+
+	if err := verifier.Verify("valid", "1.2.3.4"); err != nil {
+		t.Errorf("valid token has been refused: %s", err)
+	}
+	if err := verifier.Verify("invalid", "1.2.3.4"); err == nil {
+		t.Errorf("invalid token has been accepted")
+	}
+}
+
+type stubVerifier struct{}
+
+func (stubVerifier) NewChallenge() (*VerificationChallenge, error) {
+	return &VerificationChallenge{Type: "stub"}, nil
+}
+
+func (stubVerifier) Verify(solution, address string) error {
+	if solution != "valid" {
+		return errInvalidChallenge
+	}
+	return nil
+}
+
+func Test_requireVerification(t *testing.T) {
+	defer ConfigureVerification(nil, 0, 0)
+	ConfigureVerification(stubVerifier{}, 1, time.Hour)
+
+	request := func(solution string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/ssrEnterLobby?verification="+solution, nil)
+	}
+
+	if _, err := requireVerification(request(""), "1.2.3.4"); err != nil {
+		t.Errorf("verification was required below the threshold: %s", err)
+	}
+	if challenge, err := requireVerification(request(""), "1.2.3.4"); err != errVerificationRequired || challenge == nil {
+		t.Errorf("expected a challenge above the threshold, got %v, %v", challenge, err)
+	}
+	if _, err := requireVerification(request("invalid"), "1.2.3.4"); err != errInvalidChallenge {
+		t.Errorf("invalid solution has been accepted: %v", err)
+	}
+	if _, err := requireVerification(request("valid"), "1.2.3.4"); err != nil {
+		t.Errorf("valid solution has been refused: %s", err)
+	}
+}
@@ -0,0 +1,268 @@
+package communication
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+const (
+	// webhookQueueSize is the amount of notifications that may wait for
+	// delivery. Further notifications are dropped, so that a slow webhook
+	// can't hold up the lobbies.
+	webhookQueueSize = 100
+	webhookTimeout   = 10 * time.Second
+	// discordMaxScores is the amount of players listed in the results posted
+	// to Discord, which limits the length of messages.
+	discordMaxScores = 10
+)
+
+// Webhook receives notifications about public lobbies via POST requests.
+type Webhook struct {
+	URL string
+	// Format is either "json" for a WebhookNotification or "discord" for
+	// a message compatible with Discord webhooks.
+	Format string
+	// Events are the event types the webhook is notified about. If empty,
+	// it's notified about all of them.
+	Events []string
+}
+
+func (webhook *Webhook) wants(eventType string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, event := range webhook.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookNotification is sent to webhooks using the "json" format. Event
+// is either "lobby-created", "game-started" or "game-finished".
+type WebhookNotification struct {
+	Event string `json:"event"`
+	// Time is the unix timestamp in milliseconds at which the event
+	// happened.
+	Time  int64         `json:"time"`
+	Lobby *WebhookLobby `json:"lobby"`
+	// Scores contains the final results, ordered by rank. It's only set
+	// for "game-finished".
+	Scores []*WebhookScore `json:"scores,omitempty"`
+}
+
+// WebhookLobby describes the lobby a notification is about.
+type WebhookLobby struct {
+	ID string `json:"id"`
+	// URL is the link for joining the lobby. It's only set if the public
+	// URL of the instance has been configured.
+	URL         string   `json:"url,omitempty"`
+	Wordpack    string   `json:"wordpack"`
+	PlayerCount int      `json:"playerCount"`
+	MaxPlayers  int      `json:"maxPlayers"`
+	MaxRounds   int      `json:"maxRounds"`
+	DrawingTime int      `json:"drawingTime"`
+	Tags        []string `json:"tags"`
+	Region      string   `json:"region"`
+}
+
+// WebhookScore is the result of a single player.
+type WebhookScore struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+	Rank  int    `json:"rank"`
+}
+
+type discordWebhookPayload struct {
+	Username        string                 `json:"username"`
+	Content         string                 `json:"content"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+// discordAllowedMentions prevents player names such as "@everyone" from
+// pinging anyone.
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+type webhookDelivery struct {
+	webhook      Webhook
+	notification *WebhookNotification
+}
+
+var (
+	webhooks       []Webhook
+	webhookBaseURL string
+	webhooksMutex  = &sync.RWMutex{}
+	webhookQueue   = make(chan *webhookDelivery, webhookQueueSize)
+	webhookWorker  = &sync.Once{}
+	webhookClient  = &http.Client{Timeout: webhookTimeout}
+)
+
+// ConfigureWebhooks replaces the webhooks that are notified about public
+// lobbies. The public URL is used for linking to lobbies and may be empty.
+func ConfigureWebhooks(configured []Webhook, publicURL string) {
+	webhooksMutex.Lock()
+	webhooks = configured
+	webhookBaseURL = strings.TrimSuffix(publicURL, "/")
+	webhooksMutex.Unlock()
+
+	if len(configured) > 0 {
+		webhookWorker.Do(func() {
+			go deliverWebhooks()
+		})
+	}
+}
+
+// notifyWebhooks queues a notification for all webhooks interested in the
+// event. Private lobbies are never announced, since their ID allows anyone
+// to join. Since the notification reads the players, this has to be called
+// from within the lobbies loop, for example by the lobby notifier, or before
+// the lobby has been added to the state, as nobody can join it until then.
+func notifyWebhooks(eventType string, lobby *game.Lobby) {
+	if !lobby.IsPublic() {
+		return
+	}
+
+	webhooksMutex.RLock()
+	targets := webhooks
+	baseURL := webhookBaseURL
+	webhooksMutex.RUnlock()
+
+	var notification *WebhookNotification
+	for _, webhook := range targets {
+		if !webhook.wants(eventType) {
+			continue
+		}
+		//The lobby keeps changing, so it has to be captured right away.
+		if notification == nil {
+			notification = newWebhookNotification(eventType, lobby, baseURL)
+		}
+
+		select {
+		case webhookQueue <- &webhookDelivery{webhook: webhook, notification: notification}:
+		default:
+			lobby.Logger().Warn("too many pending webhook notifications, dropping notification", "event", eventType)
+		}
+	}
+}
+
+func newWebhookNotification(eventType string, lobby *game.Lobby, baseURL string) *WebhookNotification {
+	players := lobby.GetPlayers()
+	notification := &WebhookNotification{
+		Event: eventType,
+		Time:  time.Now().UnixNano() / int64(time.Millisecond),
+		Lobby: &WebhookLobby{
+			ID:          lobby.ID,
+			Wordpack:    lobby.Wordpack,
+			PlayerCount: len(players),
+			MaxPlayers:  lobby.MaxPlayers,
+			MaxRounds:   lobby.MaxRounds,
+			DrawingTime: lobby.DrawingTime,
+			Tags:        lobby.Tags,
+			Region:      lobby.Region,
+		},
+	}
+	if baseURL != "" {
+		notification.Lobby.URL = baseURL + "/ssrEnterLobby?lobby_id=" + lobby.ID
+	}
+
+	if eventType == "game-finished" {
+		for _, player := range players {
+			notification.Scores = append(notification.Scores, &WebhookScore{
+				Name:  player.Name,
+				Score: player.Score,
+				Rank:  player.Rank,
+			})
+		}
+		sortScores(notification.Scores)
+	}
+
+	return notification
+}
+
+// sortScores orders the scores by rank. There are only a few players, so
+// insertion sort does the job.
+func sortScores(scores []*WebhookScore) {
+	for i := 1; i < len(scores); i++ {
+		for j := i; j > 0 && scores[j].Rank < scores[j-1].Rank; j-- {
+			scores[j], scores[j-1] = scores[j-1], scores[j]
+		}
+	}
+}
+
+func deliverWebhooks() {
+	for delivery := range webhookQueue {
+		if err := sendWebhook(&delivery.webhook, delivery.notification); err != nil {
+			logging.Warn("error sending webhook notification", "event", delivery.notification.Event,
+				"lobby", delivery.notification.Lobby.ID, "error", err)
+		}
+	}
+}
+
+func sendWebhook(webhook *Webhook, notification *WebhookNotification) error {
+	var payload interface{} = notification
+	if webhook.Format == "discord" {
+		payload = &discordWebhookPayload{
+			Username:        "scribble.rs",
+			Content:         formatDiscordMessage(notification),
+			AllowedMentions: discordAllowedMentions{Parse: []string{}},
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	response, err := webhookClient.Post(webhook.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	//Reading the body allows reusing the connection.
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+func formatDiscordMessage(notification *WebhookNotification) string {
+	lobby := notification.Lobby
+	lobbyName := "lobby " + lobby.ID
+	if lobby.URL != "" {
+		lobbyName = fmt.Sprintf("[lobby %s](%s)", lobby.ID, lobby.URL)
+	}
+
+	switch notification.Event {
+	case "lobby-created":
+		return fmt.Sprintf("A new %s has been created: %d rounds of %d seconds with %s words, up to %d players.",
+			lobbyName, lobby.MaxRounds, lobby.DrawingTime, lobby.Wordpack, lobby.MaxPlayers)
+	case "game-started":
+		return fmt.Sprintf("A game with %d players has started in %s.", lobby.PlayerCount, lobbyName)
+	case "game-finished":
+		var message strings.Builder
+		fmt.Fprintf(&message, "The game in %s is over. Final scores:", lobbyName)
+		for index, score := range notification.Scores {
+			if index == discordMaxScores {
+				fmt.Fprintf(&message, "\n… and %d more", len(notification.Scores)-index)
+				break
+			}
+			fmt.Fprintf(&message, "\n%d. %s: %d", score.Rank, score.Name, score.Score)
+		}
+		return message.String()
+	}
+	return notification.Event + " in " + lobbyName
+}
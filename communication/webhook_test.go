@@ -0,0 +1,99 @@
+package communication
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_notifyWebhooks(t *testing.T) {
+	defer func() {
+		webhooks = nil
+		webhookBaseURL = ""
+	}()
+	webhooks = []Webhook{
+		{URL: "http://all.example.com", Format: "json"},
+		{URL: "http://results.example.com", Format: "discord", Events: []string{"game-finished"}},
+	}
+	webhookBaseURL = "https://scribble.example.com"
+
+	_, privateLobby, err := gameServer.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifyWebhooks("lobby-created", privateLobby)
+	if len(webhookQueue) != 0 {
+		t.Fatalf("private lobby has been announced")
+	}
+
+	_, publicLobby, err := gameServer.CreateLobby("owner", "english", true, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifyWebhooks("lobby-created", publicLobby)
+	notifyWebhooks("game-finished", publicLobby)
+	if len(webhookQueue) != 3 {
+		t.Fatalf("expected 3 queued notifications, got %d", len(webhookQueue))
+	}
+
+	created := <-webhookQueue
+	if created.webhook.URL != "http://all.example.com" || created.notification.Lobby.URL != "https://scribble.example.com/ssrEnterLobby?lobby_id="+publicLobby.ID {
+		t.Errorf("unexpected notification for created lobby: %+v", created.notification.Lobby)
+	}
+	for len(webhookQueue) > 0 {
+		finished := <-webhookQueue
+		if finished.notification.Event != "game-finished" || len(finished.notification.Scores) != 1 {
+			t.Errorf("unexpected notification for finished game: %+v", finished.notification)
+		}
+	}
+}
+
+func Test_sendWebhook(t *testing.T) {
+	var received []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+		if strings.Contains(string(received), "fail") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	notification := &WebhookNotification{
+		Event: "game-finished",
+		Lobby: &WebhookLobby{ID: "lobby"},
+		Scores: []*WebhookScore{
+			{Name: "@everyone", Score: 100, Rank: 1},
+			{Name: "second", Score: 50, Rank: 2},
+		},
+	}
+
+	if err := sendWebhook(&Webhook{URL: server.URL, Format: "json"}, notification); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &WebhookNotification{}
+	if err := json.Unmarshal(received, decoded); err != nil || len(decoded.Scores) != 2 {
+		t.Errorf("unexpected JSON notification %s", received)
+	}
+
+	if err := sendWebhook(&Webhook{URL: server.URL, Format: "discord"}, notification); err != nil {
+		t.Fatal(err)
+	}
+	payload := &discordWebhookPayload{}
+	if err := json.Unmarshal(received, payload); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payload.Content, "1. @everyone: 100\n2. second: 50") {
+		t.Errorf("unexpected discord message %q", payload.Content)
+	}
+	if !strings.Contains(string(received), `"allowed_mentions":{"parse":[]}`) {
+		t.Errorf("mentions aren't disabled: %s", received)
+	}
+
+	notification.Lobby.ID = "fail"
+	if err := sendWebhook(&Webhook{URL: server.URL, Format: "json"}, notification); err == nil {
+		t.Errorf("error status hasn't been reported")
+	}
+}
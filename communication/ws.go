@@ -1,51 +1,140 @@
 package communication
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
-	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/state"
+	"github.com/scribble-rs/scribble.rs/tracing"
+)
+
+const (
+	// pingInterval is the interval in which the server pings each client
+	// in order to detect dead connections and measure the latency.
+	pingInterval = 10 * time.Second
+	// maxMissedPongs is the amount of pings a client may leave unanswered
+	// in a row, before the connection is considered dead.
+	maxMissedPongs = 3
+	// writeTimeout is the maximum time a single write may take, before the
+	// connection is considered dead.
+	writeTimeout = 10 * time.Second
+	// compressionThreshold is the minimum size of a message in bytes, for
+	// it to be compressed. Smaller messages, such as single lines, aren't
+	// worth the CPU time and could even grow in size.
+	compressionThreshold = 512
+	// maxInboundMessageSize is the maximum size of a single message sent by
+	// a client, the same as for events sent via polling.
+	maxInboundMessageSize = maxPostedEventSize
 )
 
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     isAllowedOrigin,
+}
+
+// ConfigureCompression toggles the permessage-deflate extension. If enabled,
+// it's negotiated with each client, but only used for messages that are big
+// enough, such as the current drawing sent on connect.
+func ConfigureCompression(enabled bool) {
+	upgrader.EnableCompression = enabled
+}
+
+// gameServer creates all lobbies of this instance, which then send their
+// events via the players connections.
+var gameServer = game.NewServer(connectionTransport{}, lobbyNotifier{})
+
+// GameServer returns the server used for creating and restoring lobbies.
+func GameServer() *game.Server {
+	return gameServer
+}
+
+// connectionTransport implements game.Transport using the functions of this
+// package.
+type connectionTransport struct{}
+
+func (connectionTransport) WriteAsJSON(player *game.Player, object interface{}) error {
+	return WriteAsJSON(player, object)
+}
+
+func (connectionTransport) TriggerUpdateEvent(eventType string, data interface{}, lobby *game.Lobby) {
+	TriggerUpdateEvent(eventType, data, lobby)
+}
+
+func (connectionTransport) TriggerUpdatePerPlayerEvent(eventType string, data func(*game.Player) interface{}, lobby *game.Lobby) {
+	TriggerUpdatePerPlayerEvent(eventType, data, lobby)
+}
+
+func (connectionTransport) SendDataToEveryoneExceptSender(sender *game.Player, lobby *game.Lobby, data interface{}) {
+	SendDataToEveryoneExceptSender(sender, lobby, data)
+}
+
+func (connectionTransport) WritePublicSystemMessage(lobby *game.Lobby, text string) {
+	WritePublicSystemMessage(lobby, text)
+}
+
+func (connectionTransport) CloseConnection(player *game.Player, code int, reason string) {
+	CloseConnection(player, code, reason)
+}
+
+// lobbyNotifier implements game.LobbyNotifier by updating the lobby state,
+// the webhooks, the audit log, the game history and Rocket.Chat.
+type lobbyNotifier struct{}
+
+func (lobbyNotifier) LobbyRemoved(lobby *game.Lobby) {
+	//Lobbies that are closed before having been added, for example due to
+	//a crash, aren't part of the state, which is fine.
+	_ = state.RemoveLobby(lobby.ID)
+}
+
+func (lobbyNotifier) GameStarted(lobby *game.Lobby) {
+	notifyWebhooks("game-started", lobby)
+}
+
+func (lobbyNotifier) GameFinished(lobby *game.Lobby) {
+	notifyWebhooks("game-finished", lobby)
+	recordGame(lobby)
+	publishResults(lobby)
+}
+
+func (lobbyNotifier) RecordAudit(entry *game.AuditEntry) {
+	recordAuditEntry(entry)
+}
+
+func (lobbyNotifier) PlayerConnected(lobby *game.Lobby, player *game.Player) {
+	updateRocketChat(lobby, player)
 }
 
-func init() {
-	game.TriggerUpdateEvent = TriggerUpdateEvent
-	game.SendDataToEveryoneExceptSender = SendDataToEveryoneExceptSender
-	game.WriteAsJSON = WriteAsJSON
-	game.WritePublicSystemMessage = WritePublicSystemMessage
-	game.TriggerUpdatePerPlayerEvent = TriggerUpdatePerPlayerEvent
+func (lobbyNotifier) PlayerDisconnected(lobby *game.Lobby, player *game.Player) {
+	updateRocketChat(lobby, player)
 }
 
 func wsEndpoint(w http.ResponseWriter, r *http.Request) {
-	lobby, lobbyError := getLobby(r)
-	if lobbyError != nil {
-		http.Error(w, lobbyError.Error(), http.StatusNotFound)
+	lobby, player := getConnectingPlayer(w, r)
+	if player == nil {
 		return
 	}
 
-	//This issue can happen if you illegally request a websocket connection without ever having had
-	//a usersession or your client having deleted the usersession cookie.
-	sessionCookie := getUserSession(r)
-	if sessionCookie == "" {
-		http.Error(w, "you don't have access to this lobby;usersession not set", http.StatusUnauthorized)
+	protocolVersion, versionError := negotiateProtocolVersion(r.URL.Query().Get("protocol_version"))
+	if versionError != nil {
+		http.Error(w, versionError.Error(), http.StatusUpgradeRequired)
 		return
 	}
 
-	player := lobby.GetPlayer(sessionCookie)
-	if player == nil {
-		http.Error(w, "you don't have access to this lobby;usersession invalid", http.StatusUnauthorized)
+	encoding, encodingError := negotiateEncoding(r.URL.Query().Get("encoding"))
+	if encodingError != nil {
+		http.Error(w, encodingError.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -55,99 +144,345 @@ func wsEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("%s(%s) has connected\n", player.Name, player.ID)
+	//Since browsers don't expose the response of failed websocket
+	//handshakes, we upgrade the connection in order to tell the client why
+	//it can't connect.
+	if !lobby.CanReconnect(player) {
+		closeWithCode(ws, game.CloseCodeLobbyFull, "Your slot has been taken, since you have been gone for too long.")
+		return
+	}
+
+	lobby.PlayerLogger(player).Info("player connected", "name", player.Name)
 
-	player.SetWebsocket(ws)
-	game.OnConnected(lobby, player)
+	ws.SetReadLimit(maxInboundMessageSize)
+	player.SetProtocolVersion(protocolVersion)
+	setMessageEncoding(player, encoding)
+	connection := newWebsocketConnection(lobby, player, ws)
+	go connection.run()
+	attachConnection(lobby, player, connection, r.URL.Query().Get("resume_from"))
 
 	ws.SetCloseHandler(func(code int, text string) error {
-		game.OnDisconnected(lobby, player)
+		handleConnectionLost(lobby, player, connection)
 		return nil
 	})
 
-	go wsListen(lobby, player, ws)
+	var missedPongs int32
+	ws.SetPongHandler(newPongHandler(lobby, player, &missedPongs))
+
+	go wsListen(lobby, player, connection)
+	go wsPing(player, connection, &missedPongs)
+}
+
+// newPongHandler creates a handler that resets the missed pongs and measures
+// the players latency, using the timestamp that has been sent with the ping.
+func newPongHandler(lobby *game.Lobby, player *game.Player, missedPongs *int32) func(string) error {
+	return func(appData string) error {
+		atomic.StoreInt32(missedPongs, 0)
+		sentAt, parseError := strconv.ParseInt(appData, 10, 64)
+		if parseError == nil {
+			lobby.SetLatency(player, int(time.Since(time.Unix(0, sentAt))/time.Millisecond))
+		}
+		return nil
+	}
+}
+
+// websocketConnection is the default transport. Events are pushed to the
+// client as soon as they have been queued.
+type websocketConnection struct {
+	socket    *websocket.Conn
+	sendQueue *sendQueue
+	logger    *logging.Logger
+}
+
+func newWebsocketConnection(lobby *game.Lobby, player *game.Player, socket *websocket.Conn) *websocketConnection {
+	return &websocketConnection{
+		socket: socket,
+		logger: lobby.PlayerLogger(player),
+		sendQueue: newSendQueue(newDrawingSnapshot(lobby, player), func() {
+			//Closing the socket causes wsListen to handle the disconnect.
+			closeWithCode(socket, game.CloseCodeTooSlow, "The connection is too slow.")
+		}),
+	}
+}
+
+func (connection *websocketConnection) queue() *sendQueue {
+	return connection.sendQueue
+}
+
+// Close queues a close frame containing the given code and reason. The
+// socket is closed as soon as the frame has been sent.
+func (connection *websocketConnection) Close(code int, reason string) {
+	closeMessage := outgoingMessage{messageType: websocket.CloseMessage, data: formatCloseMessage(code, reason)}
+	if connection.sendQueue.enqueue(closeMessage, false) == errConnectionClosed {
+		closeWithCode(connection.socket, code, reason)
+	}
+}
+
+// run writes all queued messages until the queue is closed or a write
+// fails. A failed write closes the socket, since the client is either gone
+// or too slow.
+func (connection *websocketConnection) run() {
+	queue := connection.sendQueue
+	for range queue.signal {
+		for {
+			messages, open := queue.take()
+			if !open {
+				return
+			}
+			if len(messages) == 0 {
+				break
+			}
+
+			for _, message := range messages {
+				writeError := connection.write(message)
+				if writeError != nil {
+					connection.logger.Debug("error writing to socket", "error", writeError)
+				}
+
+				//After a close message, nothing may be sent anymore.
+				if writeError != nil || message.messageType == websocket.CloseMessage {
+					queue.close()
+					connection.socket.Close()
+					return
+				}
+			}
+			queue.recycle(messages)
+		}
+	}
+}
+
+func (connection *websocketConnection) write(message outgoingMessage) error {
+	socket := connection.socket
+	if message.messageType == websocket.CloseMessage {
+		return socket.WriteControl(websocket.CloseMessage, message.data, time.Now().Add(writeTimeout))
+	}
+
+	socket.SetWriteDeadline(time.Now().Add(writeTimeout))
+	//This only has an effect if compression has been negotiated.
+	socket.EnableWriteCompression(len(message.data) >= compressionThreshold)
+	return socket.WriteMessage(message.messageType, message.data)
+}
+
+// wsPing regularly pings the client and closes the connection as soon as
+// the client misses too many pongs. Closing the connection will cause
+// wsListen to handle the disconnect. The pong is handled by the pong
+// handler, which also measures the latency by using the timestamp sent
+// along with the ping.
+func wsPing(player *game.Player, connection *websocketConnection, missedPongs *int32) {
+	socket := connection.socket
+	pingTicker := time.NewTicker(pingInterval)
+	defer pingTicker.Stop()
+
+	for {
+		<-pingTicker.C
+
+		//Player has either disconnected or reconnected with a new socket.
+		if getConnection(player) != connection {
+			return
+		}
+
+		if atomic.AddInt32(missedPongs, 1) > maxMissedPongs {
+			connection.logger.Info("player missed too many pongs, closing connection", "name", player.Name)
+			closeWithCode(socket, game.CloseCodeTimeout, "The connection timed out.")
+			return
+		}
+
+		//WriteControl can safely be called concurrently to other writes.
+		timestamp := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+		pingError := socket.WriteControl(websocket.PingMessage, timestamp, time.Now().Add(pingInterval))
+		if pingError != nil {
+			connection.logger.Debug("error pinging player", "error", pingError)
+		}
+	}
 }
 
-func wsListen(lobby *game.Lobby, player *game.Player, socket *websocket.Conn) {
+// negotiateProtocolVersion determines the protocol version to use for a
+// connection, given the version declared by the client. Clients that don't
+// declare a version are assumed to use the oldest supported version. Newer
+// clients are degraded to the newest version the server supports. Only
+// clients that are too old to be supported cause an error.
+func negotiateProtocolVersion(declaredVersion string) (int, error) {
+	trimmed := strings.TrimSpace(declaredVersion)
+	if trimmed == "" {
+		return game.MinProtocolVersion, nil
+	}
+
+	version, parseError := strconv.ParseInt(trimmed, 10, 64)
+	if parseError != nil || version < 1 {
+		return 0, errors.New("the protocol version must be a positive number")
+	}
+
+	if version < game.MinProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d isn't supported anymore, the oldest supported version is %d", version, game.MinProtocolVersion)
+	}
+
+	if version > game.ProtocolVersion {
+		return game.ProtocolVersion, nil
+	}
+
+	return int(version), nil
+}
+
+func wsListen(lobby *game.Lobby, player *game.Player, connection *websocketConnection) {
+	defer connection.sendQueue.close()
+
 	//Workaround to prevent crash
 	defer func() {
 		err := recover()
 		if err != nil {
-			log.Printf("Error occurred in wsListen.\n\tError: %s\n\tPlayer: %s(%s)\n", err, player.Name, player.ID)
-			game.OnDisconnected(lobby, player)
+			connection.logger.Error("error occurred in wsListen", "error", err)
+			handleConnectionLost(lobby, player, connection)
 		}
 	}()
+
+	rateLimiter := newInboundRateLimiter(time.Now())
 	for {
-		messageType, data, err := socket.ReadMessage()
+		messageType, data, err := connection.socket.ReadMessage()
+		if err == websocket.ErrReadLimit {
+			//The client has already been sent a close frame by the socket.
+			connection.logger.Warn("player sent a message that is too big, closing connection", "name", player.Name)
+			connection.socket.Close()
+			handleConnectionLost(lobby, player, connection)
+			return
+		}
 		if err != nil {
 			if websocket.IsCloseError(err) || websocket.IsUnexpectedCloseError(err) ||
 				//This happens when the server closes the connection. It will cause 1000 retries followed by a panic.
 				strings.Contains(err.Error(), "use of closed network connection") {
 				//Make sure that the sockethandler is called
-				game.OnDisconnected(lobby, player)
+				handleConnectionLost(lobby, player, connection)
 				return
 			}
 
-			log.Printf("Error reading from socket: %s\n", err)
-		} else if messageType == websocket.TextMessage {
-			received := &game.GameEvent{}
-			err := json.Unmarshal(data, received)
-			if err != nil {
-				log.Printf("Error unmarshalling message: %s\n", err)
-				sendError := WriteAsJSON(player, game.GameEvent{Type: "system-message", Data: fmt.Sprintf("An error occurred trying to read your request, please report the error via GitHub: %s!", err)})
-				if sendError != nil {
-					log.Printf("Error sending errormessage: %s\n", sendError)
-				}
-				continue
-			}
-
-			handleError := game.HandleEvent(data, received, lobby, player)
-			if handleError != nil {
-				log.Printf("Error handling event: %s\n", handleError)
-			}
+			connection.logger.Debug("error reading from socket", "error", err)
+		} else if messageType == websocket.TextMessage || messageType == websocket.BinaryMessage {
+			handleInboundMessage(lobby, player, rateLimiter, messageType, data)
 		}
 	}
 }
 
 func SendDataToEveryoneExceptSender(sender *game.Player, lobby *game.Lobby, data interface{}) {
+	message := newPreparedMessage(data)
+	currentBroadcast := startBroadcast(lobby, message.eventType)
+	defer currentBroadcast.end()
+
 	for _, otherPlayer := range lobby.GetPlayers() {
 		if otherPlayer != sender {
-			WriteAsJSON(otherPlayer, data)
+			writePrepared(otherPlayer, message)
+			currentBroadcast.recipients++
 		}
 	}
+
+	if message.eventType != "" {
+		publishLobbyEvent(lobby, message)
+	}
 }
 
 func TriggerUpdateEvent(eventType string, data interface{}, lobby *game.Lobby) {
-	event := &game.GameEvent{Type: eventType, Data: data}
+	currentBroadcast := startBroadcast(lobby, eventType)
+	defer currentBroadcast.end()
+
+	message := newPreparedMessage(&game.GameEvent{Type: eventType, Data: data})
 	for _, otherPlayer := range lobby.GetPlayers() {
-		WriteAsJSON(otherPlayer, event)
+		writePrepared(otherPlayer, message)
+		currentBroadcast.recipients++
 	}
+
+	publishLobbyEvent(lobby, message)
 }
 
+// TriggerUpdatePerPlayerEvent sends an event with different data for each
+// player. Since the data has to be marshalled for each player, this should
+// only be used where the data actually differs.
 func TriggerUpdatePerPlayerEvent(eventType string, data func(*game.Player) interface{}, lobby *game.Lobby) {
+	currentBroadcast := startBroadcast(lobby, eventType)
+	defer currentBroadcast.end()
+
 	for _, otherPlayer := range lobby.GetPlayers() {
 		WriteAsJSON(otherPlayer, &game.GameEvent{Type: eventType, Data: data(otherPlayer)})
+		currentBroadcast.recipients++
+	}
+
+	//The public version of the event is what a player that isn't part of
+	//the game would see.
+	if hasSpectators(lobby.ID) || recordingStorage != nil {
+		publishLobbyEvent(lobby, newPreparedMessage(&game.GameEvent{Type: eventType, Data: data(game.NewSpectator())}))
 	}
 }
 
-// WriteAsJSON marshals the given input into a JSON string and sends it to the
-// player using the currently established websocket connection.
-func WriteAsJSON(player *game.Player, object interface{}) error {
-	player.GetWebsocketMutex().Lock()
-	defer player.GetWebsocketMutex().Unlock()
+// closeWithCode immediately sends a close frame containing the given code
+// and reason and closes the connection afterwards.
+func closeWithCode(socket *websocket.Conn, code int, reason string) {
+	writeError := socket.WriteControl(websocket.CloseMessage, formatCloseMessage(code, reason), time.Now().Add(time.Second))
+	if writeError != nil {
+		logging.Debug("error sending close message", "error", writeError)
+	}
+
+	socket.Close()
+}
 
-	socket := player.GetWebsocket()
-	if socket == nil || !player.Connected {
-		return errors.New("player not connected")
+func formatCloseMessage(code int, reason string) []byte {
+	//Control frames mustn't be longer than 125 bytes, including the code.
+	//The reason is cut at the start of a character, as it has to be valid
+	//UTF-8.
+	if len(reason) > 123 {
+		cut := 123
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
 	}
 
-	return socket.WriteJSON(object)
+	return websocket.FormatCloseMessage(code, reason)
 }
 
 func WritePublicSystemMessage(lobby *game.Lobby, text string) {
-	systemMessageEvent := &game.GameEvent{Type: "system-message", Data: html.EscapeString(text)}
+	currentBroadcast := startBroadcast(lobby, game.EventTypeSystemMessage)
+	defer currentBroadcast.end()
+
+	systemMessageEvent := newPreparedMessage(&game.GameEvent{Type: game.EventTypeSystemMessage, Data: html.EscapeString(text)})
 	for _, otherPlayer := range lobby.GetPlayers() {
 		//In simple message events we ignore write failures.
-		WriteAsJSON(otherPlayer, systemMessageEvent)
+		writePrepared(otherPlayer, systemMessageEvent)
+		currentBroadcast.recipients++
 	}
+
+	publishLobbyEvent(lobby, systemMessageEvent)
+}
+
+// broadcast measures sending a single event to all players of a lobby.
+type broadcast struct {
+	start      time.Time
+	span       *tracing.Span
+	recipients int
+}
+
+// startBroadcast starts measuring a broadcast. If tracing is enabled, the
+// broadcast is traced as part of the work currently being done for the
+// lobby, for example handling the stroke of the drawer. Since this happens
+// for every stroke, the broadcast isn't allocated and the attributes of the
+// span are only created if tracing is enabled.
+func startBroadcast(lobby *game.Lobby, eventType string) broadcast {
+	currentBroadcast := broadcast{start: time.Now()}
+	if tracing.Enabled() {
+		currentBroadcast.span = tracing.Start(lobby.ActiveSpan(), "broadcast", "lobby", lobby.ID, "event.type", eventType)
+	}
+	return currentBroadcast
+}
+
+// end has to be called once the event has been queued for the last
+// recipient.
+func (currentBroadcast *broadcast) end() {
+	observeBroadcast(currentBroadcast.start)
+	if currentBroadcast.span != nil {
+		currentBroadcast.span.SetAttributes("recipients", currentBroadcast.recipients)
+		currentBroadcast.span.End()
+	}
+}
+
+// publishLobbyEvent passes an event sent to all players of the lobby on to
+// the spectators and the recording of the lobby.
+func publishLobbyEvent(lobby *game.Lobby, message *preparedMessage) {
+	publishToSpectators(lobby.ID, message)
+	recordEvent(lobby, message)
 }
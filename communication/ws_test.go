@@ -0,0 +1,191 @@
+package communication
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+// BenchmarkSendDataToEveryoneExceptSender measures broadcasting a single
+// stroke in a full lobby, including taking the messages from the queues.
+func BenchmarkSendDataToEveryoneExceptSender(b *testing.B) {
+	drawer, lobby, err := GameServer().CreateLobby("drawer", "english", false, 60, 1, 24, 0, 24, nil, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer game.CloseLobby(lobby, "")
+
+	for i := 1; i < 24; i++ {
+		if _, joinError := lobby.JoinPlayer(fmt.Sprintf("guesser%d", i), "127.0.0.1", ""); joinError != nil {
+			b.Fatal(joinError)
+		}
+	}
+
+	var connections []*pollConnection
+	for _, player := range lobby.GetPlayers() {
+		connection := newTestPollConnection()
+		setConnection(player, connection)
+		player.Connected = true
+		connections = append(connections, connection)
+	}
+
+	line := &game.Line{FromX: 100, FromY: 200, ToX: 110, ToY: 220, Color: "#ff0000", LineWidth: 8}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SendDataToEveryoneExceptSender(drawer, lobby, &game.GameEvent{Type: "line", Data: line})
+		for _, connection := range connections {
+			messages, _ := connection.sendQueue.take()
+			connection.sendQueue.recycle(messages)
+		}
+	}
+}
+
+// Test_pongHandler makes sure that answered pings reset the missed pongs and
+// that the measured latency ends up in the lobby.
+func Test_pongHandler(t *testing.T) {
+	owner, lobby, err := GameServer().CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer game.CloseLobby(lobby, "")
+
+	missedPongs := int32(2)
+	sockets := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, upgradeError := upgrader.Upgrade(w, r, nil)
+		if upgradeError != nil {
+			t.Error(upgradeError)
+			return
+		}
+		socket.SetPongHandler(newPongHandler(lobby, owner, &missedPongs))
+		sockets <- socket
+		//Pongs are only handled while reading.
+		for {
+			if _, _, readError := socket.ReadMessage(); readError != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	//Pings are only answered while reading.
+	go func() {
+		for {
+			if _, _, readError := client.ReadMessage(); readError != nil {
+				return
+			}
+		}
+	}()
+
+	socket := <-sockets
+	timestamp := []byte(strconv.FormatInt(time.Now().Add(-50*time.Millisecond).UnixNano(), 10))
+	if err := socket.WriteControl(websocket.PingMessage, timestamp, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if latency := lobby.SnapshotPlayers()[0].Latency; latency >= 50 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("latency hasn't been measured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if missed := atomic.LoadInt32(&missedPongs); missed != 0 {
+		t.Errorf("expected missed pongs to be reset, got %d", missed)
+	}
+}
+
+func Test_formatCloseMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		reason     string
+		wantLength int
+	}{
+		{"short", "bye", 3},
+		{"ascii", strings.Repeat("a", 200), 123},
+		//Each umlaut takes two bytes, so the 123rd byte would split one.
+		{"multibyte", strings.Repeat("ä", 100), 122},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := formatCloseMessage(game.CloseCodeLobbyClosed, tt.reason)
+			reason := string(message[2:])
+			if len(reason) != tt.wantLength || !utf8.ValidString(reason) || !strings.HasPrefix(tt.reason, reason) {
+				t.Errorf("formatCloseMessage() reason = %q, want %d bytes of valid UTF-8", reason, tt.wantLength)
+			}
+		})
+	}
+}
+
+// Test_compressionNegotiation makes sure that compression is only used if
+// it has been enabled and the client asked for it.
+func Test_compressionNegotiation(t *testing.T) {
+	defer ConfigureCompression(false)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, upgradeError := upgrader.Upgrade(w, r, nil)
+		if upgradeError != nil {
+			t.Error(upgradeError)
+			return
+		}
+		defer socket.Close()
+		connection := &websocketConnection{socket: socket}
+		message := outgoingMessage{messageType: websocket.TextMessage, data: []byte(strings.Repeat("a", compressionThreshold))}
+		if writeError := connection.write(message); writeError != nil {
+			t.Error(writeError)
+		}
+		//Waits for the client to close the connection.
+		socket.ReadMessage()
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		serverEnabled bool
+		clientEnabled bool
+		want          bool
+	}{
+		{"both", true, true, true},
+		{"client only", false, true, false},
+		{"server only", true, false, false},
+		{"neither", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ConfigureCompression(tt.serverEnabled)
+			dialer := websocket.Dialer{EnableCompression: tt.clientEnabled}
+			client, response, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			negotiated := strings.Contains(response.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+			if negotiated != tt.want {
+				t.Errorf("compression negotiated = %v, want %v", negotiated, tt.want)
+			}
+			if _, data, readError := client.ReadMessage(); readError != nil || len(data) != compressionThreshold {
+				t.Errorf("expected the message to arrive intact, got %d bytes (%v)", len(data), readError)
+			}
+		})
+	}
+}
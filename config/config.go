@@ -0,0 +1,515 @@
+// Package config loads the server configuration. Each setting can be
+// defined in a YAML file, via an environment variable and via a command line
+// flag, which take precedence in the reverse order.
+//
+// Environment variables are named after the settings key, prefixed with
+// "SCRIBBLERS_" and converted to upper snake case. For example, the key
+// "settingBounds.maxRounds" is set via SCRIBBLERS_SETTING_BOUNDS_MAX_ROUNDS.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+const (
+	// environmentPrefix is the prefix of all environment variables read.
+	environmentPrefix = "SCRIBBLERS_"
+	// minAdminTokenLength makes sure admin tokens can't be guessed.
+	minAdminTokenLength = 16
+	// minSessionSecretLength makes sure session tokens can't be forged.
+	minSessionSecretLength = 32
+)
+
+// Config contains all settings of the server. The keys used in files and
+// the names of the corresponding command line flags are the same. Settings
+// tagged with `restart:"true"` can't be changed by reloading the
+// configuration, see ChangesRequiringRestart.
+type Config struct {
+	// PortHTTP is the port to listen on. If it's 0, the PORT environment
+	// variable or 8080 is used.
+	PortHTTP int `yaml:"portHTTP" restart:"true"`
+	// PortHTTPS is the port to serve HTTPS on. If it's 0, TLS is disabled.
+	// Otherwise, PortHTTP only redirects to HTTPS.
+	PortHTTPS   int    `yaml:"portHTTPS" restart:"true"`
+	TLSCertFile string `yaml:"tlsCertFile" restart:"true"`
+	TLSKeyFile  string `yaml:"tlsKeyFile" restart:"true"`
+	// AutocertDomains is a comma separated list of domains to obtain
+	// certificates for from Let's Encrypt, instead of using TLSCertFile.
+	AutocertDomains        string `yaml:"autocertDomains" restart:"true"`
+	AutocertCacheDirectory string `yaml:"autocertCacheDirectory" restart:"true"`
+	AutocertEmail          string `yaml:"autocertEmail" restart:"true"`
+	TrustedProxies         string `yaml:"trustedProxies" restart:"true"`
+	StrictProxyHeaders     bool   `yaml:"strictProxyHeaders" restart:"true"`
+	// GRPCPort is the port to serve the gRPC API on, see
+	// api/scribblers.proto. If it's 0, the gRPC API is disabled.
+	GRPCPort int `yaml:"grpcPort" restart:"true"`
+	// EnableTwitch allows linking lobbies to Twitch channels, whose
+	// viewers guess along via the chat.
+	EnableTwitch bool `yaml:"enableTwitch" restart:"true"`
+	// ClientIPHeader is read instead of X-Forwarded-For and X-Real-IP if
+	// the request comes from a trusted proxy.
+	ClientIPHeader string `yaml:"clientIPHeader" restart:"true"`
+	// CORSOrigins is a comma separated list of the origins that may use
+	// the API and websockets from other sites.
+	CORSOrigins       string `yaml:"corsOrigins" restart:"true"`
+	EnableCompression bool   `yaml:"enableCompression" restart:"true"`
+	EnableMetrics     bool   `yaml:"enableMetrics"`
+	// EnableProfiling serves pprof profiles via the admin API.
+	EnableProfiling    bool   `yaml:"enableProfiling"`
+	RecordingDirectory string `yaml:"recordingDirectory" restart:"true"`
+	// WordListDirectory replaces the bundled word lists, see
+	// game.ConfigureWordListDirectory.
+	WordListDirectory string `yaml:"wordListDirectory"`
+	MaxLobbies        int    `yaml:"maxLobbies"`
+	// LobbyCreationLimit is the amount of lobbies each address may create
+	// within LobbyCreationWindow minutes. 0 means there's no limit.
+	LobbyCreationLimit  int `yaml:"lobbyCreationLimit"`
+	LobbyCreationWindow int `yaml:"lobbyCreationWindow"`
+	// VerificationProvider is either "none", "hcaptcha", "recaptcha" or
+	// "pow" for a proof-of-work challenge.
+	VerificationProvider   string `yaml:"verificationProvider" restart:"true"`
+	VerificationSiteKey    string `yaml:"verificationSiteKey" restart:"true"`
+	VerificationSecret     string `yaml:"verificationSecret" restart:"true"`
+	VerificationDifficulty int    `yaml:"verificationDifficulty" restart:"true"`
+	// VerificationThreshold is the amount of lobbies an address may create
+	// or join within VerificationWindow minutes before having to pass the
+	// verification. 0 means that it's always required.
+	VerificationThreshold int    `yaml:"verificationThreshold" restart:"true"`
+	VerificationWindow    int    `yaml:"verificationWindow" restart:"true"`
+	LogLevel              string `yaml:"logLevel"`
+	LogFormat             string `yaml:"logFormat"`
+	OTLPEndpoint          string `yaml:"otlpEndpoint" restart:"true"`
+	SentryDSN             string `yaml:"sentryDSN" restart:"true"`
+	// AdminToken enables the admin API with full access for everyone
+	// passing it as a bearer token.
+	AdminToken string `yaml:"adminToken" restart:"true"`
+	// AdminTokens grant access to the admin API with limited scopes, so
+	// that moderation can be delegated. They can only be set in the file.
+	AdminTokens []AdminToken `yaml:"adminTokens" restart:"true"`
+	// SessionSecret is the key the session tokens of players are signed
+	// with. If it's empty, a random one is used, so players have to rejoin
+	// their lobbies after a restart.
+	SessionSecret string `yaml:"sessionSecret" restart:"true"`
+	// SessionLifetime is the amount of hours a session token is valid.
+	SessionLifetime int `yaml:"sessionLifetime" restart:"true"`
+	// DiscordClientID and GoogleClientID, together with their secrets,
+	// allow players to log in via these providers. Accounts are saved to
+	// AccountsFile or only kept in memory if it's empty.
+	DiscordClientID     string `yaml:"discordClientID" restart:"true"`
+	DiscordClientSecret string `yaml:"discordClientSecret" restart:"true"`
+	GoogleClientID      string `yaml:"googleClientID" restart:"true"`
+	GoogleClientSecret  string `yaml:"googleClientSecret" restart:"true"`
+	AccountsFile        string `yaml:"accountsFile" restart:"true"`
+	// StorageURL is the database the accounts, the game history, bans and
+	// presets are saved to. If it's empty, they are kept in memory, except
+	// for the accounts, if AccountsFile is set.
+	StorageURL string `yaml:"storageURL" restart:"true"`
+	// ShutdownGracePeriod is the maximum amount of seconds to wait for turns
+	// in progress to end when shutting down.
+	ShutdownGracePeriod int `yaml:"shutdownGracePeriod" restart:"true"`
+	// LobbyStateFile is where lobbies are saved when shutting down, so that
+	// they can be restored on the next start. If it's empty, lobbies are
+	// closed instead.
+	LobbyStateFile string `yaml:"lobbyStateFile" restart:"true"`
+	// RedisURL stores the lobbies in Redis instead of LobbyStateFile, so
+	// that they can be restored on a different host.
+	RedisURL string `yaml:"redisURL" restart:"true"`
+	// ClusterRedisURL makes the instance part of a cluster, whose lobby
+	// directory and messages are shared via Redis.
+	ClusterRedisURL string `yaml:"clusterRedisURL" restart:"true"`
+	// InstanceID identifies the instance within the cluster. It defaults to
+	// the hostname.
+	InstanceID string `yaml:"instanceID" restart:"true"`
+	// InstanceURL is the base URL under which this specific instance can be
+	// reached, for example https://node1.example.com.
+	InstanceURL string `yaml:"instanceURL" restart:"true"`
+	// AuditLogFile is the file moderation actions are appended to. If
+	// it's empty, only the latest actions are kept in memory.
+	AuditLogFile string `yaml:"auditLogFile" restart:"true"`
+	// Webhooks are notified about public lobbies being created and games
+	// starting or ending. They can only be set in the file.
+	Webhooks []Webhook `yaml:"webhooks"`
+	// PublicURL is the URL under which players reach the site, for example
+	// https://scribble.example.com. It's used for links in notifications.
+	PublicURL string `yaml:"publicURL"`
+	// SettingBounds limits the settings players can choose for their
+	// lobbies.
+	SettingBounds game.SettingBounds `yaml:"settingBounds"`
+}
+
+// AdminToken is a named credential for the admin API.
+type AdminToken struct {
+	// Name identifies the token holder in the logs and is used as the
+	// username for basic auth.
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	// Scope is either "read", "moderate" or "full".
+	Scope string `yaml:"scope"`
+}
+
+// Webhook is an URL that's notified about lobby events.
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Format is either "json" or "discord". It defaults to "json".
+	Format string `yaml:"format"`
+	// Events limits the notifications to "lobby-created", "game-started"
+	// and "game-finished". By default, all events are sent.
+	Events []string `yaml:"events"`
+}
+
+// Default returns the configuration used if nothing has been configured.
+func Default() *Config {
+	return &Config{
+		LogLevel:               "info",
+		LogFormat:              "text",
+		LobbyCreationWindow:    60,
+		VerificationProvider:   "none",
+		VerificationDifficulty: 18,
+		VerificationWindow:     60,
+		ShutdownGracePeriod:    60,
+		SessionLifetime:        24,
+		SettingBounds:          game.DefaultSettingBounds(),
+	}
+}
+
+// Load reads the configuration from the given file and the environment. If
+// path is empty, only the environment is read.
+func Load(path string) (*Config, error) {
+	config := Default()
+
+	if path != "" {
+		content, readError := ioutil.ReadFile(path)
+		if readError != nil {
+			return nil, readError
+		}
+		//Unknown keys are most likely typos, so they are refused.
+		if parseError := yaml.UnmarshalStrict(content, config); parseError != nil {
+			return nil, fmt.Errorf("error parsing %s: %s", path, parseError)
+		}
+	}
+
+	if envError := config.applyEnvironment(os.LookupEnv); envError != nil {
+		return nil, envError
+	}
+	//This is the name Sentry documents for all of its SDKs.
+	if config.SentryDSN == "" {
+		config.SentryDSN = os.Getenv("SENTRY_DSN")
+	}
+
+	return config, nil
+}
+
+// ApplyFlags overrides all settings whose flags have explicitly been set.
+// Flags that don't correspond to any setting are ignored.
+func (config *Config) ApplyFlags(flags *flag.FlagSet) error {
+	var applyError error
+	flags.Visit(func(setFlag *flag.Flag) {
+		if applyError != nil {
+			return
+		}
+
+		field, found := findField(reflect.ValueOf(config).Elem(), setFlag.Name)
+		if found {
+			applyError = setValue(field, setFlag.Value.String())
+			if applyError != nil {
+				applyError = fmt.Errorf("invalid value for flag %s: %s", setFlag.Name, applyError)
+			}
+		}
+	})
+
+	return applyError
+}
+
+// Validate makes sure that the configuration can be applied.
+func (config *Config) Validate() error {
+	if _, err := logging.ParseLevel(config.LogLevel); err != nil {
+		return err
+	}
+	if config.LogFormat != "text" && config.LogFormat != "json" {
+		return fmt.Errorf("unknown log format '%s', expected text or json", config.LogFormat)
+	}
+
+	if config.AdminToken != "" && len(config.AdminToken) < minAdminTokenLength {
+		return fmt.Errorf("the admin token must be at least %d characters long", minAdminTokenLength)
+	}
+	if err := validateAdminTokens(config.AdminTokens); err != nil {
+		return err
+	}
+	if config.SessionSecret != "" && len(config.SessionSecret) < minSessionSecretLength {
+		return fmt.Errorf("the session secret must be at least %d characters long", minSessionSecretLength)
+	}
+	if config.SessionLifetime < 1 {
+		return fmt.Errorf("the session lifetime must be at least one hour")
+	}
+	if err := config.validateAccounts(); err != nil {
+		return err
+	}
+	if err := validateWebhooks(config.Webhooks); err != nil {
+		return err
+	}
+	if config.LobbyCreationLimit < 0 {
+		return fmt.Errorf("the lobby creation limit must not be negative")
+	}
+	if config.LobbyCreationLimit > 0 && config.LobbyCreationWindow < 1 {
+		return fmt.Errorf("the lobby creation window must be at least one minute")
+	}
+	if err := config.validateVerification(); err != nil {
+		return err
+	}
+	if config.LobbyStateFile != "" && config.RedisURL != "" {
+		return fmt.Errorf("lobbies can either be stored in lobbyStateFile or redisURL, but not both")
+	}
+	if config.ClusterRedisURL != "" && config.InstanceURL == "" {
+		return fmt.Errorf("instances of a cluster require an instanceURL")
+	}
+	if config.ShutdownGracePeriod < 0 {
+		return fmt.Errorf("the shutdown grace period must not be negative")
+	}
+
+	bounds := config.SettingBounds
+	for _, bound := range []struct {
+		name     string
+		min, max int64
+	}{
+		{"drawing time", bounds.MinDrawingTime, bounds.MaxDrawingTime},
+		{"rounds", bounds.MinRounds, bounds.MaxRounds},
+		{"max players", bounds.MinMaxPlayers, bounds.MaxMaxPlayers},
+		{"clients per IP limit", bounds.MinClientsPerIPLimit, bounds.MaxClientsPerIPLimit},
+	} {
+		if bound.min < 1 || bound.min > bound.max {
+			return fmt.Errorf("invalid bounds for %s: %d to %d", bound.name, bound.min, bound.max)
+		}
+	}
+
+	return nil
+}
+
+func (config *Config) validateVerification() error {
+	switch config.VerificationProvider {
+	case "none":
+		return nil
+	case "hcaptcha", "recaptcha":
+		if config.VerificationSiteKey == "" || config.VerificationSecret == "" {
+			return fmt.Errorf("%s requires verificationSiteKey and verificationSecret", config.VerificationProvider)
+		}
+	case "pow":
+		if config.VerificationDifficulty < 1 || config.VerificationDifficulty > 32 {
+			return fmt.Errorf("the verification difficulty must be between 1 and 32")
+		}
+	default:
+		return fmt.Errorf("unknown verification provider '%s', expected none, hcaptcha, recaptcha or pow", config.VerificationProvider)
+	}
+
+	if config.VerificationThreshold < 0 {
+		return fmt.Errorf("the verification threshold must not be negative")
+	}
+	if config.VerificationThreshold > 0 && config.VerificationWindow < 1 {
+		return fmt.Errorf("the verification window must be at least one minute")
+	}
+	return nil
+}
+
+// AccountsEnabled indicates whether at least one login provider has been
+// configured.
+func (config *Config) AccountsEnabled() bool {
+	return config.DiscordClientID != "" || config.GoogleClientID != ""
+}
+
+func (config *Config) validateAccounts() error {
+	if (config.DiscordClientID == "") != (config.DiscordClientSecret == "") {
+		return fmt.Errorf("discord logins require discordClientID and discordClientSecret")
+	}
+	if (config.GoogleClientID == "") != (config.GoogleClientSecret == "") {
+		return fmt.Errorf("google logins require googleClientID and googleClientSecret")
+	}
+	if config.AccountsFile != "" && config.StorageURL != "" {
+		return fmt.Errorf("accounts can either be stored in accountsFile or storageURL, but not both")
+	}
+	if config.AccountsEnabled() && config.PublicURL == "" {
+		return fmt.Errorf("logins require a publicURL, which the providers redirect to")
+	}
+	return nil
+}
+
+func validateAdminTokens(tokens []AdminToken) error {
+	names := make(map[string]bool)
+	for _, token := range tokens {
+		if token.Name == "" {
+			return fmt.Errorf("all admin tokens require a name")
+		}
+		if names[token.Name] {
+			return fmt.Errorf("the admin token name '%s' is used more than once", token.Name)
+		}
+		names[token.Name] = true
+
+		if len(token.Token) < minAdminTokenLength {
+			return fmt.Errorf("the admin token '%s' must be at least %d characters long", token.Name, minAdminTokenLength)
+		}
+		if token.Scope != "read" && token.Scope != "moderate" && token.Scope != "full" {
+			return fmt.Errorf("unknown scope '%s' for admin token '%s', expected read, moderate or full", token.Scope, token.Name)
+		}
+	}
+
+	return nil
+}
+
+var webhookEvents = map[string]bool{
+	"lobby-created": true,
+	"game-started":  true,
+	"game-finished": true,
+}
+
+func validateWebhooks(webhooks []Webhook) error {
+	for _, webhook := range webhooks {
+		parsed, err := url.Parse(webhook.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid webhook URL '%s'", webhook.URL)
+		}
+		if webhook.Format != "" && webhook.Format != "json" && webhook.Format != "discord" {
+			return fmt.Errorf("unknown format '%s' for webhook '%s', expected json or discord", webhook.Format, webhook.URL)
+		}
+		for _, event := range webhook.Events {
+			if !webhookEvents[event] {
+				return fmt.Errorf("unknown event '%s' for webhook '%s', expected lobby-created, game-started or game-finished", event, webhook.URL)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ChangesRequiringRestart returns the keys of all settings that differ in
+// the given configuration, but can't be applied without restarting.
+func (config *Config) ChangesRequiringRestart(changed *Config) []string {
+	var keys []string
+	current := reflect.ValueOf(config).Elem()
+	other := reflect.ValueOf(changed).Elem()
+	for index := 0; index < current.NumField(); index++ {
+		field := current.Type().Field(index)
+		if field.Tag.Get("restart") != "true" {
+			continue
+		}
+
+		if !reflect.DeepEqual(current.Field(index).Interface(), other.Field(index).Interface()) {
+			keys = append(keys, fieldKey(field))
+		}
+	}
+
+	return keys
+}
+
+// applyEnvironment overrides all settings that have a corresponding
+// environment variable.
+func (config *Config) applyEnvironment(lookup func(string) (string, bool)) error {
+	return walkFields(reflect.ValueOf(config).Elem(), "", func(key string, field reflect.Value) error {
+		name := environmentPrefix + toUpperSnakeCase(key)
+		value, set := lookup(name)
+		if !set {
+			return nil
+		}
+
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+		return nil
+	})
+}
+
+// walkFields calls the visitor for each setting, passing the key used in
+// files, where nested keys are separated by dots.
+func walkFields(structValue reflect.Value, prefix string, visitor func(key string, field reflect.Value) error) error {
+	structType := structValue.Type()
+	for index := 0; index < structType.NumField(); index++ {
+		key := prefix + fieldKey(structType.Field(index))
+		field := structValue.Field(index)
+
+		var err error
+		if field.Kind() == reflect.Struct {
+			err = walkFields(field, key+".", visitor)
+		} else {
+			err = visitor(key, field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findField finds the top-level setting with the given key.
+func findField(structValue reflect.Value, key string) (reflect.Value, bool) {
+	structType := structValue.Type()
+	for index := 0; index < structType.NumField(); index++ {
+		field := structValue.Field(index)
+		if fieldKey(structType.Field(index)) == key && field.Kind() != reflect.Struct {
+			return field, true
+		}
+	}
+
+	return reflect.Value{}, false
+}
+
+func fieldKey(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("yaml"), ",")[0]
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Kind())
+	}
+
+	return nil
+}
+
+// toUpperSnakeCase converts a key such as "settingBounds.maxClientsPerIPLimit"
+// to "SETTING_BOUNDS_MAX_CLIENTS_PER_IP_LIMIT".
+func toUpperSnakeCase(key string) string {
+	runes := []rune(key)
+	var result strings.Builder
+	for index, character := range runes {
+		if character == '.' {
+			result.WriteRune('_')
+			continue
+		}
+
+		if index > 0 && unicode.IsUpper(character) {
+			previous := runes[index-1]
+			nextIsLower := index+1 < len(runes) && unicode.IsLower(runes[index+1])
+			if unicode.IsLower(previous) || (unicode.IsUpper(previous) && nextIsLower) {
+				result.WriteRune('_')
+			}
+		}
+		result.WriteRune(unicode.ToUpper(character))
+	}
+
+	return result.String()
+}
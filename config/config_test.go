@@ -0,0 +1,173 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_toUpperSnakeCase(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"portHTTP", "PORT_HTTP"},
+		{"sentryDSN", "SENTRY_DSN"},
+		{"otlpEndpoint", "OTLP_ENDPOINT"},
+		{"settingBounds.maxClientsPerIPLimit", "SETTING_BOUNDS_MAX_CLIENTS_PER_IP_LIMIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := toUpperSnakeCase(tt.key); got != tt.want {
+				t.Errorf("toUpperSnakeCase() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Load(t *testing.T) {
+	directory, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "config.yaml")
+	content := "portHTTP: 9000\nenableMetrics: true\nlogLevel: debug\nsettingBounds:\n  maxRounds: 10\n" +
+		"adminTokens:\n  - name: moderator\n    token: 0123456789abcdef\n    scope: moderate\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("SCRIBBLERS_LOG_LEVEL", "warn")
+	os.Setenv("SCRIBBLERS_SETTING_BOUNDS_MIN_ROUNDS", "2")
+	defer os.Unsetenv("SCRIBBLERS_LOG_LEVEL")
+	defer os.Unsetenv("SCRIBBLERS_SETTING_BOUNDS_MIN_ROUNDS")
+
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("portHTTP", 0, "")
+	flags.Bool("enableMetrics", false, "")
+	flags.String("unrelated", "", "")
+	if err := flags.Parse([]string{"-portHTTP", "9001", "-unrelated", "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ApplyFlags(flags); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.PortHTTP != 9001 {
+		t.Errorf("flag didn't take precedence over file: %d", config.PortHTTP)
+	}
+	if !config.EnableMetrics {
+		t.Errorf("unset flag overrode file")
+	}
+	if config.LogLevel != "warn" {
+		t.Errorf("environment didn't take precedence over file: %s", config.LogLevel)
+	}
+	if config.SettingBounds.MaxRounds != 10 || config.SettingBounds.MinRounds != 2 {
+		t.Errorf("unexpected round bounds: %+v", config.SettingBounds)
+	}
+	if config.SettingBounds.MaxDrawingTime != Default().SettingBounds.MaxDrawingTime {
+		t.Errorf("unconfigured bounds lost their default: %+v", config.SettingBounds)
+	}
+	if len(config.AdminTokens) != 1 || config.AdminTokens[0].Scope != "moderate" {
+		t.Errorf("unexpected admin tokens: %+v", config.AdminTokens)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("valid configuration refused: %s", err)
+	}
+}
+
+func Test_Load_invalid(t *testing.T) {
+	directory, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("portHTPP: 9000\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Errorf("unknown key has been accepted")
+	}
+
+	os.Setenv("SCRIBBLERS_MAX_LOBBIES", "many")
+	defer os.Unsetenv("SCRIBBLERS_MAX_LOBBIES")
+	if _, err := Load(""); err == nil {
+		t.Errorf("invalid environment variable has been accepted")
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	config := Default()
+	config.SettingBounds.MinMaxPlayers = 30
+	if err := config.Validate(); err == nil {
+		t.Errorf("inverted bounds have been accepted")
+	}
+
+	config = Default()
+	config.AdminTokens = []AdminToken{{Name: "moderator", Token: "0123456789abcdef", Scope: "write"}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("unknown admin scope has been accepted")
+	}
+
+	config = Default()
+	config.AdminTokens = []AdminToken{{Name: "moderator", Token: "short", Scope: "moderate"}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("short admin token has been accepted")
+	}
+
+	config = Default()
+	config.Webhooks = []Webhook{{URL: "ftp://example.com"}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("webhook with invalid URL has been accepted")
+	}
+
+	config = Default()
+	config.Webhooks = []Webhook{{URL: "https://example.com", Format: "discord", Events: []string{"lobby-closed"}}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("webhook with unknown event has been accepted")
+	}
+
+	config = Default()
+	config.LogFormat = "xml"
+	if err := config.Validate(); err == nil {
+		t.Errorf("unknown log format has been accepted")
+	}
+
+	config = Default()
+	config.VerificationProvider = "hcaptcha"
+	if err := config.Validate(); err == nil {
+		t.Errorf("hcaptcha without a secret has been accepted")
+	}
+
+	config = Default()
+	config.LobbyStateFile = "lobbies.json"
+	config.RedisURL = "redis://localhost"
+	if err := config.Validate(); err == nil {
+		t.Errorf("two lobby stores have been accepted")
+	}
+}
+
+func Test_ChangesRequiringRestart(t *testing.T) {
+	running := Default()
+	reloaded := Default()
+	reloaded.PortHTTP = 9000
+	reloaded.LogLevel = "debug"
+	reloaded.SettingBounds.MaxRounds = 5
+	reloaded.SentryDSN = "https://key@sentry.example.com/1"
+
+	changes := running.ChangesRequiringRestart(reloaded)
+	if len(changes) != 2 || changes[0] != "portHTTP" || changes[1] != "sentryDSN" {
+		t.Errorf("unexpected changes requiring a restart: %v", changes)
+	}
+}
@@ -0,0 +1,47 @@
+package game
+
+// GetState returns whether the game is "unstarted", "ongoing" or already
+// over, which is "gameOver".
+func (lobby *Lobby) GetState() string {
+	return string(lobby.state)
+}
+
+// GetOwner returns the player that's currently allowed to change the
+// lobbies settings.
+func (lobby *Lobby) GetOwner() *Player {
+	return lobby.owner
+}
+
+// GetDrawer returns the player that's drawing or has drawn last. If the
+// game hasn't started yet, nil is returned.
+func (lobby *Lobby) GetDrawer() *Player {
+	return lobby.drawer
+}
+
+// CloseLobby ends the game and disconnects all players, showing them the
+// given reason. It returns once the lobby has been torn down, see
+// Lobby.Close.
+func CloseLobby(lobby *Lobby, reason string) {
+	lobby.synchronized(func() {
+		closeLobby(lobby, CloseCodeLobbyClosed, reason)
+	})
+	lobby.waitForTeardown()
+}
+
+// KickPlayer removes the player with the given ID from the lobby, optionally
+// banning them. Unlike kicks initiated by players, there's no vote. The
+// returned value indicates whether the player has been found.
+func (lobby *Lobby) KickPlayer(playerID string, ban bool, reason string) bool {
+	var found bool
+	lobby.synchronized(func() {
+		for index, player := range lobby.players {
+			if player.ID == playerID {
+				kickPlayer(lobby, index, ban, reason)
+				found = true
+				return
+			}
+		}
+	})
+
+	return found
+}
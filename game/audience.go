@@ -0,0 +1,123 @@
+package game
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// AddAudience adds a player representing the viewers of a stream, so that
+// a streamer can play against their chat. The guesses of the viewers are
+// passed via GuessAsAudience. The audience never draws and doesn't take up a
+// player slot, but earns points like any other guesser and is therefore
+// shown on the scoreboard. The source identifies where the guesses come
+// from, such as a chat channel, so that they can be passed on again after
+// the lobby has been restored, see AudienceSource. Each lobby has at most
+// one audience, so adding another one renames the existing one instead.
+func (lobby *Lobby) AddAudience(name, source string) (*Player, error) {
+	var audience *Player
+	if !lobby.synchronized(func() {
+		audience = lobby.getAudience()
+		if audience == nil {
+			audience = createPlayer(name)
+			audience.Audience = true
+			audience.Color = lobby.nextFreePlayerColor()
+			lobby.players = append(lobby.players, audience)
+		}
+		audience.Name = name
+		audience.audienceSource = source
+
+		recalculateRanks(lobby)
+		triggerPlayersUpdate(lobby)
+	}) {
+		return nil, ErrLobbyClosed
+	}
+	return audience, nil
+}
+
+// AudienceSource returns the source passed to AddAudience or an empty
+// string if the lobby doesn't have an audience.
+func (lobby *Lobby) AudienceSource() string {
+	var source string
+	lobby.synchronized(func() {
+		if audience := lobby.getAudience(); audience != nil {
+			source = audience.audienceSource
+		}
+	})
+	return source
+}
+
+// GuessAsAudience handles a message a viewer has sent to the chat of the
+// stream. Unlike the messages of players, these aren't shown in the chat of
+// the lobby. If the message is the word, the audience is awarded points and
+// everyone is told which viewer guessed it. Once the audience has guessed
+// the word, all further guesses are ignored until the next turn. False is
+// returned if the lobby doesn't have an audience anymore, for example
+// because it has been closed.
+func (lobby *Lobby) GuessAsAudience(viewer, message string) bool {
+	hasAudience := false
+	lobby.synchronized(func() {
+		audience := lobby.getAudience()
+		if audience == nil {
+			return
+		}
+		hasAudience = true
+
+		if lobby.CurrentWord == "" || audience.State != Guessing ||
+			simplifyText(lobby.lowercaser.String(strings.TrimSpace(message))) != simplifyText(lobby.CurrentWord) {
+			return
+		}
+		if lobby.checkHooks(func(hook Hook) error { return hook.CorrectGuess(lobby, audience) }) != nil {
+			return
+		}
+
+		//The drawer doesn't get any points for the audience guessing the
+		//word, since the audience plays against the players.
+		secondsLeft := int((lobby.RoundEndTime - lobby.getTimeAsMillis()) / 1000)
+		audience.LastScore = calculateGuesserScore(lobby.hintsLeft, lobby.hintCount, secondsLeft, lobby.DrawingTime)
+		audience.Score += audience.LastScore
+		audience.State = Standby
+
+		lobby.transport.TriggerUpdateEvent(EventTypeCorrectGuess, audience.ID, lobby)
+		lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("%s guessed the word for %s.", html.EscapeString(viewer), audience.Name))
+		recalculateRanks(lobby)
+		triggerPlayersUpdate(lobby)
+	})
+	return hasAudience
+}
+
+// getAudience returns the player representing the audience, if there's one.
+func (lobby *Lobby) getAudience() *Player {
+	for _, player := range lobby.players {
+		if player.Audience {
+			return player
+		}
+	}
+	return nil
+}
+
+// commandAudience removes the audience, which ends the link to the stream:
+// "!audience off".
+func commandAudience(caller *Player, lobby *Lobby, args []string) {
+	if caller != lobby.owner {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Only the lobby owner can remove the audience."})
+		return
+	}
+
+	if len(args) < 2 || strings.ToLower(strings.TrimSpace(args[1])) != "off" {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Use '!audience off' in order to remove the audience."})
+		return
+	}
+
+	for index, player := range lobby.players {
+		if player.Audience {
+			lobby.players = append(lobby.players[:index], lobby.players[index+1:]...)
+			recalculateRanks(lobby)
+			triggerPlayersUpdate(lobby)
+			lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("%s has been removed.", player.Name))
+			return
+		}
+	}
+
+	lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "The lobby doesn't have an audience."})
+}
@@ -0,0 +1,54 @@
+package game
+
+import "testing"
+
+func Test_Audience(t *testing.T) {
+	server, transport, _ := newTestServer()
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 2, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseLobby(lobby, "")
+
+	audience, err := lobby.AddAudience("#streamer", "twitch.tv/streamer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := lobby.AddAudience("#renamed", "twitch.tv/renamed"); again != audience || lobby.AudienceSource() != "twitch.tv/renamed" {
+		t.Error("expected the existing audience to be renamed")
+	}
+	if _, err := lobby.JoinPlayer("guest", "10.0.0.1", ""); err != nil {
+		t.Errorf("the audience shouldn't take up a slot: %s", err)
+	}
+
+	lobby.synchronized(func() {
+		owner.Connected = true
+		lobby.CurrentWord = "apple"
+		lobby.RoundEndTime = lobby.getTimeAsMillis() + 60000
+	})
+	if !lobby.GuessAsAudience("viewer", "pear") || audience.Score != 0 {
+		t.Errorf("wrong guess was accepted: %+v", audience)
+	}
+	if !lobby.GuessAsAudience("viewer", " Apple ") || audience.Score == 0 || audience.State != Standby {
+		t.Errorf("correct guess wasn't accepted: %+v", audience)
+	}
+	score := audience.Score
+	lobby.GuessAsAudience("other viewer", "apple")
+	if audience.Score != score {
+		t.Error("the audience was awarded points twice")
+	}
+
+	lobby.synchronized(func() {
+		if audience.Rank != 1 || owner.Rank != 2 {
+			t.Errorf("expected the audience to be ranked, got %d and %d", audience.Rank, owner.Rank)
+		}
+		if len(transport.systemMessages) == 0 || transport.systemMessages[len(transport.systemMessages)-1] != "viewer guessed the word for #renamed." {
+			t.Errorf("unexpected system messages %v", transport.systemMessages)
+		}
+
+		handleCommand("audience off", owner, lobby)
+	})
+	if lobby.GuessAsAudience("viewer", "apple") || lobby.AudienceSource() != "" {
+		t.Error("expected the audience to be removed")
+	}
+}
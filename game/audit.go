@@ -0,0 +1,44 @@
+package game
+
+// AuditEntry describes a single moderation action, such as a kick, a ban or
+// a change of the lobby settings.
+type AuditEntry struct {
+	// Time is the unix timestamp in milliseconds at which the action
+	// happened.
+	Time    int64  `json:"time"`
+	LobbyID string `json:"lobbyId"`
+	// Action is either "kick", "ban", "votekick", "unban", "close",
+	// "settings", "instance-ban" or "instance-unban". The latter two aren't
+	// related to a lobby.
+	Action string `json:"action"`
+	// ActorType is "player" for the lobby owner, "admin" for the admin API
+	// and "vote" for votekicks, which have no single actor.
+	ActorType  string `json:"actorType"`
+	ActorID    string `json:"actorId,omitempty"`
+	ActorName  string `json:"actorName,omitempty"`
+	TargetID   string `json:"targetId,omitempty"`
+	TargetName string `json:"targetName,omitempty"`
+	Details    string `json:"details,omitempty"`
+}
+
+// recordAudit records an action taken by the given player. If the actor is
+// nil, the action was the outcome of a vote. The target is optional.
+func recordAudit(lobby *Lobby, action string, actor *Player, targetID, targetName, details string) {
+	entry := &AuditEntry{
+		Time:       lobby.getTimeAsMillis(),
+		LobbyID:    lobby.ID,
+		Action:     action,
+		TargetID:   targetID,
+		TargetName: targetName,
+		Details:    details,
+	}
+	if actor != nil {
+		entry.ActorType = "player"
+		entry.ActorID = actor.ID
+		entry.ActorName = actor.Name
+	} else {
+		entry.ActorType = "vote"
+	}
+
+	lobby.notifier.RecordAudit(entry)
+}
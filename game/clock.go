@@ -0,0 +1,104 @@
+package game
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Clock provides the current time and timers to a lobby. It can be
+// replaced in order to control the timing of a game, for example in tests
+// and simulations.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc calls the function in its own goroutine once the duration
+	// has passed.
+	AfterFunc(duration time.Duration, function func()) Timer
+}
+
+// Timer is a pending call created via Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing. False is returned if the timer
+	// has already fired or has been stopped before.
+	Stop() bool
+}
+
+// Random decides about everything that's left to chance in a lobby, such
+// as the words to choose from and the order in which hints are revealed.
+// *rand.Rand implements this interface. It's only used by a single lobby,
+// therefore it doesn't have to be safe for concurrent use.
+type Random interface {
+	Intn(n int) int
+	Shuffle(n int, swap func(i, j int))
+}
+
+// SystemClock is the Clock used by default. It uses the time package.
+type SystemClock struct{}
+
+// Now returns time.Now.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// AfterFunc wraps time.AfterFunc.
+func (SystemClock) AfterFunc(duration time.Duration, function func()) Timer {
+	return time.AfterFunc(duration, function)
+}
+
+// NewRandom creates a Random from the given seed. Lobbies using the same
+// seed and the same clock play out the same way, as long as the players
+// behave the same way.
+func NewRandom(seed int64) Random {
+	return rand.New(rand.NewSource(seed))
+}
+
+func newTimeSeededRandom() Random {
+	return NewRandom(time.Now().UnixNano())
+}
+
+// SetClock makes all lobbies created afterwards use the given clock.
+func (server *Server) SetClock(clock Clock) {
+	server.clock = clock
+}
+
+// SetRandom makes all lobbies created afterwards use a Random created by
+// the given function. Each lobby calls the function once. By default, each
+// lobby uses a Random seeded with the time of its creation.
+func (server *Server) SetRandom(newRandom func() Random) {
+	server.newRandom = newRandom
+}
+
+// now returns the current time according to the lobbies clock.
+func (lobby *Lobby) now() time.Time {
+	if lobby.clock == nil {
+		return time.Now()
+	}
+	return lobby.clock.Now()
+}
+
+// getTimeAsMillis returns the current time according to the lobbies clock
+// as a UTC unix-timestamp in milliseconds.
+func (lobby *Lobby) getTimeAsMillis() int64 {
+	return toMillis(lobby.now())
+}
+
+func toMillis(timestamp time.Time) int64 {
+	return timestamp.UTC().UnixNano() / int64(time.Millisecond)
+}
+
+// afterFunc creates a timer using the lobbies clock.
+func (lobby *Lobby) afterFunc(duration time.Duration, function func()) Timer {
+	if lobby.clock == nil {
+		return time.AfterFunc(duration, function)
+	}
+	return lobby.clock.AfterFunc(duration, function)
+}
+
+// getRandom returns the lobbies Random. Lobbies that haven't been created
+// by a server get a time seeded one on first use.
+func (lobby *Lobby) getRandom() Random {
+	if lobby.random == nil {
+		lobby.random = newTimeSeededRandom()
+	}
+	return lobby.random
+}
@@ -0,0 +1,133 @@
+package game
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves forward when told to. Timers that are due are fired
+// by Advance, on the goroutine calling it.
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	function func()
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) AfterFunc(duration time.Duration, function func()) Timer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	timer := &fakeTimer{clock: clock, deadline: clock.now.Add(duration), function: function}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+func (timer *fakeTimer) Stop() bool {
+	timer.clock.mutex.Lock()
+	defer timer.clock.mutex.Unlock()
+	for index, other := range timer.clock.timers {
+		if other == timer {
+			timer.clock.timers = append(timer.clock.timers[:index], timer.clock.timers[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward, firing all timers that are due in order
+// of their deadline. Timers created by fired timers are fired as well, if
+// they are due.
+func (clock *fakeClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	target := clock.now.Add(duration)
+	clock.mutex.Unlock()
+
+	for {
+		clock.mutex.Lock()
+		sort.SliceStable(clock.timers, func(a, b int) bool {
+			return clock.timers[a].deadline.Before(clock.timers[b].deadline)
+		})
+		if len(clock.timers) == 0 || clock.timers[0].deadline.After(target) {
+			clock.now = target
+			clock.mutex.Unlock()
+			return
+		}
+		timer := clock.timers[0]
+		clock.timers = clock.timers[1:]
+		clock.now = timer.deadline
+		clock.mutex.Unlock()
+
+		timer.function()
+	}
+}
+
+// playSeededTurn starts a game in a lobby using the given seed and lets the
+// drawer run out of time for choosing a word.
+func playSeededTurn(t *testing.T, seed int64) (*Lobby, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1600000000, 0)}
+	server, _, _ := newTestServer()
+	server.SetClock(clock)
+	server.SetRandom(func() Random { return NewRandom(seed) })
+
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := lobby.JoinPlayer("guest", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby.synchronized(func() {
+		owner.Connected = true
+		guest.Connected = true
+		startGame(lobby)
+	})
+
+	clock.Advance(wordChoiceTimeout)
+	return lobby, clock
+}
+
+func Test_deterministicLobby(t *testing.T) {
+	first, firstClock := playSeededTurn(t, 42)
+	defer CloseLobby(first, "")
+	second, secondClock := playSeededTurn(t, 42)
+	defer CloseLobby(second, "")
+
+	var firstWord, secondWord string
+	first.synchronized(func() { firstWord = first.CurrentWord })
+	second.synchronized(func() { secondWord = second.CurrentWord })
+	if firstWord == "" || firstWord != secondWord {
+		t.Errorf("expected the same word to be chosen for the same seed, got %q and %q", firstWord, secondWord)
+	}
+
+	//Once the drawing time is up, both lobbies move on to the next drawer.
+	firstClock.Advance(50 * time.Second)
+	secondClock.Advance(50 * time.Second)
+
+	first.synchronized(func() {
+		second.synchronized(func() {
+			if first.drawer == nil || second.drawer == nil || first.drawer.Name != second.drawer.Name || first.drawer == first.players[0] {
+				t.Errorf("expected the second player to draw in both lobbies")
+			}
+			if first.getTimeAsMillis() != second.getTimeAsMillis() {
+				t.Errorf("expected both clocks to show the same time")
+			}
+			if len(first.wordChoice) == 0 || first.wordChoice[0] != second.wordChoice[0] {
+				t.Errorf("expected the same word choice, got %v and %v", first.wordChoice, second.wordChoice)
+			}
+		})
+	})
+}
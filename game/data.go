@@ -1,17 +1,32 @@
 package game
 
 import (
-	"math/rand"
+	"context"
 	"sync"
 	"time"
 
 	"github.com/gofrs/uuid"
-	"github.com/gorilla/websocket"
 	"golang.org/x/text/cases"
+
+	"github.com/scribble-rs/scribble.rs/logging"
+	"github.com/scribble-rs/scribble.rs/tracing"
 )
 
 const slotReservationTime = time.Minute * 5
 
+// playerColors is the palette used for assigning each player of a lobby a
+// distinct display color. The amount of colors matches the maximum amount
+// of players per lobby, so that there's never a need to assign a color
+// twice. All colors are readable on a white background.
+var playerColors = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231",
+	"#911eb4", "#42a4b4", "#f032e6", "#7d9c1a",
+	"#b5651d", "#008080", "#9a6324", "#800000",
+	"#808000", "#000075", "#c71585", "#2e8b57",
+	"#d2691e", "#6a5acd", "#b22222", "#20603d",
+	"#8b008b", "#556b2f", "#1e90ff", "#696969",
+}
+
 // Lobby represents a game session.
 // FIXME Field visibilities should be changed in case we ever serialize this.
 type Lobby struct {
@@ -31,6 +46,12 @@ type Lobby struct {
 	CustomWords []string
 	words       []string
 	public      bool
+	// Tags are free-form labels, such as "beginners", which help finding
+	// the lobby in the lobby browser.
+	Tags []string
+	// Region is a free-form label describing where the lobby is located,
+	// which helps finding lobbies close to you.
+	Region string
 
 	// players references all participants of the Lobby.
 	players []*Player
@@ -67,8 +88,22 @@ type Lobby struct {
 	// RoundEndTime represents the time at which the current round will end.
 	// This is a UTC unix-timestamp in milliseconds.
 	RoundEndTime int64
+	// ScheduledStartTime is the time at which the game will be started
+	// automatically. Until then, the game can't be started. This is a UTC
+	// unix-timestamp in milliseconds. 0 means the game isn't scheduled.
+	ScheduledStartTime int64
+
+	// scheduler owns the timers of the lobby, such as the end of the turn.
+	scheduler scheduler
+	// turnOrder decides who draws next, see selectNextDrawer.
+	turnOrder turnOrder
+
+	// gameStarted is the time at which the current game has been started.
+	gameStarted time.Time
+	// turns contains the outcome of all turns of the current game, see
+	// Results.
+	turns []*TurnResult
 
-	timeLeftTicker        *time.Ticker
 	scoreEarnedByGuessers int
 	CustomWordsChance     int
 	ClientsPerIPLimit     int
@@ -84,6 +119,79 @@ type Lobby struct {
 	//LastPlayerDisconnectTime is used to know since when a lobby is empty, in case
 	//it is empty.
 	LastPlayerDisconnectTime *time.Time
+
+	// inviteTokens are the currently active invites, mapped by their token.
+	// They allow joining the lobby without knowing the lobbies ID.
+	inviteTokens     map[string]*InviteToken
+	inviteTokenMutex *sync.Mutex
+
+	// bans contains all players that have been removed from the lobby. Banned
+	// players can't join again for the rest of the lobbies lifetime, unless
+	// the owner unbans them.
+	bans     []*Ban
+	banMutex *sync.Mutex
+
+	// activeSpan is the span of the work currently being done for the
+	// lobby, such as handling an event. Broadcasts are attached to it.
+	activeSpan *tracing.Span
+	spanMutex  *sync.Mutex
+
+	// crashed is set to 1 once a panic has occurred while working on the
+	// lobby, see recoverLobby.
+	crashed int32
+	// closed is set to 1 once the lobby has been closed, see closeLobby.
+	closed int32
+
+	// transport delivers events to the players, while notifier is told
+	// about what's happening in the lobby. Both are provided by the Server
+	// that created the lobby.
+	transport Transport
+	notifier  LobbyNotifier
+
+	// actions are run one after another by the lobbies loop, which closes
+	// stopped once it has ended, see runLoop.
+	actions chan func()
+	stopped chan struct{}
+
+	// lifetime is cancelled once the lobby has been closed. All background
+	// work of the lobby, such as pending timers and the work started via Go,
+	// is counted by background, so that the lobby is only removed once all
+	// of it has stopped. See lifecycle.go.
+	lifetime       context.Context
+	endLifetime    context.CancelFunc
+	background     sync.WaitGroup
+	lifecycleMutex sync.Mutex
+
+	// clock and random are the source of time and chance of the lobby. See
+	// Server.SetClock and Server.SetRandom.
+	clock  Clock
+	random Random
+
+	// hooks are asked about key events of the lobby, see
+	// Server.RegisterHook.
+	hooks []Hook
+}
+
+// Ban identifies a player that has been removed from a lobby, both via their
+// session and their address.
+type Ban struct {
+	PlayerName  string
+	userSession string
+	address     string
+}
+
+// InviteToken allows joining a lobby without knowing its permanent ID. Each
+// token is only valid for a certain amount of time and joins.
+type InviteToken struct {
+	Token     string
+	ExpiresAt time.Time
+	// UsesLeft is the amount of players that can still join using this
+	// token. Reconnecting players don't consume any uses.
+	UsesLeft int
+}
+
+func (token *InviteToken) isValid() bool {
+	return token.UsesLeft > 0 && time.Now().Before(token.ExpiresAt)
 }
 
 type gameState string
@@ -125,34 +233,86 @@ const MaxPlayerNameLength int = 30
 
 // Player represents a participant in a Lobby.
 type Player struct {
-	// userSession uniquely identifies the player.
+	// userSession uniquely identifies the player. It's part of the players
+	// session token and replaced whenever a new token is issued, which
+	// invalidates all previous ones. See RotateUserSession.
 	userSession      string
-	ws               *websocket.Conn
-	socketMutex      *sync.Mutex
+	sessionMutex     sync.Mutex
 	lastKnownAddress string
+	// accountID is set if the player has logged in, see LinkAccount.
+	accountID string
+	// audienceSource is where the guesses of the audience come from, see
+	// Lobby.AddAudience.
+	audienceSource string
 	// disconnectTime is used to kick a player in case the lobby doesn't have
 	// space for new players. The player with the oldest disconnect.Time will
 	// get kicked.
 	disconnectTime *time.Time
 
 	votedForKick map[string]bool
+	// reactionCount is the amount of reactions sent during the current turn.
+	reactionCount int
+
+	// protocolVersion is the version of the websocket protocol negotiated
+	// with the players client.
+	protocolVersion int
+	// transportState is owned by the transport, which uses it for keeping
+	// track of the players connection. The game never looks at it.
+	transportState interface{}
 
 	// ID uniquely identified the Player.
 	ID string `json:"id"`
 	// Name is the players displayed name
 	Name string `json:"name"`
+	// Color is the players display color, used for the playerlist and chat.
+	// It's unique within a lobby, but will be reused after a player leaves.
+	Color string `json:"color"`
 	// Score is the points that the player got in the current Lobby.
 	Score int `json:"score"`
-	// Connected defines whether the players websocket connection is currently
+	// Connected defines whether the players connection is currently
 	// established. This has previously been in state but has been moved out
 	// in order to avoid losing the state on refreshing the page.
-	// While checking the websocket against nil would be enough, we still need
-	// this field for sending it via the APIs.
 	Connected bool `json:"connected"`
 	// Rank is the current ranking of the player in his Lobby
 	LastScore int         `json:"lastScore"`
 	Rank      int         `json:"rank"`
 	State     PlayerState `json:"state"`
+	// Latency is the round trip time of the players connection in
+	// milliseconds, as measured by the last answered ping.
+	Latency int `json:"latency"`
+	// Avatar is the URL of the profile picture of players that have logged
+	// in. Anonymous players don't have one.
+	Avatar string `json:"avatar,omitempty"`
+	// Audience marks the player representing the viewers of a stream, who
+	// never connects, see Lobby.AddAudience.
+	Audience bool `json:"audience,omitempty"`
+}
+
+// GetAccountID returns the ID of the account the player has logged in with
+// or an empty string for anonymous players.
+func (player *Player) GetAccountID() string {
+	return player.accountID
+}
+
+// copyForReading copies everything that can be read from outside of the
+// game package, leaving out the session and the transport state.
+func (player *Player) copyForReading() *Player {
+	return &Player{
+		lastKnownAddress: player.lastKnownAddress,
+		accountID:        player.accountID,
+		protocolVersion:  player.protocolVersion,
+		ID:               player.ID,
+		Name:             player.Name,
+		Color:            player.Color,
+		Score:            player.Score,
+		Connected:        player.Connected,
+		LastScore:        player.LastScore,
+		Rank:             player.Rank,
+		State:            player.State,
+		Latency:          player.Latency,
+		Avatar:           player.Avatar,
+		Audience:         player.Audience,
+	}
 }
 
 // GetLastKnownAddress returns the last known IP-Address used for an HTTP request.
@@ -166,29 +326,46 @@ func (player *Player) SetLastKnownAddress(address string) {
 	player.lastKnownAddress = address
 }
 
-// GetWebsocket simply returns the players websocket connection. This method
-// exists to encapsulate the websocket field and prevent accidental sending
-// the websocket data via the network.
-func (player *Player) GetWebsocket() *websocket.Conn {
-	return player.ws
+// GetProtocolVersion returns the websocket protocol version negotiated with
+// the players client.
+func (player *Player) GetProtocolVersion() int {
+	return player.protocolVersion
+}
+
+// SetProtocolVersion sets the websocket protocol version negotiated with the
+// players client.
+func (player *Player) SetProtocolVersion(version int) {
+	player.protocolVersion = version
 }
 
-// SetWebsocket sets the given connection as the players websocket connection.
-func (player *Player) SetWebsocket(socket *websocket.Conn) {
-	player.ws = socket
+// GetTransportState returns the state set via SetTransportState.
+func (player *Player) GetTransportState() interface{} {
+	return player.transportState
 }
 
-// GetWebsocketMutex returns a mutex for locking the websocket connection.
-// Since gorilla websockets shits it self when two calls happen at
-// the same time, we need a mutex per player, since each player has their
-// own socket. This getter extends to prevent accidentally sending the mutex
-// via the network.
-func (player *Player) GetWebsocketMutex() *sync.Mutex {
-	return player.socketMutex
+// SetTransportState allows the transport to attach its own state, such as
+// the players connection, to the player. This isn't part of the Player
+// itself, so that the game doesn't depend on how players are connected.
+func (player *Player) SetTransportState(state interface{}) {
+	player.transportState = state
 }
 
 // GetUserSession returns the players current user session.
 func (player *Player) GetUserSession() string {
+	player.sessionMutex.Lock()
+	defer player.sessionMutex.Unlock()
+
+	return player.userSession
+}
+
+// RotateUserSession replaces the players user session with a new one and
+// returns it. Afterwards, the player can't be found via the previous one
+// anymore.
+func (player *Player) RotateUserSession() string {
+	player.sessionMutex.Lock()
+	defer player.sessionMutex.Unlock()
+
+	player.userSession = uuid.Must(uuid.NewV4()).String()
 	return player.userSession
 }
 
@@ -201,20 +378,97 @@ const (
 )
 
 // GetPlayer searches for a player, identifying them by usersession.
-func (lobby *Lobby) GetPlayer(userSession string) *Player {
-	for _, player := range lobby.players {
-		if player.userSession == userSession {
-			return player
+// Logger returns a logger that tags all entries with the ID of the lobby.
+func (lobby *Lobby) Logger() *logging.Logger {
+	return logging.With("lobby", lobby.ID)
+}
+
+// PlayerLogger returns a logger that tags all entries with the ID of the
+// lobby and the ID of the given player.
+func (lobby *Lobby) PlayerLogger(player *Player) *logging.Logger {
+	return logging.With("lobby", lobby.ID, "player", player.ID)
+}
+
+// startActiveSpan starts a span for work done for the lobby. Until the
+// returned function has been called, broadcasts are attached to the span.
+// Since events of the same lobby can be handled concurrently, a broadcast
+// might end up being attached to the span of concurrent work.
+func (lobby *Lobby) startActiveSpan(name string, keyValues ...interface{}) (*tracing.Span, func()) {
+	if !tracing.Enabled() {
+		return nil, func() {}
+	}
+
+	lobby.spanMutex.Lock()
+	parent := lobby.activeSpan
+	span := tracing.Start(parent, name, append([]interface{}{"lobby", lobby.ID}, keyValues...)...)
+	lobby.activeSpan = span
+	lobby.spanMutex.Unlock()
+
+	return span, func() {
+		span.End()
+
+		lobby.spanMutex.Lock()
+		if lobby.activeSpan == span {
+			lobby.activeSpan = parent
 		}
+		lobby.spanMutex.Unlock()
+	}
+}
+
+// ActiveSpan returns the span of the work currently being done for the
+// lobby. If there's none or tracing is disabled, nil is returned.
+func (lobby *Lobby) ActiveSpan() *tracing.Span {
+	if !tracing.Enabled() {
+		return nil
 	}
 
-	return nil
+	lobby.spanMutex.Lock()
+	defer lobby.spanMutex.Unlock()
+
+	return lobby.activeSpan
+}
+
+// GetPlayer returns the player with the given user session or nil if there's
+// no such player. Since players join and leave on the lobbies loop, the
+// search happens there as well.
+func (lobby *Lobby) GetPlayer(userSession string) *Player {
+	var match *Player
+	lobby.synchronized(func() {
+		for _, player := range lobby.players {
+			if player.GetUserSession() == userSession {
+				match = player
+				return
+			}
+		}
+	})
+
+	return match
 }
 
 func (lobby *Lobby) ClearDrawing() {
 	lobby.currentDrawing = make([]interface{}, 0, 0)
 }
 
+// GetCurrentDrawing returns all drawing instructions of the current turn.
+// The returned slice must not be modified.
+func (lobby *Lobby) GetCurrentDrawing() []interface{} {
+	return lobby.currentDrawing
+}
+
+// SnapshotCurrentDrawing copies the drawing instructions of the current turn
+// on the lobbies loop, so that the result can be used anywhere, for example
+// by a connections writer. If the lobby has already been closed, nil is
+// returned.
+func (lobby *Lobby) SnapshotCurrentDrawing() []interface{} {
+	var drawing []interface{}
+	lobby.synchronized(func() {
+		drawing = make([]interface{}, len(lobby.currentDrawing))
+		copy(drawing, lobby.currentDrawing)
+	})
+
+	return drawing
+}
+
 // AppendLine adds a line direction to the current drawing. This exists in order
 // to prevent adding arbitrary elements to the drawing, as the backing array is
 // an empty interface type.
@@ -229,6 +483,27 @@ func (lobby *Lobby) AppendFill(fill *FillEvent) {
 	lobby.currentDrawing = append(lobby.currentDrawing, fill)
 }
 
+// nextFreePlayerColor returns the first color of the palette that isn't
+// currently used by any player in the lobby. If all colors are taken, the
+// color is chosen depending on the player count.
+func (lobby *Lobby) nextFreePlayerColor() string {
+	for _, color := range playerColors {
+		taken := false
+		for _, player := range lobby.players {
+			if player.Color == color {
+				taken = true
+				break
+			}
+		}
+
+		if !taken {
+			return color
+		}
+	}
+
+	return playerColors[len(lobby.players)%len(playerColors)]
+}
+
 func createPlayer(name string) *Player {
 	return &Player{
 		Name:         name,
@@ -238,9 +513,11 @@ func createPlayer(name string) *Player {
 		LastScore:    0,
 		Rank:         1,
 		votedForKick: make(map[string]bool),
-		socketMutex:  &sync.Mutex{},
 		State:        Guessing,
 		Connected:    false,
+
+		//Until the client connects, we assume the oldest version.
+		protocolVersion: MinProtocolVersion,
 	}
 }
 
@@ -264,12 +541,10 @@ func createLobby(
 		EnableVotekick:    enableVotekick,
 		currentDrawing:    make([]interface{}, 0, 0),
 		state:             unstarted,
-	}
-
-	if len(customWords) > 1 {
-		rand.Shuffle(len(lobby.CustomWords), func(i, j int) {
-			lobby.CustomWords[i], lobby.CustomWords[j] = lobby.CustomWords[j], lobby.CustomWords[i]
-		})
+		inviteTokens:      make(map[string]*InviteToken),
+		inviteTokenMutex:  &sync.Mutex{},
+		banMutex:          &sync.Mutex{},
+		spanMutex:         &sync.Mutex{},
 	}
 
 	return lobby
@@ -281,6 +556,155 @@ type GameEvent struct {
 	Data interface{} `json:"data"`
 }
 
+// CreateInviteToken generates a new invite for the lobby, which is valid for
+// the given duration and amount of joins.
+func (lobby *Lobby) CreateInviteToken(validFor time.Duration, uses int) *InviteToken {
+	lobby.inviteTokenMutex.Lock()
+	defer lobby.inviteTokenMutex.Unlock()
+
+	lobby.removeInvalidInviteTokens()
+
+	token := &InviteToken{
+		Token:     uuid.Must(uuid.NewV4()).String(),
+		ExpiresAt: time.Now().Add(validFor),
+		UsesLeft:  uses,
+	}
+	lobby.inviteTokens[token.Token] = token
+	return token
+}
+
+// HasValidInviteToken indicates whether the given token can currently be
+// used to join the lobby.
+func (lobby *Lobby) HasValidInviteToken(token string) bool {
+	lobby.inviteTokenMutex.Lock()
+	defer lobby.inviteTokenMutex.Unlock()
+
+	inviteToken, available := lobby.inviteTokens[token]
+	return available && inviteToken.isValid()
+}
+
+// UseInviteToken consumes one use of the given token. If the token isn't
+// valid anymore, false is returned. Used up tokens are kept until the next
+// cleanup, so that ReturnInviteToken can still give their use back.
+func (lobby *Lobby) UseInviteToken(token string) bool {
+	lobby.inviteTokenMutex.Lock()
+	defer lobby.inviteTokenMutex.Unlock()
+
+	inviteToken, available := lobby.inviteTokens[token]
+	if !available || !inviteToken.isValid() {
+		return false
+	}
+
+	inviteToken.UsesLeft--
+	return true
+}
+
+// ReturnInviteToken gives back a use consumed via UseInviteToken, for
+// example if the player couldn't join after all. Revoked tokens stay revoked.
+func (lobby *Lobby) ReturnInviteToken(token string) {
+	lobby.inviteTokenMutex.Lock()
+	defer lobby.inviteTokenMutex.Unlock()
+
+	if inviteToken, available := lobby.inviteTokens[token]; available {
+		inviteToken.UsesLeft++
+	}
+}
+
+// RevokeInviteToken invalidates the given token. The returned value
+// indicates whether the token was still valid.
+func (lobby *Lobby) RevokeInviteToken(token string) bool {
+	lobby.inviteTokenMutex.Lock()
+	defer lobby.inviteTokenMutex.Unlock()
+
+	inviteToken, available := lobby.inviteTokens[token]
+	delete(lobby.inviteTokens, token)
+	return available && inviteToken.isValid()
+}
+
+// RevokeAllInviteTokens invalidates all invites of the lobby and returns
+// how many were still valid.
+func (lobby *Lobby) RevokeAllInviteTokens() int {
+	lobby.inviteTokenMutex.Lock()
+	defer lobby.inviteTokenMutex.Unlock()
+
+	lobby.removeInvalidInviteTokens()
+	count := len(lobby.inviteTokens)
+	lobby.inviteTokens = make(map[string]*InviteToken)
+	return count
+}
+
+func (lobby *Lobby) removeInvalidInviteTokens() {
+	for key, inviteToken := range lobby.inviteTokens {
+		if !inviteToken.isValid() {
+			delete(lobby.inviteTokens, key)
+		}
+	}
+}
+
+// BanPlayer prevents the given player from joining the lobby again. This
+// doesn't remove the player from the lobby.
+func (lobby *Lobby) BanPlayer(player *Player) {
+	lobby.banMutex.Lock()
+	defer lobby.banMutex.Unlock()
+
+	lobby.bans = append(lobby.bans, &Ban{
+		PlayerName:  player.Name,
+		userSession: player.GetUserSession(),
+		address:     player.lastKnownAddress,
+	})
+}
+
+// IsBanned checks whether either the given session or the given address
+// belong to a banned player.
+func (lobby *Lobby) IsBanned(userSession, address string) bool {
+	lobby.banMutex.Lock()
+	defer lobby.banMutex.Unlock()
+
+	for _, ban := range lobby.bans {
+		if (userSession != "" && ban.userSession == userSession) ||
+			(address != "" && ban.address == address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetBans returns a copy of all bans in the order they were issued.
+func (lobby *Lobby) GetBans() []*Ban {
+	lobby.banMutex.Lock()
+	defer lobby.banMutex.Unlock()
+
+	bans := make([]*Ban, len(lobby.bans))
+	copy(bans, lobby.bans)
+	return bans
+}
+
+// Unban lifts the ban at the given index, as returned by GetBans. The
+// returned value indicates whether the ban existed.
+func (lobby *Lobby) Unban(index int) (*Ban, bool) {
+	lobby.banMutex.Lock()
+	defer lobby.banMutex.Unlock()
+
+	if index < 0 || index >= len(lobby.bans) {
+		return nil, false
+	}
+
+	ban := lobby.bans[index]
+	lobby.bans = append(lobby.bans[:index], lobby.bans[index+1:]...)
+	return ban, true
+}
+
+// UnbanAll lifts all bans and returns the amount of lifted bans.
+func (lobby *Lobby) UnbanAll() int {
+	lobby.banMutex.Lock()
+	defer lobby.banMutex.Unlock()
+
+	count := len(lobby.bans)
+	lobby.bans = nil
+	return count
+}
+
 // GetConnectedPlayerCount returns the amount of player that have currently
 // established a socket connection.
 func (lobby *Lobby) GetConnectedPlayerCount() int {
@@ -308,10 +732,38 @@ func (lobby *Lobby) IsPublic() bool {
 	return lobby.public
 }
 
+// GetPlayers returns all players of the lobby. Since the players are changed
+// on the lobbies loop, this may only be called from within the loop, for
+// example by the transport, notifiers and hooks. Use SnapshotPlayers
+// anywhere else.
 func (lobby *Lobby) GetPlayers() []*Player {
 	return lobby.players
 }
 
+// SnapshotPlayers returns copies of all players, which are made on the
+// lobbies loop. Therefore they can be read anywhere, for example by HTTP
+// handlers, but changing them doesn't affect the lobby.
+func (lobby *Lobby) SnapshotPlayers() []*Player {
+	var players []*Player
+	lobby.synchronized(func() {
+		players = make([]*Player, 0, len(lobby.players))
+		for _, player := range lobby.players {
+			players = append(players, player.copyForReading())
+		}
+	})
+
+	return players
+}
+
+// SetLatency sets the round trip time of the players connection in
+// milliseconds. Since the players are read on the lobbies loop, the value
+// is applied there as well.
+func (lobby *Lobby) SetLatency(player *Player, latency int) {
+	lobby.synchronized(func() {
+		player.Latency = latency
+	})
+}
+
 // GetOccupiedPlayerSlots counts the available slots which can be taken by new
 // players. Whether a slot is available is determined by the player count and
 // whether a player is disconnect or furthermore how long they have been
@@ -319,8 +771,11 @@ func (lobby *Lobby) GetPlayers() []*Player {
 // Lobby.GetConnectedPlayerCount.
 func (lobby *Lobby) GetOccupiedPlayerSlots() int {
 	var occupiedPlayerSlots int
-	now := time.Now()
+	now := lobby.now()
 	for _, player := range lobby.players {
+		if player.Audience {
+			continue
+		}
 		if player.Connected {
 			occupiedPlayerSlots++
 		} else {
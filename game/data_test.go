@@ -45,3 +45,80 @@ func TestOccupiedPlayerCount(t *testing.T) {
 	}
 
 }
+
+func TestNextFreePlayerColor(t *testing.T) {
+	lobby := &Lobby{}
+	for i := 0; i < len(playerColors); i++ {
+		color := lobby.nextFreePlayerColor()
+		for _, player := range lobby.players {
+			if player.Color == color {
+				t.Errorf("Color %s has been assigned twice", color)
+			}
+		}
+		lobby.players = append(lobby.players, &Player{Color: color})
+	}
+
+	//Removing a player should free up their color for the next player.
+	freedColor := lobby.players[3].Color
+	lobby.players = append(lobby.players[:3], lobby.players[4:]...)
+	if color := lobby.nextFreePlayerColor(); color != freedColor {
+		t.Errorf("Expected freed color %s to be reused, but got %s", freedColor, color)
+	}
+}
+
+func TestInviteTokens(t *testing.T) {
+	lobby := createLobby(60, 1, 2, nil, 0, 1, false)
+
+	token := lobby.CreateInviteToken(time.Minute, 2)
+	if !lobby.HasValidInviteToken(token.Token) {
+		t.Error("Freshly created invite should be valid")
+	}
+
+	if !lobby.UseInviteToken(token.Token) || !lobby.UseInviteToken(token.Token) {
+		t.Error("Invite should be usable twice")
+	}
+	if lobby.UseInviteToken(token.Token) {
+		t.Error("Invite shouldn't be usable after all uses have been consumed")
+	}
+	lobby.ReturnInviteToken(token.Token)
+	if !lobby.UseInviteToken(token.Token) {
+		t.Error("Invite should be usable again after a use has been returned")
+	}
+
+	expired := lobby.CreateInviteToken(-time.Minute, 1)
+	if lobby.HasValidInviteToken(expired.Token) || lobby.UseInviteToken(expired.Token) {
+		t.Error("Expired invite shouldn't be valid")
+	}
+
+	revoked := lobby.CreateInviteToken(time.Minute, 1)
+	if !lobby.RevokeInviteToken(revoked.Token) {
+		t.Error("Revoking an existing invite should succeed")
+	}
+	if lobby.UseInviteToken(revoked.Token) {
+		t.Error("Revoked invite shouldn't be usable")
+	}
+}
+
+func TestBans(t *testing.T) {
+	lobby := createLobby(60, 1, 4, nil, 0, 4, true)
+
+	player, err := lobby.JoinPlayer("Kicked", "10.0.0.1", "")
+	if err != nil {
+		t.Fatalf("Joining shouldn't fail: %s", err)
+	}
+	lobby.BanPlayer(player)
+
+	if _, err := lobby.JoinPlayer("Kicked", "10.0.0.1", ""); err != ErrPlayerBanned {
+		t.Errorf("Joining with a banned address should fail, but got: %v", err)
+	}
+	if _, err := lobby.JoinPlayer("Kicked", "10.0.0.2", player.userSession); err != ErrPlayerBanned {
+		t.Errorf("Joining with a banned session should fail, but got: %v", err)
+	}
+
+	if _, unbanned := lobby.Unban(0); !unbanned {
+		t.Error("Unbanning an existing ban should succeed")
+	}
+	if _, err := lobby.JoinPlayer("Kicked", "10.0.0.1", player.userSession); err != nil {
+		t.Errorf("Joining after being unbanned should succeed, but got: %s", err)
+	}
+}
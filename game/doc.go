@@ -0,0 +1,9 @@
+// Package game contains the rules of scribble.rs, such as the turns, the
+// scoring, the hints and the word lists. It doesn't know how players are
+// connected. Instead, a Server is created with a Transport for delivering
+// events to the players and a LobbyNotifier for things that matter to the
+// rest of the application. Each lobby runs its own loop, therefore the
+// exported functions may be called from any goroutine. Closing a lobby
+// cancels its context and waits for its background work before the lobby is
+// removed.
+package game
@@ -0,0 +1,520 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	// maxMessageLength is the maximum amount of characters a single chat
+	// message may consist of.
+	maxMessageLength = 1000
+	// drawingMargin is how far lines may exceed the drawing board. This is
+	// necessary, since the cursor might leave the board while drawing.
+	drawingMargin = DrawingBoardBaseWidth
+	// wordChoiceCount is the amount of words the drawer can choose from.
+	wordChoiceCount = 3
+	// maxSignalingPayloadSize is the maximum size of a session description
+	// or ICE candidate in bytes.
+	maxSignalingPayloadSize = 16 * 1024
+	// maxReactionsPerTurn is the amount of reactions each player may send
+	// during a single turn.
+	maxReactionsPerTurn = 5
+)
+
+// These codes are sent as part of an EventError and allow clients to react
+// to the different kinds of rejected events.
+const (
+	// ErrorCodeMalformedEvent means that the event couldn't be parsed at all.
+	ErrorCodeMalformedEvent = "malformed-event"
+	// ErrorCodeUnknownEvent means that the event type isn't known.
+	ErrorCodeUnknownEvent = "unknown-event"
+	// ErrorCodeInvalidPayload means that the event data doesn't match the
+	// payload type of the event or is out of range.
+	ErrorCodeInvalidPayload = "invalid-payload"
+	// ErrorCodeNotYourTurn means that the event can only be sent by the
+	// drawer, for example a line, and the sender isn't drawing right now.
+	ErrorCodeNotYourTurn = "not-your-turn"
+	// ErrorCodePermissionDenied means that the player isn't allowed to send
+	// the event, for example because only the owner may send it.
+	ErrorCodePermissionDenied = "permission-denied"
+	// ErrorCodeRateLimited means that the client has sent too many events
+	// and the event has been dropped.
+	ErrorCodeRateLimited = "rate-limited"
+	// ErrorCodeRejected means that a Hook has rejected the event, for
+	// example a chat message. The message explains why.
+	ErrorCodeRejected = "rejected"
+
+	// ErrorCodeInvalidData is the former name of ErrorCodeInvalidPayload.
+	//
+	// Deprecated: Use ErrorCodeInvalidPayload instead.
+	ErrorCodeInvalidData = ErrorCodeInvalidPayload
+	// ErrorCodeForbidden is the former name of ErrorCodePermissionDenied.
+	//
+	// Deprecated: Use ErrorCodePermissionDenied instead.
+	ErrorCodeForbidden = ErrorCodePermissionDenied
+)
+
+var hexColorPattern = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+// availableReactions contains all reactions players can send.
+var availableReactions = map[string]bool{
+	"applause": true,
+	"laugh":    true,
+	"thinking": true,
+}
+
+// Reaction is broadcast whenever a player reacts, allowing feedback without
+// having to write a message.
+type Reaction struct {
+	PlayerID   string `json:"playerId"`
+	PlayerName string `json:"playerName"`
+	Reaction   string `json:"reaction"`
+}
+
+// SignalingMessage is relayed between two players of the same lobby, allowing
+// them to establish a peer-to-peer connection for voice chat via WebRTC.
+type SignalingMessage struct {
+	// Target is the ID of the receiving player. It's set by the sender.
+	Target string `json:"target,omitempty"`
+	// Source is the ID of the sending player. It's set by the server.
+	Source string `json:"source,omitempty"`
+	// Payload is the session description or ICE candidate. It's relayed
+	// without being interpreted by the server.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// EventError is sent as the data of an "error" event, whenever an event of a
+// client has been rejected.
+type EventError struct {
+	// Event is the type of the rejected event. It's empty if the event
+	// couldn't be parsed.
+	Event string `json:"event"`
+	// Code is one of the ErrorCode constants.
+	Code string `json:"code"`
+	// Message is a human readable description of the problem.
+	Message string `json:"message"`
+}
+
+func (err *EventError) Error() string {
+	return fmt.Sprintf("%s (%s): %s", err.Event, err.Code, err.Message)
+}
+
+func invalidData(format string, values ...interface{}) *EventError {
+	return &EventError{Code: ErrorCodeInvalidPayload, Message: fmt.Sprintf(format, values...)}
+}
+
+// eventAction handles an event whose data has already been decoded and
+// validated.
+type eventAction func(lobby *Lobby, player *Player)
+
+// eventHandler describes how a certain type of inbound event is validated
+// and handled. Events are only handled after they've been decoded and the
+// sender has been found to be allowed to send them.
+type eventHandler struct {
+	// decode parses and validates the events data and binds it to the
+	// function handling the event. Each event type has its own payload
+	// type, see the EventType constants.
+	decode func(received *InboundEvent) (eventAction, *EventError)
+	// allowed decides whether the player is allowed to send the event. If
+	// nil, everyone is allowed to send it.
+	allowed func(lobby *Lobby, player *Player) bool
+	// drawerOnly signals that allowed only lets the drawer send the event,
+	// so that others are told that it's not their turn.
+	drawerOnly bool
+}
+
+// eventHandlers contains all events a client is allowed to send.
+var eventHandlers map[string]*eventHandler
+
+func init() {
+	eventHandlers = map[string]*eventHandler{
+		EventTypeMessage: {
+			decode: stringEvent(validateMessage, handleMessageEvent),
+		},
+		EventTypeLine: {
+			decode: func(received *InboundEvent) (eventAction, *EventError) {
+				line, parseError := parseLine(received)
+				if parseError != nil {
+					return nil, parseError
+				}
+				return func(lobby *Lobby, player *Player) { handleLineEvent(lobby, player, line) }, nil
+			},
+			allowed:    (*Lobby).canDraw,
+			drawerOnly: true,
+		},
+		EventTypeFill: {
+			decode: func(received *InboundEvent) (eventAction, *EventError) {
+				fill, parseError := parseFill(received)
+				if parseError != nil {
+					return nil, parseError
+				}
+				return func(lobby *Lobby, player *Player) { handleFillEvent(lobby, player, fill) }, nil
+			},
+			allowed:    (*Lobby).canDraw,
+			drawerOnly: true,
+		},
+		EventTypeClearDrawingBoard: {
+			decode:     withoutData(handleClearDrawingBoardEvent),
+			allowed:    (*Lobby).canDraw,
+			drawerOnly: true,
+		},
+		EventTypeChooseWord: {
+			decode: func(received *InboundEvent) (eventAction, *EventError) {
+				index, parseError := parseChooseWord(received)
+				if parseError != nil {
+					return nil, parseError
+				}
+				return func(lobby *Lobby, player *Player) { handleChooseWordEvent(lobby, player, index) }, nil
+			},
+			allowed:    canChooseWord,
+			drawerOnly: true,
+		},
+		EventTypeKickVote: {
+			decode:  stringEvent(nil, handleKickEvent),
+			allowed: func(lobby *Lobby, player *Player) bool { return lobby.EnableVotekick },
+		},
+		EventTypeStart: {
+			decode:  withoutData(handleStartEvent),
+			allowed: func(lobby *Lobby, player *Player) bool { return lobby.Round == 0 && player == lobby.owner },
+		},
+		EventTypeNameChange: {
+			decode: stringEvent(nil, func(lobby *Lobby, player *Player, name string) {
+				commandNick(player, lobby, name)
+			}),
+		},
+		EventTypeRequestDrawing: {
+			decode: withoutData(func(lobby *Lobby, player *Player) {
+				lobby.transport.WriteAsJSON(player, GameEvent{Type: EventTypeDrawing, Data: lobby.currentDrawing})
+			}),
+		},
+		EventTypeTimeSync: {
+			decode: withoutData(func(lobby *Lobby, player *Player) {
+				lobby.transport.WriteAsJSON(player, GameEvent{Type: EventTypeTimeSync, Data: generateTimeSync(lobby)})
+			}),
+		},
+		EventTypeReaction: {
+			decode: stringEvent(validateReaction, handleReactionEvent),
+		},
+		//These events are the signaling channel for WebRTC voice chat. They
+		//are relayed to the target player only.
+		EventTypeRTCOffer: {
+			decode: signalingEvent(relaySignalingMessage(EventTypeRTCOffer)),
+		},
+		EventTypeRTCAnswer: {
+			decode: signalingEvent(relaySignalingMessage(EventTypeRTCAnswer)),
+		},
+		EventTypeRTCICECandidate: {
+			decode: signalingEvent(relaySignalingMessage(EventTypeRTCICECandidate)),
+		},
+		EventTypeKeepAlive: {
+			//This is a known dummy event in order to avoid accidental websocket
+			//connection closure. However, no action is required on the server.
+			decode: withoutData(func(lobby *Lobby, player *Player) {}),
+		},
+	}
+}
+
+// IsKnownEvent determines whether clients are allowed to send events of the
+// given type.
+func IsKnownEvent(eventType string) bool {
+	_, known := eventHandlers[eventType]
+	return known
+}
+
+// validateEvent makes sure the event is known, its data is valid and the
+// player is allowed to send it. The returned action handles the event.
+func validateEvent(received *InboundEvent, lobby *Lobby, player *Player) (eventAction, *EventError) {
+	handler, known := eventHandlers[received.Type]
+	if !known {
+		return nil, &EventError{
+			Event:   received.Type,
+			Code:    ErrorCodeUnknownEvent,
+			Message: fmt.Sprintf("unknown event type '%s'", received.Type),
+		}
+	}
+
+	action, parseError := handler.decode(received)
+	if parseError != nil {
+		parseError.Event = received.Type
+		return nil, parseError
+	}
+
+	if handler.allowed != nil && !handler.allowed(lobby, player) {
+		if handler.drawerOnly {
+			return nil, &EventError{
+				Event:   received.Type,
+				Code:    ErrorCodeNotYourTurn,
+				Message: "only the drawer can do this right now",
+			}
+		}
+		return nil, &EventError{
+			Event:   received.Type,
+			Code:    ErrorCodePermissionDenied,
+			Message: "you aren't allowed to do this right now",
+		}
+	}
+
+	return action, nil
+}
+
+// sendEventError notifies the player about a rejected event.
+func sendEventError(lobby *Lobby, player *Player, eventError *EventError) {
+	lobby.transport.WriteAsJSON(player, GameEvent{Type: EventTypeError, Data: eventError})
+}
+
+// withoutData creates the decoder of an event that doesn't carry any data.
+// Data sent anyway is ignored.
+func withoutData(handle eventAction) func(*InboundEvent) (eventAction, *EventError) {
+	return func(received *InboundEvent) (eventAction, *EventError) {
+		return handle, nil
+	}
+}
+
+// stringEvent creates the decoder of an event whose data is a string. The
+// validation is optional.
+func stringEvent(validate func(string) *EventError, handle func(*Lobby, *Player, string)) func(*InboundEvent) (eventAction, *EventError) {
+	return func(received *InboundEvent) (eventAction, *EventError) {
+		var text *string
+		if err := received.DecodeData(&text); err != nil || text == nil {
+			return nil, invalidData("data must be a string")
+		}
+
+		if validate != nil {
+			if validationError := validate(*text); validationError != nil {
+				return nil, validationError
+			}
+		}
+
+		return func(lobby *Lobby, player *Player) { handle(lobby, player, *text) }, nil
+	}
+}
+
+// signalingEvent creates the decoder of a WebRTC signaling event.
+func signalingEvent(handle func(*Lobby, *Player, *SignalingMessage)) func(*InboundEvent) (eventAction, *EventError) {
+	return func(received *InboundEvent) (eventAction, *EventError) {
+		message, parseError := parseSignalingMessage(received)
+		if parseError != nil {
+			return nil, parseError
+		}
+		return func(lobby *Lobby, player *Player) { handle(lobby, player, message) }, nil
+	}
+}
+
+func validateMessage(message string) *EventError {
+	if utf8.RuneCountInString(message) > maxMessageLength {
+		return invalidData("messages can't be longer than %d characters", maxMessageLength)
+	}
+
+	return nil
+}
+
+func parseLine(received *InboundEvent) (*Line, *EventError) {
+	var line *Line
+	if err := received.DecodeData(&line); err != nil || line == nil {
+		return nil, invalidData("data must be a line")
+	}
+
+	if !isWithinDrawingBounds(line.FromX, line.FromY, drawingMargin) ||
+		!isWithinDrawingBounds(line.ToX, line.ToY, drawingMargin) {
+		return nil, invalidData("line is too far outside of the drawing board")
+	}
+
+	if !hexColorPattern.MatchString(line.Color) {
+		return nil, invalidData("color must be in the format #rrggbb")
+	}
+
+	//In case the line is too big, we overwrite the data of the event.
+	//This will prevent clients from lagging due to too thick lines.
+	if line.LineWidth > float32(MaxBrushSize) {
+		line.LineWidth = MaxBrushSize
+	} else if line.LineWidth < float32(MinBrushSize) {
+		line.LineWidth = MinBrushSize
+	}
+
+	return line, nil
+}
+
+func parseFill(received *InboundEvent) (*Fill, *EventError) {
+	var fill *Fill
+	if err := received.DecodeData(&fill); err != nil || fill == nil {
+		return nil, invalidData("data must be a fill")
+	}
+
+	if !isWithinDrawingBounds(fill.X, fill.Y, 0) {
+		return nil, invalidData("fill must be on the drawing board")
+	}
+
+	if !hexColorPattern.MatchString(fill.Color) {
+		return nil, invalidData("color must be in the format #rrggbb")
+	}
+
+	return fill, nil
+}
+
+func isWithinDrawingBounds(x, y, margin float32) bool {
+	return x >= -margin && x <= DrawingBoardBaseWidth+margin &&
+		y >= -margin && y <= DrawingBoardBaseHeight+margin
+}
+
+func parseChooseWord(received *InboundEvent) (int, *EventError) {
+	var index *int
+	if err := received.DecodeData(&index); err != nil || index == nil {
+		return 0, invalidData("data must be the index of the chosen word")
+	}
+
+	if *index < 0 || *index >= wordChoiceCount {
+		return 0, invalidData("index must be between 0 and %d", wordChoiceCount-1)
+	}
+
+	return *index, nil
+}
+
+func validateReaction(reaction string) *EventError {
+	if !availableReactions[reaction] {
+		return invalidData("unknown reaction '%s'", reaction)
+	}
+
+	return nil
+}
+
+func handleReactionEvent(lobby *Lobby, player *Player, reaction string) {
+	if player.reactionCount >= maxReactionsPerTurn {
+		sendEventError(lobby, player, &EventError{
+			Event:   EventTypeReaction,
+			Code:    ErrorCodeRateLimited,
+			Message: fmt.Sprintf("you can only react %d times per turn", maxReactionsPerTurn),
+		})
+		return
+	}
+
+	player.reactionCount++
+	lobby.transport.TriggerUpdateEvent(EventTypeReaction, &Reaction{
+		PlayerID:   player.ID,
+		PlayerName: player.Name,
+		Reaction:   reaction,
+	}, lobby)
+}
+
+func parseSignalingMessage(received *InboundEvent) (*SignalingMessage, *EventError) {
+	var message *SignalingMessage
+	if err := received.DecodeData(&message); err != nil || message == nil {
+		return nil, invalidData("data must be a signaling message")
+	}
+
+	if message.Target == "" {
+		return nil, invalidData("target is missing")
+	}
+
+	if len(message.Payload) == 0 || len(message.Payload) > maxSignalingPayloadSize {
+		return nil, invalidData("payload must be between 1 and %d bytes", maxSignalingPayloadSize)
+	}
+
+	return message, nil
+}
+
+// relaySignalingMessage creates a handler that passes signaling messages on
+// to the target player, telling it who sent the message.
+func relaySignalingMessage(eventType string) func(lobby *Lobby, player *Player, message *SignalingMessage) {
+	return func(lobby *Lobby, player *Player, message *SignalingMessage) {
+		for _, target := range lobby.players {
+			if target.ID == message.Target && target != player && target.Connected {
+				lobby.transport.WriteAsJSON(target, GameEvent{Type: eventType, Data: &SignalingMessage{
+					Source:  player.ID,
+					Payload: message.Payload,
+				}})
+				return
+			}
+		}
+
+		sendEventError(lobby, player, &EventError{
+			Event:   eventType,
+			Code:    ErrorCodeInvalidPayload,
+			Message: "the target player isn't connected",
+		})
+	}
+}
+
+func canChooseWord(lobby *Lobby, player *Player) bool {
+	return player == lobby.drawer && len(lobby.wordChoice) > 0
+}
+
+func handleChooseWordEvent(lobby *Lobby, player *Player, chosenIndex int) {
+	if chosenIndex >= len(lobby.wordChoice) {
+		return
+	}
+
+	drawer, word := lobby.drawer, lobby.wordChoice[chosenIndex]
+	if rejection := lobby.checkHooks(func(hook Hook) error { return hook.WordChosen(lobby, drawer, word) }); rejection != nil {
+		sendRejection(lobby, player, EventTypeChooseWord, rejection)
+		return
+	}
+
+	chooseWord(lobby, chosenIndex)
+}
+
+// chooseWord makes the word at the given index of the drawers choice the
+// word to be guessed.
+func chooseWord(lobby *Lobby, chosenIndex int) {
+	lobby.CurrentWord = lobby.wordChoice[chosenIndex]
+
+	//Depending on how long the word is, a fixed amount of hints
+	//would be too easy or too hard.
+	runeCount := utf8.RuneCountInString(lobby.CurrentWord)
+	if runeCount <= 2 {
+		lobby.hintCount = 0
+	} else if runeCount <= 4 {
+		lobby.hintCount = 1
+	} else if runeCount <= 9 {
+		lobby.hintCount = 2
+	} else {
+		lobby.hintCount = 3
+	}
+	lobby.hintsLeft = lobby.hintCount
+
+	lobby.wordChoice = nil
+	lobby.wordHints = createWordHintFor(lobby.CurrentWord, false)
+	lobby.wordHintsShown = createWordHintFor(lobby.CurrentWord, true)
+	triggerWordHintUpdate(lobby)
+	scheduleWordTasks(lobby)
+}
+
+func handleMessageEvent(lobby *Lobby, player *Player, message string) {
+	if len(message) > 0 && message[0] == '!' {
+		handleCommand(message[1:], player, lobby)
+	} else {
+		handleMessage(message, player, lobby)
+	}
+}
+
+func handleLineEvent(lobby *Lobby, player *Player, line *Line) {
+	lobby.AppendLine(&LineEvent{Type: EventTypeLine, Data: line})
+	lobby.cancelTask(taskAFKCheck)
+
+	//Only the validated data is forwarded, omitting any unknown fields.
+	lobby.transport.SendDataToEveryoneExceptSender(player, lobby, &GameEvent{Type: EventTypeLine, Data: line})
+}
+
+func handleFillEvent(lobby *Lobby, player *Player, fill *Fill) {
+	lobby.AppendFill(&FillEvent{Type: EventTypeFill, Data: fill})
+	lobby.cancelTask(taskAFKCheck)
+
+	lobby.transport.SendDataToEveryoneExceptSender(player, lobby, &GameEvent{Type: EventTypeFill, Data: fill})
+}
+
+func handleClearDrawingBoardEvent(lobby *Lobby, player *Player) {
+	if len(lobby.currentDrawing) > 0 {
+		lobby.ClearDrawing()
+		lobby.transport.SendDataToEveryoneExceptSender(player, lobby, &GameEvent{Type: EventTypeClearDrawingBoard})
+	}
+}
+
+func handleStartEvent(lobby *Lobby, player *Player) {
+	if lobby.ScheduledStartTime > lobby.getTimeAsMillis() {
+		lobby.transport.WriteAsJSON(player, GameEvent{Type: EventTypeSystemMessage, Data: "The game can't be started before the scheduled start time."})
+	} else {
+		startGame(lobby)
+	}
+}
@@ -0,0 +1,139 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_validateEvent(t *testing.T) {
+	drawer := &Player{State: Drawing}
+	guesser := &Player{State: Guessing}
+	lobby := &Lobby{
+		owner:       drawer,
+		drawer:      drawer,
+		CurrentWord: "word",
+	}
+
+	tests := []struct {
+		name     string
+		raw      string
+		player   *Player
+		wantCode string
+	}{
+		{"valid message", `{"type":"message","data":"hello"}`, guesser, ""},
+		{"message without string", `{"type":"message","data":5}`, guesser, ErrorCodeInvalidPayload},
+		{"unknown event", `{"type":"explode"}`, guesser, ErrorCodeUnknownEvent},
+		{"valid line", `{"type":"line","data":{"fromX":1,"fromY":1,"toX":2,"toY":2,"color":"#ff00ff","lineWidth":8}}`, drawer, ""},
+		{"line by guesser", `{"type":"line","data":{"fromX":1,"fromY":1,"toX":2,"toY":2,"color":"#ff00ff","lineWidth":8}}`, guesser, ErrorCodeNotYourTurn},
+		{"line far outside", `{"type":"line","data":{"fromX":1,"fromY":1,"toX":100000,"toY":2,"color":"#ff00ff","lineWidth":8}}`, drawer, ErrorCodeInvalidPayload},
+		{"line with invalid color", `{"type":"line","data":{"fromX":1,"fromY":1,"toX":2,"toY":2,"color":"red;","lineWidth":8}}`, drawer, ErrorCodeInvalidPayload},
+		{"fill outside", `{"type":"fill","data":{"x":-1,"y":1,"color":"#000000"}}`, drawer, ErrorCodeInvalidPayload},
+		{"fill without data", `{"type":"fill"}`, drawer, ErrorCodeInvalidPayload},
+		{"choose word out of range", `{"type":"choose-word","data":3}`, drawer, ErrorCodeInvalidPayload},
+		{"choose word without choice", `{"type":"choose-word","data":1}`, drawer, ErrorCodeNotYourTurn},
+		{"start by non owner", `{"type":"start"}`, guesser, ErrorCodePermissionDenied},
+		{"kick vote while disabled", `{"type":"kick-vote","data":"a"}`, guesser, ErrorCodePermissionDenied},
+		{"keep alive", `{"type":"keep-alive"}`, guesser, ""},
+		{"reaction", `{"type":"reaction","data":"applause"}`, guesser, ""},
+		{"unknown reaction", `{"type":"reaction","data":"boo"}`, guesser, ErrorCodeInvalidPayload},
+		{"rtc offer", `{"type":"rtc-offer","data":{"target":"a","payload":{"sdp":"v=0"}}}`, guesser, ""},
+		{"rtc offer without target", `{"type":"rtc-offer","data":{"payload":{"sdp":"v=0"}}}`, guesser, ErrorCodeInvalidPayload},
+		{"rtc candidate without payload", `{"type":"rtc-ice-candidate","data":{"target":"a"}}`, guesser, ErrorCodeInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := &InboundEvent{}
+			if err := json.Unmarshal([]byte(tt.raw), received); err != nil {
+				t.Fatal(err)
+			}
+
+			_, eventError := validateEvent(received, lobby, tt.player)
+			gotCode := ""
+			if eventError != nil {
+				gotCode = eventError.Code
+				if eventError.Event != received.Type {
+					t.Errorf("error event type = %s, want %s", eventError.Event, received.Type)
+				}
+			}
+			if gotCode != tt.wantCode {
+				t.Errorf("validateEvent() code = %s, want %s (%v)", gotCode, tt.wantCode, eventError)
+			}
+		})
+	}
+}
+
+func Test_parseLineClampsLineWidth(t *testing.T) {
+	line, eventError := parseLine(&InboundEvent{Data: json.RawMessage(`{"fromX":1,"fromY":1,"toX":2,"toY":2,"color":"#ff00ff","lineWidth":1000}`)})
+	if eventError != nil {
+		t.Fatal(eventError)
+	}
+
+	if lineWidth := line.LineWidth; lineWidth != MaxBrushSize {
+		t.Errorf("lineWidth = %f, want %d", lineWidth, MaxBrushSize)
+	}
+}
+
+func Test_relaySignalingMessage(t *testing.T) {
+	sender := &Player{ID: "sender", Connected: true}
+	receiver := &Player{ID: "receiver", Connected: true}
+	transport := &recordingTransport{}
+	lobby := &Lobby{players: []*Player{sender, receiver}, transport: transport}
+
+	relay := relaySignalingMessage(EventTypeRTCAnswer)
+	relay(lobby, sender, &SignalingMessage{Target: "receiver", Payload: json.RawMessage(`{"sdp":"v=0"}`)})
+	if len(transport.sentTo) != 1 || transport.sentTo[0] != receiver {
+		t.Fatalf("expected message to be relayed to the receiver, got %v", transport.sentTo)
+	}
+	relayedEvent := transport.sent[0].(GameEvent)
+	relayed := relayedEvent.Data.(*SignalingMessage)
+	if relayedEvent.Type != "rtc-answer" || relayed.Source != "sender" || relayed.Target != "" {
+		t.Errorf("unexpected relayed message %+v", relayedEvent)
+	}
+
+	//Messages to oneself or to disconnected players are rejected.
+	receiver.Connected = false
+	relay(lobby, sender, &SignalingMessage{Target: "receiver", Payload: json.RawMessage(`{}`)})
+	relay(lobby, sender, &SignalingMessage{Target: "sender", Payload: json.RawMessage(`{}`)})
+	if len(transport.sent) != 3 || transport.sent[1].(GameEvent).Type != "error" || transport.sent[2].(GameEvent).Type != "error" {
+		t.Errorf("expected errors to be sent, got %+v", transport.sent)
+	}
+}
+
+func Test_handleReactionEvent(t *testing.T) {
+	player := &Player{ID: "a", Name: "Alice"}
+	transport := &recordingTransport{}
+	lobby := &Lobby{players: []*Player{player}, transport: transport}
+
+	for i := 0; i < maxReactionsPerTurn+2; i++ {
+		handleReactionEvent(lobby, player, "laugh")
+	}
+
+	if len(transport.events) != maxReactionsPerTurn || len(transport.sent) != 2 {
+		t.Errorf("expected %d reactions and 2 errors, got %d and %d", maxReactionsPerTurn, len(transport.events), len(transport.sent))
+	}
+}
+
+func Test_HandleEvent(t *testing.T) {
+	server, transport, _ := newTestServer()
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handleError := HandleEvent(&InboundEvent{Type: EventTypeLine, Data: json.RawMessage(`{"color":"#000000"}`)}, lobby, owner)
+	if eventError, rejected := handleError.(*EventError); !rejected || eventError.Code != ErrorCodeNotYourTurn {
+		t.Errorf("expected the line to be rejected, got %v", handleError)
+	}
+	if len(transport.sent) != 1 || transport.sent[0].(GameEvent).Type != EventTypeError {
+		t.Errorf("expected the owner to receive an error, got %+v", transport.sent)
+	}
+
+	if handleError := HandleEvent(&InboundEvent{Type: EventTypeKeepAlive}, lobby, owner); handleError != nil {
+		t.Errorf("expected keep-alive to be handled, got %v", handleError)
+	}
+
+	CloseLobby(lobby, "")
+	if handleError := HandleEvent(&InboundEvent{Type: EventTypeKeepAlive}, lobby, owner); handleError != ErrLobbyClosed {
+		t.Errorf("expected ErrLobbyClosed, got %v", handleError)
+	}
+}
@@ -0,0 +1,86 @@
+package game
+
+import "html"
+
+// Hook is told about key events of the lobbies and can reject some of the
+// actions causing them. This allows custom rules, bridges to other chats and
+// moderation extensions without changing the game package. Embed BaseHook
+// in order to only implement some of the methods.
+//
+// Hooks are called from within the lobbies loop, after the event has been
+// validated, but before it has any effect. They may read the lobby and the
+// players, but mustn't modify them or call functions that hand work to the
+// loop, such as Lobby.JoinPlayer or HandleEvent, as they would wait for
+// themselves. Anything slow, such as network requests, has to be done via
+// Lobby.Go.
+//
+// Rejecting an action is done by returning an error, whose message is shown
+// to the player.
+type Hook interface {
+	// PlayerJoining is called before a player joins the lobby. Returning an
+	// error rejects the player and is returned by Lobby.JoinPlayer.
+	PlayerJoining(lobby *Lobby, name, address string) error
+	// WordChosen is called before the word becomes the word to be guessed.
+	// If the choice is rejected, the drawer has to choose another word. If
+	// the drawer doesn't choose in time, the first word that isn't rejected
+	// is chosen and the turn is skipped if all of them are rejected.
+	WordChosen(lobby *Lobby, drawer *Player, word string) error
+	// CorrectGuess is called before the player is awarded points for
+	// guessing the word. If the guess is rejected, the player keeps on
+	// guessing and the guess isn't shown to anyone.
+	CorrectGuess(lobby *Lobby, player *Player) error
+	// ChatMessage is called before a message is shown to the other players.
+	// This includes wrong guesses, but neither correct guesses nor commands.
+	// Rejected messages are dropped.
+	ChatMessage(lobby *Lobby, player *Player, message string) error
+	// RoundEnded is called after everyone has drawn in the round, before
+	// either the next round begins or the game finishes.
+	RoundEnded(lobby *Lobby, round int)
+}
+
+// BaseHook implements Hook without observing or rejecting anything.
+type BaseHook struct{}
+
+// PlayerJoining implements Hook.
+func (BaseHook) PlayerJoining(lobby *Lobby, name, address string) error { return nil }
+
+// WordChosen implements Hook.
+func (BaseHook) WordChosen(lobby *Lobby, drawer *Player, word string) error { return nil }
+
+// CorrectGuess implements Hook.
+func (BaseHook) CorrectGuess(lobby *Lobby, player *Player) error { return nil }
+
+// ChatMessage implements Hook.
+func (BaseHook) ChatMessage(lobby *Lobby, player *Player, message string) error { return nil }
+
+// RoundEnded implements Hook.
+func (BaseHook) RoundEnded(lobby *Lobby, round int) {}
+
+// RegisterHook adds a hook to all lobbies created or restored afterwards,
+// therefore hooks should be registered before serving any players. Hooks
+// are called in the order of their registration and the first one
+// rejecting an action stops the others from being asked.
+func (server *Server) RegisterHook(hook Hook) {
+	server.hooks = append(server.hooks, hook)
+}
+
+// checkHooks asks each hook whether the action is allowed and returns the
+// first rejection.
+func (lobby *Lobby) checkHooks(check func(hook Hook) error) error {
+	for _, hook := range lobby.hooks {
+		if err := check(hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRejection tells the player that a hook has rejected their event. The
+// message is escaped, as it's shown in the chat, just like chat messages.
+func sendRejection(lobby *Lobby, player *Player, eventType string, rejection error) {
+	sendEventError(lobby, player, &EventError{
+		Event:   eventType,
+		Code:    ErrorCodeRejected,
+		Message: html.EscapeString(rejection.Error()),
+	})
+}
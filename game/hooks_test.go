@@ -0,0 +1,147 @@
+package game
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// rulesHook enforces a few house rules and remembers the rounds that have
+// ended.
+type rulesHook struct {
+	BaseHook
+	rejectGuesses  bool
+	rejectAllWords bool
+	endedRounds    []int
+}
+
+func (hook *rulesHook) PlayerJoining(lobby *Lobby, name, address string) error {
+	if name == "spammer" {
+		return errors.New("you aren't welcome here")
+	}
+	return nil
+}
+
+func (hook *rulesHook) WordChosen(lobby *Lobby, drawer *Player, word string) error {
+	if hook.rejectAllWords || word == "pear" {
+		return errors.New("pick another word")
+	}
+	return nil
+}
+
+func (hook *rulesHook) CorrectGuess(lobby *Lobby, player *Player) error {
+	if hook.rejectGuesses {
+		return errors.New("guessing is paused")
+	}
+	return nil
+}
+
+func (hook *rulesHook) ChatMessage(lobby *Lobby, player *Player, message string) error {
+	if strings.Contains(message, "badword") {
+		return errors.New("mind your language")
+	}
+	return nil
+}
+
+func (hook *rulesHook) RoundEnded(lobby *Lobby, round int) {
+	hook.endedRounds = append(hook.endedRounds, round)
+}
+
+// countEvents returns how often an event of the given type has been sent to
+// all players.
+func countEvents(transport *recordingTransport, eventType string) int {
+	var count int
+	for _, sent := range transport.events {
+		if sent == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+// lastRejection returns the message of the latest rejection sent to a
+// player, if any.
+func lastRejection(transport *recordingTransport) string {
+	if len(transport.sent) == 0 {
+		return ""
+	}
+	event, isEvent := transport.sent[len(transport.sent)-1].(GameEvent)
+	if !isEvent || event.Type != EventTypeError {
+		return ""
+	}
+	if eventError := event.Data.(*EventError); eventError.Code == ErrorCodeRejected {
+		return eventError.Message
+	}
+	return ""
+}
+
+func Test_Hooks(t *testing.T) {
+	server, transport, notifier := newTestServer()
+	hook := &rulesHook{}
+	server.RegisterHook(hook)
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseLobby(lobby, "")
+
+	if _, err := lobby.JoinPlayer("spammer", "10.0.0.2", ""); err == nil || err.Error() != "you aren't welcome here" {
+		t.Errorf("expected the join to be rejected, got %v", err)
+	}
+	guest, err := lobby.JoinPlayer("guest", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lobby.synchronized(func() {
+		owner.Connected = true
+		guest.Connected = true
+		startGame(lobby)
+
+		lobby.wordChoice = []string{"pear", "apple"}
+		handleChooseWordEvent(lobby, owner, 0)
+		if lobby.CurrentWord != "" || lastRejection(transport) != "pick another word" {
+			t.Errorf("expected the word choice to be rejected, got %q", lobby.CurrentWord)
+		}
+		handleChooseWordEvent(lobby, owner, 1)
+		if lobby.CurrentWord != "apple" {
+			t.Errorf("expected the second choice to be accepted, got %q", lobby.CurrentWord)
+		}
+
+		messages := countEvents(transport, EventTypeMessage)
+		handleMessage("what a badword", guest, lobby)
+		if countEvents(transport, EventTypeMessage) != messages || lastRejection(transport) != "mind your language" {
+			t.Error("expected the message to be dropped")
+		}
+		handleMessage("hello", guest, lobby)
+		if countEvents(transport, EventTypeMessage) != messages+1 {
+			t.Error("expected the message to be sent")
+		}
+
+		hook.rejectGuesses = true
+		handleMessage("apple", guest, lobby)
+		if guest.State != Guessing || guest.Score != 0 || lastRejection(transport) != "guessing is paused" {
+			t.Errorf("expected the guess to be rejected, got %+v", guest)
+		}
+		hook.rejectGuesses = false
+		handleMessage("apple", guest, lobby)
+		if guest.Score == 0 || lobby.drawer != guest {
+			t.Error("expected the guess to end the turn")
+		}
+		if len(hook.endedRounds) != 0 {
+			t.Errorf("round ended too early: %v", hook.endedRounds)
+		}
+
+		//Without any allowed word, the guests turn is skipped, which
+		//finishes the game.
+		hook.rejectAllWords = true
+		wordChoiceTimeoutTask(lobby)
+		if notifier.finishedGames != 1 {
+			t.Error("expected the turn to be skipped")
+		}
+	})
+
+	if len(hook.endedRounds) != 1 || hook.endedRounds[0] != 1 {
+		t.Errorf("expected the first round to end, got %v", hook.endedRounds)
+	}
+}
@@ -0,0 +1,111 @@
+package game
+
+import (
+	"context"
+)
+
+// Context returns a context that is cancelled once the lobby has been
+// closed. Work done on behalf of the lobby, for example sending
+// notifications, should observe it, so that it doesn't outlive the lobby.
+func (lobby *Lobby) Context() context.Context {
+	lobby.lifecycleMutex.Lock()
+	defer lobby.lifecycleMutex.Unlock()
+
+	return lobby.getLifetime()
+}
+
+// getLifetime creates the lobbies context on first use. This must only be
+// called while holding the lifecycleMutex.
+func (lobby *Lobby) getLifetime() context.Context {
+	if lobby.lifetime == nil {
+		lobby.lifetime, lobby.endLifetime = context.WithCancel(context.Background())
+	}
+	return lobby.lifetime
+}
+
+// Go runs the given work on a new goroutine that the lobby waits for before
+// it's removed. The work receives the lobbies context and has to return
+// soon after it has been cancelled. If the lobby has already been closed,
+// the work isn't run and false is returned.
+func (lobby *Lobby) Go(work func(ctx context.Context)) bool {
+	ctx, running := lobby.startBackgroundWork()
+	if !running {
+		return false
+	}
+
+	go func() {
+		defer lobby.background.Done()
+		work(ctx)
+	}()
+	return true
+}
+
+// startBackgroundWork counts a new piece of background work, unless the
+// lobby has been closed already. Each successful call has to be followed by
+// a call to lobby.background.Done once the work has stopped.
+func (lobby *Lobby) startBackgroundWork() (context.Context, bool) {
+	lobby.lifecycleMutex.Lock()
+	defer lobby.lifecycleMutex.Unlock()
+
+	ctx := lobby.getLifetime()
+	if ctx.Err() != nil {
+		return ctx, false
+	}
+
+	//Adding happens while holding the mutex, so that it can't race with
+	//the waiting in teardown, which only starts after the cancellation.
+	lobby.background.Add(1)
+	return ctx, true
+}
+
+// stopBackgroundWork cancels the lobbies context, which prevents any new
+// background work from being started.
+func (lobby *Lobby) stopBackgroundWork() {
+	lobby.lifecycleMutex.Lock()
+	defer lobby.lifecycleMutex.Unlock()
+
+	lobby.getLifetime()
+	lobby.endLifetime()
+}
+
+// finishClosing is called once the lobby has been marked as closed. Lobbies
+// with a loop are torn down as soon as the loop has stopped, see runLoop.
+func (lobby *Lobby) finishClosing() {
+	//Lobbies without a loop could be closed by their own background work,
+	//for example a timer, so they are removed without waiting.
+	if lobby.actions == nil {
+		lobby.stopBackgroundWork()
+		lobby.notifier.LobbyRemoved(lobby)
+		return
+	}
+
+	//Cancelling early tells the background work to stop, while the loop
+	//is still finishing the current action.
+	lobby.stopBackgroundWork()
+}
+
+// teardown waits for all background work of the closed lobby to stop and
+// removes the lobby afterwards. Pending timers have been stopped by
+// cancelAllTasks and timers that are firing right now drop their action,
+// since synchronized observes the lobbies context.
+func (lobby *Lobby) teardown() {
+	lobby.stopBackgroundWork()
+	lobby.background.Wait()
+	lobby.notifier.LobbyRemoved(lobby)
+}
+
+// Close closes the lobby, unless that has happened already, and waits until
+// its loop and all of its background work have stopped. Once it returns,
+// the lobby has been removed and nothing is running on its behalf anymore.
+// This must not be called by work started via Go, as it would wait for
+// itself.
+func (lobby *Lobby) Close() {
+	CloseLobby(lobby, "The lobby has been closed.")
+}
+
+// waitForTeardown blocks until the lobby has been removed.
+func (lobby *Lobby) waitForTeardown() {
+	if lobby.stopped != nil {
+		<-lobby.stopped
+	}
+}
@@ -1,16 +1,15 @@
 package game
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
-	"log"
 	"math"
-	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-	"unicode/utf8"
 
 	commands "github.com/Bios-Marcel/cmdp"
 	"github.com/Bios-Marcel/discordemojimap"
@@ -22,7 +21,8 @@ import (
 )
 
 var (
-	LobbySettingBounds = &SettingBounds{
+	// defaultSettingBounds are used unless configured otherwise.
+	defaultSettingBounds = SettingBounds{
 		MinDrawingTime:       60,
 		MaxDrawingTime:       300,
 		MinRounds:            1,
@@ -31,18 +31,85 @@ var (
 		MaxMaxPlayers:        24,
 		MinClientsPerIPLimit: 1,
 		MaxClientsPerIPLimit: 24,
+		MaxStartDelayMinutes: 24 * 60,
+		MaxTags:              5,
+		MaxTagLength:         20,
+		MaxRegionLength:      32,
 	}
-	SupportedLanguages = map[string]string{
-		"english": "English",
-		"italian": "Italian",
-		"german":  "German",
-		"french":  "French",
-		"dutch":   "Dutch",
-		"swedish": "Swedish",
-		"pokemon": "Pokemon",
-	}
+	settingBounds      = &defaultSettingBounds
+	settingBoundsMutex = &sync.RWMutex{}
+)
+
+// DefaultSettingBounds returns the bounds used unless configured otherwise.
+func DefaultSettingBounds() SettingBounds {
+	return defaultSettingBounds
+}
+
+// GetSettingBounds returns the bounds currently used for validating lobby
+// settings. The returned value mustn't be modified.
+func GetSettingBounds() *SettingBounds {
+	settingBoundsMutex.RLock()
+	defer settingBoundsMutex.RUnlock()
+
+	return settingBounds
+}
+
+// SetSettingBounds replaces the bounds used for validating lobby settings.
+// Existing lobbies keep their settings.
+func SetSettingBounds(bounds SettingBounds) {
+	settingBoundsMutex.Lock()
+	defer settingBoundsMutex.Unlock()
+
+	settingBounds = &bounds
+}
+
+// These codes are sent as part of the close frame whenever the server closes
+// a websocket connection, so that clients can show an appropriate message.
+// RFC 6455 reserves the range from 4000 to 4999 for applications.
+const (
+	// CloseCodeLobbyClosed means that the lobby has been closed by its owner.
+	CloseCodeLobbyClosed = 4000
+	// CloseCodeKicked means that the player has been kicked from the lobby.
+	CloseCodeKicked = 4001
+	// CloseCodeLobbyFull means that the players slot has been taken by
+	// someone else while they were disconnected.
+	CloseCodeLobbyFull = 4002
+	// CloseCodeTooSlow means that the client couldn't keep up with the
+	// events sent to it. The client may reconnect.
+	CloseCodeTooSlow = 4003
+	// CloseCodeTimeout means that the client didn't answer the servers
+	// pings. The client may reconnect.
+	CloseCodeTimeout = 4004
+	// CloseCodeRateLimited means that the client has kept sending more
+	// events than allowed.
+	CloseCodeRateLimited = 4005
+	// CloseCodeShutdown means that the lobby has been closed, because the
+	// server is shutting down.
+	CloseCodeShutdown = 4006
+	// CloseCodeRestarting means that the server is restarting, but the lobby
+	// will be restored afterwards. The client should reconnect.
+	CloseCodeRestarting = 4007
+)
+
+// ErrPlayerBanned is returned when a player tries joining a lobby that they
+// have been banned from.
+var ErrPlayerBanned = errors.New("you have been banned from this lobby")
+
+const (
+	// ProtocolVersion is the newest version of the websocket protocol that
+	// this server speaks. Whenever events are changed in an incompatible
+	// manner, this has to be incremented.
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest version of the websocket protocol
+	// that this server still supports. Clients that don't declare any
+	// version are treated as using this version.
+	MinProtocolVersion = 1
 )
 
+// timeSyncInterval is the interval in which time-sync events are sent during
+// a round.
+const timeSyncInterval = 10 * time.Second
+
 const (
 	DrawingBoardBaseWidth  = 1600
 	DrawingBoardBaseHeight = 900
@@ -51,32 +118,41 @@ const (
 
 	maxBaseScore      = 200
 	maxHintBonusScore = 60
+
+	// minPlayersForScheduledStart is the amount of connected players needed
+	// for a scheduled lobby to start automatically.
+	minPlayersForScheduledStart = 2
+
+	defaultInviteValidMinutes = 60
+	maxInviteValidMinutes     = 24 * 60
+	defaultInviteUses         = 10
+	maxInviteUses             = 100
 )
 
 // SettingBounds defines the lower and upper bounds for the user-specified
 // lobby creation input.
 type SettingBounds struct {
-	MinDrawingTime       int64
-	MaxDrawingTime       int64
-	MinRounds            int64
-	MaxRounds            int64
-	MinMaxPlayers        int64
-	MaxMaxPlayers        int64
-	MinClientsPerIPLimit int64
-	MaxClientsPerIPLimit int64
-}
-
-// LineEvent is basically the same as GameEvent, but with a specific Data type.
-// We use this for reparsing as soon as we know that the type is right. It's
-// a bit unperformant, but will do for now.
+	MinDrawingTime       int64 `yaml:"minDrawingTime"`
+	MaxDrawingTime       int64 `yaml:"maxDrawingTime"`
+	MinRounds            int64 `yaml:"minRounds"`
+	MaxRounds            int64 `yaml:"maxRounds"`
+	MinMaxPlayers        int64 `yaml:"minMaxPlayers"`
+	MaxMaxPlayers        int64 `yaml:"maxMaxPlayers"`
+	MinClientsPerIPLimit int64 `yaml:"minClientsPerIPLimit"`
+	MaxClientsPerIPLimit int64 `yaml:"maxClientsPerIPLimit"`
+	MaxStartDelayMinutes int64 `yaml:"maxStartDelayMinutes"`
+	MaxTags              int64 `yaml:"maxTags"`
+	MaxTagLength         int64 `yaml:"maxTagLength"`
+	MaxRegionLength      int64 `yaml:"maxRegionLength"`
+}
+
+// LineEvent is a line of the current drawing, as part of EventTypeDrawing.
 type LineEvent struct {
 	Type string `json:"type"`
 	Data *Line  `json:"data"`
 }
 
-// FillEvent is basically the same as GameEvent, but with a specific Data type.
-// We use this for reparsing as soon as we know that the type is right. It's
-// a bit unperformant, but will do for now.
+// FillEvent is a fill of the current drawing, as part of EventTypeDrawing.
 type FillEvent struct {
 	Type string `json:"type"`
 	Data *Fill  `json:"data"`
@@ -89,129 +165,38 @@ type KickVote struct {
 	RequiredVoteCount int    `json:"requiredVoteCount"`
 }
 
-func HandleEvent(raw []byte, received *GameEvent, lobby *Lobby, player *Player) error {
-	if received.Type == "message" {
-		dataAsString, isString := (received.Data).(string)
-		if !isString {
-			return fmt.Errorf("invalid data received: '%s'", received.Data)
-		}
-
-		if strings.HasPrefix(dataAsString, "!") {
-			handleCommand(dataAsString[1:], player, lobby)
-		} else {
-			handleMessage(dataAsString, player, lobby)
-		}
-	} else if received.Type == "line" {
-		if lobby.canDraw(player) {
-			line := &LineEvent{}
-			jsonError := json.Unmarshal(raw, line)
-			if jsonError != nil {
-				return fmt.Errorf("error decoding data: %s", jsonError)
-			}
-
-			//In case the line is too big, we overwrite the data of the event.
-			//This will prevent clients from lagging due to too thick lines.
-			if line.Data.LineWidth > float32(MaxBrushSize) {
-				line.Data.LineWidth = MaxBrushSize
-				received.Data = line.Data
-			} else if line.Data.LineWidth < float32(MinBrushSize) {
-				line.Data.LineWidth = MinBrushSize
-				received.Data = line.Data
-			}
-
-			lobby.AppendLine(line)
-
-			//We directly forward the event, as it seems to be valid.
-			SendDataToEveryoneExceptSender(player, lobby, received)
-		}
-	} else if received.Type == "fill" {
-		if lobby.canDraw(player) {
-			fill := &FillEvent{}
-			jsonError := json.Unmarshal(raw, fill)
-			if jsonError != nil {
-				return fmt.Errorf("error decoding data: %s", jsonError)
-			}
-			lobby.AppendFill(fill)
-
-			//We directly forward the event, as it seems to be valid.
-			SendDataToEveryoneExceptSender(player, lobby, received)
-		}
-	} else if received.Type == "clear-drawing-board" {
-		if lobby.canDraw(player) && len(lobby.currentDrawing) > 0 {
-			lobby.ClearDrawing()
-			SendDataToEveryoneExceptSender(player, lobby, received)
-		}
-	} else if received.Type == "choose-word" {
-		chosenIndex, isInt := (received.Data).(int)
-		if !isInt {
-			asFloat, isFloat32 := (received.Data).(float64)
-			if isFloat32 && asFloat < 4 {
-				chosenIndex = int(asFloat)
-			} else {
-				return fmt.Errorf("invalid data in choose-word event: %v", received.Data)
-			}
-		}
-
-		drawer := lobby.drawer
-		if player == drawer && len(lobby.wordChoice) > 0 && chosenIndex >= 0 && chosenIndex <= 2 {
-			lobby.CurrentWord = lobby.wordChoice[chosenIndex]
-
-			//Depending on how long the word is, a fixed amount of hints
-			//would be too easy or too hard.
-			runeCount := utf8.RuneCountInString(lobby.CurrentWord)
-			if runeCount <= 2 {
-				lobby.hintCount = 0
-			} else if runeCount <= 4 {
-				lobby.hintCount = 1
-			} else if runeCount <= 9 {
-				lobby.hintCount = 2
-			} else {
-				lobby.hintCount = 3
-			}
-			lobby.hintsLeft = lobby.hintCount
+// HandleEvent validates and handles an event sent by a player. If the event
+// is rejected, the player receives an EventTypeError event and the
+// *EventError is returned as well, so that the caller can log it. Events
+// sent to a lobby that has already been closed are dropped, returning
+// ErrLobbyClosed.
+func HandleEvent(received *InboundEvent, lobby *Lobby, player *Player) error {
+	var eventError *EventError
+	if !lobby.synchronized(func() {
+		eventError = handleEvent(received, lobby, player)
+	}) {
+		return ErrLobbyClosed
+	}
 
-			lobby.wordChoice = nil
-			lobby.wordHints = createWordHintFor(lobby.CurrentWord, false)
-			lobby.wordHintsShown = createWordHintFor(lobby.CurrentWord, true)
-			triggerWordHintUpdate(lobby)
-		}
-	} else if received.Type == "kick-vote" {
-		if lobby.EnableVotekick {
-			toKickID, isString := (received.Data).(string)
-			if !isString {
-				return fmt.Errorf("invalid data in kick-vote event: %v", received.Data)
-			}
+	//Returning the nil pointer directly would result in a non-nil error.
+	if eventError != nil {
+		return eventError
+	}
+	return nil
+}
 
-			handleKickEvent(lobby, player, toKickID)
-		}
-	} else if received.Type == "start" {
-		if lobby.Round == 0 && player == lobby.owner {
-			//We are reseting each players score, since players could
-			//technically be player a second game after the last one
-			//has already ended.
-			for _, otherPlayer := range lobby.players {
-				otherPlayer.Score = 0
-				otherPlayer.LastScore = 0
-				//Since nobody has any points in the beginning, everyone has practically
-				//the same rank, therefore y'll winners for now.
-				otherPlayer.Rank = 1
-			}
+func handleEvent(received *InboundEvent, lobby *Lobby, player *Player) *EventError {
+	span, endSpan := lobby.startActiveSpan("HandleEvent", "event.type", received.Type, "player", player.ID)
+	defer endSpan()
 
-			advanceLobby(lobby)
-		}
-	} else if received.Type == "name-change" {
-		newName, isString := (received.Data).(string)
-		if !isString {
-			return fmt.Errorf("invalid data in name-change event: %v", received.Data)
-		}
-		commandNick(player, lobby, newName)
-	} else if received.Type == "request-drawing" {
-		WriteAsJSON(player, GameEvent{Type: "drawing", Data: lobby.currentDrawing})
-	} else if received.Type == "keep-alive" {
-		//This is a known dummy event in order to avoid accidental websocket
-		//connection closure. However, no action is required on the server.
+	action, eventError := validateEvent(received, lobby, player)
+	if eventError != nil {
+		span.SetError(eventError)
+		sendEventError(lobby, player, eventError)
+		return eventError
 	}
 
+	action(lobby, player)
 	return nil
 }
 
@@ -236,7 +221,12 @@ func handleMessage(message string, sender *Player, lobby *Lobby) {
 		normSearched := simplifyText(currentWord)
 
 		if normSearched == normInput {
-			secondsLeft := int(lobby.RoundEndTime/1000 - time.Now().UTC().UnixNano()/1000000000)
+			if rejection := lobby.checkHooks(func(hook Hook) error { return hook.CorrectGuess(lobby, sender) }); rejection != nil {
+				sendRejection(lobby, sender, EventTypeMessage, rejection)
+				return
+			}
+
+			secondsLeft := int((lobby.RoundEndTime - lobby.getTimeAsMillis()) / 1000)
 
 			sender.LastScore = calculateGuesserScore(lobby.hintsLeft, lobby.hintCount, secondsLeft, lobby.DrawingTime)
 			sender.Score += sender.LastScore
@@ -244,18 +234,18 @@ func handleMessage(message string, sender *Player, lobby *Lobby) {
 			lobby.scoreEarnedByGuessers += sender.LastScore
 			sender.State = Standby
 
-			TriggerUpdateEvent("correct-guess", sender.ID, lobby)
+			lobby.transport.TriggerUpdateEvent(EventTypeCorrectGuess, sender.ID, lobby)
 
 			if !lobby.isAnyoneStillGuessing() {
 				advanceLobby(lobby)
 			} else {
 				//Since the word has been guessed correctly, we reveal it.
-				WriteAsJSON(sender, GameEvent{Type: "update-wordhint", Data: lobby.wordHintsShown})
+				lobby.transport.WriteAsJSON(sender, GameEvent{Type: EventTypeUpdateWordHint, Data: lobby.wordHintsShown})
 				recalculateRanks(lobby)
 				triggerPlayersUpdate(lobby)
 			}
 		} else if levenshtein.ComputeDistance(normInput, normSearched) == 1 {
-			WriteAsJSON(sender, GameEvent{Type: "close-guess", Data: trimmedMessage})
+			lobby.transport.WriteAsJSON(sender, GameEvent{Type: EventTypeCloseGuess, Data: trimmedMessage})
 			//In cases of a close guess, we still send the message to everyone.
 			//This allows other players to guess the word by watching what the
 			//other players are misstyping.
@@ -292,26 +282,42 @@ func (lobby *Lobby) isAnyoneStillGuessing() bool {
 	return false
 }
 
+// allowMessage asks the hooks whether the message may be shown to the
+// other players.
+func allowMessage(message string, sender *Player, lobby *Lobby) bool {
+	rejection := lobby.checkHooks(func(hook Hook) error { return hook.ChatMessage(lobby, sender, message) })
+	if rejection != nil {
+		sendRejection(lobby, sender, EventTypeMessage, rejection)
+		return false
+	}
+	return true
+}
+
 func sendMessageToAll(message string, sender *Player, lobby *Lobby) {
-	messageEvent := GameEvent{Type: "message", Data: Message{
+	if !allowMessage(message, sender, lobby) {
+		return
+	}
+
+	lobby.transport.TriggerUpdateEvent(EventTypeMessage, Message{
 		Author:   html.EscapeString(sender.Name),
 		AuthorID: sender.ID,
 		Content:  html.EscapeString(discordemojimap.Replace(message)),
-	}}
-	for _, target := range lobby.players {
-		WriteAsJSON(target, messageEvent)
-	}
+	}, lobby)
 }
 
 func sendMessageToAllNonGuessing(message string, sender *Player, lobby *Lobby) {
-	messageEvent := GameEvent{Type: "non-guessing-player-message", Data: Message{
+	if !allowMessage(message, sender, lobby) {
+		return
+	}
+
+	messageEvent := GameEvent{Type: EventTypeNonGuessingPlayerMessage, Data: Message{
 		Author:   html.EscapeString(sender.Name),
 		AuthorID: sender.ID,
 		Content:  html.EscapeString(discordemojimap.Replace(message)),
 	}}
 	for _, target := range lobby.players {
 		if target.State != Guessing {
-			WriteAsJSON(target, messageEvent)
+			lobby.transport.WriteAsJSON(target, messageEvent)
 		}
 	}
 }
@@ -340,7 +346,7 @@ func handleKickEvent(lobby *Lobby, player *Player, toKickID string) {
 		playerToKick := lobby.players[toKick]
 		if !playerToKick.Connected {
 			//TODO Send error event
-			WriteAsJSON(player, GameEvent{Type: "system-message", Data: fmt.Sprintf("You can't kick a disconnected player.")})
+			lobby.transport.WriteAsJSON(player, GameEvent{Type: EventTypeSystemMessage, Data: fmt.Sprintf("You can't kick a disconnected player.")})
 			return
 		}
 
@@ -355,7 +361,7 @@ func handleKickEvent(lobby *Lobby, player *Player, toKickID string) {
 		votesNeeded := calculateVotesNeededToKick(playerToKick, lobby)
 
 		kickEvent := &GameEvent{
-			Type: "kick-vote",
+			Type: EventTypeKickVote,
 			Data: &KickVote{
 				PlayerID:          playerToKick.ID,
 				PlayerName:        playerToKick.Name,
@@ -365,55 +371,68 @@ func handleKickEvent(lobby *Lobby, player *Player, toKickID string) {
 		}
 
 		for _, otherPlayer := range lobby.players {
-			WriteAsJSON(otherPlayer, kickEvent)
+			lobby.transport.WriteAsJSON(otherPlayer, kickEvent)
 		}
 
 		if voteKickCount >= votesNeeded {
-			//Since the player is already kicked, we first clean up the kicking information related to that player
-			for _, otherPlayer := range lobby.players {
-				delete(otherPlayer.votedForKick, toKickID)
-			}
+			//The player is banned for the rest of the lobbies lifetime, so
+			//that they can't simply rejoin.
+			recordAudit(lobby, "votekick", nil, playerToKick.ID, playerToKick.Name, fmt.Sprintf("%d of %d required votes", voteKickCount, votesNeeded))
+			kickPlayer(lobby, toKick, true, "You have been kicked from the lobby.")
+		}
+	}
+}
 
-			if playerToKick.ws != nil {
-				playerToKick.ws.Close()
-			}
-			lobby.players = append(lobby.players[:toKick], lobby.players[toKick+1:]...)
-
-			if lobby.drawer == playerToKick {
-				TriggerUpdateEvent("drawer-kicked", nil, lobby)
-				//Since the drawing person has been kicked, that probably means that he/she was trolling, therefore
-				//we redact everyones last earned score.
-				for _, otherPlayer := range lobby.players {
-					otherPlayer.Score -= otherPlayer.LastScore
-					otherPlayer.LastScore = 0
-				}
-				lobby.scoreEarnedByGuessers = 0
-				//We must absolutely not set lobby.drawer to nil, since this would cause the drawing order to be ruined.
-			}
+// kickPlayer removes the player at the given index from the lobby, closing
+// their connection with the given reason. If the drawer is kicked, the
+// turn ends.
+func kickPlayer(lobby *Lobby, toKick int, ban bool, reason string) {
+	playerToKick := lobby.players[toKick]
 
-			//If the owner is kicked, we choose the next best person as the owner.
-			if lobby.owner == playerToKick {
-				for _, otherPlayer := range lobby.players {
-					potentialOwner := otherPlayer
-					if potentialOwner.Connected {
-						lobby.owner = potentialOwner
-						TriggerUpdateEvent("owner-change", &OwnerChangeEvent{
-							PlayerID:   potentialOwner.ID,
-							PlayerName: potentialOwner.Name,
-						}, lobby)
-						break
-					}
-				}
-			}
+	//Since the player is already kicked, we first clean up the kicking information related to that player
+	for _, otherPlayer := range lobby.players {
+		delete(otherPlayer.votedForKick, playerToKick.ID)
+	}
 
-			recalculateRanks(lobby)
-			triggerPlayersUpdate(lobby)
+	if ban {
+		lobby.BanPlayer(playerToKick)
+	}
+	lobby.transport.CloseConnection(playerToKick, CloseCodeKicked, reason)
+	lobby.players = append(lobby.players[:toKick], lobby.players[toKick+1:]...)
 
-			if lobby.drawer == playerToKick || !lobby.isAnyoneStillGuessing() {
-				advanceLobby(lobby)
+	if lobby.drawer == playerToKick {
+		lobby.transport.TriggerUpdateEvent(EventTypeDrawerKicked, nil, lobby)
+		//Since the drawing person has been kicked, that probably means that he/she was trolling, therefore
+		//we redact everyones last earned score.
+		for _, otherPlayer := range lobby.players {
+			otherPlayer.Score -= otherPlayer.LastScore
+			otherPlayer.LastScore = 0
+		}
+		lobby.scoreEarnedByGuessers = 0
+		//We must absolutely not set lobby.drawer to nil, since this would cause the drawing order to be ruined.
+	}
+
+	//If the owner is kicked, we choose the next best person as the owner.
+	if lobby.owner == playerToKick {
+		for _, otherPlayer := range lobby.players {
+			potentialOwner := otherPlayer
+			if potentialOwner.Connected {
+				lobby.owner = potentialOwner
+				lobby.transport.TriggerUpdateEvent(EventTypeOwnerChange, &OwnerChangeEvent{
+					PlayerID:   potentialOwner.ID,
+					PlayerName: potentialOwner.Name,
+				}, lobby)
+				break
 			}
 		}
 	}
+
+	recalculateRanks(lobby)
+	triggerPlayersUpdate(lobby)
+
+	if lobby.drawer == playerToKick || !lobby.isAnyoneStillGuessing() {
+		advanceLobby(lobby)
+	}
 }
 
 type OwnerChangeEvent struct {
@@ -456,6 +475,18 @@ func handleCommand(commandString string, caller *Player, lobby *Lobby) {
 		switch strings.ToLower(command[0]) {
 		case "setmp":
 			commandSetMP(caller, lobby, command)
+		case "invite":
+			commandInvite(caller, lobby, command)
+		case "revoke":
+			commandRevoke(caller, lobby, command)
+		case "bans":
+			commandBans(caller, lobby)
+		case "unban":
+			commandUnban(caller, lobby, command)
+		case "close":
+			commandClose(caller, lobby, command)
+		case "audience":
+			commandAudience(caller, lobby, command)
 		case "help":
 			//TODO
 		}
@@ -470,13 +501,14 @@ func commandNick(caller *Player, lobby *Lobby, name string) {
 		newName = newName[:MaxPlayerNameLength+1]
 	}
 
+	oldName := caller.Name
 	if newName == "" {
 		caller.Name = GeneratePlayerName()
 	} else {
 		caller.Name = newName
 	}
 
-	fmt.Printf("%s is now %s\n", caller.Name, newName)
+	lobby.PlayerLogger(caller).Debug("player changed their name", "oldName", oldName, "name", caller.Name)
 
 	triggerPlayersUpdate(lobby)
 }
@@ -490,34 +522,278 @@ func commandSetMP(caller *Player, lobby *Lobby, args []string) {
 		newMaxPlayersValue := strings.TrimSpace(args[1])
 		newMaxPlayersValueInt, err := strconv.ParseInt(newMaxPlayersValue, 10, 64)
 		if err == nil {
-			if int(newMaxPlayersValueInt) >= len(lobby.players) && newMaxPlayersValueInt <= LobbySettingBounds.MaxMaxPlayers && newMaxPlayersValueInt >= LobbySettingBounds.MinMaxPlayers {
+			bounds := GetSettingBounds()
+			if int(newMaxPlayersValueInt) >= len(lobby.players) && newMaxPlayersValueInt <= bounds.MaxMaxPlayers && newMaxPlayersValueInt >= bounds.MinMaxPlayers {
+				recordAudit(lobby, "settings", caller, "", "", fmt.Sprintf("maxPlayers changed from %d to %d", lobby.MaxPlayers, newMaxPlayersValueInt))
 				lobby.MaxPlayers = int(newMaxPlayersValueInt)
 
-				WritePublicSystemMessage(lobby, fmt.Sprintf("MaxPlayers value has been changed to %d", lobby.MaxPlayers))
+				lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("MaxPlayers value has been changed to %d", lobby.MaxPlayers))
 			} else {
-				if len(lobby.players) > int(LobbySettingBounds.MinMaxPlayers) {
-					WriteAsJSON(caller, GameEvent{Type: "system-message", Data: fmt.Sprintf("MaxPlayers value should be between %d and %d.", len(lobby.players), LobbySettingBounds.MaxMaxPlayers)})
+				if len(lobby.players) > int(bounds.MinMaxPlayers) {
+					lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: fmt.Sprintf("MaxPlayers value should be between %d and %d.", len(lobby.players), bounds.MaxMaxPlayers)})
 				} else {
-					WriteAsJSON(caller, GameEvent{Type: "system-message", Data: fmt.Sprintf("MaxPlayers value should be between %d and %d.", LobbySettingBounds.MinMaxPlayers, LobbySettingBounds.MaxMaxPlayers)})
+					lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: fmt.Sprintf("MaxPlayers value should be between %d and %d.", bounds.MinMaxPlayers, bounds.MaxMaxPlayers)})
 				}
 			}
 		} else {
-			WriteAsJSON(caller, GameEvent{Type: "system-message", Data: "MaxPlayers value must be numeric."})
+			lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "MaxPlayers value must be numeric."})
 		}
 	} else {
-		WriteAsJSON(caller, GameEvent{Type: "system-message", Data: "Only the lobby owner can change MaxPlayers setting."})
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Only the lobby owner can change MaxPlayers setting."})
 	}
 }
 
+// commandInvite creates a new invite token for the lobby. Optionally, the
+// amount of minutes the token is valid for and the amount of joins it allows
+// can be passed: "!invite [minutes] [uses]".
+func commandInvite(caller *Player, lobby *Lobby, args []string) {
+	if caller != lobby.owner {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Only the lobby owner can create invites."})
+		return
+	}
+
+	validMinutes := defaultInviteValidMinutes
+	uses := defaultInviteUses
+	if len(args) >= 2 {
+		parsed, err := strconv.ParseInt(strings.TrimSpace(args[1]), 10, 64)
+		if err != nil || parsed < 1 || parsed > maxInviteValidMinutes {
+			lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: fmt.Sprintf("The invite duration must be between 1 and %d minutes.", maxInviteValidMinutes)})
+			return
+		}
+		validMinutes = int(parsed)
+	}
+	if len(args) >= 3 {
+		parsed, err := strconv.ParseInt(strings.TrimSpace(args[2]), 10, 64)
+		if err != nil || parsed < 1 || parsed > maxInviteUses {
+			lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: fmt.Sprintf("The invite uses must be between 1 and %d.", maxInviteUses)})
+			return
+		}
+		uses = int(parsed)
+	}
+
+	inviteToken := lobby.CreateInviteToken(time.Duration(validMinutes)*time.Minute, uses)
+	lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: fmt.Sprintf(
+		"Invite created, it's valid for %d minutes and %d joins: /ssrEnterLobby?invite=%s (Revoke via '!revoke %s')",
+		validMinutes, uses, inviteToken.Token, inviteToken.Token)})
+}
+
+// commandRevoke invalidates either a single invite token or all invite
+// tokens of the lobby: "!revoke <token|all>".
+func commandRevoke(caller *Player, lobby *Lobby, args []string) {
+	if caller != lobby.owner {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Only the lobby owner can revoke invites."})
+		return
+	}
+
+	if len(args) < 2 {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Please specify the invite to revoke or 'all'."})
+		return
+	}
+
+	token := strings.TrimSpace(args[1])
+	if strings.ToLower(token) == "all" {
+		revokedCount := lobby.RevokeAllInviteTokens()
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: fmt.Sprintf("Revoked %d invites.", revokedCount)})
+	} else if lobby.RevokeInviteToken(token) {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "The invite has been revoked."})
+	} else {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "The invite doesn't exist."})
+	}
+}
+
+// commandBans lists all players banned from the lobby, numbered, so that the
+// owner can unban them via "!unban <number>".
+func commandBans(caller *Player, lobby *Lobby) {
+	if caller != lobby.owner {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Only the lobby owner can view bans."})
+		return
+	}
+
+	bans := lobby.GetBans()
+	if len(bans) == 0 {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Nobody has been banned."})
+		return
+	}
+
+	banList := make([]string, 0, len(bans))
+	for index, ban := range bans {
+		banList = append(banList, fmt.Sprintf("%d: %s", index+1, ban.PlayerName))
+	}
+	lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Banned players: " + strings.Join(banList, ", ")})
+}
+
+// commandUnban lifts one or all bans: "!unban <number|all>".
+func commandUnban(caller *Player, lobby *Lobby, args []string) {
+	if caller != lobby.owner {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Only the lobby owner can unban players."})
+		return
+	}
+
+	if len(args) < 2 {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Please specify the number of the ban, as shown by '!bans', or 'all'."})
+		return
+	}
+
+	value := strings.TrimSpace(args[1])
+	if strings.ToLower(value) == "all" {
+		unbannedCount := lobby.UnbanAll()
+		recordAudit(lobby, "unban", caller, "", "", fmt.Sprintf("unbanned all %d players", unbannedCount))
+		lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("%d players have been unbanned.", unbannedCount))
+		return
+	}
+
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "The ban number must be numeric."})
+		return
+	}
+
+	ban, existed := lobby.Unban(int(number) - 1)
+	if !existed {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "The ban doesn't exist."})
+		return
+	}
+
+	recordAudit(lobby, "unban", caller, "", ban.PlayerName, "")
+	lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("%s has been unbanned.", ban.PlayerName))
+}
+
+// commandClose ends the game and closes the lobby for good. Optionally, a
+// reason can be passed, which will be shown to all players: "!close [reason]".
+func commandClose(caller *Player, lobby *Lobby, args []string) {
+	if caller != lobby.owner {
+		lobby.transport.WriteAsJSON(caller, GameEvent{Type: EventTypeSystemMessage, Data: "Only the lobby owner can close the lobby."})
+		return
+	}
+
+	reason := strings.TrimSpace(strings.Join(args[1:], " "))
+	if reason == "" {
+		reason = "The lobby owner has closed the lobby."
+	}
+
+	recordAudit(lobby, "close", caller, "", "", reason)
+	closeLobby(lobby, CloseCodeLobbyClosed, reason)
+}
+
+// closeLobby ends the game, notifies all players about the closure and then
+// closes all connections using the given code. Afterwards, the lobby is
+// removed, so nobody can join it anymore, once all of its background work
+// has stopped. Lobbies can only be closed once.
+func closeLobby(lobby *Lobby, closeCode int, reason string) {
+	if !atomic.CompareAndSwapInt32(&lobby.closed, 0, 1) {
+		return
+	}
+
+	lobby.cancelAllTasks()
+
+	lobby.drawer = nil
+	lobby.CurrentWord = ""
+	lobby.ScheduledStartTime = 0
+	lobby.state = gameOver
+
+	lobby.transport.TriggerUpdateEvent(EventTypeLobbyClosed, html.EscapeString(reason), lobby)
+	for _, player := range lobby.players {
+		lobby.transport.CloseConnection(player, closeCode, reason)
+	}
+
+	lobby.finishClosing()
+}
+
+// startGame resets all scores and starts the first turn.
+func startGame(lobby *Lobby) {
+	lobby.ScheduledStartTime = 0
+	lobby.gameStarted = lobby.now()
+	lobby.turns = nil
+
+	//We are reseting each players score, since players could
+	//technically be player a second game after the last one
+	//has already ended.
+	for _, otherPlayer := range lobby.players {
+		otherPlayer.Score = 0
+		otherPlayer.LastScore = 0
+		//Since nobody has any points in the beginning, everyone has practically
+		//the same rank, therefore y'll winners for now.
+		otherPlayer.Rank = 1
+	}
+
+	advanceLobby(lobby)
+}
+
+// ScheduleStart locks the game until the given time. Once the time has been
+// reached, the game will be started automatically, as soon as enough
+// players are connected. Until then, a countdown is broadcasted.
+func (lobby *Lobby) ScheduleStart(startTime time.Time) {
+	lobby.synchronized(func() {
+		scheduleStart(lobby, startTime)
+	})
+}
+
+func scheduleStart(lobby *Lobby, startTime time.Time) {
+	lobby.ScheduledStartTime = startTime.UTC().UnixNano() / 1000000
+	lobby.schedule(taskScheduledStart, scheduledStartInterval, scheduledStartTask)
+}
+
+// StartCountdown is sent to all players at certain points in time before a
+// scheduled game starts.
+type StartCountdown struct {
+	// StartTime is the amount of milliseconds left until the game starts.
+	StartTime int `json:"startTime"`
+}
+
+func scheduledStartTask(lobby *Lobby) {
+	if lobby.ScheduledStartTime == 0 || lobby.state != unstarted {
+		return
+	}
+
+	timeLeft := lobby.ScheduledStartTime - lobby.getTimeAsMillis()
+	if timeLeft <= 0 {
+		if lobby.GetConnectedPlayerCount() >= minPlayersForScheduledStart {
+			startGame(lobby)
+			return
+		}
+
+		//We only announce this once, right after the start time has been
+		//reached. After that, we silently wait for more players.
+		if timeLeft > -1000 {
+			lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("The game will start as soon as %d players are connected.", minPlayersForScheduledStart))
+		}
+		lobby.schedule(taskScheduledStart, scheduledStartInterval, scheduledStartTask)
+		return
+	}
+
+	secondsLeft := (timeLeft + 999) / 1000
+	if isCountdownAnnouncement(secondsLeft) {
+		lobby.transport.TriggerUpdateEvent(EventTypeStartCountdown, &StartCountdown{StartTime: int(timeLeft)}, lobby)
+		if secondsLeft >= 60 {
+			lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("The game starts in %d minutes.", secondsLeft/60))
+		} else {
+			lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("The game starts in %d seconds.", secondsLeft))
+		}
+	}
+	lobby.schedule(taskScheduledStart, scheduledStartInterval, scheduledStartTask)
+}
+
+// isCountdownAnnouncement decides whether the countdown of a scheduled lobby
+// should be announced to the players at the given amount of seconds left.
+func isCountdownAnnouncement(secondsLeft int64) bool {
+	if secondsLeft >= 60 {
+		minutesLeft := secondsLeft / 60
+		return secondsLeft%60 == 0 && (minutesLeft <= 5 || minutesLeft%15 == 0)
+	}
+
+	return secondsLeft == 30 || secondsLeft == 10 || secondsLeft <= 5
+}
+
 // advanceLobby will either start the game or jump over to the next turn.
 func advanceLobby(lobby *Lobby) {
-	if lobby.timeLeftTicker != nil {
-		lobby.timeLeftTicker.Stop()
-		lobby.timeLeftTicker = nil
-	}
+	_, endSpan := lobby.startActiveSpan("advanceLobby", "round", lobby.Round)
+	defer endSpan()
+
+	lobby.cancelAllTasks()
 
 	//The drawer can potentially be null if he's kicked, in that case we proceed with the round if anyone has already
 	drawer := lobby.drawer
+	var drawerScore int
 	if drawer != nil && lobby.scoreEarnedByGuessers > 0 {
 
 		//Average score, but minus one player, since the own score is 0 and doesn't count.
@@ -534,7 +810,9 @@ func advanceLobby(lobby *Lobby) {
 
 		drawer.LastScore = averageScore
 		drawer.Score += drawer.LastScore
+		drawerScore = averageScore
 	}
+	lobby.recordTurn(drawerScore)
 
 	//We need this for the next-turn event, in order to allow the client
 	//to know which word was previously supposed to be guessed.
@@ -552,9 +830,23 @@ func advanceLobby(lobby *Lobby) {
 		}
 		otherPlayer.State = Guessing
 		otherPlayer.votedForKick = make(map[string]bool)
+		otherPlayer.reactionCount = 0
+	}
+
+	//While shutting down, no new turns are started, so the lobby is closed
+	//as soon as the current turn is over.
+	if IsShuttingDown() {
+		closeForShutdown(lobby)
+		return
 	}
 
 	newDrawer, roundOver := selectNextDrawer(lobby)
+	//Before the first turn, no round has been played yet.
+	if roundOver && lobby.state == ongoing {
+		for _, hook := range lobby.hooks {
+			hook.RoundEnded(lobby, lobby.Round)
+		}
+	}
 	if roundOver {
 		if lobby.Round == lobby.MaxRounds {
 			endGame(lobby)
@@ -569,20 +861,20 @@ func advanceLobby(lobby *Lobby) {
 	lobby.ClearDrawing()
 	lobby.drawer = newDrawer
 	lobby.drawer.State = Drawing
+	lobby.turnOrder.markDrawn(newDrawer)
 	lobby.state = ongoing
-	lobby.wordChoice = GetRandomWords(3, lobby)
+	lobby.wordChoice = GetRandomWords(wordChoiceCount, lobby)
 
 	recalculateRanks(lobby)
 
 	//We use milliseconds for higher accuracy
-	lobby.RoundEndTime = time.Now().UTC().UnixNano()/1000000 + int64(lobby.DrawingTime)*1000
-	lobby.timeLeftTicker = time.NewTicker(1 * time.Second)
-	go roundTimerTicker(lobby)
+	lobby.RoundEndTime = lobby.getTimeAsMillis() + int64(lobby.DrawingTime)*1000
+	scheduleTurn(lobby)
 
 	nextTurnEvent := &NextTurn{
 		Round:        lobby.Round,
 		Players:      lobby.players,
-		RoundEndTime: int(lobby.RoundEndTime - getTimeAsMillis()),
+		RoundEndTime: int(lobby.RoundEndTime - lobby.getTimeAsMillis()),
 	}
 
 	//In the first turn, we set this field to null to signal that
@@ -592,9 +884,13 @@ func advanceLobby(lobby *Lobby) {
 	if !firstTurn {
 		nextTurnEvent.PreviousWord = &previousWord
 	}
-	TriggerUpdateEvent("next-turn", nextTurnEvent, lobby)
+	lobby.transport.TriggerUpdateEvent(EventTypeNextTurn, nextTurnEvent, lobby)
+
+	lobby.transport.WriteAsJSON(lobby.drawer, &GameEvent{Type: EventTypeYourTurn, Data: lobby.wordChoice})
 
-	WriteAsJSON(lobby.drawer, &GameEvent{Type: "your-turn", Data: lobby.wordChoice})
+	if firstTurn {
+		lobby.notifier.GameStarted(lobby)
+	}
 }
 
 func endGame(lobby *Lobby) {
@@ -605,74 +901,55 @@ func endGame(lobby *Lobby) {
 	recalculateRanks(lobby)
 
 	for _, player := range lobby.players {
-		WriteAsJSON(player, GameEvent{
-			Type: "ready",
+		lobby.transport.WriteAsJSON(player, GameEvent{
+			Type: EventTypeReady,
 			Data: generateReadyData(lobby, player),
 		})
 	}
+
+	lobby.notifier.GameFinished(lobby)
 }
 
 // selectNextDrawer returns the next person that's supposed to be drawing, but
 // doesn't tell the lobby yet. The boolean signals whether the current round is
-// over.
+// over. Disconnected players are skipped, but still draw during the round if
+// they reconnect in time.
 func selectNextDrawer(lobby *Lobby) (*Player, bool) {
-	for index, otherPlayer := range lobby.players {
-		if otherPlayer == lobby.drawer {
-			//If we have someone that's drawing, take the next one
-			for i := index + 1; i < len(lobby.players); i++ {
-				player := lobby.players[i]
-				if player.Connected {
-					return player, false
-				}
-			}
+	if lobby.state == ongoing {
+		if next := lobby.turnOrder.next(lobby.players); next != nil {
+			return next, false
 		}
 	}
 
+	lobby.turnOrder.startRound()
+	if next := lobby.turnOrder.next(lobby.players); next != nil {
+		return next, true
+	}
+
+	//Nobody is connected, so someone has to draw for the turn to time out,
+	//until either a player reconnects or the lobby is cleaned up.
 	return lobby.players[0], true
 }
 
-func roundTimerTicker(lobby *Lobby) {
-	for {
-		ticker := lobby.timeLeftTicker
-		if ticker == nil {
-			return
-		}
-
-		select {
-		case <-ticker.C:
-			currentTime := getTimeAsMillis()
-			if currentTime >= lobby.RoundEndTime {
-				go advanceLobby(lobby)
-			}
-
-			if lobby.hintsLeft > 0 && lobby.wordHints != nil {
-				revealHintEveryXMilliseconds := int64(lobby.DrawingTime * 1000 / (lobby.hintCount + 1))
-				//If you have a drawingtime of 120 seconds and three hints, you
-				//want to reveal a hint every 40 seconds, so that the two hints
-				//are visible for at least a third of the time. //If the word
-				//was chosen at 60 seconds, we'll still reveal one hint
-				//instantly, as the time is already lower than 80.
-				revealHintAtXOrLower := revealHintEveryXMilliseconds * int64(lobby.hintsLeft)
-				timeLeft := lobby.RoundEndTime - currentTime
-				if timeLeft <= revealHintAtXOrLower {
-					lobby.hintsLeft--
-
-					for {
-						randomIndex := rand.Int() % len(lobby.wordHints)
-						if lobby.wordHints[randomIndex].Character == 0 {
-							lobby.wordHints[randomIndex].Character = []rune(lobby.CurrentWord)[randomIndex]
-							triggerWordHintUpdate(lobby)
-							break
-						}
-					}
-				}
-			}
-		}
-	}
+// TimeSync allows clients to correct their countdowns, as timers on the
+// client can drift or be delayed, for example in background tabs.
+type TimeSync struct {
+	// ServerTime is the current time of the server as a UTC
+	// unix-timestamp in milliseconds. It's only meant for estimating the
+	// clock offset, since client clocks can't be trusted to be accurate.
+	ServerTime int64 `json:"serverTime"`
+	// RoundEndTime is the amount of milliseconds left in the current
+	// round. 0 means there's no ongoing round.
+	RoundEndTime int `json:"roundEndTime"`
 }
 
-func getTimeAsMillis() int64 {
-	return time.Now().UTC().UnixNano() / 1000000
+func generateTimeSync(lobby *Lobby) *TimeSync {
+	currentTime := lobby.getTimeAsMillis()
+	timeSync := &TimeSync{ServerTime: currentTime}
+	if lobby.state == ongoing && lobby.RoundEndTime > currentTime {
+		timeSync.RoundEndTime = int(lobby.RoundEndTime - currentTime)
+	}
+	return timeSync
 }
 
 // NextTurn represents the data necessary for displaying the lobby state right
@@ -688,13 +965,14 @@ type NextTurn struct {
 // recalculateRanks will assign each player his respective rank in the lobby
 // according to everyones current score. This will not trigger any events.
 func recalculateRanks(lobby *Lobby) {
+	//The audience never connects, but is still part of the scoreboard.
 	for _, a := range lobby.players {
-		if !a.Connected {
+		if !a.Connected && !a.Audience {
 			continue
 		}
 		playersThatAreHigher := 0
 		for _, b := range lobby.players {
-			if !b.Connected {
+			if !b.Connected && !b.Audience {
 				continue
 			}
 			if b.Score > a.Score {
@@ -732,14 +1010,8 @@ func createWordHintFor(word string, showAll bool) []*WordHint {
 	return wordHints
 }
 
-var TriggerUpdatePerPlayerEvent func(eventType string, data func(*Player) interface{}, lobby *Lobby)
-var TriggerUpdateEvent func(eventType string, data interface{}, lobby *Lobby)
-var SendDataToEveryoneExceptSender func(sender *Player, lobby *Lobby, data interface{})
-var WriteAsJSON func(player *Player, object interface{}) error
-var WritePublicSystemMessage func(lobby *Lobby, text string)
-
 func triggerPlayersUpdate(lobby *Lobby) {
-	TriggerUpdateEvent("update-players", lobby.players, lobby)
+	lobby.transport.TriggerUpdateEvent(EventTypeUpdatePlayers, lobby.players, lobby)
 }
 
 func triggerWordHintUpdate(lobby *Lobby) {
@@ -747,7 +1019,7 @@ func triggerWordHintUpdate(lobby *Lobby) {
 		return
 	}
 
-	TriggerUpdatePerPlayerEvent("update-wordhint", func(player *Player) interface{} {
+	lobby.transport.TriggerUpdatePerPlayerEvent("update-wordhint", func(player *Player) interface{} {
 		return lobby.GetAvailableWordHints(player)
 	}, lobby)
 }
@@ -759,8 +1031,13 @@ type Rounds struct {
 
 // CreateLobby allows creating a lobby, optionally returning errors that
 // occurred during creation.
-func CreateLobby(playerName, chosenLanguage string, publicLobby bool, drawingTime, rounds, maxPlayers, customWordChance, clientsPerIPLimit int, customWords []string, enableVotekick bool) (*Player, *Lobby, error) {
+func (server *Server) CreateLobby(playerName, chosenLanguage string, publicLobby bool, drawingTime, rounds, maxPlayers, customWordChance, clientsPerIPLimit int, customWords []string, enableVotekick bool) (*Player, *Lobby, error) {
+	if IsShuttingDown() {
+		return nil, nil, ErrShuttingDown
+	}
+
 	lobby := createLobby(drawingTime, rounds, maxPlayers, customWords, customWordChance, clientsPerIPLimit, enableVotekick)
+	server.attach(lobby)
 	lobby.Wordpack = chosenLanguage
 	lobby.public = publicLobby
 
@@ -772,9 +1049,13 @@ func CreateLobby(playerName, chosenLanguage string, publicLobby bool, drawingTim
 		for customWordIndex, customWord := range customWords {
 			customWords[customWordIndex] = lobby.lowercaser.String(customWord)
 		}
+		lobby.getRandom().Shuffle(len(lobby.CustomWords), func(i, j int) {
+			lobby.CustomWords[i], lobby.CustomWords[j] = lobby.CustomWords[j], lobby.CustomWords[i]
+		})
 	}
 
 	player := createPlayer(playerName)
+	player.Color = lobby.nextFreePlayerColor()
 
 	lobby.players = append(lobby.players, player)
 	lobby.owner = player
@@ -786,7 +1067,9 @@ func CreateLobby(playerName, chosenLanguage string, publicLobby bool, drawingTim
 	}
 
 	lobby.words = words
+	lobby.shuffleWords()
 
+	go lobby.runLoop()
 	return player, lobby, nil
 }
 
@@ -814,23 +1097,32 @@ type Message struct {
 // This includes all the necessary things for properly running a client
 // without receiving any more data.
 type Ready struct {
+	// ProtocolVersion is the version of the protocol that has been
+	// negotiated for this connection.
+	ProtocolVersion int `json:"protocolVersion"`
+
 	PlayerID     string `json:"playerId"`
 	PlayerName   string `json:"playerName"`
 	AllowDrawing bool   `json:"allowDrawing"`
 
-	VotekickEnabled bool          `json:"votekickEnabled"`
-	GameState       gameState     `json:"gameState"`
-	OwnerID         string        `json:"ownerId"`
-	Round           int           `json:"round"`
-	MaxRound        int           `json:"maxRounds"`
-	RoundEndTime    int           `json:"roundEndTime"`
-	WordHints       []*WordHint   `json:"wordHints"`
-	Players         []*Player     `json:"players"`
-	CurrentDrawing  []interface{} `json:"currentDrawing"`
+	VotekickEnabled bool      `json:"votekickEnabled"`
+	GameState       gameState `json:"gameState"`
+	OwnerID         string    `json:"ownerId"`
+	Round           int       `json:"round"`
+	MaxRound        int       `json:"maxRounds"`
+	RoundEndTime    int       `json:"roundEndTime"`
+	// ScheduledStartTime is the amount of milliseconds left until the game
+	// starts automatically. 0 means the game isn't scheduled.
+	ScheduledStartTime int           `json:"scheduledStartTime"`
+	WordHints          []*WordHint   `json:"wordHints"`
+	Players            []*Player     `json:"players"`
+	CurrentDrawing     []interface{} `json:"currentDrawing"`
 }
 
 func generateReadyData(lobby *Lobby, player *Player) *Ready {
 	ready := &Ready{
+		ProtocolVersion: player.protocolVersion,
+
 		PlayerID:     player.ID,
 		AllowDrawing: player.State == Drawing,
 		PlayerName:   player.Name,
@@ -850,42 +1142,113 @@ func generateReadyData(lobby *Lobby, player *Player) *Ready {
 		//0 is interpreted as "no time left".
 		ready.RoundEndTime = 0
 	} else {
-		ready.RoundEndTime = int(lobby.RoundEndTime - getTimeAsMillis())
+		ready.RoundEndTime = int(lobby.RoundEndTime - lobby.getTimeAsMillis())
 	}
 
+	if lobby.ScheduledStartTime != 0 {
+		ready.ScheduledStartTime = int(lobby.ScheduledStartTime - lobby.getTimeAsMillis())
+		//Since 0 means "not scheduled", we have to make sure we don't
+		//accidentally send it when the start time has just been reached.
+		if ready.ScheduledStartTime <= 0 {
+			ready.ScheduledStartTime = 1
+		}
+	}
+
+	return ready
+}
+
+// NewSpectator creates a placeholder player for read-only observers of a
+// lobby. It's never part of the lobby and is treated as a guessing player,
+// so data generated for it never reveals the current word.
+func NewSpectator() *Player {
+	return &Player{
+		State:           Guessing,
+		protocolVersion: ProtocolVersion,
+	}
+}
+
+// GenerateSpectatorReadyData creates the initial state for a read-only
+// observer of the lobby. It may only be called from within the lobbies loop,
+// for example by the transport. Use SnapshotSpectatorReadyData anywhere else.
+func GenerateSpectatorReadyData(lobby *Lobby) *Ready {
+	return generateReadyData(lobby, NewSpectator())
+}
+
+// SnapshotSpectatorReadyData creates the initial state for a read-only
+// observer on the lobbies loop. The players and the drawing are copied, so
+// the result can be used anywhere. If the lobby has already been closed, nil
+// is returned.
+func SnapshotSpectatorReadyData(lobby *Lobby) *Ready {
+	var ready *Ready
+	lobby.synchronized(func() {
+		ready = GenerateSpectatorReadyData(lobby)
+		ready.Players = make([]*Player, 0, len(lobby.players))
+		for _, player := range lobby.players {
+			ready.Players = append(ready.Players, player.copyForReading())
+		}
+		ready.CurrentDrawing = append([]interface{}(nil), lobby.currentDrawing...)
+	})
+
 	return ready
 }
 
+// OnConnected sends the current state of the lobby to a player that has
+// just established a connection and tells everyone else about it.
 func OnConnected(lobby *Lobby, player *Player) {
+	lobby.synchronized(func() {
+		onConnected(lobby, player)
+	})
+}
+
+func onConnected(lobby *Lobby, player *Player) {
 	player.Connected = true
-	WriteAsJSON(player, GameEvent{Type: "ready", Data: generateReadyData(lobby, player)})
+	lobby.transport.WriteAsJSON(player, GameEvent{Type: EventTypeReady, Data: generateReadyData(lobby, player)})
 
 	//This state is reached when the player refreshes before having chosen a word.
 	if lobby.drawer == player && lobby.CurrentWord == "" {
-		WriteAsJSON(lobby.drawer, &GameEvent{Type: "your-turn", Data: lobby.wordChoice})
+		lobby.transport.WriteAsJSON(lobby.drawer, &GameEvent{Type: EventTypeYourTurn, Data: lobby.wordChoice})
 	}
 
-	updateRocketChat(lobby, player)
+	lobby.notifier.PlayerConnected(lobby, player)
 
 	//TODO Only send to everyone except for the new player, since it's part of the ready event.
 	triggerPlayersUpdate(lobby)
 }
 
+// OnResumed is called instead of OnConnected, if a client has resumed its
+// previous session after a reconnect. Since all missed events have been
+// resent already, the client doesn't need a ready event. The transport is
+// responsible for marking the player as connected, as it has to happen
+// right after resending the missed events.
+func OnResumed(lobby *Lobby, player *Player) {
+	lobby.synchronized(func() {
+		lobby.notifier.PlayerConnected(lobby, player)
+		triggerPlayersUpdate(lobby)
+	})
+}
+
+// OnDisconnected marks the player as disconnected, reserving their slot for
+// a while, and tells everyone else about it.
 func OnDisconnected(lobby *Lobby, player *Player) {
+	lobby.synchronized(func() {
+		onDisconnected(lobby, player)
+	})
+}
+
+func onDisconnected(lobby *Lobby, player *Player) {
 	//We want to avoid calling the handler twice.
-	if player.ws == nil {
+	if !player.Connected {
 		return
 	}
 
-	log.Printf("Player %s(%s) disconnected.\n", player.Name, player.ID)
+	lobby.PlayerLogger(player).Info("player disconnected", "name", player.Name)
 	player.Connected = false
-	player.ws = nil
-	disconnectTime := time.Now()
+	disconnectTime := lobby.now()
 	player.disconnectTime = &disconnectTime
 
 	lobby.LastPlayerDisconnectTime = &disconnectTime
 
-	updateRocketChat(lobby, player)
+	lobby.notifier.PlayerDisconnected(lobby, player)
 
 	if lobby.HasConnectedPlayers() {
 		triggerPlayersUpdate(lobby)
@@ -907,14 +1270,60 @@ func (lobby *Lobby) GetAvailableWordHints(player *Player) []*WordHint {
 }
 
 // JoinPlayer creates a new player object using the given name and adds it
-// to the lobbies playerlist. The new players is returned.
-func (lobby *Lobby) JoinPlayer(playerName string) *Player {
+// to the lobbies playerlist. The new players is returned. The address and
+// the session the client previously used are checked against the bans of
+// this lobby. If the client is banned, ErrPlayerBanned is returned. While the
+// server is shutting down, ErrShuttingDown is returned and if the lobby has
+// already been closed, ErrLobbyClosed.
+func (lobby *Lobby) JoinPlayer(playerName, address, previousUserSession string) (*Player, error) {
+	var player *Player
+	err := ErrLobbyClosed
+	lobby.synchronized(func() {
+		player, err = joinPlayer(lobby, playerName, address, previousUserSession)
+	})
+	return player, err
+}
+
+func joinPlayer(lobby *Lobby, playerName, address, previousUserSession string) (*Player, error) {
+	if IsShuttingDown() {
+		return nil, ErrShuttingDown
+	}
+	if lobby.IsBanned(previousUserSession, address) {
+		return nil, ErrPlayerBanned
+	}
+	if rejection := lobby.checkHooks(func(hook Hook) error { return hook.PlayerJoining(lobby, playerName, address) }); rejection != nil {
+		return nil, rejection
+	}
+
 	player := createPlayer(playerName)
+	player.lastKnownAddress = address
+	player.Color = lobby.nextFreePlayerColor()
 
-	//FIXME Make a dedicated method that uses a mutex?
 	lobby.players = append(lobby.players, player)
 
-	return player
+	return player, nil
+}
+
+// LinkAccount marks the player as being logged in with the given account.
+// The avatar is shown to everyone else. This is meant to be called right
+// after joining or creating the lobby, before the player connects.
+func (lobby *Lobby) LinkAccount(player *Player, accountID, avatar string) {
+	lobby.synchronized(func() {
+		player.accountID = accountID
+		player.Avatar = avatar
+	})
+}
+
+// CanReconnect decides whether a player that's part of the lobby may
+// reconnect. This is only forbidden if the player has been disconnected for
+// so long, that their slot has been taken by someone else.
+func (lobby *Lobby) CanReconnect(player *Player) bool {
+	if player.Connected || player.disconnectTime == nil ||
+		lobby.now().Sub(*player.disconnectTime) < slotReservationTime {
+		return true
+	}
+
+	return lobby.HasFreePlayerSlot()
 }
 
 func (lobby *Lobby) canDraw(player *Player) bool {
@@ -2,6 +2,7 @@ package game
 
 import (
 	"testing"
+	"time"
 )
 
 func createLobbyWithDemoPlayers(playercount int) *Lobby {
@@ -131,3 +132,121 @@ func Test_calculateGuesserScore(t *testing.T) {
 		lastDecline = newDecline
 	}
 }
+
+func Test_generateTimeSync(t *testing.T) {
+	lobby := &Lobby{state: ongoing, RoundEndTime: toMillis(time.Now()) + 30000}
+	timeSync := generateTimeSync(lobby)
+	if timeSync.RoundEndTime <= 29000 || timeSync.RoundEndTime > 30000 {
+		t.Errorf("unexpected time left %d", timeSync.RoundEndTime)
+	}
+	if timeSync.ServerTime == 0 {
+		t.Error("server time not set")
+	}
+
+	lobby.state = gameOver
+	if timeSync := generateTimeSync(lobby); timeSync.RoundEndTime != 0 {
+		t.Errorf("expected no time left after game over, got %d", timeSync.RoundEndTime)
+	}
+}
+
+func TestCanReconnect(t *testing.T) {
+	lobby := createLobbyWithDemoPlayers(2)
+	lobby.MaxPlayers = 2
+
+	longAgo := time.Now().Add(-2 * slotReservationTime)
+	gone := &Player{disconnectTime: &longAgo}
+	if lobby.CanReconnect(gone) {
+		t.Error("player whose slot has been taken could reconnect")
+	}
+
+	lobby.MaxPlayers = 3
+	if !lobby.CanReconnect(gone) {
+		t.Error("player couldn't reconnect, even though there's a free slot")
+	}
+
+	lobby.MaxPlayers = 2
+	recently := time.Now()
+	reserved := &Player{disconnectTime: &recently}
+	if !lobby.CanReconnect(reserved) {
+		t.Error("player with reserved slot couldn't reconnect")
+	}
+}
+
+func Test_selectNextDrawer(t *testing.T) {
+	lobby := &Lobby{}
+	a, b, c, d := createPlayer("a"), createPlayer("b"), createPlayer("c"), createPlayer("d")
+	for _, player := range []*Player{a, b, c, d} {
+		player.Connected = true
+	}
+	lobby.players = []*Player{a, b, c, d}
+
+	expectNext := func(expected *Player, expectedRoundOver bool) {
+		t.Helper()
+		drawer, roundOver := selectNextDrawer(lobby)
+		if drawer != expected || roundOver != expectedRoundOver {
+			t.Fatalf("expected %s (round over: %v), got %s (round over: %v)", expected.Name, expectedRoundOver, drawer.Name, roundOver)
+		}
+		lobby.state = ongoing
+		lobby.drawer = drawer
+		lobby.turnOrder.markDrawn(drawer)
+	}
+
+	expectNext(a, true)
+
+	//Disconnected players are skipped, but draw if they come back in time.
+	b.Connected = false
+	expectNext(c, false)
+
+	//Players leaving, including the drawer, don't cause anyone to be
+	//skipped, while players joining draw at the end of the round.
+	e := createPlayer("e")
+	e.Connected = true
+	lobby.players = []*Player{b, d, e}
+	b.Connected = true
+	expectNext(b, false)
+	lobby.players = []*Player{d, e}
+	expectNext(d, false)
+	expectNext(e, false)
+	expectNext(d, true)
+
+	//Without anyone connected, a new round starts with the first player.
+	d.Connected = false
+	e.Connected = false
+	expectNext(d, true)
+}
+
+func Test_commandClose(t *testing.T) {
+	server, transport, notifier := newTestServer()
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := lobby.JoinPlayer("guest", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lobby.synchronized(func() {
+		handleMessageEvent(lobby, guest, "!close bye")
+		if len(transport.closeCodes) != 0 || len(transport.sent) == 0 || transport.sentTo[len(transport.sentTo)-1] != guest {
+			t.Errorf("expected only the guest to be told that they can't close the lobby, got %v", transport.sent)
+		}
+	})
+
+	lobby.synchronized(func() {
+		handleMessageEvent(lobby, owner, "!close  time for bed ")
+	})
+	<-lobby.stopped
+	if len(transport.closeCodes) != 2 || transport.closeCodes[0] != CloseCodeLobbyClosed {
+		t.Errorf("expected all connections to be closed, got %v", transport.closeCodes)
+	}
+	if len(notifier.removedLobbies) != 1 || notifier.removedLobbies[0] != lobby.ID {
+		t.Errorf("expected lobby to be removed, got %v", notifier.removedLobbies)
+	}
+	if entry := notifier.auditEntries[len(notifier.auditEntries)-1]; entry.Action != "close" || entry.ActorID != owner.ID || entry.Details != "time for bed" {
+		t.Errorf("unexpected audit entry %+v", entry)
+	}
+	if _, joinError := lobby.JoinPlayer("late", "10.0.0.2", ""); joinError != ErrLobbyClosed {
+		t.Errorf("expected joining the closed lobby to fail, got %v", joinError)
+	}
+}
@@ -0,0 +1,66 @@
+package game
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrLobbyClosed is returned when trying to join a lobby that has already
+// been closed.
+var ErrLobbyClosed = errors.New("the lobby has been closed")
+
+// runLoop is the goroutine that all changes to the state of the lobby are
+// made on. Websocket readers, timers and HTTP handlers hand their work to
+// it via synchronized, so that the lobby never has to be locked. The loop
+// stops once the lobby has been closed. Afterwards the lobby is torn down,
+// before stopped is closed.
+func (lobby *Lobby) runLoop() {
+	defer close(lobby.stopped)
+	defer lobby.teardown()
+
+	done := lobby.Context().Done()
+	for {
+		select {
+		case action := <-lobby.actions:
+			lobby.execute(action)
+			if atomic.LoadInt32(&lobby.closed) == 1 {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// execute runs a single action, isolating panics to the lobby.
+func (lobby *Lobby) execute(action func()) {
+	defer recoverLobby(lobby)
+	action()
+}
+
+// synchronized runs the action on the lobbies loop and waits for it to
+// finish. If the lobby has already been closed, the action is dropped and
+// false is returned. Since the lobbies context is cancelled on closing,
+// actions handed over by timers and other background work can't keep the
+// lobby from being torn down. This must never be called from within the loop, for
+// example by an event handler, as it would wait for itself.
+func (lobby *Lobby) synchronized(action func()) bool {
+	//Lobbies that have been created without a server, for example in
+	//tests, don't have a loop.
+	if lobby.actions == nil {
+		lobby.execute(action)
+		return true
+	}
+
+	done := make(chan struct{})
+	select {
+	case lobby.actions <- func() {
+		defer close(done)
+		action()
+	}:
+		<-done
+		return true
+	case <-lobby.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,155 @@
+package game
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_lobbyLoop(t *testing.T) {
+	server, _, notifier := newTestServer()
+	_, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 50, 0, 50, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Joining concurrently mustn't lose any players.
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			if _, joinError := lobby.JoinPlayer("guest", "10.0.0.1", ""); joinError != nil {
+				t.Errorf("joining shouldn't fail: %s", joinError)
+			}
+		}()
+	}
+	waitGroup.Wait()
+	if len(lobby.GetPlayers()) != 21 {
+		t.Errorf("expected 21 players, got %d", len(lobby.GetPlayers()))
+	}
+
+	CloseLobby(lobby, "closed")
+	<-lobby.stopped
+	if len(notifier.removedLobbies) != 1 {
+		t.Errorf("expected lobby to be removed once, got %v", notifier.removedLobbies)
+	}
+
+	//Once the loop has stopped, all actions are dropped.
+	if _, joinError := lobby.JoinPlayer("late", "10.0.0.2", ""); joinError != ErrLobbyClosed {
+		t.Errorf("expected ErrLobbyClosed, got %v", joinError)
+	}
+	if lobby.KickPlayer(lobby.GetPlayers()[0].ID, false, "kicked") {
+		t.Error("kicking from a closed lobby shouldn't succeed")
+	}
+	CloseLobby(lobby, "closed again")
+	if len(notifier.removedLobbies) != 1 {
+		t.Errorf("expected lobby to be removed once, got %v", notifier.removedLobbies)
+	}
+}
+
+func Test_readPlayersOutsideOfLoop(t *testing.T) {
+	server, _, _ := newTestServer()
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 50, 0, 50, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseLobby(lobby, "closed")
+
+	//Reading the players while others join mustn't race, which is only
+	//detected when running the tests with -race.
+	var waitGroup sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		waitGroup.Add(2)
+		go func() {
+			defer waitGroup.Done()
+			if _, joinError := lobby.JoinPlayer("guest", "10.0.0.1", ""); joinError != nil {
+				t.Errorf("joining shouldn't fail: %s", joinError)
+			}
+		}()
+		go func() {
+			defer waitGroup.Done()
+			if lobby.GetPlayer(owner.GetUserSession()) != owner {
+				t.Error("the owner should be found")
+			}
+			for _, player := range lobby.SnapshotPlayers() {
+				if player.Name == "" || player.GetUserSession() != "" {
+					t.Errorf("unexpected player copy %+v", player)
+				}
+			}
+			if ready := SnapshotSpectatorReadyData(lobby); ready == nil || len(ready.Players) == 0 {
+				t.Error("the spectator state should contain the players")
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	if players := lobby.SnapshotPlayers(); len(players) != 21 || players[0] == owner || players[0].ID != owner.ID {
+		t.Errorf("expected copies of 21 players, got %d", len(players))
+	}
+}
+
+func Test_snapshotCurrentDrawing(t *testing.T) {
+	server, _, _ := newTestServer()
+	_, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if drawing := lobby.SnapshotCurrentDrawing(); drawing == nil || len(drawing) != 0 {
+		t.Errorf("expected an empty drawing, got %v", drawing)
+	}
+
+	lobby.synchronized(func() { lobby.AppendLine(&LineEvent{Type: EventTypeLine}) })
+	drawing := lobby.SnapshotCurrentDrawing()
+	lobby.synchronized(func() { lobby.AppendLine(&LineEvent{Type: EventTypeLine}) })
+	if len(drawing) != 1 {
+		t.Errorf("the snapshot should be a copy, got %d instructions", len(drawing))
+	}
+
+	CloseLobby(lobby, "closed")
+	if drawing := lobby.SnapshotCurrentDrawing(); drawing != nil {
+		t.Errorf("expected no drawing for closed lobbies, got %v", drawing)
+	}
+}
+
+func Test_lobbyClose(t *testing.T) {
+	server, _, notifier := newTestServer()
+	_, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby.ScheduleStart(time.Now().Add(time.Hour))
+
+	//Background work has to have stopped before the lobby is removed.
+	var stoppedWork int32
+	started := lobby.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		atomic.StoreInt32(&stoppedWork, 1)
+	})
+	if !started {
+		t.Fatal("background work should be started for open lobbies")
+	}
+
+	lobby.Close()
+	if atomic.LoadInt32(&stoppedWork) != 1 {
+		t.Error("background work survived the lobby")
+	}
+	if len(notifier.removedLobbies) != 1 {
+		t.Errorf("expected lobby to be removed once, got %v", notifier.removedLobbies)
+	}
+	if lobby.Context().Err() == nil {
+		t.Error("the context of a closed lobby should be cancelled")
+	}
+	if lobby.Go(func(ctx context.Context) {}) {
+		t.Error("background work shouldn't be started for closed lobbies")
+	}
+
+	lobby.Close()
+	if len(notifier.removedLobbies) != 1 {
+		t.Errorf("expected lobby to be removed once, got %v", notifier.removedLobbies)
+	}
+}
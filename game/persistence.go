@@ -0,0 +1,371 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// LobbySnapshot contains everything needed to restore a lobby after the
+// server has been restarted. Connections aren't part of it, so players have
+// to reconnect using their session.
+type LobbySnapshot struct {
+	ID                string   `json:"id"`
+	Wordpack          string   `json:"wordpack"`
+	Public            bool     `json:"public"`
+	DrawingTime       int      `json:"drawingTime"`
+	MaxRounds         int      `json:"maxRounds"`
+	MaxPlayers        int      `json:"maxPlayers"`
+	CustomWords       []string `json:"customWords"`
+	CustomWordsChance int      `json:"customWordsChance"`
+	ClientsPerIPLimit int      `json:"clientsPerIPLimit"`
+	EnableVotekick    bool     `json:"enableVotekick"`
+	Tags              []string `json:"tags"`
+	Region            string   `json:"region"`
+
+	Players   []*PlayerSnapshot `json:"players"`
+	OwnerID   string            `json:"ownerId"`
+	CreatorID string            `json:"creatorId"`
+	DrawerID  string            `json:"drawerId"`
+	// DrawnThisRound contains the IDs of the players that have drawn during
+	// the current round, including the drawer.
+	DrawnThisRound []string `json:"drawnThisRound"`
+
+	State          string      `json:"state"`
+	Round          int         `json:"round"`
+	CurrentWord    string      `json:"currentWord"`
+	WordChoice     []string    `json:"wordChoice"`
+	WordHints      []*WordHint `json:"wordHints"`
+	WordHintsShown []*WordHint `json:"wordHintsShown"`
+	HintsLeft      int         `json:"hintsLeft"`
+	HintCount      int         `json:"hintCount"`
+	// RoundTimeLeft is the amount of milliseconds left in the current turn.
+	// The time the server has been down for isn't subtracted, since the
+	// players couldn't draw or guess either.
+	RoundTimeLeft         int64 `json:"roundTimeLeft"`
+	ScheduledStartTime    int64 `json:"scheduledStartTime"`
+	ScoreEarnedByGuessers int   `json:"scoreEarnedByGuessers"`
+	// CurrentDrawing contains the line and fill events of the current turn.
+	CurrentDrawing []json.RawMessage `json:"currentDrawing"`
+	// GameStarted and Turns are needed for the results of the current game.
+	GameStarted time.Time     `json:"gameStarted"`
+	Turns       []*TurnResult `json:"turns"`
+
+	InviteTokens []*InviteToken `json:"inviteTokens"`
+	Bans         []*BanSnapshot `json:"bans"`
+}
+
+// PlayerSnapshot is the persisted state of a player.
+type PlayerSnapshot struct {
+	ID               string      `json:"id"`
+	UserSession      string      `json:"userSession"`
+	Name             string      `json:"name"`
+	Color            string      `json:"color"`
+	Score            int         `json:"score"`
+	LastScore        int         `json:"lastScore"`
+	Rank             int         `json:"rank"`
+	State            PlayerState `json:"state"`
+	LastKnownAddress string      `json:"lastKnownAddress"`
+	AccountID        string      `json:"accountId,omitempty"`
+	Avatar           string      `json:"avatar,omitempty"`
+	Audience         bool        `json:"audience,omitempty"`
+	AudienceSource   string      `json:"audienceSource,omitempty"`
+}
+
+// BanSnapshot is the persisted state of a ban.
+type BanSnapshot struct {
+	PlayerName  string `json:"playerName"`
+	UserSession string `json:"userSession"`
+	Address     string `json:"address"`
+}
+
+// SuspendLobby stops all timers of the lobby and asks all players to
+// reconnect, so that the lobby can be snapshotted and restored after a
+// restart. The lobby is removed afterwards, once its background work has
+// stopped. If the lobby has already been closed, false is returned and
+// nothing happens.
+func SuspendLobby(lobby *Lobby) bool {
+	var suspended bool
+	lobby.synchronized(func() {
+		suspended = suspendLobby(lobby)
+	})
+	if suspended {
+		lobby.waitForTeardown()
+	}
+	return suspended
+}
+
+func suspendLobby(lobby *Lobby) bool {
+	if !atomic.CompareAndSwapInt32(&lobby.closed, 0, 1) {
+		return false
+	}
+
+	lobby.cancelAllTasks()
+
+	for _, player := range lobby.players {
+		lobby.transport.CloseConnection(player, CloseCodeRestarting, "The server is restarting, reconnecting in a moment.")
+	}
+
+	lobby.finishClosing()
+	return true
+}
+
+// Snapshot captures the current state of the lobby. It should only be taken
+// after the lobby has been suspended, since the state might change otherwise.
+func (lobby *Lobby) Snapshot() *LobbySnapshot {
+	var snapshot *LobbySnapshot
+	//Once the lobby has been suspended, its loop has stopped and nothing
+	//changes the state anymore.
+	if !lobby.synchronized(func() { snapshot = lobby.snapshot() }) {
+		snapshot = lobby.snapshot()
+	}
+	return snapshot
+}
+
+func (lobby *Lobby) snapshot() *LobbySnapshot {
+	snapshot := &LobbySnapshot{
+		ID:                    lobby.ID,
+		Wordpack:              lobby.Wordpack,
+		Public:                lobby.public,
+		DrawingTime:           lobby.DrawingTime,
+		MaxRounds:             lobby.MaxRounds,
+		MaxPlayers:            lobby.MaxPlayers,
+		CustomWords:           lobby.CustomWords,
+		CustomWordsChance:     lobby.CustomWordsChance,
+		ClientsPerIPLimit:     lobby.ClientsPerIPLimit,
+		EnableVotekick:        lobby.EnableVotekick,
+		Tags:                  lobby.Tags,
+		Region:                lobby.Region,
+		State:                 string(lobby.state),
+		Round:                 lobby.Round,
+		CurrentWord:           lobby.CurrentWord,
+		WordChoice:            lobby.wordChoice,
+		WordHints:             lobby.wordHints,
+		WordHintsShown:        lobby.wordHintsShown,
+		HintsLeft:             lobby.hintsLeft,
+		HintCount:             lobby.hintCount,
+		ScheduledStartTime:    lobby.ScheduledStartTime,
+		ScoreEarnedByGuessers: lobby.scoreEarnedByGuessers,
+		GameStarted:           lobby.gameStarted,
+		Turns:                 lobby.turns,
+	}
+
+	for _, player := range lobby.players {
+		snapshot.Players = append(snapshot.Players, &PlayerSnapshot{
+			ID:               player.ID,
+			UserSession:      player.GetUserSession(),
+			Name:             player.Name,
+			Color:            player.Color,
+			Score:            player.Score,
+			LastScore:        player.LastScore,
+			Rank:             player.Rank,
+			State:            player.State,
+			LastKnownAddress: player.lastKnownAddress,
+			AccountID:        player.accountID,
+			Avatar:           player.Avatar,
+			Audience:         player.Audience,
+			AudienceSource:   player.audienceSource,
+		})
+	}
+	if lobby.owner != nil {
+		snapshot.OwnerID = lobby.owner.ID
+	}
+	if lobby.creator != nil {
+		snapshot.CreatorID = lobby.creator.ID
+	}
+	if lobby.drawer != nil {
+		snapshot.DrawerID = lobby.drawer.ID
+	}
+	snapshot.DrawnThisRound = lobby.turnOrder.drawnPlayerIDs(lobby.players)
+
+	if lobby.state == ongoing {
+		if timeLeft := lobby.RoundEndTime - lobby.getTimeAsMillis(); timeLeft > 0 {
+			snapshot.RoundTimeLeft = timeLeft
+		}
+	}
+
+	for _, step := range lobby.currentDrawing {
+		//Lines and fills are always valid JSON.
+		encoded, _ := json.Marshal(step)
+		snapshot.CurrentDrawing = append(snapshot.CurrentDrawing, encoded)
+	}
+
+	lobby.inviteTokenMutex.Lock()
+	lobby.removeInvalidInviteTokens()
+	for _, token := range lobby.inviteTokens {
+		snapshot.InviteTokens = append(snapshot.InviteTokens, token)
+	}
+	lobby.inviteTokenMutex.Unlock()
+
+	for _, ban := range lobby.GetBans() {
+		snapshot.Bans = append(snapshot.Bans, &BanSnapshot{
+			PlayerName:  ban.PlayerName,
+			UserSession: ban.userSession,
+			Address:     ban.address,
+		})
+	}
+
+	return snapshot
+}
+
+// RestoreLobby recreates a lobby from the given snapshot. All players are
+// treated as having just disconnected, so their slots are reserved until
+// they reconnect. Timers of ongoing turns and scheduled starts are resumed.
+// Since the remaining words aren't part of the snapshot, the word list is
+// read again, so words might repeat.
+func (server *Server) RestoreLobby(snapshot *LobbySnapshot) (*Lobby, error) {
+	lobby := createLobby(snapshot.DrawingTime, snapshot.MaxRounds, snapshot.MaxPlayers,
+		nil, snapshot.CustomWordsChance, snapshot.ClientsPerIPLimit, snapshot.EnableVotekick)
+	server.attach(lobby)
+	lobby.ID = snapshot.ID
+	//The custom words have already been shuffled and partially used.
+	lobby.CustomWords = snapshot.CustomWords
+	lobby.Wordpack = snapshot.Wordpack
+	lobby.public = snapshot.Public
+	lobby.Tags = snapshot.Tags
+	lobby.Region = snapshot.Region
+	lobby.lowercaser = cases.Lower(language.Make(getLanguageIdentifier(snapshot.Wordpack)))
+
+	words, err := readWordList(lobby.lowercaser, snapshot.Wordpack)
+	if err != nil {
+		return nil, err
+	}
+	lobby.words = words
+	lobby.shuffleWords()
+
+	switch gameState(snapshot.State) {
+	case unstarted, ongoing, gameOver:
+		lobby.state = gameState(snapshot.State)
+	default:
+		return nil, fmt.Errorf("unknown game state '%s'", snapshot.State)
+	}
+
+	now := lobby.now()
+	lobby.LastPlayerDisconnectTime = &now
+	playersByID := make(map[string]*Player, len(snapshot.Players))
+	for _, playerSnapshot := range snapshot.Players {
+		disconnectTime := now
+		player := &Player{
+			ID:               playerSnapshot.ID,
+			userSession:      playerSnapshot.UserSession,
+			Name:             playerSnapshot.Name,
+			Color:            playerSnapshot.Color,
+			Score:            playerSnapshot.Score,
+			LastScore:        playerSnapshot.LastScore,
+			Rank:             playerSnapshot.Rank,
+			State:            playerSnapshot.State,
+			lastKnownAddress: playerSnapshot.LastKnownAddress,
+			accountID:        playerSnapshot.AccountID,
+			Avatar:           playerSnapshot.Avatar,
+			Audience:         playerSnapshot.Audience,
+			audienceSource:   playerSnapshot.AudienceSource,
+			disconnectTime:   &disconnectTime,
+			votedForKick:     make(map[string]bool),
+			protocolVersion:  MinProtocolVersion,
+		}
+		lobby.players = append(lobby.players, player)
+		playersByID[player.ID] = player
+	}
+	if len(lobby.players) == 0 {
+		return nil, fmt.Errorf("lobby %s doesn't have any players", snapshot.ID)
+	}
+
+	lobby.owner = playersByID[snapshot.OwnerID]
+	if lobby.owner == nil {
+		lobby.owner = lobby.players[0]
+	}
+	lobby.creator = playersByID[snapshot.CreatorID]
+	if lobby.creator == nil {
+		lobby.creator = lobby.owner
+	}
+	lobby.drawer = playersByID[snapshot.DrawerID]
+	if lobby.state == ongoing && lobby.drawer == nil {
+		return nil, fmt.Errorf("the drawer of lobby %s is missing", snapshot.ID)
+	}
+	restoreTurnOrder(lobby, snapshot.DrawnThisRound)
+
+	lobby.Round = snapshot.Round
+	lobby.CurrentWord = snapshot.CurrentWord
+	lobby.wordChoice = snapshot.WordChoice
+	lobby.wordHints = snapshot.WordHints
+	lobby.wordHintsShown = snapshot.WordHintsShown
+	lobby.hintsLeft = snapshot.HintsLeft
+	lobby.hintCount = snapshot.HintCount
+	lobby.scoreEarnedByGuessers = snapshot.ScoreEarnedByGuessers
+	lobby.gameStarted = snapshot.GameStarted
+	lobby.turns = snapshot.Turns
+
+	for _, encoded := range snapshot.CurrentDrawing {
+		step := &InboundEvent{}
+		if err := json.Unmarshal(encoded, step); err != nil {
+			return nil, err
+		}
+
+		switch step.Type {
+		case EventTypeLine:
+			var line *Line
+			if err := json.Unmarshal(step.Data, &line); err != nil || line == nil {
+				return nil, fmt.Errorf("invalid line in lobby %s", snapshot.ID)
+			}
+			lobby.AppendLine(&LineEvent{Type: EventTypeLine, Data: line})
+		case EventTypeFill:
+			var fill *Fill
+			if err := json.Unmarshal(step.Data, &fill); err != nil || fill == nil {
+				return nil, fmt.Errorf("invalid fill in lobby %s", snapshot.ID)
+			}
+			lobby.AppendFill(&FillEvent{Type: EventTypeFill, Data: fill})
+		default:
+			return nil, fmt.Errorf("unknown drawing step '%s' in lobby %s", step.Type, snapshot.ID)
+		}
+	}
+
+	for _, token := range snapshot.InviteTokens {
+		lobby.inviteTokens[token.Token] = token
+	}
+	for _, ban := range snapshot.Bans {
+		lobby.bans = append(lobby.bans, &Ban{
+			PlayerName:  ban.PlayerName,
+			userSession: ban.UserSession,
+			address:     ban.Address,
+		})
+	}
+
+	if lobby.state == ongoing {
+		lobby.RoundEndTime = lobby.getTimeAsMillis() + snapshot.RoundTimeLeft
+		scheduleTurn(lobby)
+	} else if lobby.state == unstarted && snapshot.ScheduledStartTime != 0 {
+		scheduleStart(lobby, time.Unix(0, snapshot.ScheduledStartTime*int64(time.Millisecond)))
+	}
+
+	go lobby.runLoop()
+	return lobby, nil
+}
+
+// restoreTurnOrder marks the given players as having drawn during the
+// current round. Snapshots taken before the turn order was persisted don't
+// contain any, in which case everyone up to the drawer has drawn.
+func restoreTurnOrder(lobby *Lobby, drawnPlayerIDs []string) {
+	lobby.turnOrder.startRound()
+	if lobby.state != ongoing {
+		return
+	}
+
+	if len(drawnPlayerIDs) == 0 {
+		for _, player := range lobby.players {
+			lobby.turnOrder.markDrawn(player)
+			if player == lobby.drawer {
+				break
+			}
+		}
+		return
+	}
+
+	for _, id := range drawnPlayerIDs {
+		lobby.turnOrder.drawn[id] = true
+	}
+	lobby.turnOrder.markDrawn(lobby.drawer)
+}
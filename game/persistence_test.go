@@ -0,0 +1,89 @@
+package game
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_Snapshot_RestoreLobby(t *testing.T) {
+	server, _, _ := newTestServer()
+	owner, lobby, err := server.CreateLobby("owner", "english", true, 120, 4, 12, 50, 1, []string{"tree", "house"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guesser, err := lobby.JoinPlayer("guesser", "1.2.3.4", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby.BanPlayer(&Player{Name: "troll", userSession: "session", lastKnownAddress: "5.6.7.8"})
+	invite := lobby.CreateInviteToken(time.Hour, 5)
+
+	owner.Score = 150
+	lobby.state = ongoing
+	lobby.Round = 2
+	lobby.drawer = owner
+	lobby.turnOrder.markDrawn(owner)
+	owner.State = Drawing
+	lobby.CurrentWord = "tree"
+	lobby.RoundEndTime = lobby.getTimeAsMillis() + 60000
+	lobby.AppendLine(&LineEvent{Type: "line", Data: &Line{FromX: 1, FromY: 2, ToX: 3, ToY: 4, Color: "#000000", LineWidth: 8}})
+	lobby.AppendFill(&FillEvent{Type: "fill", Data: &Fill{X: 5, Y: 6, Color: "#ffffff"}})
+
+	encoded, err := json.Marshal(lobby.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := &LobbySnapshot{}
+	if err := json.Unmarshal(encoded, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := server.RestoreLobby(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseLobby(restored, "")
+
+	if restored.ID != lobby.ID || !restored.IsPublic() || restored.Round != 2 || restored.CurrentWord != "tree" {
+		t.Errorf("lobby settings haven't been restored: %+v", restored)
+	}
+	if len(restored.players) != 2 || restored.GetPlayer(owner.GetUserSession()) == nil || restored.GetPlayer(guesser.GetUserSession()) == nil {
+		t.Fatalf("players can't reconnect using their sessions")
+	}
+	if restored.drawer.ID != owner.ID || restored.owner.ID != owner.ID || restored.drawer.Score != 150 {
+		t.Errorf("drawer, owner or score haven't been restored")
+	}
+	if !restored.turnOrder.hasDrawn(restored.drawer) || restored.turnOrder.hasDrawn(restored.GetPlayer(guesser.GetUserSession())) {
+		t.Errorf("the turn order hasn't been restored")
+	}
+	if restored.drawer.Connected || !restored.CanReconnect(restored.drawer) {
+		t.Errorf("players have to be disconnected, but able to reconnect")
+	}
+	if timeLeft := restored.RoundEndTime - restored.getTimeAsMillis(); timeLeft <= 50000 || timeLeft > 60000 {
+		t.Errorf("expected about a minute left in the turn, got %dms", timeLeft)
+	}
+	if drawing := restored.GetCurrentDrawing(); len(drawing) != 2 {
+		t.Errorf("expected line and fill to be restored, got %v", drawing)
+	} else if line, isLine := drawing[0].(*LineEvent); !isLine || line.Data.ToY != 4 {
+		t.Errorf("expected first step to be the line, got %v", drawing[0])
+	}
+	if !restored.IsBanned("", "5.6.7.8") || !restored.HasValidInviteToken(invite.Token) {
+		t.Errorf("bans and invites haven't been restored")
+	}
+}
+
+func Test_RestoreLobby_invalid(t *testing.T) {
+	server, _, _ := newTestServer()
+	if _, err := server.RestoreLobby(&LobbySnapshot{ID: "empty", Wordpack: "english", State: "unstarted"}); err == nil {
+		t.Errorf("lobby without players has been restored")
+	}
+	if _, err := server.RestoreLobby(&LobbySnapshot{
+		ID:       "drawerless",
+		Wordpack: "english",
+		State:    "ongoing",
+		Players:  []*PlayerSnapshot{{ID: "a", UserSession: "session"}},
+	}); err == nil {
+		t.Errorf("ongoing lobby without drawer has been restored")
+	}
+}
@@ -0,0 +1,137 @@
+package game
+
+import (
+	"encoding/json"
+)
+
+// These are the types of the events that clients may send. Events of any
+// other type are rejected with ErrorCodeUnknownEvent. The payload of each
+// event is described next to its type.
+const (
+	// EventTypeMessage is a chat message or a guess. The data is a string.
+	// Messages starting with an exclamation mark are commands.
+	EventTypeMessage = "message"
+	// EventTypeLine draws a line. The data is a Line. Only the drawer may
+	// send it.
+	EventTypeLine = "line"
+	// EventTypeFill fills an area of the drawing board. The data is a Fill.
+	// Only the drawer may send it.
+	EventTypeFill = "fill"
+	// EventTypeClearDrawingBoard clears the drawing board. It doesn't carry
+	// any data. Only the drawer may send it.
+	EventTypeClearDrawingBoard = "clear-drawing-board"
+	// EventTypeChooseWord chooses one of the words offered by
+	// EventTypeYourTurn. The data is the index of the word.
+	EventTypeChooseWord = "choose-word"
+	// EventTypeKickVote votes for kicking a player. The data is the ID of
+	// the player. Afterwards, a KickVote is sent to everyone.
+	EventTypeKickVote = "kick-vote"
+	// EventTypeStart starts the game. It doesn't carry any data. Only the
+	// owner may send it.
+	EventTypeStart = "start"
+	// EventTypeNameChange changes the name of the sender. The data is the
+	// new name.
+	EventTypeNameChange = "name-change"
+	// EventTypeRequestDrawing asks for the current drawing, which is sent as
+	// EventTypeDrawing. It doesn't carry any data.
+	EventTypeRequestDrawing = "request-drawing"
+	// EventTypeTimeSync is sent regularly during a turn, its data is a
+	// TimeSync. Clients may request it by sending it without data.
+	EventTypeTimeSync = "time-sync"
+	// EventTypeReaction is a reaction to the current drawing. Clients send
+	// the name of the reaction as data, everyone receives a Reaction.
+	EventTypeReaction = "reaction"
+	// EventTypeRTCOffer, EventTypeRTCAnswer and EventTypeRTCICECandidate
+	// are the signaling channel for voice chat. The data is a
+	// SignalingMessage, which is relayed to its target.
+	EventTypeRTCOffer        = "rtc-offer"
+	EventTypeRTCAnswer       = "rtc-answer"
+	EventTypeRTCICECandidate = "rtc-ice-candidate"
+	// EventTypeKeepAlive prevents the connection from being closed due to
+	// inactivity. It doesn't carry any data and is ignored.
+	EventTypeKeepAlive = "keep-alive"
+)
+
+// These are the types of the events that are only sent by the server. Some
+// of the client event types above are sent by the server as well.
+const (
+	// EventTypeReady is sent after connecting. The data is a Ready.
+	EventTypeReady = "ready"
+	// EventTypeNextTurn is sent to everyone at the start of each turn. The
+	// data is a NextTurn.
+	EventTypeNextTurn = "next-turn"
+	// EventTypeYourTurn is sent to the drawer at the start of each turn.
+	// The data is the list of words to choose from.
+	EventTypeYourTurn = "your-turn"
+	// EventTypeWordChosen is sent to the drawer if they didn't choose a
+	// word in time. The data is the word that has been chosen for them.
+	EventTypeWordChosen = "word-chosen"
+	// EventTypeUpdateWordHint is sent whenever a hint is revealed. The data
+	// is a list of WordHint.
+	EventTypeUpdateWordHint = "update-wordhint"
+	// EventTypeCorrectGuess is sent to everyone once a player has guessed
+	// the word. The data is the ID of the player.
+	EventTypeCorrectGuess = "correct-guess"
+	// EventTypeCloseGuess is sent to a player whose guess was almost
+	// correct. The data is the guess.
+	EventTypeCloseGuess = "close-guess"
+	// EventTypeNonGuessingPlayerMessage is a Message that is only visible to
+	// players that aren't guessing anymore.
+	EventTypeNonGuessingPlayerMessage = "non-guessing-player-message"
+	// EventTypeSystemMessage is a message by the server. The data is the
+	// HTML escaped text.
+	EventTypeSystemMessage = "system-message"
+	// EventTypeDrawerKicked is sent once the drawer has been kicked. It
+	// doesn't carry any data.
+	EventTypeDrawerKicked = "drawer-kicked"
+	// EventTypeOwnerChange is sent once the owner has left. The data is an
+	// OwnerChangeEvent.
+	EventTypeOwnerChange = "owner-change"
+	// EventTypeUpdatePlayers is sent whenever the players or their scores
+	// change. The data is the list of players.
+	EventTypeUpdatePlayers = "update-players"
+	// EventTypeLobbyClosed is sent before the lobby is closed. The data is
+	// the HTML escaped reason.
+	EventTypeLobbyClosed = "lobby-closed"
+	// EventTypeStartCountdown announces a scheduled start. The data is a
+	// StartCountdown.
+	EventTypeStartCountdown = "start-countdown"
+	// EventTypeDrawing contains the current drawing, a list of LineEvent
+	// and FillEvent.
+	EventTypeDrawing = "drawing"
+	// EventTypeError is sent whenever an event has been rejected. The data
+	// is an EventError.
+	EventTypeError = "error"
+	// EventTypeGameResults is sent to the players once a game is over. The
+	// data contains links for downloading the results, which are provided
+	// by the transport.
+	EventTypeGameResults = "game-results"
+)
+
+// InboundEvent is an event sent by a client. The data is kept in its raw
+// form, so that it can be decoded into the payload type belonging to the
+// event, once the type is known.
+type InboundEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	// decodeData decodes the data of events that haven't been sent as JSON,
+	// see NewInboundEvent.
+	decodeData func(payload interface{}) error
+}
+
+// NewInboundEvent creates an event whose data has been sent in a format
+// other than JSON. Once the type of the payload is known, decodeData is
+// called for decoding the data straight into it. It has to follow the same
+// rules as json.Unmarshal.
+func NewInboundEvent(eventType string, decodeData func(payload interface{}) error) *InboundEvent {
+	return &InboundEvent{Type: eventType, decodeData: decodeData}
+}
+
+// DecodeData decodes the data of the event into the given payload, which
+// has to be a pointer.
+func (event *InboundEvent) DecodeData(payload interface{}) error {
+	if event.decodeData != nil {
+		return event.decodeData(payload)
+	}
+	return json.Unmarshal(event.Data, payload)
+}
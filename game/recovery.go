@@ -0,0 +1,53 @@
+package game
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// CrashReporter is notified about panics that occurred while working on a
+// lobby, for example in order to forward them to an error tracker.
+type CrashReporter interface {
+	ReportCrash(lobbyID string, recovered interface{}, stack []byte)
+}
+
+var crashReporter CrashReporter
+
+// ConfigureCrashReporter sets the reporter that all recovered panics are
+// passed to.
+func ConfigureCrashReporter(reporter CrashReporter) {
+	crashReporter = reporter
+}
+
+// recoverLobby is deferred for every action run by the lobbies loop. A panic
+// only closes the affected lobby, while all other lobbies keep running.
+func recoverLobby(lobby *Lobby) {
+	if recovered := recover(); recovered != nil {
+		handleLobbyCrash(lobby, recovered, debug.Stack())
+	}
+}
+
+func handleLobbyCrash(lobby *Lobby, recovered interface{}, stack []byte) {
+	lobby.Logger().Error("recovered from panic, closing lobby", "panic", fmt.Sprint(recovered), "stack", string(stack))
+	if crashReporter != nil {
+		crashReporter.ReportCrash(lobby.ID, recovered, stack)
+	}
+
+	//Lobbies without a loop could crash on multiple goroutines at once,
+	//but the lobby only needs to be closed once.
+	if !atomic.CompareAndSwapInt32(&lobby.crashed, 0, 1) {
+		return
+	}
+
+	//The state of the lobby could be broken in a way that prevents closing
+	//it properly. In that case, it's at least made unreachable.
+	defer func() {
+		if closeError := recover(); closeError != nil {
+			lobby.Logger().Error("error closing crashed lobby", "panic", fmt.Sprint(closeError))
+			atomic.StoreInt32(&lobby.closed, 1)
+			lobby.finishClosing()
+		}
+	}()
+	closeLobby(lobby, CloseCodeLobbyClosed, "The lobby has been closed due to an internal error.")
+}
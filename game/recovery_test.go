@@ -0,0 +1,40 @@
+package game
+
+import (
+	"testing"
+)
+
+type recordingCrashReporter struct {
+	crashes []interface{}
+}
+
+func (reporter *recordingCrashReporter) ReportCrash(lobbyID string, recovered interface{}, stack []byte) {
+	reporter.crashes = append(reporter.crashes, recovered)
+}
+
+func Test_recoverLobby(t *testing.T) {
+	player := &Player{ID: "a", Connected: true}
+	transport := &recordingTransport{}
+	notifier := &recordingNotifier{}
+	lobby := &Lobby{ID: "crashing", players: []*Player{player}, transport: transport, notifier: notifier}
+	reporter := &recordingCrashReporter{}
+	defer func(original CrashReporter) { crashReporter = original }(crashReporter)
+	crashReporter = reporter
+
+	crash := func() {
+		defer recoverLobby(lobby)
+		panic("broken")
+	}
+	crash()
+	crash()
+
+	if len(reporter.crashes) != 2 {
+		t.Errorf("expected both crashes to be reported, got %v", reporter.crashes)
+	}
+	if len(transport.events) != 1 || transport.events[0] != "lobby-closed" || len(transport.closeCodes) != 1 {
+		t.Errorf("expected lobby to be closed once, got events %v and close codes %v", transport.events, transport.closeCodes)
+	}
+	if len(notifier.removedLobbies) != 1 || notifier.removedLobbies[0] != "crashing" {
+		t.Errorf("expected lobby to be removed once, got %v", notifier.removedLobbies)
+	}
+}
@@ -0,0 +1,95 @@
+package game
+
+import (
+	"sort"
+	"time"
+)
+
+// TurnResult is the outcome of a single turn.
+type TurnResult struct {
+	Round    int    `json:"round"`
+	DrawerID string `json:"drawerId"`
+	Drawer   string `json:"drawer"`
+	// Word is the word that had to be guessed. It's empty if the turn
+	// ended before the drawer had chosen a word.
+	Word string `json:"word"`
+	// Points maps the IDs of all players that scored during the turn,
+	// including the drawer, to the points they've earned.
+	Points map[string]int `json:"points"`
+}
+
+// PlayerResult is the final score of a player.
+type PlayerResult struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	AccountID string `json:"accountId,omitempty"`
+	Score     int    `json:"score"`
+	Rank      int    `json:"rank"`
+}
+
+// GameResults describe a finished game, see Lobby.Results.
+type GameResults struct {
+	LobbyID  string    `json:"lobbyId"`
+	Wordpack string    `json:"wordpack"`
+	Rounds   int       `json:"rounds"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Players are ordered by score. Players that have left the lobby
+	// before the game was over aren't part of the results.
+	Players []*PlayerResult `json:"players"`
+	// Turns are in the order they've been played in.
+	Turns []*TurnResult `json:"turns"`
+}
+
+// recordTurn remembers the outcome of the current turn. It has to be called
+// before the scores of the turn are reset. Turns of drawers that have been
+// kicked aren't recorded, since their points have been taken back.
+func (lobby *Lobby) recordTurn(drawerScore int) {
+	if lobby.state != ongoing || lobby.drawer == nil {
+		return
+	}
+
+	turn := &TurnResult{
+		Round:    lobby.Round,
+		DrawerID: lobby.drawer.ID,
+		Drawer:   lobby.drawer.Name,
+		Word:     lobby.CurrentWord,
+		Points:   make(map[string]int),
+	}
+	if drawerScore > 0 {
+		turn.Points[lobby.drawer.ID] = drawerScore
+	}
+	for _, player := range lobby.players {
+		//Only players that have guessed the word are on standby.
+		if player.State == Standby && player.LastScore > 0 {
+			turn.Points[player.ID] = player.LastScore
+		}
+	}
+	lobby.turns = append(lobby.turns, turn)
+}
+
+// Results returns the results of the game that has just been finished. It
+// must only be called by the LobbyNotifier while handling GameFinished.
+func (lobby *Lobby) Results() *GameResults {
+	results := &GameResults{
+		LobbyID:  lobby.ID,
+		Wordpack: lobby.Wordpack,
+		Rounds:   lobby.MaxRounds,
+		Started:  lobby.gameStarted,
+		Finished: lobby.now(),
+		Turns:    lobby.turns,
+	}
+	for _, player := range lobby.players {
+		results.Players = append(results.Players, &PlayerResult{
+			ID:        player.ID,
+			Name:      player.Name,
+			AccountID: player.accountID,
+			Score:     player.Score,
+			Rank:      player.Rank,
+		})
+	}
+	sort.SliceStable(results.Players, func(a, b int) bool {
+		return results.Players[a].Score > results.Players[b].Score
+	})
+	return results
+}
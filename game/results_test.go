@@ -0,0 +1,61 @@
+package game
+
+import "testing"
+
+func Test_Results(t *testing.T) {
+	server, _, notifier := newTestServer()
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseLobby(lobby, "")
+	guest, err := lobby.JoinPlayer("guest", "10.0.0.1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results *GameResults
+	lobby.synchronized(func() {
+		owner.Connected = true
+		guest.Connected = true
+		startGame(lobby)
+
+		//The owner draws first and the guest guesses the word, which ends
+		//the turn right away.
+		lobby.CurrentWord = "apple"
+		handleMessage("apple", guest, lobby)
+
+		//Nobody guesses the guests word, which ends the game.
+		lobby.CurrentWord = "pear"
+		advanceLobby(lobby)
+
+		if notifier.finishedGames == 1 {
+			results = lobby.Results()
+		}
+	})
+	if results == nil {
+		t.Fatal("expected the game to be over")
+	}
+
+	if len(results.Turns) != 2 {
+		t.Fatalf("expected two turns, got %+v", results.Turns)
+	}
+	first, second := results.Turns[0], results.Turns[1]
+	if first.DrawerID != owner.ID || first.Word != "apple" || first.Points[guest.ID] == 0 || first.Points[owner.ID] == 0 {
+		t.Errorf("unexpected first turn %+v", first)
+	}
+	if second.DrawerID != guest.ID || second.Word != "pear" || len(second.Points) != 0 {
+		t.Errorf("unexpected second turn %+v", second)
+	}
+	if len(results.Players) != 2 {
+		t.Fatalf("expected two players, got %d", len(results.Players))
+	}
+	for _, player := range results.Players {
+		if player.Score != first.Points[player.ID] {
+			t.Errorf("score of %s doesn't match the points of the turns: %+v", player.Name, player)
+		}
+	}
+	if results.LobbyID != lobby.ID || results.Rounds != 1 || results.Finished.Before(results.Started) {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
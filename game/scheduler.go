@@ -0,0 +1,214 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+// taskKind identifies a task of the scheduler. Each lobby has at most one
+// pending task of each kind.
+type taskKind int
+
+const (
+	// taskRoundEnd ends the current turn once the drawing time is up.
+	taskRoundEnd taskKind = iota
+	// taskHintReveal reveals the next word hint.
+	taskHintReveal
+	// taskTimeSync regularly tells all clients how much time is left.
+	taskTimeSync
+	// taskWordChoice chooses a word for drawers that take too long.
+	taskWordChoice
+	// taskAFKCheck skips the turn of drawers that don't draw anything.
+	taskAFKCheck
+	// taskScheduledStart counts down to and then starts a scheduled game.
+	taskScheduledStart
+)
+
+const (
+	// wordChoiceTimeout is the time a drawer has for choosing a word,
+	// before a random one is chosen for them.
+	wordChoiceTimeout = 20 * time.Second
+	// afkTimeout is the time after choosing a word, after which the turn is
+	// skipped if the drawer hasn't drawn anything yet.
+	afkTimeout = 45 * time.Second
+	// scheduledStartInterval is the interval in which scheduled lobbies
+	// check whether the countdown has to be announced.
+	scheduledStartInterval = 1 * time.Second
+)
+
+// scheduler owns all timers of a lobby, such as the end of the turn and the
+// hint reveals. Tasks don't need a goroutine while waiting and are run on
+// the lobbies loop, so they can safely change the lobby. A task that has
+// been cancelled or replaced before its timer fired is dropped, even if it
+// has already been handed to the loop.
+type scheduler struct {
+	tasks map[taskKind]*scheduledTask
+	// generation is increased for every scheduled task, so that a task can
+	// tell whether it's still the current one of its kind.
+	generation uint64
+}
+
+type scheduledTask struct {
+	generation uint64
+	timer      Timer
+}
+
+// schedule runs the task on the lobbies loop once the delay has passed,
+// replacing any pending task of the same kind.
+func (lobby *Lobby) schedule(kind taskKind, delay time.Duration, task func(*Lobby)) {
+	scheduler := &lobby.scheduler
+	if scheduler.tasks == nil {
+		scheduler.tasks = make(map[taskKind]*scheduledTask)
+	}
+	lobby.cancelTask(kind)
+
+	//Closed lobbies don't schedule anything anymore, as they wouldn't be
+	//torn down otherwise.
+	if _, running := lobby.startBackgroundWork(); !running {
+		return
+	}
+
+	scheduler.generation++
+	generation := scheduler.generation
+	scheduler.tasks[kind] = &scheduledTask{
+		generation: generation,
+		timer: lobby.afterFunc(delay, func() {
+			defer lobby.background.Done()
+			lobby.synchronized(func() {
+				current, pending := scheduler.tasks[kind]
+				if !pending || current.generation != generation {
+					return
+				}
+
+				delete(scheduler.tasks, kind)
+				task(lobby)
+			})
+		}),
+	}
+}
+
+// isScheduled indicates whether a task of the given kind is pending.
+func (lobby *Lobby) isScheduled(kind taskKind) bool {
+	_, pending := lobby.scheduler.tasks[kind]
+	return pending
+}
+
+// cancelTask drops the pending task of the given kind, if there's any.
+func (lobby *Lobby) cancelTask(kind taskKind) {
+	if task, pending := lobby.scheduler.tasks[kind]; pending {
+		//Timers that have fired already count themselves as done.
+		if task.timer.Stop() {
+			lobby.background.Done()
+		}
+		delete(lobby.scheduler.tasks, kind)
+	}
+}
+
+// cancelAllTasks drops all pending tasks. This has to happen whenever a
+// turn ends and when the lobby is closed, so that no timers are left behind.
+func (lobby *Lobby) cancelAllTasks() {
+	for kind := range lobby.scheduler.tasks {
+		lobby.cancelTask(kind)
+	}
+}
+
+// scheduleTurn schedules all tasks of the current turn, relative to its
+// end. Tasks that depend on the word, such as the hint reveals, are only
+// scheduled once it has been chosen.
+func scheduleTurn(lobby *Lobby) {
+	lobby.schedule(taskRoundEnd, millisUntil(lobby, lobby.RoundEndTime), advanceLobby)
+	lobby.schedule(taskTimeSync, timeSyncInterval, timeSyncTask)
+
+	if lobby.CurrentWord == "" {
+		lobby.schedule(taskWordChoice, wordChoiceTimeout, wordChoiceTimeoutTask)
+	} else {
+		scheduleWordTasks(lobby)
+	}
+}
+
+// scheduleWordTasks schedules the tasks that only make sense once the
+// drawer has chosen a word.
+func scheduleWordTasks(lobby *Lobby) {
+	lobby.cancelTask(taskWordChoice)
+	scheduleHintReveal(lobby)
+	if len(lobby.currentDrawing) == 0 {
+		lobby.schedule(taskAFKCheck, afkTimeout, afkCheckTask)
+	}
+}
+
+func timeSyncTask(lobby *Lobby) {
+	lobby.transport.TriggerUpdateEvent(EventTypeTimeSync, generateTimeSync(lobby), lobby)
+	lobby.schedule(taskTimeSync, timeSyncInterval, timeSyncTask)
+}
+
+// scheduleHintReveal schedules revealing the next hint, if there are any
+// left. If you have a drawingtime of 120 seconds and three hints, you want
+// to reveal a hint every 30 seconds, so that the hints are evenly
+// distributed across the turn. If the word was chosen late, the overdue
+// hints are revealed one per second.
+func scheduleHintReveal(lobby *Lobby) {
+	if lobby.hintsLeft <= 0 || lobby.wordHints == nil {
+		return
+	}
+
+	revealHintEveryXMilliseconds := int64(lobby.DrawingTime * 1000 / (lobby.hintCount + 1))
+	revealAt := lobby.RoundEndTime - revealHintEveryXMilliseconds*int64(lobby.hintsLeft)
+	delay := millisUntil(lobby, revealAt)
+	if delay < time.Second {
+		delay = time.Second
+	}
+	lobby.schedule(taskHintReveal, delay, hintRevealTask)
+}
+
+func hintRevealTask(lobby *Lobby) {
+	lobby.hintsLeft--
+	for {
+		randomIndex := lobby.getRandom().Intn(len(lobby.wordHints))
+		if lobby.wordHints[randomIndex].Character == 0 {
+			lobby.wordHints[randomIndex].Character = []rune(lobby.CurrentWord)[randomIndex]
+			triggerWordHintUpdate(lobby)
+			break
+		}
+	}
+
+	scheduleHintReveal(lobby)
+}
+
+// wordChoiceTimeoutTask chooses a random word for a drawer that hasn't
+// chosen one in time.
+func wordChoiceTimeoutTask(lobby *Lobby) {
+	if lobby.drawer == nil || len(lobby.wordChoice) == 0 {
+		return
+	}
+
+	//Starting at a random word, the first one the hooks allow is chosen.
+	drawer, offset := lobby.drawer, lobby.getRandom().Intn(len(lobby.wordChoice))
+	for i := range lobby.wordChoice {
+		index := (offset + i) % len(lobby.wordChoice)
+		word := lobby.wordChoice[index]
+		if lobby.checkHooks(func(hook Hook) error { return hook.WordChosen(lobby, drawer, word) }) == nil {
+			chooseWord(lobby, index)
+			lobby.transport.WriteAsJSON(lobby.drawer, GameEvent{Type: EventTypeWordChosen, Data: lobby.CurrentWord})
+			return
+		}
+	}
+
+	lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("None of the words offered to %s are allowed, skipping their turn.", drawer.Name))
+	advanceLobby(lobby)
+}
+
+// afkCheckTask skips the turn if the drawer hasn't drawn anything since
+// choosing the word, as they are most likely away. Drawing cancels the
+// check.
+func afkCheckTask(lobby *Lobby) {
+	if lobby.drawer == nil {
+		return
+	}
+
+	lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("%s seems to be away, skipping their turn.", lobby.drawer.Name))
+	advanceLobby(lobby)
+}
+
+func millisUntil(lobby *Lobby, timestamp int64) time.Duration {
+	return time.Duration(timestamp-lobby.getTimeAsMillis()) * time.Millisecond
+}
@@ -0,0 +1,78 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_schedule(t *testing.T) {
+	//Tasks run on the loop, so they are only scheduled from within the loop
+	//as well.
+	server, _, _ := newTestServer()
+	_, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseLobby(lobby, "")
+	ran := make(chan string, 3)
+
+	lobby.synchronized(func() {
+		lobby.schedule(taskRoundEnd, 10*time.Millisecond, func(*Lobby) { ran <- "replaced" })
+		lobby.schedule(taskRoundEnd, 10*time.Millisecond, func(*Lobby) { ran <- "round-end" })
+		lobby.schedule(taskTimeSync, 10*time.Millisecond, func(*Lobby) { ran <- "cancelled" })
+		lobby.cancelTask(taskTimeSync)
+	})
+
+	if task := <-ran; task != "round-end" {
+		t.Errorf("expected only the replacing task to run, got %s", task)
+	}
+
+	lobby.synchronized(func() {
+		lobby.schedule(taskHintReveal, 10*time.Millisecond, func(*Lobby) { ran <- "hint" })
+		lobby.schedule(taskAFKCheck, 10*time.Millisecond, func(*Lobby) { ran <- "afk" })
+		lobby.cancelAllTasks()
+		if lobby.isScheduled(taskHintReveal) || lobby.isScheduled(taskAFKCheck) {
+			t.Error("expected all tasks to be cancelled")
+		}
+	})
+
+	select {
+	case task := <-ran:
+		t.Errorf("expected no task to run, but %s did", task)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_wordChoiceTimeoutTask(t *testing.T) {
+	drawer := &Player{ID: "drawer", Connected: true, State: Drawing}
+	transport := &recordingTransport{}
+	lobby := &Lobby{
+		transport:    transport,
+		players:      []*Player{drawer},
+		drawer:       drawer,
+		state:        ongoing,
+		DrawingTime:  120,
+		RoundEndTime: toMillis(time.Now()) + 120000,
+		wordChoice:   []string{"apple", "house", "dinosaur"},
+	}
+	defer lobby.cancelAllTasks()
+
+	wordChoiceTimeoutTask(lobby)
+	if lobby.CurrentWord == "" || lobby.wordChoice != nil {
+		t.Fatalf("expected a word to be chosen, got %q", lobby.CurrentWord)
+	}
+	if len(transport.sent) == 0 {
+		t.Fatal("expected the drawer to be told about the word")
+	}
+	if event, ok := transport.sent[len(transport.sent)-1].(GameEvent); !ok || event.Type != "word-chosen" || event.Data != lobby.CurrentWord {
+		t.Errorf("expected word-chosen event, got %+v", transport.sent[len(transport.sent)-1])
+	}
+	if !lobby.isScheduled(taskHintReveal) || !lobby.isScheduled(taskAFKCheck) {
+		t.Error("expected hint reveal and AFK check to be scheduled")
+	}
+
+	handleLineEvent(lobby, drawer, &Line{ToX: 1, ToY: 1, Color: "#000000", LineWidth: 8})
+	if lobby.isScheduled(taskAFKCheck) {
+		t.Error("drawing should cancel the AFK check")
+	}
+}
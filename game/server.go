@@ -0,0 +1,75 @@
+package game
+
+// Transport delivers events to the players of a lobby. The game logic
+// doesn't know how players are connected, so that it can be tested and
+// embedded without any networking.
+type Transport interface {
+	// WriteAsJSON sends the event to a single player.
+	WriteAsJSON(player *Player, object interface{}) error
+	// TriggerUpdateEvent sends the same event to all players of the lobby.
+	TriggerUpdateEvent(eventType string, data interface{}, lobby *Lobby)
+	// TriggerUpdatePerPlayerEvent sends an event with different data for
+	// each player of the lobby.
+	TriggerUpdatePerPlayerEvent(eventType string, data func(*Player) interface{}, lobby *Lobby)
+	// SendDataToEveryoneExceptSender sends the event to all players of the
+	// lobby, except for the one that caused it.
+	SendDataToEveryoneExceptSender(sender *Player, lobby *Lobby, data interface{})
+	// WritePublicSystemMessage shows the text in the chat of all players.
+	WritePublicSystemMessage(lobby *Lobby, text string)
+	// CloseConnection closes the connection of the player, passing the
+	// given close code and reason to the client.
+	CloseConnection(player *Player, code int, reason string)
+}
+
+// LobbyNotifier is told about things happening in a lobby that matter to
+// the rest of the server.
+type LobbyNotifier interface {
+	// LobbyRemoved is called once the lobby has been closed and nobody
+	// may join it anymore.
+	LobbyRemoved(lobby *Lobby)
+	// GameStarted is called when the first turn of a game begins.
+	GameStarted(lobby *Lobby)
+	// GameFinished is called after the last turn of a game, once the final
+	// ranks have been determined.
+	GameFinished(lobby *Lobby)
+	// RecordAudit is called for every moderation action.
+	RecordAudit(entry *AuditEntry)
+	// PlayerConnected is called whenever a player has connected to the
+	// lobby, including reconnects.
+	PlayerConnected(lobby *Lobby, player *Player)
+	// PlayerDisconnected is called once a player has lost their connection.
+	PlayerDisconnected(lobby *Lobby, player *Player)
+}
+
+// Server creates lobbies and provides them with the transport and notifier
+// they use for the rest of their lifetime.
+type Server struct {
+	transport Transport
+	notifier  LobbyNotifier
+	clock     Clock
+	newRandom func() Random
+	hooks     []Hook
+}
+
+// NewServer creates a server whose lobbies use the given transport and
+// notifier.
+func NewServer(transport Transport, notifier LobbyNotifier) *Server {
+	return &Server{
+		transport: transport,
+		notifier:  notifier,
+		clock:     SystemClock{},
+		newRandom: newTimeSeededRandom,
+	}
+}
+
+// attach prepares the lobby for being used by the server. Its loop has to be
+// started via runLoop once the lobby has been set up completely.
+func (server *Server) attach(lobby *Lobby) {
+	lobby.transport = server.transport
+	lobby.notifier = server.notifier
+	lobby.clock = server.clock
+	lobby.random = server.newRandom()
+	lobby.hooks = server.hooks
+	lobby.actions = make(chan func())
+	lobby.stopped = make(chan struct{})
+}
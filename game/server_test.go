@@ -0,0 +1,106 @@
+package game
+
+import "testing"
+
+// recordingTransport remembers everything the game sends instead of
+// delivering it.
+type recordingTransport struct {
+	sentTo         []*Player
+	sent           []interface{}
+	events         []string
+	systemMessages []string
+	closeCodes     []int
+}
+
+func (transport *recordingTransport) WriteAsJSON(player *Player, object interface{}) error {
+	transport.sentTo = append(transport.sentTo, player)
+	transport.sent = append(transport.sent, object)
+	return nil
+}
+
+func (transport *recordingTransport) TriggerUpdateEvent(eventType string, data interface{}, lobby *Lobby) {
+	transport.events = append(transport.events, eventType)
+}
+
+func (transport *recordingTransport) TriggerUpdatePerPlayerEvent(eventType string, data func(*Player) interface{}, lobby *Lobby) {
+	transport.events = append(transport.events, eventType)
+}
+
+func (transport *recordingTransport) SendDataToEveryoneExceptSender(sender *Player, lobby *Lobby, data interface{}) {
+}
+
+func (transport *recordingTransport) WritePublicSystemMessage(lobby *Lobby, text string) {
+	transport.systemMessages = append(transport.systemMessages, text)
+}
+
+func (transport *recordingTransport) CloseConnection(player *Player, code int, reason string) {
+	transport.closeCodes = append(transport.closeCodes, code)
+}
+
+// recordingNotifier remembers all notifications about lobbies.
+type recordingNotifier struct {
+	removedLobbies []string
+	startedGames   int
+	finishedGames  int
+	auditEntries   []*AuditEntry
+	connections    int
+	disconnections int
+}
+
+func (notifier *recordingNotifier) LobbyRemoved(lobby *Lobby) {
+	notifier.removedLobbies = append(notifier.removedLobbies, lobby.ID)
+}
+
+func (notifier *recordingNotifier) GameStarted(lobby *Lobby) {
+	notifier.startedGames++
+}
+
+func (notifier *recordingNotifier) GameFinished(lobby *Lobby) {
+	notifier.finishedGames++
+}
+
+func (notifier *recordingNotifier) RecordAudit(entry *AuditEntry) {
+	notifier.auditEntries = append(notifier.auditEntries, entry)
+}
+
+func (notifier *recordingNotifier) PlayerConnected(lobby *Lobby, player *Player) {
+	notifier.connections++
+}
+
+func (notifier *recordingNotifier) PlayerDisconnected(lobby *Lobby, player *Player) {
+	notifier.disconnections++
+}
+
+// newTestServer creates a server whose lobbies record their events instead
+// of sending them.
+func newTestServer() (*Server, *recordingTransport, *recordingNotifier) {
+	transport := &recordingTransport{}
+	notifier := &recordingNotifier{}
+	return NewServer(transport, notifier), transport, notifier
+}
+
+func Test_Server(t *testing.T) {
+	server, transport, notifier := newTestServer()
+	owner, lobby, err := server.CreateLobby("owner", "english", false, 60, 1, 4, 0, 4, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner.Connected = true
+
+	commandSetMP(owner, lobby, []string{"setmp", "6"})
+	if lobby.MaxPlayers != 6 || len(transport.systemMessages) != 1 {
+		t.Errorf("expected max players to be changed publicly, got %d and %v", lobby.MaxPlayers, transport.systemMessages)
+	}
+	if len(notifier.auditEntries) != 1 || notifier.auditEntries[0].Action != "settings" || notifier.auditEntries[0].ActorID != owner.ID {
+		t.Errorf("expected the settings change to be audited, got %+v", notifier.auditEntries)
+	}
+
+	commandClose(owner, lobby, []string{"close"})
+	<-lobby.stopped
+	if len(transport.closeCodes) != 1 || transport.closeCodes[0] != CloseCodeLobbyClosed {
+		t.Errorf("expected the owners connection to be closed, got %v", transport.closeCodes)
+	}
+	if len(notifier.removedLobbies) != 1 || notifier.removedLobbies[0] != lobby.ID {
+		t.Errorf("expected lobby to be removed, got %v", notifier.removedLobbies)
+	}
+}
@@ -0,0 +1,63 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrShuttingDown is returned when trying to create or join a lobby while
+// the server is shutting down.
+var ErrShuttingDown = errors.New("the server is shutting down, please try again in a moment")
+
+// shuttingDown is set to 1 once BeginShutdown has been called.
+var shuttingDown int32
+
+// BeginShutdown stops new lobbies from being created and new players from
+// joining. Players that are already part of a lobby can still reconnect.
+// Lobbies with a turn in progress are closed once the turn is over, while
+// all other lobbies have to be closed via CloseForShutdown.
+func BeginShutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+// IsShuttingDown indicates whether BeginShutdown has been called.
+func IsShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// IsTurnInProgress indicates whether someone is currently drawing.
+func (lobby *Lobby) IsTurnInProgress() bool {
+	return lobby.state == ongoing && lobby.drawer != nil
+}
+
+// AnnounceShutdown tells all players how long it takes until the lobby is
+// closed. Just like the countdown of scheduled games, this only happens at
+// certain points in time, so it can be called every second.
+func AnnounceShutdown(lobby *Lobby, secondsLeft int64) {
+	if !isCountdownAnnouncement(secondsLeft) {
+		return
+	}
+
+	lobby.synchronized(func() {
+		if secondsLeft >= 60 {
+			lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("The server is restarting. This lobby will be closed after the current turn, but in %d minutes at the latest.", secondsLeft/60))
+		} else {
+			lobby.transport.WritePublicSystemMessage(lobby, fmt.Sprintf("The server is restarting. This lobby will be closed after the current turn, but in %d seconds at the latest.", secondsLeft))
+		}
+	})
+}
+
+// CloseForShutdown shows the final scores to all players and then closes
+// the lobby.
+func CloseForShutdown(lobby *Lobby) {
+	lobby.synchronized(func() {
+		closeForShutdown(lobby)
+	})
+}
+
+func closeForShutdown(lobby *Lobby) {
+	recalculateRanks(lobby)
+	triggerPlayersUpdate(lobby)
+	closeLobby(lobby, CloseCodeShutdown, "The server is restarting. Please create a new lobby in a moment.")
+}
@@ -0,0 +1,62 @@
+package game
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func Test_advanceLobby_whileShuttingDown(t *testing.T) {
+	drawer := &Player{ID: "a", Connected: true, State: Drawing}
+	guesser := &Player{ID: "b", Connected: true, State: Standby, Score: 100}
+	transport := &recordingTransport{}
+	notifier := &recordingNotifier{}
+	lobby := &Lobby{
+		ID:                    "draining",
+		transport:             transport,
+		notifier:              notifier,
+		players:               []*Player{drawer, guesser},
+		drawer:                drawer,
+		state:                 ongoing,
+		CurrentWord:           "word",
+		scoreEarnedByGuessers: 100,
+	}
+
+	defer atomic.StoreInt32(&shuttingDown, 0)
+	BeginShutdown()
+	if !lobby.IsTurnInProgress() {
+		t.Fatalf("expected turn to be in progress")
+	}
+	advanceLobby(lobby)
+
+	if drawer.Score != 100 {
+		t.Errorf("expected drawer to be awarded the score of the last turn, got %d", drawer.Score)
+	}
+	if events := transport.events; len(events) != 2 || events[0] != "update-players" || events[1] != "lobby-closed" {
+		t.Errorf("expected final scores and closure to be sent, got %v", events)
+	}
+	if closeCodes := transport.closeCodes; len(closeCodes) != 2 || closeCodes[0] != CloseCodeShutdown || closeCodes[1] != CloseCodeShutdown {
+		t.Errorf("expected all connections to be closed due to the shutdown, got %v", closeCodes)
+	}
+	if len(notifier.removedLobbies) != 1 || notifier.removedLobbies[0] != "draining" {
+		t.Errorf("expected lobby to be removed, got %v", notifier.removedLobbies)
+	}
+
+	//The drain loop might try closing the lobby again.
+	CloseForShutdown(lobby)
+	if len(notifier.removedLobbies) != 1 {
+		t.Errorf("expected lobby to be closed only once, got %v", notifier.removedLobbies)
+	}
+}
+
+func Test_CreateLobby_whileShuttingDown(t *testing.T) {
+	defer atomic.StoreInt32(&shuttingDown, 0)
+	BeginShutdown()
+
+	server, _, _ := newTestServer()
+	if _, _, err := server.CreateLobby("owner", "english", false, 60, 2, 4, 0, 4, nil, false); err != ErrShuttingDown {
+		t.Errorf("expected ErrShuttingDown, got %v", err)
+	}
+	if _, err := (&Lobby{}).JoinPlayer("player", "127.0.0.1", ""); err != ErrShuttingDown {
+		t.Errorf("expected ErrShuttingDown, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package game
+
+// turnOrder remembers who has drawn during the current round. Instead of
+// relying on the position of the current drawer, the next drawer is the
+// first player in the lobby that hasn't drawn yet. Therefore players can
+// join, leave and reconnect at any time, without anyone being skipped or
+// drawing twice in the same round. Players that join during a round draw
+// at the end of it.
+type turnOrder struct {
+	// drawn contains the IDs of the players that have drawn during the
+	// current round, including the current drawer.
+	drawn map[string]bool
+}
+
+// startRound forgets who has drawn, so that everyone gets to draw again.
+func (order *turnOrder) startRound() {
+	order.drawn = make(map[string]bool)
+}
+
+// markDrawn records that the player is drawing during the current round.
+func (order *turnOrder) markDrawn(player *Player) {
+	if order.drawn == nil {
+		order.startRound()
+	}
+	order.drawn[player.ID] = true
+}
+
+// hasDrawn indicates whether the player has drawn during the current round.
+func (order *turnOrder) hasDrawn(player *Player) bool {
+	return order.drawn[player.ID]
+}
+
+// next returns the first connected player that hasn't drawn during the
+// current round or nil if there's none left.
+func (order *turnOrder) next(players []*Player) *Player {
+	for _, player := range players {
+		if player.Connected && !order.hasDrawn(player) {
+			return player
+		}
+	}
+	return nil
+}
+
+// drawnPlayerIDs returns the IDs of the players that have drawn during the
+// current round, in the order of the given players.
+func (order *turnOrder) drawnPlayerIDs(players []*Player) []string {
+	var ids []string
+	for _, player := range players {
+		if order.hasDrawn(player) {
+			ids = append(ids, player.ID)
+		}
+	}
+	return ids
+}
@@ -1,30 +1,97 @@
 package game
 
 import (
-	"math/rand"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/gobuffalo/packr/v2"
 	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 var (
-	wordListCache       = make(map[string][]string)
-	languageIdentifiers = map[string]string{
-		"english": "en",
-		"italian": "it",
-		"german":  "de",
-		"french":  "fr",
-		"dutch":   "nl",
-		"swedish": "se",
-		"pokemon": "gen1pokemon",
+	wordListCache      = make(map[string][]string)
+	wordListCacheMutex = &sync.RWMutex{}
+	wordBox            = packr.New("words", "../resources/words")
+
+	bundledWordSource = &wordSource{
+		identifiers: map[string]string{
+			"english": "en",
+			"italian": "it",
+			"german":  "de",
+			"french":  "fr",
+			"dutch":   "nl",
+			"swedish": "se",
+			"pokemon": "gen1pokemon",
+		},
+		names: map[string]string{
+			"english": "English",
+			"italian": "Italian",
+			"german":  "German",
+			"french":  "French",
+			"dutch":   "Dutch",
+			"swedish": "Swedish",
+			"pokemon": "Pokemon",
+		},
+		supplier: wordBox.FindString,
 	}
-	wordBox = packr.New("words", "../resources/words")
+	currentWordSource = bundledWordSource
+	wordSourceMutex   = &sync.RWMutex{}
 )
 
+// wordSource defines the available languages and where their word lists
+// are read from. It's replaced as a whole and never modified.
+type wordSource struct {
+	// identifiers maps each language to the identifier of its word list.
+	identifiers map[string]string
+	// names maps each language to its display name.
+	names map[string]string
+	// supplier returns the contents of the word list with the given
+	// identifier.
+	supplier func(string) (string, error)
+}
+
+func getWordSource() *wordSource {
+	wordSourceMutex.RLock()
+	defer wordSourceMutex.RUnlock()
+
+	return currentWordSource
+}
+
+// GetSupportedLanguages returns the display names of all languages that
+// lobbies can be created with. The returned map mustn't be modified.
+func GetSupportedLanguages() map[string]string {
+	return getWordSource().names
+}
+
+// GetLoadedWordListSizes returns the amount of words of each word list that
+// has been loaded so far, sorted by language.
+func GetLoadedWordListSizes() []WordListSize {
+	wordListCacheMutex.RLock()
+	defer wordListCacheMutex.RUnlock()
+
+	sizes := make([]WordListSize, 0, len(wordListCache))
+	for language, words := range wordListCache {
+		sizes = append(sizes, WordListSize{Language: language, Words: len(words)})
+	}
+	sort.Slice(sizes, func(a, b int) bool {
+		return sizes[a].Language < sizes[b].Language
+	})
+	return sizes
+}
+
+// WordListSize is the amount of words available for a language.
+type WordListSize struct {
+	Language string
+	Words    int
+}
+
 func getLanguageIdentifier(language string) string {
-	return languageIdentifiers[language]
+	return getWordSource().identifiers[language]
 }
 
 // readWordListInternal exists for testing purposes.
@@ -33,11 +100,12 @@ func readWordListInternal(
 	wordlistSupplier func(string) (string, error)) ([]string, error) {
 
 	languageIdentifier := getLanguageIdentifier(chosenLanguage)
+	wordListCacheMutex.RLock()
 	list, available := wordListCache[languageIdentifier]
+	wordListCacheMutex.RUnlock()
 	if available {
 		copiedList := make([]string, len(list))
 		copy(copiedList, list)
-		shuffleWordList(copiedList)
 		return copiedList, nil
 	}
 
@@ -69,22 +137,127 @@ func readWordListInternal(
 		}
 	}
 
+	wordListCacheMutex.Lock()
 	wordListCache[languageIdentifier] = words
+	wordListCacheMutex.Unlock()
 
 	copiedList := make([]string, len(words))
 	copy(copiedList, words)
-	shuffleWordList(copiedList)
 	return copiedList, nil
 }
 
 // readWordList reads the wordlist for the given language from the filesystem.
 // If found, the list is cached and will be read from the cache upon next
-// request. The returned slice is a safe copy and can be mutated. If the
-// specified has no corresponding wordlist, an error is returned. This has been
-// a panic before, however, this could enable a user to forcefully crash the
-// whole application.
+// request. The returned slice is a safe copy in the order of the file and
+// has to be shuffled by the lobby. If the specified has no corresponding
+// wordlist, an error is returned. This has been a panic before, however, this
+// could enable a user to forcefully crash the whole application.
 func readWordList(lowercaser cases.Caser, chosenLanguage string) ([]string, error) {
-	return readWordListInternal(lowercaser, chosenLanguage, wordBox.FindString)
+	return readWordListInternal(lowercaser, chosenLanguage, getWordSource().supplier)
+}
+
+// ConfigureWordListDirectory makes the server read word lists from the
+// given directory instead of using the bundled ones. Each file has to be
+// named after the identifier of its language, such as "en". Afterwards,
+// only languages that have a word list in the directory are supported.
+// Languages unknown to the server are named after their file. An empty
+// directory restores the bundled word lists. Word lists read before are
+// dropped from the cache, so new lobbies use the new word lists.
+func ConfigureWordListDirectory(directory string) error {
+	if directory == "" {
+		setWordSource(bundledWordSource)
+		return nil
+	}
+
+	files, readError := ioutil.ReadDir(directory)
+	if readError != nil {
+		return readError
+	}
+
+	knownLanguages := make(map[string]string, len(bundledWordSource.identifiers))
+	for chosenLanguage, identifier := range bundledWordSource.identifiers {
+		knownLanguages[identifier] = chosenLanguage
+	}
+
+	identifiers := make(map[string]string)
+	supportedLanguages := make(map[string]string)
+	for _, file := range files {
+		identifier := file.Name()
+		if file.IsDir() || strings.HasPrefix(identifier, ".") {
+			continue
+		}
+
+		chosenLanguage, known := knownLanguages[identifier]
+		if known {
+			supportedLanguages[chosenLanguage] = bundledWordSource.names[chosenLanguage]
+		} else {
+			chosenLanguage = identifier
+			supportedLanguages[chosenLanguage] = identifier
+		}
+		identifiers[chosenLanguage] = identifier
+	}
+	if len(identifiers) == 0 {
+		return fmt.Errorf("the directory %s doesn't contain any word lists", directory)
+	}
+
+	setWordSource(&wordSource{
+		identifiers: identifiers,
+		names:       supportedLanguages,
+		supplier: func(identifier string) (string, error) {
+			content, err := ioutil.ReadFile(filepath.Join(directory, identifier))
+			return string(content), err
+		},
+	})
+	return nil
+}
+
+func setWordSource(source *wordSource) {
+	wordSourceMutex.Lock()
+	currentWordSource = source
+	wordSourceMutex.Unlock()
+
+	clearWordListCache()
+}
+
+func clearWordListCache() {
+	wordListCacheMutex.Lock()
+	wordListCache = make(map[string][]string)
+	wordListCacheMutex.Unlock()
+}
+
+// ReloadWordLists drops all cached word lists and reads them again. New
+// lobbies will use the new word lists, while existing lobbies keep the
+// words they have already drawn from the old ones.
+func ReloadWordLists() error {
+	clearWordListCache()
+	return LoadWordLists()
+}
+
+// LoadWordLists reads the word lists of all languages into the cache, so
+// that creating the first lobby for each language doesn't have to wait.
+func LoadWordLists() error {
+	for chosenLanguage, identifier := range getWordSource().identifiers {
+		if _, err := readWordList(cases.Lower(language.Make(identifier)), chosenLanguage); err != nil {
+			return fmt.Errorf("error loading word list for %s: %s", chosenLanguage, err)
+		}
+	}
+
+	return nil
+}
+
+// WordListsLoaded indicates whether the word lists of all languages are
+// available in the cache.
+func WordListsLoaded() bool {
+	wordListCacheMutex.RLock()
+	defer wordListCacheMutex.RUnlock()
+
+	for _, identifier := range getWordSource().identifiers {
+		if _, available := wordListCache[identifier]; !available {
+			return false
+		}
+	}
+
+	return true
 }
 
 // GetRandomWords gets a custom amount of random words for the passed Lobby.
@@ -106,7 +279,7 @@ func GetRandomWords(wordCount int, lobby *Lobby) []string {
 
 		words := make([]string, 0, wordCount)
 		for i := 0; i <= wordCount; i++ {
-			if rand.Intn(100)+1 < lobby.CustomWordsChance {
+			if lobby.getRandom().Intn(100)+1 < lobby.CustomWordsChance {
 				words = append(words, popCustomWords(1, lobby)...)
 			} else {
 				words = append(words, popWordpackWords(1, lobby)...)
@@ -139,7 +312,7 @@ func popWordpackWords(wordCount int, lobby *Lobby) []string {
 			//deeper problem.
 			panic(readError)
 		}
-		shuffleWordList(lobby.words)
+		lobby.shuffleWords()
 	}
 	wordIndex := len(lobby.words) - wordCount
 	lastThreeWords := lobby.words[wordIndex:]
@@ -147,9 +320,10 @@ func popWordpackWords(wordCount int, lobby *Lobby) []string {
 	return lastThreeWords
 }
 
-func shuffleWordList(wordlist []string) {
-	rand.Seed(time.Now().Unix())
-	rand.Shuffle(len(wordlist), func(a, b int) {
-		wordlist[a], wordlist[b] = wordlist[b], wordlist[a]
+// shuffleWords shuffles the words of the wordpack that haven't been used
+// yet, using the lobbies Random.
+func (lobby *Lobby) shuffleWords() {
+	lobby.getRandom().Shuffle(len(lobby.words), func(a, b int) {
+		lobby.words[a], lobby.words[b] = lobby.words[b], lobby.words[a]
 	})
 }
@@ -2,6 +2,9 @@ package game
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -17,7 +20,7 @@ func Test_readWordList(t *testing.T) {
 		}
 	})
 
-	for language := range languageIdentifiers {
+	for language := range GetSupportedLanguages() {
 		t.Run(fmt.Sprintf("Testing language file for %s", language), func(t *testing.T) {
 			//First run from box/drive
 			testWordList(language, t)
@@ -172,6 +175,43 @@ func Test_getRandomWordsReloading(t *testing.T) {
 	})
 }
 
+func Test_ConfigureWordListDirectory(t *testing.T) {
+	directory, err := ioutil.TempDir("", "words")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	for name, content := range map[string]string{"en": "house\ntree", "es": "casa\nárbol", ".hidden": "x"} {
+		if err := ioutil.WriteFile(filepath.Join(directory, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer ConfigureWordListDirectory("")
+
+	if err := ConfigureWordListDirectory(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	languages := GetSupportedLanguages()
+	if len(languages) != 2 || languages["english"] != "English" || languages["es"] != "es" {
+		t.Errorf("unexpected languages: %v", languages)
+	}
+
+	words, err := readWordList(cases.Lower(language.Spanish), "es")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != 2 || !arrayContains(words, "casa") {
+		t.Errorf("unexpected words: %v", words)
+	}
+
+	if err := ConfigureWordListDirectory(filepath.Join(directory, "missing")); err == nil {
+		t.Errorf("missing directory has been accepted")
+	}
+}
+
 func arrayContains(array []string, item string) bool {
 	for _, arrayItem := range array {
 		if arrayItem == item {
@@ -0,0 +1,215 @@
+// Package logging provides leveled, structured logging. Each entry consists
+// of a message and a list of key-value pairs, such as the ID of the lobby
+// and player an entry is about. Entries are written either as human readable
+// text or as one JSON object per line.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// All available levels, ordered by severity.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelError {
+		return "unknown"
+	}
+	return levelNames[level]
+}
+
+// ParseLevel parses the name of a level, such as "info".
+func ParseLevel(name string) (Level, error) {
+	for index, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(index), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level '%s', expected one of: %s", name, strings.Join(levelNames, ", "))
+}
+
+var (
+	mutex              = &sync.Mutex{}
+	output   io.Writer = os.Stderr
+	minLevel           = LevelInfo
+	useJSON  bool
+)
+
+// Configure sets the minimum level of entries that are written and whether
+// entries are written as JSON instead of text.
+func Configure(level Level, jsonOutput bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	minLevel = level
+	useJSON = jsonOutput
+}
+
+// Logger adds a set of key-value pairs to all entries it writes. The zero
+// value is a logger without any additional pairs.
+type Logger struct {
+	keyValues []interface{}
+}
+
+// With creates a logger that adds the given key-value pairs to each entry.
+func With(keyValues ...interface{}) *Logger {
+	return &Logger{keyValues: keyValues}
+}
+
+// With creates a logger that adds the given key-value pairs in addition to
+// the ones of this logger.
+func (logger *Logger) With(keyValues ...interface{}) *Logger {
+	combined := make([]interface{}, 0, len(logger.keyValues)+len(keyValues))
+	combined = append(combined, logger.keyValues...)
+	return &Logger{keyValues: append(combined, keyValues...)}
+}
+
+// Debug writes an entry that is only of interest while debugging.
+func (logger *Logger) Debug(message string, keyValues ...interface{}) {
+	logger.write(LevelDebug, message, keyValues)
+}
+
+// Info writes an entry about something that happened during normal
+// operation.
+func (logger *Logger) Info(message string, keyValues ...interface{}) {
+	logger.write(LevelInfo, message, keyValues)
+}
+
+// Warn writes an entry about something unexpected that didn't cause an
+// operation to fail.
+func (logger *Logger) Warn(message string, keyValues ...interface{}) {
+	logger.write(LevelWarn, message, keyValues)
+}
+
+// Error writes an entry about a failed operation.
+func (logger *Logger) Error(message string, keyValues ...interface{}) {
+	logger.write(LevelError, message, keyValues)
+}
+
+var defaultLogger = &Logger{}
+
+// Debug writes an entry without additional context, see Logger.Debug.
+func Debug(message string, keyValues ...interface{}) {
+	defaultLogger.write(LevelDebug, message, keyValues)
+}
+
+// Info writes an entry without additional context, see Logger.Info.
+func Info(message string, keyValues ...interface{}) {
+	defaultLogger.write(LevelInfo, message, keyValues)
+}
+
+// Warn writes an entry without additional context, see Logger.Warn.
+func Warn(message string, keyValues ...interface{}) {
+	defaultLogger.write(LevelWarn, message, keyValues)
+}
+
+// Error writes an entry without additional context, see Logger.Error.
+func Error(message string, keyValues ...interface{}) {
+	defaultLogger.write(LevelError, message, keyValues)
+}
+
+func (logger *Logger) write(level Level, message string, keyValues []interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if level < minLevel {
+		return
+	}
+
+	pairs := make([]interface{}, 0, len(logger.keyValues)+len(keyValues))
+	pairs = append(pairs, logger.keyValues...)
+	pairs = append(pairs, keyValues...)
+	//A missing value is more helpful than dropping the key.
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs, nil)
+	}
+
+	buffer := &bytes.Buffer{}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if useJSON {
+		writeJSON(buffer, now, level, message, pairs)
+	} else {
+		writeText(buffer, now, level, message, pairs)
+	}
+
+	//There's nowhere left to report failures to.
+	output.Write(buffer.Bytes())
+}
+
+func writeText(buffer *bytes.Buffer, now string, level Level, message string, pairs []interface{}) {
+	fmt.Fprintf(buffer, "%s %-5s %s", now, strings.ToUpper(level.String()), message)
+	for index := 0; index < len(pairs); index += 2 {
+		buffer.WriteByte(' ')
+		buffer.WriteString(fmt.Sprint(pairs[index]))
+		buffer.WriteByte('=')
+
+		value := formatValue(pairs[index+1])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		buffer.WriteString(value)
+	}
+	buffer.WriteByte('\n')
+}
+
+func writeJSON(buffer *bytes.Buffer, now string, level Level, message string, pairs []interface{}) {
+	buffer.WriteString(`{"time":`)
+	writeJSONValue(buffer, now)
+	buffer.WriteString(`,"level":`)
+	writeJSONValue(buffer, level.String())
+	buffer.WriteString(`,"message":`)
+	writeJSONValue(buffer, message)
+	for index := 0; index < len(pairs); index += 2 {
+		buffer.WriteByte(',')
+		writeJSONValue(buffer, fmt.Sprint(pairs[index]))
+		buffer.WriteByte(':')
+
+		value := pairs[index+1]
+		//Errors would otherwise be marshalled as empty objects.
+		if err, isError := value.(error); isError {
+			value = err.Error()
+		}
+		writeJSONValue(buffer, value)
+	}
+	buffer.WriteString("}\n")
+}
+
+func writeJSONValue(buffer *bytes.Buffer, value interface{}) {
+	encoded, marshalError := json.Marshal(value)
+	if marshalError != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buffer.Write(encoded)
+}
+
+func formatValue(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case error:
+		return typed.Error()
+	default:
+		return fmt.Sprint(typed)
+	}
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// captureOutput redirects all entries into a buffer. The returned function
+// restores the previous configuration.
+func captureOutput(level Level, jsonOutput bool) (*bytes.Buffer, func()) {
+	buffer := &bytes.Buffer{}
+	previousOutput, previousLevel, previousJSON := output, minLevel, useJSON
+
+	output = buffer
+	Configure(level, jsonOutput)
+	return buffer, func() {
+		output, minLevel, useJSON = previousOutput, previousLevel, previousJSON
+	}
+}
+
+func Test_textOutput(t *testing.T) {
+	buffer, restore := captureOutput(LevelInfo, false)
+	defer restore()
+
+	logger := With("lobby", "abc").With("player", "def")
+	logger.Debug("not written")
+	logger.Info("player connected", "name", "John Doe", "error", errors.New("oops"))
+
+	line := buffer.String()
+	if strings.Contains(line, "not written") {
+		t.Errorf("debug entry has been written despite level info: %s", line)
+	}
+	if !strings.HasSuffix(line, ` INFO  player connected lobby=abc player=def name="John Doe" error=oops`+"\n") {
+		t.Errorf("unexpected entry: %s", line)
+	}
+}
+
+func Test_jsonOutput(t *testing.T) {
+	buffer, restore := captureOutput(LevelDebug, true)
+	defer restore()
+
+	With("lobby", "abc").Warn("something failed", "error", errors.New("oops"), "count", 3, "dangling")
+
+	entry := make(map[string]interface{})
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("entry isn't valid JSON: %s\n%s", err, buffer.String())
+	}
+
+	expected := map[string]interface{}{
+		"level":    "warn",
+		"message":  "something failed",
+		"lobby":    "abc",
+		"error":    "oops",
+		"count":    float64(3),
+		"dangling": nil,
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("expected %s to be %v, but was %v", key, value, entry[key])
+		}
+	}
+	if _, hasTime := entry["time"]; !hasTime {
+		t.Error("entry doesn't contain the time")
+	}
+}
+
+func Test_ParseLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		want      Level
+		wantError bool
+	}{
+		{"debug", LevelDebug, false},
+		{"WARN", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", LevelInfo, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantError {
+				t.Errorf("ParseLevel() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
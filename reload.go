@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/communication"
+	"github.com/scribble-rs/scribble.rs/config"
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+// loadConfiguration reads the configuration file, the environment and the
+// command line flags.
+func loadConfiguration(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ApplyFlags(flag.CommandLine); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// applyReloadableSettings applies all settings that can be changed while
+// the server is running. Lobbies that already exist keep their settings.
+func applyReloadableSettings(cfg *config.Config) error {
+	//Validate has already made sure that the level is valid.
+	logLevel, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.Configure(logLevel, cfg.LogFormat == "json")
+
+	game.SetSettingBounds(cfg.SettingBounds)
+	communication.ConfigureMetrics(cfg.EnableMetrics)
+	communication.ConfigureProfiling(cfg.EnableProfiling)
+	communication.ConfigureCapacity(cfg.MaxLobbies)
+	communication.ConfigureLobbyCreationLimit(cfg.LobbyCreationLimit, time.Duration(cfg.LobbyCreationWindow)*time.Minute)
+	communication.ConfigureWebhooks(webhooks(cfg), cfg.PublicURL)
+	return game.ConfigureWordListDirectory(cfg.WordListDirectory)
+}
+
+// adminCredentials converts the configured admin tokens. The scopes have
+// already been validated.
+func adminCredentials(cfg *config.Config) []communication.AdminCredential {
+	var credentials []communication.AdminCredential
+	if cfg.AdminToken != "" {
+		credentials = append(credentials, communication.AdminCredential{
+			Name:  "admin",
+			Token: cfg.AdminToken,
+			Scope: communication.AdminScopeFull,
+		})
+	}
+	for _, token := range cfg.AdminTokens {
+		scope, _ := communication.ParseAdminScope(token.Scope)
+		credentials = append(credentials, communication.AdminCredential{
+			Name:  token.Name,
+			Token: token.Token,
+			Scope: scope,
+		})
+	}
+
+	return credentials
+}
+
+// webhooks converts the configured webhooks, which have already been
+// validated.
+func webhooks(cfg *config.Config) []communication.Webhook {
+	var converted []communication.Webhook
+	for _, webhook := range cfg.Webhooks {
+		format := webhook.Format
+		if format == "" {
+			format = "json"
+		}
+		converted = append(converted, communication.Webhook{
+			URL:    webhook.URL,
+			Format: format,
+			Events: webhook.Events,
+		})
+	}
+
+	return converted
+}
+
+// reloadMutex prevents reloads triggered via the signal and the admin API
+// from interleaving.
+var reloadMutex = &sync.Mutex{}
+
+// reloadConfiguration reads the configuration again and applies all
+// settings that can be changed at runtime. Changes to other settings are
+// reported, but ignored until the next restart. If the new configuration is
+// invalid, the current one is kept. All errors are logged, as well as
+// returned.
+func reloadConfiguration(path string, running *config.Config) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	reloaded, err := loadConfiguration(path)
+	if err != nil {
+		logging.Error("error reloading configuration, keeping the current one", "error", err)
+		return err
+	}
+
+	for _, key := range running.ChangesRequiringRestart(reloaded) {
+		logging.Warn("changed setting only takes effect after a restart", "key", key)
+	}
+
+	if err := applyReloadableSettings(reloaded); err != nil {
+		logging.Error("error applying reloaded configuration", "error", err)
+		return err
+	}
+	if err := game.LoadWordLists(); err != nil {
+		logging.Error("error loading word lists", "error", err)
+		return err
+	}
+
+	logging.Info("reloaded configuration and word lists")
+	return nil
+}
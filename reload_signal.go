@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/scribble-rs/scribble.rs/config"
+)
+
+// reloadOnSignal reloads the configuration and word lists whenever the
+// process receives SIGHUP.
+func reloadOnSignal(path string, running *config.Config) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			reloadConfiguration(path, running)
+		}
+	}()
+}
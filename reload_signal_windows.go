@@ -0,0 +1,6 @@
+package main
+
+import "github.com/scribble-rs/scribble.rs/config"
+
+// reloadOnSignal does nothing, since Windows doesn't support SIGHUP.
+func reloadOnSignal(path string, running *config.Config) {}
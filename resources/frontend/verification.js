@@ -0,0 +1,55 @@
+//Shows the CAPTCHA widget or solves the proof-of-work challenge sent by the
+//server. Once done, onSolved is called with the solution, which has to be
+//sent as the "verification" parameter.
+function solveVerification(challenge, container, onSolved) {
+    if (challenge.type === "pow") {
+        container.innerText = "Verifying your browser, this might take a moment ...";
+        solveProofOfWork(challenge.challenge, challenge.difficulty).then((solution) => {
+            container.innerText = "Verified.";
+            onSolved(solution);
+        });
+        return;
+    }
+
+    let script = document.createElement("script");
+    if (challenge.type === "hcaptcha") {
+        window.onVerificationLoaded = () => {
+            hcaptcha.render(container, { sitekey: challenge.siteKey, callback: onSolved });
+        };
+        script.src = "https://js.hcaptcha.com/1/api.js?onload=onVerificationLoaded&render=explicit";
+    } else if (challenge.type === "recaptcha") {
+        window.onVerificationLoaded = () => {
+            grecaptcha.render(container, { sitekey: challenge.siteKey, callback: onSolved });
+        };
+        script.src = "https://www.google.com/recaptcha/api.js?onload=onVerificationLoaded&render=explicit";
+    } else {
+        container.innerText = "Unknown verification type: " + challenge.type;
+        return;
+    }
+    script.async = true;
+    document.head.appendChild(script);
+}
+
+//Searches for a nonce, so that the SHA-256 hash of "challenge:nonce" starts
+//with the given amount of zero bits.
+async function solveProofOfWork(challenge, difficulty) {
+    let encoder = new TextEncoder();
+    for (let nonce = 0; ; nonce++) {
+        let solution = challenge + ":" + nonce;
+        let hash = new Uint8Array(await crypto.subtle.digest("SHA-256", encoder.encode(solution)));
+        if (leadingZeroBits(hash) >= difficulty) {
+            return solution;
+        }
+    }
+}
+
+function leadingZeroBits(hash) {
+    let zeroBits = 0;
+    for (let i = 0; i < hash.length; i++) {
+        if (hash[i] !== 0) {
+            return zeroBits + Math.clz32(hash[i]) - 24;
+        }
+        zeroBits += 8;
+    }
+    return zeroBits;
+}
@@ -0,0 +1,214 @@
+package simulation
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/scribble-rs/scribble.rs/client"
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+const (
+	// wrongGuessInterval is the minimum time between two wrong guesses of
+	// a bot.
+	wrongGuessInterval = 3 * time.Second
+	// strokeLineWidth is the width of all lines drawn by bots.
+	strokeLineWidth = 8
+)
+
+// bot is a single simulated player. All of its decisions are made on its
+// own goroutine, see run.
+type bot struct {
+	lobby  *simulatedLobby
+	name   string
+	owner  bool
+	random *rand.Rand
+
+	connection *client.Client
+
+	// mutex guards the playerID, as it's accessed by the lobbies kick
+	// goroutine.
+	mutex    *sync.Mutex
+	playerID string
+
+	drawing        bool
+	guessed        bool
+	guessAt        time.Time
+	nextWrongGuess time.Time
+}
+
+func newBot(lobby *simulatedLobby, name string, owner bool, connection *client.Client, seed int64) *bot {
+	return &bot{
+		lobby:      lobby,
+		name:       name,
+		owner:      owner,
+		random:     rand.New(rand.NewSource(seed)),
+		mutex:      &sync.Mutex{},
+		connection: connection,
+		guessed:    true,
+	}
+}
+
+func (bot *bot) getPlayerID() string {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+
+	return bot.playerID
+}
+
+// send passes the bots connection to the given function and records the
+// sent event. Events sent after the connection has been closed are
+// ignored, as the closing is handled by run.
+func (bot *bot) send(function func(*client.Client) error) {
+	recorder := bot.lobby.recorder
+	if err := function(bot.connection); err != nil {
+		if err != client.ErrClosed {
+			recorder.add(&recorder.errors)
+		}
+		return
+	}
+	recorder.add(&recorder.eventsSent)
+}
+
+// run handles the incoming events and acts in the interval lines are drawn
+// in, until the simulation is stopped.
+func (bot *bot) run(stop <-chan struct{}) {
+	actTicker := time.NewTicker(bot.lobby.config.StrokeInterval)
+	defer actTicker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			bot.connection.Close()
+			return
+		case event, open := <-bot.connection.Events():
+			if !open {
+				bot.handleClosedConnection(stop)
+				return
+			}
+			bot.handleEvent(event)
+		case <-actTicker.C:
+			bot.act()
+		}
+	}
+}
+
+// handleClosedConnection records why the bot has stopped playing before the
+// end of the simulation. Kicked bots can't rejoin, as they are banned.
+func (bot *bot) handleClosedConnection(stop <-chan struct{}) {
+	select {
+	case <-stop:
+		return
+	default:
+	}
+
+	bot.lobby.removeBot(bot)
+	recorder := bot.lobby.recorder
+	closeError, isCloseError := bot.connection.Err().(*websocket.CloseError)
+	if isCloseError && closeError.Code == game.CloseCodeKicked {
+		recorder.add(&recorder.kicks)
+	} else {
+		recorder.add(&recorder.errors)
+	}
+}
+
+func (bot *bot) handleEvent(event *client.Event) {
+	recorder := bot.lobby.recorder
+	recorder.add(&recorder.eventsReceived)
+
+	switch event.Type {
+	case game.EventTypeReady:
+		ready := &game.Ready{}
+		if event.Decode(ready) != nil {
+			return
+		}
+		bot.mutex.Lock()
+		bot.playerID = ready.PlayerID
+		bot.mutex.Unlock()
+
+		//Once the game is over, it's started again right away.
+		if bot.owner && ready.Round == 0 {
+			bot.send((*client.Client).Start)
+		}
+	case game.EventTypeNextTurn:
+		if bot.owner {
+			recorder.add(&recorder.turns)
+		}
+		bot.drawing = false
+		bot.guessed = false
+		bot.guessAt = time.Now().Add(time.Duration(bot.random.Int63n(int64(bot.lobby.config.GuessDelay))))
+	case game.EventTypeYourTurn:
+		var words []string
+		if event.Decode(&words) != nil || len(words) == 0 {
+			return
+		}
+		index := bot.random.Intn(len(words))
+		bot.send(func(connection *client.Client) error {
+			return connection.ChooseWord(index)
+		})
+		bot.lobby.setWord(words[index])
+		bot.drawing = true
+		bot.guessed = true
+	case game.EventTypeWordChosen:
+		var word string
+		if event.Decode(&word) == nil {
+			bot.lobby.setWord(word)
+		}
+	case game.EventTypeCorrectGuess:
+		var playerID string
+		if event.Decode(&playerID) == nil && playerID == bot.getPlayerID() {
+			recorder.add(&recorder.correctGuesses)
+		}
+	case game.EventTypeLine:
+		var line game.Line
+		if event.Decode(&line) != nil {
+			return
+		}
+		if sentAt, known := bot.lobby.strokeSentAt(line.Color); known {
+			recorder.recordLatency(time.Since(sentAt))
+		}
+	}
+}
+
+// act draws a line while drawing. Otherwise the bot guesses every now and
+// then and eventually guesses the word, which it knows by cheating.
+func (bot *bot) act() {
+	if bot.drawing {
+		line := game.Line{
+			FromX:     float32(bot.random.Intn(game.DrawingBoardBaseWidth)),
+			FromY:     float32(bot.random.Intn(game.DrawingBoardBaseHeight)),
+			ToX:       float32(bot.random.Intn(game.DrawingBoardBaseWidth)),
+			ToY:       float32(bot.random.Intn(game.DrawingBoardBaseHeight)),
+			Color:     bot.lobby.nextStroke(),
+			LineWidth: strokeLineWidth,
+		}
+		bot.send(func(connection *client.Client) error {
+			return connection.DrawLine(line)
+		})
+		return
+	}
+
+	if bot.guessed {
+		return
+	}
+
+	now := time.Now()
+	if now.After(bot.guessAt) {
+		if word := bot.lobby.getWord(); word != "" {
+			bot.send(func(connection *client.Client) error {
+				return connection.SendMessage(word)
+			})
+		}
+		bot.guessed = true
+	} else if now.After(bot.nextWrongGuess) {
+		guess := fmt.Sprintf("guess %d", bot.random.Intn(1000))
+		bot.send(func(connection *client.Client) error {
+			return connection.SendMessage(guess)
+		})
+		bot.nextWrongGuess = now.Add(wrongGuessInterval + time.Duration(bot.random.Int63n(int64(wrongGuessInterval))))
+	}
+}
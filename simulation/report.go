@@ -0,0 +1,147 @@
+package simulation
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxRecordedLatency is the highest latency that can be told apart from
+// others. Anything slower is counted as maxRecordedLatency.
+const maxRecordedLatency = 10 * time.Second
+
+// Report summarizes a simulation run.
+type Report struct {
+	Lobbies  int
+	Players  int
+	Duration time.Duration
+
+	// Turns is the amount of turns started across all lobbies.
+	Turns int64
+	// EventsSent is the amount of events sent by all bots.
+	EventsSent int64
+	// EventsReceived is the amount of events received by all bots.
+	EventsReceived int64
+	// CorrectGuesses is the amount of guesses that hit the word.
+	CorrectGuesses int64
+	// Kicks is the amount of bots that have been kicked.
+	Kicks int64
+	// Errors is the amount of failed sends and unexpectedly closed
+	// connections.
+	Errors int64
+
+	// LatencyP50, LatencyP95, LatencyP99 and LatencyMax describe the time it
+	// took for a line drawn by a drawer to reach the other players.
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	LatencyP99 time.Duration
+	LatencyMax time.Duration
+}
+
+// EventsReceivedPerSecond is the throughput of the broadcast path.
+func (report *Report) EventsReceivedPerSecond() float64 {
+	if report.Duration <= 0 {
+		return 0
+	}
+	return float64(report.EventsReceived) / report.Duration.Seconds()
+}
+
+// Write prints the report in a human readable form.
+func (report *Report) Write(writer io.Writer) {
+	fmt.Fprintf(writer, "lobbies:          %d\n", report.Lobbies)
+	fmt.Fprintf(writer, "players:          %d\n", report.Players)
+	fmt.Fprintf(writer, "duration:         %s\n", report.Duration.Round(time.Millisecond))
+	fmt.Fprintf(writer, "turns:            %d\n", report.Turns)
+	fmt.Fprintf(writer, "events sent:      %d\n", report.EventsSent)
+	fmt.Fprintf(writer, "events received:  %d (%.0f/s)\n", report.EventsReceived, report.EventsReceivedPerSecond())
+	fmt.Fprintf(writer, "correct guesses:  %d\n", report.CorrectGuesses)
+	fmt.Fprintf(writer, "kicks:            %d\n", report.Kicks)
+	fmt.Fprintf(writer, "errors:           %d\n", report.Errors)
+	fmt.Fprintf(writer, "line latency:     p50 %s, p95 %s, p99 %s, max %s\n",
+		report.LatencyP50, report.LatencyP95, report.LatencyP99, report.LatencyMax)
+}
+
+// recorder collects the measurements of all bots.
+type recorder struct {
+	mutex *sync.Mutex
+
+	turns          int64
+	eventsSent     int64
+	eventsReceived int64
+	correctGuesses int64
+	kicks          int64
+	errors         int64
+
+	// latencies counts the received lines per millisecond of latency.
+	latencies    []int64
+	latencyCount int64
+	maxLatency   time.Duration
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		mutex:     &sync.Mutex{},
+		latencies: make([]int64, maxRecordedLatency/time.Millisecond+1),
+	}
+}
+
+func (recorder *recorder) add(counter *int64) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	*counter++
+}
+
+func (recorder *recorder) recordLatency(latency time.Duration) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if latency > recorder.maxLatency {
+		recorder.maxLatency = latency
+	}
+	if latency > maxRecordedLatency {
+		latency = maxRecordedLatency
+	}
+	recorder.latencies[latency/time.Millisecond]++
+	recorder.latencyCount++
+}
+
+// percentile returns the latency below which the given fraction of all
+// recorded latencies lie, with millisecond precision.
+func (recorder *recorder) percentile(fraction float64) time.Duration {
+	if recorder.latencyCount == 0 {
+		return 0
+	}
+
+	threshold := int64(fraction * float64(recorder.latencyCount))
+	var count int64
+	for millis, amount := range recorder.latencies {
+		count += amount
+		if count > threshold {
+			return time.Duration(millis) * time.Millisecond
+		}
+	}
+	return maxRecordedLatency
+}
+
+func (recorder *recorder) report(config Config, duration time.Duration) *Report {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return &Report{
+		Lobbies:        config.Lobbies,
+		Players:        config.Lobbies * config.PlayersPerLobby,
+		Duration:       duration,
+		Turns:          recorder.turns,
+		EventsSent:     recorder.eventsSent,
+		EventsReceived: recorder.eventsReceived,
+		CorrectGuesses: recorder.correctGuesses,
+		Kicks:          recorder.kicks,
+		Errors:         recorder.errors,
+		LatencyP50:     recorder.percentile(0.5),
+		LatencyP95:     recorder.percentile(0.95),
+		LatencyP99:     recorder.percentile(0.99),
+		LatencyMax:     recorder.maxLatency,
+	}
+}
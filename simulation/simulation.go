@@ -0,0 +1,277 @@
+// Package simulation runs lobbies full of bots inside of the current
+// process, in order to measure the throughput and latency of the server.
+// The bots use the same websocket protocol and HTTP endpoints as the
+// official web client, so the whole path of an event is covered. They draw,
+// guess and kick each other, just like real players would.
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/client"
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/state"
+
+	//Registers the HTTP endpoints.
+	"github.com/scribble-rs/scribble.rs/communication"
+)
+
+// Config defines the size of a simulation and the behaviour of its bots.
+type Config struct {
+	// Lobbies is the amount of lobbies to run concurrently.
+	Lobbies int
+	// PlayersPerLobby is the amount of bots in each lobby, including the
+	// owner, who starts the game.
+	PlayersPerLobby int
+	// Duration is how long the bots play, not including the time it takes
+	// to join the lobbies.
+	Duration time.Duration
+	// DrawingTime is the drawing time of each turn in seconds.
+	DrawingTime int
+	// StrokeInterval is the time between two lines drawn by a drawer.
+	StrokeInterval time.Duration
+	// GuessDelay is the maximum time a bot takes for guessing the word
+	// after it has been chosen.
+	GuessDelay time.Duration
+	// KickInterval is the time between two votekicks in each lobby. Kicked
+	// bots leave the simulation, as they are banned from the lobby. Zero
+	// disables kicking.
+	KickInterval time.Duration
+	// Seed is used for all decisions of the bots.
+	Seed int64
+}
+
+// DefaultConfig returns a config simulating a busy instance for a minute.
+func DefaultConfig() Config {
+	return Config{
+		Lobbies:         100,
+		PlayersPerLobby: 8,
+		Duration:        time.Minute,
+		DrawingTime:     60,
+		StrokeInterval:  30 * time.Millisecond,
+		GuessDelay:      20 * time.Second,
+		KickInterval:    30 * time.Second,
+		Seed:            time.Now().UnixNano(),
+	}
+}
+
+func (config Config) validate() error {
+	bounds := game.GetSettingBounds()
+	if config.Lobbies < 1 {
+		return errors.New("at least one lobby is required")
+	}
+	if int64(config.PlayersPerLobby) < bounds.MinMaxPlayers || int64(config.PlayersPerLobby) > bounds.MaxMaxPlayers {
+		return fmt.Errorf("the players per lobby must be between %d and %d", bounds.MinMaxPlayers, bounds.MaxMaxPlayers)
+	}
+	if config.Duration <= 0 || config.StrokeInterval <= 0 || config.GuessDelay <= 0 {
+		return errors.New("the duration, stroke interval and guess delay must be positive")
+	}
+	return nil
+}
+
+// simulatedLobby is shared by all bots of a lobby. Since the bots cheat in
+// order to be able to guess the word, the drawer shares it here. The time
+// each line has been drawn is shared as well, so that the other bots can
+// measure the latency.
+type simulatedLobby struct {
+	config   Config
+	recorder *recorder
+
+	mutex *sync.Mutex
+	word  string
+	// strokes maps the color of each line drawn during the current turn to
+	// the time it has been sent. Each line has a unique color.
+	strokes     map[string]time.Time
+	strokeCount int
+	bots        []*bot
+}
+
+// Run creates the lobbies, lets the bots play for the configured duration
+// and reports the results. The HTTP server the bots connect to is started
+// on a random local port and stopped afterwards.
+func Run(config Config) (*Report, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	server := httptest.NewServer(http.DefaultServeMux)
+	defer server.Close()
+
+	recorder := newRecorder()
+	random := rand.New(rand.NewSource(config.Seed))
+	stop := make(chan struct{})
+	waitGroup := &sync.WaitGroup{}
+
+	var lobbies []*game.Lobby
+	defer func() {
+		close(stop)
+		waitGroup.Wait()
+		for _, lobby := range lobbies {
+			game.CloseLobby(lobby, "The simulation is over.")
+		}
+	}()
+
+	for index := 0; index < config.Lobbies; index++ {
+		lobby, err := startLobby(config, server.URL, index, recorder, random, stop, waitGroup)
+		if lobby != nil {
+			lobbies = append(lobbies, lobby)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	time.Sleep(config.Duration)
+	return recorder.report(config, time.Since(start)), nil
+}
+
+// startLobby creates a lobby and connects its bots. The owner connects last
+// and starts the game right away.
+func startLobby(config Config, serverURL string, index int, recorder *recorder, random *rand.Rand, stop <-chan struct{}, waitGroup *sync.WaitGroup) (*game.Lobby, error) {
+	owner, lobby, err := communication.GameServer().CreateLobby(fmt.Sprintf("bot-%d-0", index), "english", false,
+		config.DrawingTime, int(game.GetSettingBounds().MaxRounds), config.PlayersPerLobby, 0, config.PlayersPerLobby, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	state.AddLobby(lobby)
+
+	simulated := &simulatedLobby{
+		config:   config,
+		recorder: recorder,
+		mutex:    &sync.Mutex{},
+		strokes:  make(map[string]time.Time),
+	}
+
+	for player := 1; player < config.PlayersPerLobby; player++ {
+		name := fmt.Sprintf("bot-%d-%d", index, player)
+		connection, joinError := client.Join(serverURL, lobby.ID, name)
+		if joinError != nil {
+			return lobby, fmt.Errorf("error joining lobby %s: %s", lobby.ID, joinError)
+		}
+		simulated.addBot(newBot(simulated, name, false, connection, random.Int63()), stop, waitGroup)
+	}
+
+	connection, connectError := client.Connect(serverURL, &client.LobbyData{LobbyID: lobby.ID}, communication.SessionToken(lobby, owner))
+	if connectError != nil {
+		return lobby, fmt.Errorf("error connecting owner to lobby %s: %s", lobby.ID, connectError)
+	}
+	simulated.addBot(newBot(simulated, owner.Name, true, connection, random.Int63()), stop, waitGroup)
+
+	if config.KickInterval > 0 {
+		kickRandom := rand.New(rand.NewSource(random.Int63()))
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			simulated.kickRegularly(kickRandom, stop)
+		}()
+	}
+
+	return lobby, nil
+}
+
+func (lobby *simulatedLobby) addBot(bot *bot, stop <-chan struct{}, waitGroup *sync.WaitGroup) {
+	lobby.mutex.Lock()
+	lobby.bots = append(lobby.bots, bot)
+	lobby.mutex.Unlock()
+
+	waitGroup.Add(1)
+	go func() {
+		defer waitGroup.Done()
+		bot.run(stop)
+	}()
+}
+
+func (lobby *simulatedLobby) removeBot(bot *bot) {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+
+	for index, otherBot := range lobby.bots {
+		if otherBot == bot {
+			lobby.bots = append(lobby.bots[:index], lobby.bots[index+1:]...)
+			return
+		}
+	}
+}
+
+// kickRegularly lets all bots vote for kicking a random bot, except for the
+// owner, who has to stay in order to restart the game. Kicking stops once
+// there are only two bots left.
+func (lobby *simulatedLobby) kickRegularly(random *rand.Rand, stop <-chan struct{}) {
+	kickTicker := time.NewTicker(lobby.config.KickInterval)
+	defer kickTicker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-kickTicker.C:
+		}
+
+		lobby.mutex.Lock()
+		bots := append([]*bot(nil), lobby.bots...)
+		lobby.mutex.Unlock()
+		if len(bots) <= 2 {
+			return
+		}
+
+		target := bots[random.Intn(len(bots))]
+		targetID := target.getPlayerID()
+		if target.owner || targetID == "" {
+			continue
+		}
+
+		for _, voter := range bots {
+			if voter != target {
+				voter.send(func(connection *client.Client) error {
+					return connection.VoteKick(targetID)
+				})
+			}
+		}
+	}
+}
+
+// setWord shares the word chosen by the drawer and forgets about the lines
+// of the previous turn.
+func (lobby *simulatedLobby) setWord(word string) {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+
+	lobby.word = word
+	lobby.strokes = make(map[string]time.Time)
+}
+
+func (lobby *simulatedLobby) getWord() string {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+
+	return lobby.word
+}
+
+// nextStroke returns a color that hasn't been used before in this turn and
+// remembers when the line using it has been sent.
+func (lobby *simulatedLobby) nextStroke() string {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+
+	lobby.strokeCount++
+	color := fmt.Sprintf("#%06x", lobby.strokeCount%0x1000000)
+	lobby.strokes[color] = time.Now()
+	return color
+}
+
+// strokeSentAt returns the time the line with the given color has been
+// sent, if it's known.
+func (lobby *simulatedLobby) strokeSentAt(color string) (time.Time, bool) {
+	lobby.mutex.Lock()
+	defer lobby.mutex.Unlock()
+
+	sentAt, known := lobby.strokes[color]
+	return sentAt, known
+}
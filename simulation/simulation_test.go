@@ -0,0 +1,60 @@
+package simulation
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Run(t *testing.T) {
+	report, err := Run(Config{
+		Lobbies:         2,
+		PlayersPerLobby: 4,
+		Duration:        3 * time.Second,
+		DrawingTime:     60,
+		StrokeInterval:  20 * time.Millisecond,
+		GuessDelay:      time.Second,
+		KickInterval:    time.Second,
+		Seed:            1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Players != 8 || report.Turns < 2 {
+		t.Errorf("expected both lobbies to play, got %d players and %d turns", report.Players, report.Turns)
+	}
+	if report.EventsReceived <= report.EventsSent || report.LatencyMax == 0 {
+		t.Errorf("expected drawn lines to be broadcasted, got %d sent and %d received events", report.EventsSent, report.EventsReceived)
+	}
+	if report.Kicks == 0 {
+		t.Error("expected bots to be kicked")
+	}
+	if report.Errors != 0 {
+		t.Errorf("expected no errors, got %d", report.Errors)
+	}
+}
+
+func Test_recorder_percentile(t *testing.T) {
+	recorder := newRecorder()
+	for millis := 1; millis <= 100; millis++ {
+		recorder.recordLatency(time.Duration(millis) * time.Millisecond)
+	}
+	recorder.recordLatency(time.Minute)
+
+	tests := []struct {
+		fraction float64
+		want     time.Duration
+	}{
+		{0.5, 51 * time.Millisecond},
+		{0.99, 100 * time.Millisecond},
+		{1, maxRecordedLatency},
+	}
+	for _, tt := range tests {
+		if got := recorder.percentile(tt.fraction); got != tt.want {
+			t.Errorf("percentile(%v) = %s, want %s", tt.fraction, got, tt.want)
+		}
+	}
+	if recorder.maxLatency != time.Minute {
+		t.Errorf("expected the exact maximum to be kept, got %s", recorder.maxLatency)
+	}
+}
@@ -0,0 +1,161 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// ErrAccountNotExistent is returned if no account matches the query.
+var ErrAccountNotExistent = errors.New("the requested account does not exist")
+
+// Account is the persistent identity of a player that has logged in via one
+// of the OAuth providers. Players without an account stay anonymous, as
+// before.
+type Account struct {
+	ID string `json:"id"`
+	// Provider is the name of the OAuth provider used for logging in, for
+	// example discord or google.
+	Provider string `json:"provider"`
+	// Subject is the ID of the user at the provider. Together with the
+	// provider, it identifies the account when logging in again.
+	Subject string `json:"subject"`
+	// Name is used as the player name in all lobbies. It defaults to the
+	// name at the provider, but can be changed.
+	Name string `json:"name"`
+	// Avatar is the URL of the users profile picture at the provider.
+	Avatar  string    `json:"avatar,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// NewAccount creates an account with a new ID for the given login.
+func NewAccount(provider, subject, name, avatar string) *Account {
+	return &Account{
+		ID:       uuid.Must(uuid.NewV4()).String(),
+		Provider: provider,
+		Subject:  subject,
+		Name:     name,
+		Avatar:   avatar,
+		Created:  time.Now(),
+	}
+}
+
+// AccountStore keeps the accounts of all players that have logged in.
+type AccountStore interface {
+	// Get returns the account with the given ID.
+	Get(id string) (*Account, error)
+	// FindByLogin returns the account created for the given user of the
+	// provider.
+	FindByLogin(provider, subject string) (*Account, error)
+	// Save creates or replaces the account.
+	Save(account *Account) error
+}
+
+// MemoryAccountStore keeps the accounts in memory, so they are lost when
+// restarting. If Path is set, the accounts are read from and written to that
+// JSON file, see NewFileAccountStore.
+type MemoryAccountStore struct {
+	Path string
+
+	mutex    sync.Mutex
+	accounts map[string]*Account
+}
+
+// NewFileAccountStore reads all accounts from the given file. Each change is
+// written back to it. If the file doesn't exist yet, it's created once the
+// first account has been saved.
+func NewFileAccountStore(path string) (*MemoryAccountStore, error) {
+	store := &MemoryAccountStore{
+		Path:     path,
+		accounts: make(map[string]*Account),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []*Account
+	if err := json.Unmarshal(content, &accounts); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		store.accounts[account.ID] = account
+	}
+	return store, nil
+}
+
+// Get returns a copy of the account, so that it can't be changed without
+// saving it.
+func (store *MemoryAccountStore) Get(id string) (*Account, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	account, found := store.accounts[id]
+	if !found {
+		return nil, ErrAccountNotExistent
+	}
+	copied := *account
+	return &copied, nil
+}
+
+// FindByLogin searches through all accounts, which is fine for the amount of
+// players a single instance has.
+func (store *MemoryAccountStore) FindByLogin(provider, subject string) (*Account, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, account := range store.accounts {
+		if account.Provider == provider && account.Subject == subject {
+			copied := *account
+			return &copied, nil
+		}
+	}
+	return nil, ErrAccountNotExistent
+}
+
+// Save stores a copy of the account and writes all accounts to the file, if
+// there is one.
+func (store *MemoryAccountStore) Save(account *Account) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.accounts == nil {
+		store.accounts = make(map[string]*Account)
+	}
+	copied := *account
+	store.accounts[account.ID] = &copied
+
+	if store.Path == "" {
+		return nil
+	}
+	return store.write()
+}
+
+// write replaces the file the same way FileLobbyStore does, so that it's
+// never only partially written. It must only be called while holding the
+// mutex.
+func (store *MemoryAccountStore) write() error {
+	accounts := make([]*Account, 0, len(store.accounts))
+	for _, account := range store.accounts {
+		accounts = append(accounts, account)
+	}
+	content, err := json.Marshal(accounts)
+	if err != nil {
+		return err
+	}
+
+	temporaryPath := store.Path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, store.Path)
+}
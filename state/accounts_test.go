@@ -0,0 +1,47 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_FileAccountStore(t *testing.T) {
+	directory, err := ioutil.TempDir("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	path := filepath.Join(directory, "accounts.json")
+
+	store, err := NewFileAccountStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindByLogin("discord", "1234"); err != ErrAccountNotExistent {
+		t.Errorf("expected ErrAccountNotExistent for an empty store, got %v", err)
+	}
+
+	account := NewAccount("discord", "1234", "marcel", "https://example.com/avatar.png")
+	if err := store.Save(account); err != nil {
+		t.Fatal(err)
+	}
+	//Changes aren't visible before saving.
+	account.Name = "renamed"
+	if stored, _ := store.Get(account.ID); stored == nil || stored.Name != "marcel" {
+		t.Errorf("unsaved change is visible: %+v", stored)
+	}
+
+	reopened, err := NewFileAccountStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := reopened.FindByLogin("discord", "1234")
+	if err != nil || found.ID != account.ID || found.Avatar != account.Avatar {
+		t.Errorf("account wasn't persisted, got %+v, %v", found, err)
+	}
+	if _, err := reopened.FindByLogin("google", "1234"); err != ErrAccountNotExistent {
+		t.Errorf("expected logins of other providers not to match, got %v", err)
+	}
+}
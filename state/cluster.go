@@ -0,0 +1,215 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// directoryEntryLifetime is how long an entry of the lobby directory is
+// valid without being updated. Instances refresh their entries regularly,
+// so entries of instances that have crashed disappear after this time.
+const directoryEntryLifetime = 30 * time.Second
+
+// DirectoryEntry describes a lobby hosted by an instance of the cluster.
+type DirectoryEntry struct {
+	LobbyID    string `json:"lobbyId"`
+	InstanceID string `json:"instanceId"`
+	// InstanceURL is the base URL under which the instance hosting the
+	// lobby can be reached directly.
+	InstanceURL string `json:"instanceUrl"`
+	Public      bool   `json:"public"`
+	// Listing is what the lobby browser shows for public lobbies.
+	Listing json.RawMessage `json:"listing,omitempty"`
+	// UpdatedAt is the unix timestamp in milliseconds of the last refresh.
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+func (entry *DirectoryEntry) isExpired(now time.Time) bool {
+	return now.Sub(time.Unix(0, entry.UpdatedAt*int64(time.Millisecond))) > directoryEntryLifetime
+}
+
+// ClusterMessage is sent between the instances of a cluster, for example
+// for moderating a lobby hosted by a different instance.
+type ClusterMessage struct {
+	// Type is either "announcement", "close-lobby" or "kick-player".
+	Type string `json:"type"`
+	// Sender is the ID of the instance that sent the message.
+	Sender   string `json:"sender"`
+	LobbyID  string `json:"lobbyId,omitempty"`
+	PlayerID string `json:"playerId,omitempty"`
+	Ban      bool   `json:"ban,omitempty"`
+	Text     string `json:"text,omitempty"`
+	// Admin is the name of the admin credential that caused the message.
+	Admin string `json:"admin,omitempty"`
+}
+
+// ClusterBackend allows several instances to share a directory of their
+// lobbies and to exchange messages. Each lobby is only hosted by the
+// instance that created it.
+type ClusterBackend interface {
+	// UpdateDirectory adds or refreshes the given entries.
+	UpdateDirectory(entries []*DirectoryEntry) error
+	// LookupLobby returns the entry of the given lobby. If there's none,
+	// nil is returned.
+	LookupLobby(lobbyID string) (*DirectoryEntry, error)
+	// ListLobbies returns the entries of all lobbies of the cluster.
+	ListLobbies() ([]*DirectoryEntry, error)
+	// Publish sends the message to all instances, including the sender.
+	Publish(message *ClusterMessage) error
+	// Subscribe passes all published messages to the handler until the
+	// connection is lost, which is returned as an error.
+	Subscribe(handler func(*ClusterMessage)) error
+}
+
+// RedisCluster is a ClusterBackend storing the directory as a hash and using
+// the pub/sub feature of Redis for messages.
+type RedisCluster struct {
+	*redisOptions
+	directoryKey string
+	channel      string
+}
+
+// NewRedisCluster creates a backend for the given URL, for example
+// redis://:password@localhost:6379/0. All instances of the cluster have to
+// use the same Redis database.
+func NewRedisCluster(rawURL string) (*RedisCluster, error) {
+	options, _, err := parseRedisURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisCluster{
+		redisOptions: options,
+		directoryKey: "scribblers:directory",
+		channel:      "scribblers:messages",
+	}, nil
+}
+
+// UpdateDirectory sets all entries with a single command.
+func (cluster *RedisCluster) UpdateDirectory(entries []*DirectoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	arguments := []string{"HSET", cluster.directoryKey}
+	for _, entry := range entries {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		arguments = append(arguments, entry.LobbyID, string(encoded))
+	}
+
+	connection, err := cluster.connect()
+	if err != nil {
+		return err
+	}
+	defer connection.close()
+
+	_, err = connection.do(arguments...)
+	return err
+}
+
+// LookupLobby ignores expired entries.
+func (cluster *RedisCluster) LookupLobby(lobbyID string) (*DirectoryEntry, error) {
+	connection, err := cluster.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer connection.close()
+
+	encoded, err := connection.do("HGET", cluster.directoryKey, lobbyID)
+	if err != nil || encoded == nil {
+		return nil, err
+	}
+
+	entry := &DirectoryEntry{}
+	if err := json.Unmarshal([]byte(*encoded), entry); err != nil {
+		return nil, err
+	}
+	if entry.isExpired(time.Now()) {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// ListLobbies removes expired entries from the directory.
+func (cluster *RedisCluster) ListLobbies() ([]*DirectoryEntry, error) {
+	connection, err := cluster.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer connection.close()
+
+	fields, err := connection.doArray("HGETALL", cluster.directoryKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var entries []*DirectoryEntry
+	expired := []string{"HDEL", cluster.directoryKey}
+	for i := 0; i+1 < len(fields); i += 2 {
+		entry := &DirectoryEntry{}
+		if err := json.Unmarshal([]byte(fields[i+1]), entry); err != nil || entry.isExpired(now) {
+			expired = append(expired, fields[i])
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(expired) > 2 {
+		if _, err := connection.do(expired...); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// Publish sends the message to the channel of the cluster.
+func (cluster *RedisCluster) Publish(message *ClusterMessage) error {
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	connection, err := cluster.connect()
+	if err != nil {
+		return err
+	}
+	defer connection.close()
+
+	_, err = connection.do("PUBLISH", cluster.channel, string(encoded))
+	return err
+}
+
+// Subscribe keeps a dedicated connection open for receiving messages.
+// Malformed messages are skipped.
+func (cluster *RedisCluster) Subscribe(handler func(*ClusterMessage)) error {
+	connection, err := cluster.connect()
+	if err != nil {
+		return err
+	}
+	defer connection.close()
+
+	if _, err := connection.doArray("SUBSCRIBE", cluster.channel); err != nil {
+		return err
+	}
+
+	//Messages can take arbitrarily long to arrive.
+	connection.connection.SetDeadline(time.Time{})
+	for {
+		reply, err := connection.readArrayReply()
+		if err != nil {
+			return err
+		}
+		if len(reply) != 3 || reply[0] != "message" {
+			continue
+		}
+
+		message := &ClusterMessage{}
+		if json.Unmarshal([]byte(reply[2]), message) == nil {
+			handler(message)
+		}
+	}
+}
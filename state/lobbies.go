@@ -1,16 +1,21 @@
 package state
 
 import (
-	"log"
+	"errors"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/scribble-rs/scribble.rs/game"
 )
 
+// ErrLobbyNotExistent is returned when looking up or removing a lobby that
+// isn't part of this instance.
+var ErrLobbyNotExistent = errors.New("the requested lobby doesn't exist")
+
 var (
-	createDeleteMutex               = &sync.Mutex{}
-	lobbies           []*game.Lobby = nil
+	lobbiesMutex = &sync.RWMutex{}
+	lobbies      = make(map[string]*game.Lobby)
 )
 
 func init() {
@@ -20,41 +25,60 @@ func init() {
 		for {
 			<-lobbyCleanupTicker.C
 
-			createDeleteMutex.Lock()
-
-			for index := len(lobbies) - 1; index >= 0; index-- {
-				lobby := lobbies[index]
+			//Closing a lobby removes it, which requires the mutex, therefore
+			//the abandoned lobbies are only collected while holding it.
+			var abandonedLobbies []*game.Lobby
+			lobbiesMutex.RLock()
+			for _, lobby := range lobbies {
 				if lobby.HasConnectedPlayers() {
 					continue
 				}
 
 				disconnectTime := lobby.LastPlayerDisconnectTime
 				if disconnectTime == nil || time.Since(*disconnectTime) >= 75*time.Second {
-					removeLobbyByIndex(index)
+					abandonedLobbies = append(abandonedLobbies, lobby)
 				}
 			}
+			lobbiesMutex.RUnlock()
 
-			createDeleteMutex.Unlock()
+			//The lobby has to be closed in order to stop its loop.
+			for _, lobby := range abandonedLobbies {
+				game.CloseLobby(lobby, "The lobby has been closed due to inactivity.")
+			}
 		}
 	}()
 }
 
 // AddLobby adds a lobby to the instance, making it visible for GetLobby calls.
 func AddLobby(lobby *game.Lobby) {
-	createDeleteMutex.Lock()
-	defer createDeleteMutex.Unlock()
+	lobbiesMutex.Lock()
+	defer lobbiesMutex.Unlock()
+
+	lobbies[lobby.ID] = lobby
+}
+
+// GetLobby returns the Lobby that has a matching ID. If there's none,
+// ErrLobbyNotExistent is returned.
+func GetLobby(id string) (*game.Lobby, error) {
+	lobbiesMutex.RLock()
+	defer lobbiesMutex.RUnlock()
+
+	lobby, available := lobbies[id]
+	if !available {
+		return nil, ErrLobbyNotExistent
+	}
 
-	lobbies = append(lobbies, lobby)
+	return lobby, nil
 }
 
-// GetLobby returns a Lobby that has a matching ID or no Lobby if none could
-// be found.
-func GetLobby(id string) *game.Lobby {
-	createDeleteMutex.Lock()
-	defer createDeleteMutex.Unlock()
+// GetLobbyByInviteToken returns the Lobby that the given invite token is
+// valid for or no Lobby if the token is invalid.
+func GetLobbyByInviteToken(token string) *game.Lobby {
+	lobbiesMutex.RLock()
+	defer lobbiesMutex.RUnlock()
 
 	for _, l := range lobbies {
-		if l.ID == id {
+		if l.HasValidInviteToken(token) {
 			return l
 		}
 	}
@@ -66,18 +90,46 @@ func GetLobby(id string) *game.Lobby {
 // both private and public lobbies and it doesn't matter whether the game is
 // already over, hasn't even started or is still ongoing.
 func GetActiveLobbyCount() int {
-	createDeleteMutex.Lock()
-	defer createDeleteMutex.Unlock()
+	lobbiesMutex.RLock()
+	defer lobbiesMutex.RUnlock()
 
 	return len(lobbies)
 }
 
+// GetConnectedPlayerCount returns the amount of players that are currently
+// connected to any lobby.
+func GetConnectedPlayerCount() int {
+	lobbiesMutex.RLock()
+	defer lobbiesMutex.RUnlock()
+
+	var count int
+	for _, lobby := range lobbies {
+		count += lobby.GetConnectedPlayerCount()
+	}
+	return count
+}
+
+// GetLobbies returns all lobbies of the instance, ordered by their ID.
+func GetLobbies() []*game.Lobby {
+	lobbiesMutex.RLock()
+	defer lobbiesMutex.RUnlock()
+
+	result := make([]*game.Lobby, 0, len(lobbies))
+	for _, lobby := range lobbies {
+		result = append(result, lobby)
+	}
+
+	sortLobbies(result)
+	return result
+}
+
 // GetPublicLobbies returns all lobbies with their public flag set to true.
 // This implies that the lobbies can be found in the lobby browser ob the
-// homepage.
+// homepage. The lobbies are ordered by their ID, so that the lobby browser
+// doesn't reorder them every time it's refreshed.
 func GetPublicLobbies() []*game.Lobby {
-	createDeleteMutex.Lock()
-	defer createDeleteMutex.Unlock()
+	lobbiesMutex.RLock()
+	defer lobbiesMutex.RUnlock()
 
 	var publicLobbies []*game.Lobby
 	for _, lobby := range lobbies {
@@ -86,33 +138,28 @@ func GetPublicLobbies() []*game.Lobby {
 		}
 	}
 
+	sortLobbies(publicLobbies)
 	return publicLobbies
 }
 
-// RemoveLobby deletes a lobby, not allowing anyone to connect to it again.
-func RemoveLobby(id string) {
-	createDeleteMutex.Lock()
-	defer createDeleteMutex.Unlock()
-
-	removeLobby(id)
+func sortLobbies(lobbies []*game.Lobby) {
+	sort.Slice(lobbies, func(a, b int) bool {
+		return lobbies[a].ID < lobbies[b].ID
+	})
 }
 
-func removeLobby(id string) {
-	indexToDelete := -1
-	for index, l := range lobbies {
-		if l.ID == id {
-			indexToDelete = index
-			break
-		}
-	}
-
-	if indexToDelete != -1 {
-		removeLobbyByIndex(indexToDelete)
+// RemoveLobby deletes a lobby, not allowing anyone to connect to it again.
+// If the lobby isn't part of the instance, ErrLobbyNotExistent is returned.
+func RemoveLobby(id string) error {
+	lobbiesMutex.Lock()
+	defer lobbiesMutex.Unlock()
+
+	lobby, available := lobbies[id]
+	if !available {
+		return ErrLobbyNotExistent
 	}
-}
 
-func removeLobbyByIndex(indexToDelete int) {
-	lobby := lobbies[indexToDelete]
-	lobbies = append(lobbies[:indexToDelete], lobbies[indexToDelete+1:]...)
-	log.Printf("Closing lobby %s. There are currently %d open lobbies left.\n", lobby.ID, len(lobbies))
+	delete(lobbies, id)
+	lobby.Logger().Info("closing lobby", "remainingLobbies", len(lobbies))
+	return nil
 }
@@ -0,0 +1,39 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+func Test_lobbyRegistry(t *testing.T) {
+	first := &game.Lobby{ID: "b"}
+	second := &game.Lobby{ID: "a"}
+	AddLobby(first)
+	AddLobby(second)
+
+	if lobby, err := GetLobby("b"); err != nil || lobby != first {
+		t.Errorf("expected lobby b to be found, got %v, %v", lobby, err)
+	}
+	if _, err := GetLobby("c"); err != ErrLobbyNotExistent {
+		t.Errorf("expected ErrLobbyNotExistent for unknown lobby, got %v", err)
+	}
+	if lobbies := GetLobbies(); len(lobbies) != 2 || lobbies[0] != second || lobbies[1] != first {
+		t.Errorf("expected lobbies to be ordered by ID, got %v", lobbies)
+	}
+
+	if err := RemoveLobby("b"); err != nil {
+		t.Errorf("removing lobby b failed: %s", err)
+	}
+	if err := RemoveLobby("b"); err != ErrLobbyNotExistent {
+		t.Errorf("expected ErrLobbyNotExistent when removing twice, got %v", err)
+	}
+	if _, err := GetLobby("b"); err != ErrLobbyNotExistent {
+		t.Errorf("expected removed lobby to be gone, got %v", err)
+	}
+
+	RemoveLobby("a")
+	if count := GetActiveLobbyCount(); count != 0 {
+		t.Errorf("expected no lobbies to be left, got %d", count)
+	}
+}
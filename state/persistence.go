@@ -0,0 +1,95 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/scribble-rs/scribble.rs/game"
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+// LobbyStore keeps the lobbies of the instance while it's restarting.
+type LobbyStore interface {
+	Save(snapshots []*game.LobbySnapshot) error
+	// Load returns the lobbies saved last. Afterwards they are removed, so
+	// that they can't be restored twice.
+	Load() ([]*game.LobbySnapshot, error)
+}
+
+// FileLobbyStore saves all lobbies into a single JSON file.
+type FileLobbyStore struct {
+	Path string
+}
+
+// Save writes a temporary file and then replaces the previous file, so
+// that it's never only partially written.
+func (store *FileLobbyStore) Save(snapshots []*game.LobbySnapshot) error {
+	content, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+
+	temporaryPath := store.Path + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temporaryPath, store.Path)
+}
+
+// Load reads and then deletes the file. If it doesn't exist, there aren't
+// any lobbies to restore.
+func (store *FileLobbyStore) Load() ([]*game.LobbySnapshot, error) {
+	content, err := ioutil.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*game.LobbySnapshot
+	if err := json.Unmarshal(content, &snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, os.Remove(store.Path)
+}
+
+// SaveLobbies suspends all lobbies and saves them to the store, so that
+// they can be restored via RestoreLobbies after a restart. Afterwards, the
+// instance doesn't have any lobbies left. The amount of saved lobbies is
+// returned.
+func SaveLobbies(store LobbyStore) (int, error) {
+	var snapshots []*game.LobbySnapshot
+	for _, lobby := range GetLobbies() {
+		if game.SuspendLobby(lobby) {
+			snapshots = append(snapshots, lobby.Snapshot())
+		}
+	}
+
+	return len(snapshots), store.Save(snapshots)
+}
+
+// RestoreLobbies adds all lobbies saved via SaveLobbies, recreating them via
+// the given server. Lobbies that can't be restored are skipped. The amount
+// of restored lobbies is returned.
+func RestoreLobbies(store LobbyStore, server *game.Server) (int, error) {
+	snapshots, err := store.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	var restored int
+	for _, snapshot := range snapshots {
+		lobby, restoreError := server.RestoreLobby(snapshot)
+		if restoreError != nil {
+			logging.Warn("error restoring lobby", "lobby", snapshot.ID, "error", restoreError)
+			continue
+		}
+
+		AddLobby(lobby)
+		restored++
+	}
+
+	return restored, nil
+}
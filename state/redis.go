@@ -0,0 +1,277 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+// redisTimeout limits connecting to Redis, as well as each command.
+const redisTimeout = 10 * time.Second
+
+// redisOptions contains everything needed for connecting to Redis.
+type redisOptions struct {
+	address  string
+	password string
+	database int
+}
+
+// parseRedisURL parses URLs such as redis://:password@localhost:6379/0. The
+// path optionally selects the database. The query parameters are returned
+// for settings specific to the caller.
+func parseRedisURL(rawURL string) (*redisOptions, url.Values, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parsed.Scheme != "redis" {
+		return nil, nil, fmt.Errorf("unsupported scheme '%s', expected redis", parsed.Scheme)
+	}
+
+	options := &redisOptions{address: parsed.Host}
+	if parsed.Port() == "" {
+		options.address = net.JoinHostPort(parsed.Hostname(), "6379")
+	}
+	if parsed.User != nil {
+		options.password, _ = parsed.User.Password()
+	}
+	if database := strings.Trim(parsed.Path, "/"); database != "" {
+		options.database, err = strconv.Atoi(database)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid redis database '%s'", database)
+		}
+	}
+
+	return options, parsed.Query(), nil
+}
+
+// RedisLobbyStore saves all lobbies as a single JSON value in Redis, so that
+// the instance can be restarted on a different host without losing games.
+// Only the few commands needed are implemented, therefore no client library
+// is required.
+type RedisLobbyStore struct {
+	*redisOptions
+	// key is the key the lobbies are stored under.
+	key string
+}
+
+// NewRedisLobbyStore creates a store for the given URL, for example
+// redis://:password@localhost:6379/0. The query parameter "key" changes the
+// key the lobbies are stored under, which defaults to "scribblers:lobbies".
+// Instances of a cluster pass their ID, which is appended to the key, so that
+// they don't overwrite each others lobbies.
+func NewRedisLobbyStore(rawURL, instanceID string) (*RedisLobbyStore, error) {
+	options, query, err := parseRedisURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &RedisLobbyStore{
+		redisOptions: options,
+		key:          "scribblers:lobbies",
+	}
+	if key := query.Get("key"); key != "" {
+		store.key = key
+	}
+	if instanceID != "" {
+		store.key = store.key + ":" + instanceID
+	}
+
+	return store, nil
+}
+
+// Save replaces the lobbies saved previously.
+func (store *RedisLobbyStore) Save(snapshots []*game.LobbySnapshot) error {
+	content, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+
+	connection, err := store.connect()
+	if err != nil {
+		return err
+	}
+	defer connection.close()
+
+	_, err = connection.do("SET", store.key, string(content))
+	return err
+}
+
+// Load reads and then deletes the saved lobbies.
+func (store *RedisLobbyStore) Load() ([]*game.LobbySnapshot, error) {
+	connection, err := store.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer connection.close()
+
+	content, err := connection.do("GET", store.key)
+	if err != nil || content == nil {
+		return nil, err
+	}
+
+	var snapshots []*game.LobbySnapshot
+	if err := json.Unmarshal([]byte(*content), &snapshots); err != nil {
+		return nil, err
+	}
+	_, err = connection.do("DEL", store.key)
+	return snapshots, err
+}
+
+// CheckHealth makes sure that Redis can be reached.
+func (store *RedisLobbyStore) CheckHealth() error {
+	connection, err := store.connect()
+	if err != nil {
+		return err
+	}
+	defer connection.close()
+
+	_, err = connection.do("PING")
+	return err
+}
+
+type redisConnection struct {
+	connection net.Conn
+	reader     *bufio.Reader
+}
+
+func (options *redisOptions) connect() (*redisConnection, error) {
+	connection, err := net.DialTimeout("tcp", options.address, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	redis := &redisConnection{connection: connection, reader: bufio.NewReader(connection)}
+	if options.password != "" {
+		if _, err := redis.do("AUTH", options.password); err != nil {
+			redis.close()
+			return nil, err
+		}
+	}
+	if options.database != 0 {
+		if _, err := redis.do("SELECT", strconv.Itoa(options.database)); err != nil {
+			redis.close()
+			return nil, err
+		}
+	}
+
+	return redis, nil
+}
+
+func (redis *redisConnection) close() {
+	redis.connection.Close()
+}
+
+// do sends a command and returns its reply. Nil is returned for replies
+// that don't contain a value.
+func (redis *redisConnection) do(arguments ...string) (*string, error) {
+	redis.connection.SetDeadline(time.Now().Add(redisTimeout))
+	if err := redis.send(arguments...); err != nil {
+		return nil, err
+	}
+
+	return redis.readReply()
+}
+
+// doArray sends a command whose reply is an array, such as HGETALL. Nil
+// elements are returned as empty strings.
+func (redis *redisConnection) doArray(arguments ...string) ([]string, error) {
+	redis.connection.SetDeadline(time.Now().Add(redisTimeout))
+	if err := redis.send(arguments...); err != nil {
+		return nil, err
+	}
+
+	return redis.readArrayReply()
+}
+
+func (redis *redisConnection) send(arguments ...string) error {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(arguments))
+	for _, argument := range arguments {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(argument), argument)
+	}
+	_, err := io.WriteString(redis.connection, command.String())
+	return err
+}
+
+func (redis *redisConnection) readLine() (string, error) {
+	line, err := redis.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return "", errors.New("empty reply from redis")
+	}
+	return line, nil
+}
+
+func (redis *redisConnection) readArrayReply() ([]string, error) {
+	line, err := redis.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line[0] == '-' {
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	}
+	count, err := strconv.Atoi(line[1:])
+	if line[0] != '*' || err != nil {
+		return nil, fmt.Errorf("expected array reply from redis, got: %s", line)
+	}
+
+	elements := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		element, err := redis.readReply()
+		if err != nil {
+			return nil, err
+		}
+		if element == nil {
+			elements = append(elements, "")
+		} else {
+			elements = append(elements, *element)
+		}
+	}
+	return elements, nil
+}
+
+func (redis *redisConnection) readReply() (*string, error) {
+	line, err := redis.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+', ':':
+		value := line[1:]
+		return &value, nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply from redis: %s", line)
+		}
+		if length < 0 {
+			return nil, nil
+		}
+
+		//The value is followed by a line break.
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(redis.reader, value); err != nil {
+			return nil, err
+		}
+		result := string(value[:length])
+		return &result, nil
+	default:
+		return nil, fmt.Errorf("unsupported reply from redis: %s", line)
+	}
+}
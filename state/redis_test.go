@@ -0,0 +1,209 @@
+package state
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/game"
+)
+
+// serveFakeRedis answers GET, SET, DEL, AUTH, PING and the hash commands
+// using in-memory maps, for as many connections as the test opens.
+func serveFakeRedis(listener net.Listener, password string) {
+	values := make(map[string]string)
+	hashes := make(map[string]map[string]string)
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		reader := bufio.NewReader(connection)
+		for {
+			arguments, err := readFakeRedisCommand(reader)
+			if err != nil {
+				break
+			}
+
+			switch strings.ToUpper(arguments[0]) {
+			case "AUTH":
+				if arguments[1] == password {
+					io.WriteString(connection, "+OK\r\n")
+				} else {
+					io.WriteString(connection, "-WRONGPASS invalid password\r\n")
+				}
+			case "PING":
+				io.WriteString(connection, "+PONG\r\n")
+			case "SET":
+				values[arguments[1]] = arguments[2]
+				io.WriteString(connection, "+OK\r\n")
+			case "GET":
+				if value, available := values[arguments[1]]; available {
+					fmt.Fprintf(connection, "$%d\r\n%s\r\n", len(value), value)
+				} else {
+					io.WriteString(connection, "$-1\r\n")
+				}
+			case "DEL":
+				delete(values, arguments[1])
+				io.WriteString(connection, ":1\r\n")
+			case "HSET":
+				if hashes[arguments[1]] == nil {
+					hashes[arguments[1]] = make(map[string]string)
+				}
+				for i := 2; i+1 < len(arguments); i += 2 {
+					hashes[arguments[1]][arguments[i]] = arguments[i+1]
+				}
+				io.WriteString(connection, ":1\r\n")
+			case "HGET":
+				if value, available := hashes[arguments[1]][arguments[2]]; available {
+					fmt.Fprintf(connection, "$%d\r\n%s\r\n", len(value), value)
+				} else {
+					io.WriteString(connection, "$-1\r\n")
+				}
+			case "HGETALL":
+				fmt.Fprintf(connection, "*%d\r\n", 2*len(hashes[arguments[1]]))
+				for field, value := range hashes[arguments[1]] {
+					fmt.Fprintf(connection, "$%d\r\n%s\r\n$%d\r\n%s\r\n", len(field), field, len(value), value)
+				}
+			case "HDEL":
+				for _, field := range arguments[2:] {
+					delete(hashes[arguments[1]], field)
+				}
+				io.WriteString(connection, ":1\r\n")
+			default:
+				io.WriteString(connection, "-ERR unknown command\r\n")
+			}
+		}
+		connection.Close()
+	}
+}
+
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+	arguments := make([]string, count)
+	for i := range arguments {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, value); err != nil {
+			return nil, err
+		}
+		arguments[i] = string(value[:length])
+	}
+	return arguments, nil
+}
+
+func Test_RedisLobbyStore(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveFakeRedis(listener, "secret")
+
+	store, err := NewRedisLobbyStore("redis://:secret@"+listener.Addr().String()+"?key=test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.key != "test" {
+		t.Errorf("expected key from the URL to be used, got %s", store.key)
+	}
+	if err := store.CheckHealth(); err != nil {
+		t.Errorf("expected store to be healthy: %s", err)
+	}
+
+	if err := store.Save([]*game.LobbySnapshot{{ID: "a"}, {ID: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].ID != "a" || snapshots[1].ID != "b" {
+		t.Errorf("expected saved lobbies to be loaded, got %v", snapshots)
+	}
+
+	//Lobbies are only restored once.
+	if snapshots, err := store.Load(); err != nil || snapshots != nil {
+		t.Errorf("expected no lobbies after loading them, got %v, %v", snapshots, err)
+	}
+
+	store.password = "wrong"
+	if err := store.CheckHealth(); err == nil {
+		t.Errorf("wrong password has been accepted")
+	}
+}
+
+func Test_NewRedisLobbyStore(t *testing.T) {
+	store, err := NewRedisLobbyStore("redis://localhost/2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.address != "localhost:6379" || store.database != 2 || store.key != "scribblers:lobbies" {
+		t.Errorf("unexpected store %+v", store)
+	}
+
+	//Instances of a cluster mustn't share their lobbies.
+	store, err = NewRedisLobbyStore("redis://localhost/2?key=custom", "instance-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.key != "custom:instance-1" {
+		t.Errorf("expected the instance ID to be part of the key, got %s", store.key)
+	}
+
+	if _, err := NewRedisLobbyStore("http://localhost", ""); err == nil {
+		t.Errorf("wrong scheme has been accepted")
+	}
+}
+
+func Test_RedisCluster_directory(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveFakeRedis(listener, "")
+
+	cluster, err := NewRedisCluster("redis://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if err := cluster.UpdateDirectory([]*DirectoryEntry{
+		{LobbyID: "fresh", InstanceID: "a", UpdatedAt: now},
+		{LobbyID: "stale", InstanceID: "b", UpdatedAt: now - 60000},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry, err := cluster.LookupLobby("fresh"); err != nil || entry == nil || entry.InstanceID != "a" {
+		t.Errorf("expected fresh lobby to be found, got %v, %v", entry, err)
+	}
+	if entry, err := cluster.LookupLobby("stale"); err != nil || entry != nil {
+		t.Errorf("expected stale lobby to be ignored, got %v, %v", entry, err)
+	}
+
+	entries, err := cluster.ListLobbies()
+	if err != nil || len(entries) != 1 || entries[0].LobbyID != "fresh" {
+		t.Errorf("expected only the fresh lobby to be listed, got %v, %v", entries, err)
+	}
+	if entry, _ := cluster.LookupLobby("stale"); entry != nil {
+		t.Errorf("expected stale lobby to be removed")
+	}
+}
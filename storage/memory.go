@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// maxMemoryGames is the amount of games the memory store remembers. Older
+// games are forgotten, so that the memory usage doesn't keep growing.
+const maxMemoryGames = 1000
+
+// Memory keeps everything in memory, except for the accounts, which are
+// handled by the given account store. It's used unless a storage URL has
+// been configured.
+type Memory struct {
+	state.AccountStore
+
+	mutex   sync.Mutex
+	games   []*GameRecord
+	bans    []*Ban
+	presets map[string]*Preset
+}
+
+// NewMemory creates an empty store, using the given store for accounts.
+func NewMemory(accounts state.AccountStore) *Memory {
+	return &Memory{
+		AccountStore: accounts,
+		presets:      make(map[string]*Preset),
+	}
+}
+
+// SaveGame remembers the game, forgetting the oldest one if necessary.
+func (memory *Memory) SaveGame(record *GameRecord) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	memory.games = append(memory.games, record)
+	if len(memory.games) > maxMemoryGames {
+		memory.games = memory.games[len(memory.games)-maxMemoryGames:]
+	}
+	return nil
+}
+
+// GamesOfAccount searches the remembered games, the newest one first.
+func (memory *Memory) GamesOfAccount(accountID string, limit int) ([]*GameRecord, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var games []*GameRecord
+	for index := len(memory.games) - 1; index >= 0 && len(games) < limit; index-- {
+		if memory.games[index].hasAccount(accountID) {
+			games = append(games, memory.games[index])
+		}
+	}
+	return games, nil
+}
+
+// SaveBan adds the ban or replaces the one with the same ID.
+func (memory *Memory) SaveBan(ban *Ban) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	copied := *ban
+	for index, existing := range memory.bans {
+		if existing.ID == ban.ID {
+			memory.bans[index] = &copied
+			return nil
+		}
+	}
+	memory.bans = append(memory.bans, &copied)
+	return nil
+}
+
+// RemoveBan lifts the ban.
+func (memory *Memory) RemoveBan(id string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for index, ban := range memory.bans {
+		if ban.ID == id {
+			memory.bans = append(memory.bans[:index], memory.bans[index+1:]...)
+			return nil
+		}
+	}
+	return ErrBanNotExistent
+}
+
+// Bans returns copies of all bans, the newest one first.
+func (memory *Memory) Bans() ([]*Ban, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	bans := make([]*Ban, 0, len(memory.bans))
+	for index := len(memory.bans) - 1; index >= 0; index-- {
+		copied := *memory.bans[index]
+		bans = append(bans, &copied)
+	}
+	return bans, nil
+}
+
+// FindBan returns the first matching ban.
+func (memory *Memory) FindBan(accountID, address string) (*Ban, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for _, ban := range memory.bans {
+		if ban.matches(accountID, address) {
+			copied := *ban
+			return &copied, nil
+		}
+	}
+	return nil, ErrBanNotExistent
+}
+
+// SavePreset adds the preset or replaces the one with the same ID.
+func (memory *Memory) SavePreset(preset *Preset) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	copied := *preset
+	memory.presets[preset.ID] = &copied
+	return nil
+}
+
+// Presets returns copies of the presets of the account.
+func (memory *Memory) Presets(accountID string) ([]*Preset, error) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	var presets []*Preset
+	for _, preset := range memory.presets {
+		if preset.AccountID == accountID {
+			copied := *preset
+			presets = append(presets, &copied)
+		}
+	}
+	sort.Slice(presets, func(a, b int) bool {
+		return presets[a].Name < presets[b].Name
+	})
+	return presets, nil
+}
+
+// RemovePreset deletes the preset, if it belongs to the account.
+func (memory *Memory) RemovePreset(accountID, id string) error {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	preset, found := memory.presets[id]
+	if !found || preset.AccountID != accountID {
+		return ErrPresetNotExistent
+	}
+	delete(memory.presets, id)
+	return nil
+}
+
+// Close does nothing, as there's nothing to release.
+func (memory *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build postgres
+// +build postgres
+
+package storage
+
+import (
+	"os"
+	"testing"
+)
+
+// Test_Postgres requires an empty database, which is passed via
+// SCRIBBLERS_TEST_POSTGRES_URL.
+func Test_Postgres(t *testing.T) {
+	url := os.Getenv("SCRIBBLERS_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("SCRIBBLERS_TEST_POSTGRES_URL isn't set")
+	}
+
+	store, err := Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	testStore(t, store)
+}
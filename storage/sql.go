@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// schema creates all tables, unless they exist already. It's understood by
+// SQLite and Postgres alike. Times are stored as milliseconds since the
+// epoch, since both databases treat timestamps differently.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS accounts (
+		id TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		name TEXT NOT NULL,
+		avatar TEXT NOT NULL,
+		created BIGINT NOT NULL,
+		UNIQUE (provider, subject)
+	)`,
+	`CREATE TABLE IF NOT EXISTS games (
+		id TEXT PRIMARY KEY,
+		lobby_id TEXT NOT NULL,
+		wordpack TEXT NOT NULL,
+		rounds INTEGER NOT NULL,
+		finished BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS game_players (
+		game_id TEXT NOT NULL REFERENCES games (id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		account_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		score INTEGER NOT NULL,
+		rank INTEGER NOT NULL,
+		PRIMARY KEY (game_id, position)
+	)`,
+	`CREATE INDEX IF NOT EXISTS game_players_account ON game_players (account_id)`,
+	`CREATE TABLE IF NOT EXISTS bans (
+		id TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		address TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		created BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS presets (
+		id TEXT PRIMARY KEY,
+		account_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		settings TEXT NOT NULL
+	)`,
+}
+
+// SQL is a store backed by a database/sql driver. The queries are written
+// with ? as placeholder and rewritten for databases using numbered
+// placeholders.
+type SQL struct {
+	db *sql.DB
+	// numberedPlaceholders is true for Postgres, which expects $1, $2 and
+	// so on.
+	numberedPlaceholders bool
+}
+
+// openSQL connects to the database and creates the tables.
+func openSQL(driver, dataSource string, numberedPlaceholders bool) (*SQL, error) {
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &SQL{db: db, numberedPlaceholders: numberedPlaceholders}
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func (store *SQL) rebind(query string) string {
+	if !store.numberedPlaceholders {
+		return query
+	}
+
+	var rebound strings.Builder
+	placeholder := 0
+	for _, character := range query {
+		if character == '?' {
+			placeholder++
+			rebound.WriteString("$" + strconv.Itoa(placeholder))
+		} else {
+			rebound.WriteRune(character)
+		}
+	}
+	return rebound.String()
+}
+
+func (store *SQL) exec(query string, arguments ...interface{}) error {
+	_, err := store.db.Exec(store.rebind(query), arguments...)
+	return err
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+const accountColumns = "id, provider, subject, name, avatar, created"
+
+func (store *SQL) queryAccount(condition string, arguments ...interface{}) (*state.Account, error) {
+	account := &state.Account{}
+	var created int64
+	err := store.db.QueryRow(store.rebind("SELECT "+accountColumns+" FROM accounts WHERE "+condition), arguments...).
+		Scan(&account.ID, &account.Provider, &account.Subject, &account.Name, &account.Avatar, &created)
+	if err == sql.ErrNoRows {
+		return nil, state.ErrAccountNotExistent
+	}
+	if err != nil {
+		return nil, err
+	}
+	account.Created = fromMillis(created)
+	return account, nil
+}
+
+// Get returns the account with the given ID.
+func (store *SQL) Get(id string) (*state.Account, error) {
+	return store.queryAccount("id = ?", id)
+}
+
+// FindByLogin returns the account created for the given user of the
+// provider.
+func (store *SQL) FindByLogin(provider, subject string) (*state.Account, error) {
+	return store.queryAccount("provider = ? AND subject = ?", provider, subject)
+}
+
+// Save creates or replaces the account.
+func (store *SQL) Save(account *state.Account) error {
+	return store.exec("INSERT INTO accounts ("+accountColumns+") VALUES (?, ?, ?, ?, ?, ?) "+
+		"ON CONFLICT (id) DO UPDATE SET name = excluded.name, avatar = excluded.avatar",
+		account.ID, account.Provider, account.Subject, account.Name, account.Avatar, toMillis(account.Created))
+}
+
+// SaveGame inserts the game and its players within a single transaction.
+func (store *SQL) SaveGame(record *GameRecord) error {
+	transaction, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := transaction.Exec(store.rebind("INSERT INTO games (id, lobby_id, wordpack, rounds, finished) VALUES (?, ?, ?, ?, ?)"),
+		record.ID, record.LobbyID, record.Wordpack, record.Rounds, toMillis(record.Finished)); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	for position, player := range record.Players {
+		if _, err := transaction.Exec(store.rebind("INSERT INTO game_players (game_id, position, account_id, name, score, rank) VALUES (?, ?, ?, ?, ?, ?)"),
+			record.ID, position, player.AccountID, player.Name, player.Score, player.Rank); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+// GamesOfAccount returns the latest games of the account, including all of
+// their players.
+func (store *SQL) GamesOfAccount(accountID string, limit int) ([]*GameRecord, error) {
+	rows, err := store.db.Query(store.rebind("SELECT id, lobby_id, wordpack, rounds, finished FROM games "+
+		"WHERE id IN (SELECT game_id FROM game_players WHERE account_id = ?) ORDER BY finished DESC LIMIT ?"),
+		accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []*GameRecord
+	for rows.Next() {
+		record := &GameRecord{}
+		var finished int64
+		if err := rows.Scan(&record.ID, &record.LobbyID, &record.Wordpack, &record.Rounds, &finished); err != nil {
+			return nil, err
+		}
+		record.Finished = fromMillis(finished)
+		games = append(games, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, record := range games {
+		if record.Players, err = store.gamePlayers(record.ID); err != nil {
+			return nil, err
+		}
+	}
+	return games, nil
+}
+
+func (store *SQL) gamePlayers(gameID string) ([]*GameRecordPlayer, error) {
+	rows, err := store.db.Query(store.rebind("SELECT account_id, name, score, rank FROM game_players WHERE game_id = ? ORDER BY position"), gameID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var players []*GameRecordPlayer
+	for rows.Next() {
+		player := &GameRecordPlayer{}
+		if err := rows.Scan(&player.AccountID, &player.Name, &player.Score, &player.Rank); err != nil {
+			return nil, err
+		}
+		players = append(players, player)
+	}
+	return players, rows.Err()
+}
+
+// SaveBan creates or replaces the ban.
+func (store *SQL) SaveBan(ban *Ban) error {
+	return store.exec("INSERT INTO bans (id, account_id, address, reason, created) VALUES (?, ?, ?, ?, ?) "+
+		"ON CONFLICT (id) DO UPDATE SET account_id = excluded.account_id, address = excluded.address, reason = excluded.reason",
+		ban.ID, ban.AccountID, ban.Address, ban.Reason, toMillis(ban.Created))
+}
+
+// RemoveBan lifts the ban with the given ID.
+func (store *SQL) RemoveBan(id string) error {
+	result, err := store.db.Exec(store.rebind("DELETE FROM bans WHERE id = ?"), id)
+	return requireAffected(result, err, ErrBanNotExistent)
+}
+
+func (store *SQL) queryBans(condition string, arguments ...interface{}) ([]*Ban, error) {
+	rows, err := store.db.Query(store.rebind("SELECT id, account_id, address, reason, created FROM bans "+condition), arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*Ban
+	for rows.Next() {
+		ban := &Ban{}
+		var created int64
+		if err := rows.Scan(&ban.ID, &ban.AccountID, &ban.Address, &ban.Reason, &created); err != nil {
+			return nil, err
+		}
+		ban.Created = fromMillis(created)
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+// Bans returns all bans, the newest one first.
+func (store *SQL) Bans() ([]*Ban, error) {
+	return store.queryBans("ORDER BY created DESC")
+}
+
+// FindBan returns a ban matching either the account or the address.
+func (store *SQL) FindBan(accountID, address string) (*Ban, error) {
+	bans, err := store.queryBans("WHERE (account_id <> '' AND account_id = ?) OR (address <> '' AND address = ?) LIMIT 1", accountID, address)
+	if err != nil {
+		return nil, err
+	}
+	if len(bans) == 0 {
+		return nil, ErrBanNotExistent
+	}
+	return bans[0], nil
+}
+
+// SavePreset creates or replaces the preset. The settings are stored as
+// JSON.
+func (store *SQL) SavePreset(preset *Preset) error {
+	settings, err := json.Marshal(preset.Settings)
+	if err != nil {
+		return err
+	}
+	return store.exec("INSERT INTO presets (id, account_id, name, settings) VALUES (?, ?, ?, ?) "+
+		"ON CONFLICT (id) DO UPDATE SET name = excluded.name, settings = excluded.settings",
+		preset.ID, preset.AccountID, preset.Name, string(settings))
+}
+
+// Presets returns the presets of the account, ordered by name.
+func (store *SQL) Presets(accountID string) ([]*Preset, error) {
+	rows, err := store.db.Query(store.rebind("SELECT id, name, settings FROM presets WHERE account_id = ? ORDER BY name"), accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var presets []*Preset
+	for rows.Next() {
+		preset := &Preset{AccountID: accountID}
+		var settings string
+		if err := rows.Scan(&preset.ID, &preset.Name, &settings); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(settings), &preset.Settings); err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+	return presets, rows.Err()
+}
+
+// RemovePreset deletes the preset, if it belongs to the account.
+func (store *SQL) RemovePreset(accountID, id string) error {
+	result, err := store.db.Exec(store.rebind("DELETE FROM presets WHERE id = ? AND account_id = ?"), id, accountID)
+	return requireAffected(result, err, ErrPresetNotExistent)
+}
+
+// requireAffected returns notFound if the statement didn't affect any rows.
+func requireAffected(result sql.Result, err error, notFound error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// Close closes the connection to the database.
+func (store *SQL) Close() error {
+	return store.db.Close()
+}
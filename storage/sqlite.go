@@ -0,0 +1,30 @@
+//go:build sqlite
+// +build sqlite
+
+package storage
+
+import (
+	"strings"
+
+	//Registers the sqlite3 driver, which requires cgo.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	backends["sqlite"] = openSQLite
+}
+
+// openSQLite opens the database file given by a URL such as
+// sqlite:///var/lib/scribblers/data.db or sqlite:data.db for a relative
+// path. The file is created if it doesn't exist yet.
+func openSQLite(url string) (Store, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(url, "sqlite:"), "//")
+	//The busy timeout prevents errors while another connection writes.
+	store, err := openSQL("sqlite3", "file:"+path+"?_busy_timeout=5000&_foreign_keys=on", false)
+	if err != nil {
+		return nil, err
+	}
+	//SQLite only allows a single writer at a time anyway.
+	store.db.SetMaxOpenConns(1)
+	return store, nil
+}
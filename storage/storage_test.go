@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/scribble-rs/scribble.rs/state"
+)
+
+// testStore makes sure that the store behaves like all other
+// implementations. It's run against every backend that has been built.
+func testStore(t *testing.T, store Store) {
+	account := state.NewAccount("discord", "1234", "marcel", "")
+	if err := store.Save(account); err != nil {
+		t.Fatal(err)
+	}
+	account.Name = "renamed"
+	if err := store.Save(account); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.FindByLogin("discord", "1234"); err != nil || found.ID != account.ID || found.Name != "renamed" {
+		t.Errorf("unexpected account %+v, %v", found, err)
+	}
+	if _, err := store.Get("unknown"); err != state.ErrAccountNotExistent {
+		t.Errorf("expected ErrAccountNotExistent, got %v", err)
+	}
+
+	first := NewGameRecord("lobby", "english", 3, []*GameRecordPlayer{
+		{Name: "anonymous", Score: 50, Rank: 2},
+		{AccountID: account.ID, Name: "renamed", Score: 100, Rank: 1},
+	})
+	second := NewGameRecord("lobby", "german", 2, []*GameRecordPlayer{{AccountID: account.ID, Name: "renamed", Rank: 1}})
+	second.Finished = first.Finished.Add(1000000000)
+	unrelated := NewGameRecord("other", "english", 1, []*GameRecordPlayer{{Name: "anonymous", Rank: 1}})
+	for _, record := range []*GameRecord{first, second, unrelated} {
+		if err := store.SaveGame(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	games, err := store.GamesOfAccount(account.ID, 10)
+	if err != nil || len(games) != 2 || games[0].ID != second.ID || games[1].ID != first.ID {
+		t.Fatalf("unexpected games %v, %v", games, err)
+	}
+	if players := games[1].Players; len(players) != 2 || players[0].AccountID != account.ID || players[1].Score != 50 {
+		t.Errorf("players aren't ordered by rank: %+v", players)
+	}
+	if games, _ := store.GamesOfAccount(account.ID, 1); len(games) != 1 {
+		t.Errorf("limit wasn't applied, got %d games", len(games))
+	}
+
+	addressBan := NewBan("", "10.0.0.1", "spam")
+	accountBan := NewBan(account.ID, "", "cheating")
+	for _, ban := range []*Ban{addressBan, accountBan} {
+		if err := store.SaveBan(ban); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ban, err := store.FindBan("", "10.0.0.1"); err != nil || ban.ID != addressBan.ID {
+		t.Errorf("address ban wasn't found: %+v, %v", ban, err)
+	}
+	if ban, err := store.FindBan(account.ID, "10.0.0.2"); err != nil || ban.ID != accountBan.ID {
+		t.Errorf("account ban wasn't found: %+v, %v", ban, err)
+	}
+	if _, err := store.FindBan("", ""); err != ErrBanNotExistent {
+		t.Errorf("empty values matched a ban: %v", err)
+	}
+	if err := store.RemoveBan(addressBan.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RemoveBan(addressBan.ID); err != ErrBanNotExistent {
+		t.Errorf("expected ErrBanNotExistent when removing twice, got %v", err)
+	}
+	if bans, err := store.Bans(); err != nil || len(bans) != 1 || bans[0].Reason != "cheating" {
+		t.Errorf("unexpected bans %v, %v", bans, err)
+	}
+
+	quick := NewPreset(account.ID, "quick", map[string]string{"rounds": "2"})
+	long := NewPreset(account.ID, "long", map[string]string{"rounds": "10", "drawing_time": "180"})
+	for _, preset := range []*Preset{quick, long} {
+		if err := store.SavePreset(preset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	presets, err := store.Presets(account.ID)
+	if err != nil || len(presets) != 2 || presets[0].Name != "long" || presets[0].Settings["drawing_time"] != "180" {
+		t.Errorf("unexpected presets %v, %v", presets, err)
+	}
+	if err := store.RemovePreset("someone-else", quick.ID); err != ErrPresetNotExistent {
+		t.Errorf("preset of another account has been removed: %v", err)
+	}
+	if err := store.RemovePreset(account.ID, quick.ID); err != nil {
+		t.Fatal(err)
+	}
+	if presets, _ := store.Presets(account.ID); len(presets) != 1 {
+		t.Errorf("preset hasn't been removed: %v", presets)
+	}
+}
+
+func Test_Memory(t *testing.T) {
+	testStore(t, NewMemory(&state.MemoryAccountStore{}))
+}
+
+func Test_Open(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{url: "data.db", wantErr: "invalid storage URL"},
+		{url: "mysql://localhost/scribblers", wantErr: "unknown storage 'mysql'"},
+	}
+	//Without the build tag, the error tells how to enable the backend.
+	if _, built := backends["postgres"]; !built {
+		tests = append(tests, struct {
+			url     string
+			wantErr string
+		}{url: "postgres://localhost/scribblers", wantErr: "-tags postgres"})
+	}
+	for _, tt := range tests {
+		if _, err := Open(tt.url); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Open(%q) error = %v, want %q", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func Test_rebind(t *testing.T) {
+	query := "SELECT id FROM bans WHERE account_id = ? OR address = ?"
+	if rebound := (&SQL{}).rebind(query); rebound != query {
+		t.Errorf("query has been changed: %s", rebound)
+	}
+	if rebound := (&SQL{numberedPlaceholders: true}).rebind(query); rebound != "SELECT id FROM bans WHERE account_id = $1 OR address = $2" {
+		t.Errorf("unexpected query %s", rebound)
+	}
+}
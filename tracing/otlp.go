@@ -0,0 +1,169 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding.
+type OTLPExporter struct {
+	// Endpoint is the URL spans are posted to, usually ending in
+	// "/v1/traces".
+	Endpoint string
+	// ServiceName identifies this server in the tracing backend.
+	ServiceName string
+	Client      *http.Client
+}
+
+// NewOTLPExporter creates an exporter posting to the given endpoint.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// The following types mirror the JSON mapping of the OTLP protobuf
+// messages. IDs are hex encoded and timestamps are strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+)
+
+// Export posts all given spans in a single request.
+func (exporter *OTLPExporter) Export(spans []*SpanData) error {
+	body, marshalError := json.Marshal(exporter.newRequest(spans))
+	if marshalError != nil {
+		return marshalError
+	}
+
+	response, requestError := exporter.Client.Post(exporter.Endpoint, "application/json", bytes.NewReader(body))
+	if requestError != nil {
+		return requestError
+	}
+	defer response.Body.Close()
+	//Draining the body allows reusing the connection.
+	io.Copy(ioutil.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("collector responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+func (exporter *OTLPExporter) newRequest(spans []*SpanData) *otlpRequest {
+	convertedSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		converted := otlpSpan{
+			TraceID:           hex.EncodeToString(span.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.SpanID[:]),
+			Name:              span.Name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        convertAttributes(span.Attributes),
+		}
+		if span.ParentSpanID != (SpanID{}) {
+			converted.ParentSpanID = hex.EncodeToString(span.ParentSpanID[:])
+		}
+		if span.Error != "" {
+			converted.Status = &otlpStatus{Code: otlpStatusCodeError, Message: span.Error}
+		}
+		convertedSpans = append(convertedSpans, converted)
+	}
+
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: convertAttributes([]Attribute{
+			{Key: "service.name", Value: exporter.ServiceName},
+		})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/scribble-rs/scribble.rs"},
+			Spans: convertedSpans,
+		}},
+	}}}
+}
+
+func convertAttributes(attributes []Attribute) []otlpAttribute {
+	converted := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value otlpValue
+		switch typed := attribute.Value.(type) {
+		case bool:
+			value.BoolValue = &typed
+		case int:
+			intValue := strconv.Itoa(typed)
+			value.IntValue = &intValue
+		case int64:
+			intValue := strconv.FormatInt(typed, 10)
+			value.IntValue = &intValue
+		case float64:
+			value.DoubleValue = &typed
+		default:
+			stringValue := fmt.Sprint(typed)
+			value.StringValue = &stringValue
+		}
+		converted = append(converted, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return converted
+}
@@ -0,0 +1,183 @@
+// Package tracing records spans of work, such as the handling of an event,
+// and exports them to an OpenTelemetry collector. As long as no exporter has
+// been configured, tracing is disabled and all operations are no-ops, so
+// instrumented code doesn't have to check whether tracing is enabled.
+package tracing
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/scribble-rs/scribble.rs/logging"
+)
+
+const (
+	// bufferSize is the amount of finished spans buffered for the exporter.
+	// If the exporter can't keep up, further spans are dropped.
+	bufferSize = 2048
+	// batchSize is the maximum amount of spans exported at once.
+	batchSize = 512
+	// exportInterval is the maximum time a finished span waits for being
+	// exported.
+	exportInterval = 5 * time.Second
+)
+
+// TraceID identifies all spans belonging to the same trace.
+type TraceID [16]byte
+
+// SpanID identifies a single span within a trace.
+type SpanID [8]byte
+
+// Attribute is a key-value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is the immutable record of a finished span, as passed to the
+// exporter.
+type SpanData struct {
+	Name         string
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	// Error is the reason for the work having failed. It's empty if the
+	// work has succeeded.
+	Error string
+}
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(spans []*SpanData) error
+}
+
+var (
+	finishedSpans chan *SpanData
+	enabled       bool
+)
+
+// Configure enables tracing, exporting all spans via the given exporter.
+// This has to be called before any span is started.
+func Configure(exporter Exporter) {
+	finishedSpans = make(chan *SpanData, bufferSize)
+	enabled = true
+	go exportSpans(exporter)
+}
+
+// Enabled indicates whether spans are being recorded.
+func Enabled() bool {
+	return enabled
+}
+
+// Span is a single unit of work. A nil Span is valid and ignores all
+// operations, which is what Start returns if tracing is disabled.
+type Span struct {
+	mutex *sync.Mutex
+	data  *SpanData
+	ended bool
+}
+
+// Start starts a new span. If parent is nil, the span starts a new trace.
+// The key-value pairs are added as attributes.
+func Start(parent *Span, name string, keyValues ...interface{}) *Span {
+	if !enabled {
+		return nil
+	}
+
+	data := &SpanData{
+		Name:  name,
+		Start: time.Now(),
+	}
+	if parent != nil {
+		data.TraceID = parent.data.TraceID
+		data.ParentSpanID = parent.data.SpanID
+	} else {
+		rand.Read(data.TraceID[:])
+	}
+	rand.Read(data.SpanID[:])
+
+	span := &Span{mutex: &sync.Mutex{}, data: data}
+	span.SetAttributes(keyValues...)
+	return span
+}
+
+// SetAttributes adds the given key-value pairs as attributes.
+func (span *Span) SetAttributes(keyValues ...interface{}) {
+	if span == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	for index := 0; index+1 < len(keyValues); index += 2 {
+		span.data.Attributes = append(span.data.Attributes, Attribute{
+			Key:   fmt.Sprint(keyValues[index]),
+			Value: keyValues[index+1],
+		})
+	}
+}
+
+// SetError marks the span as failed. A nil error is ignored.
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	span.data.Error = err.Error()
+	span.mutex.Unlock()
+}
+
+// End finishes the span and queues it for exporting. Ending a span more
+// than once has no effect.
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+
+	span.mutex.Lock()
+	defer span.mutex.Unlock()
+
+	if span.ended {
+		return
+	}
+	span.ended = true
+	span.data.End = time.Now()
+
+	select {
+	case finishedSpans <- span.data:
+	default:
+		//Tracing must never slow down the game.
+	}
+}
+
+func exportSpans(exporter Exporter) {
+	exportTicker := time.NewTicker(exportInterval)
+	defer exportTicker.Stop()
+
+	batch := make([]*SpanData, 0, batchSize)
+	for {
+		select {
+		case span := <-finishedSpans:
+			batch = append(batch, span)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-exportTicker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		if exportError := exporter.Export(batch); exportError != nil {
+			logging.Warn("error exporting spans", "spans", len(batch), "error", exportError)
+		}
+		batch = make([]*SpanData, 0, batchSize)
+	}
+}
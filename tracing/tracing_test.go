@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// enableForTest enables tracing without exporting. Finished spans can be
+// read from the returned channel. The returned function disables tracing
+// again.
+func enableForTest() (chan *SpanData, func()) {
+	finishedSpans = make(chan *SpanData, bufferSize)
+	enabled = true
+	return finishedSpans, func() {
+		enabled = false
+		finishedSpans = nil
+	}
+}
+
+func Test_disabledTracing(t *testing.T) {
+	span := Start(nil, "disabled")
+	if span != nil {
+		t.Fatalf("expected nil span while tracing is disabled, but got %+v", span)
+	}
+
+	//None of these may panic.
+	span.SetAttributes("key", "value")
+	span.SetError(errors.New("error"))
+	span.End()
+}
+
+func Test_spanHierarchy(t *testing.T) {
+	spans, disable := enableForTest()
+	defer disable()
+
+	parent := Start(nil, "parent", "lobby", "abc")
+	child := Start(parent, "child")
+	child.SetError(errors.New("failed"))
+	child.End()
+	child.End()
+	parent.End()
+
+	finishedChild, finishedParent := <-spans, <-spans
+	if len(spans) != 0 {
+		t.Errorf("spans ended twice have been exported twice")
+	}
+
+	if finishedChild.TraceID != finishedParent.TraceID {
+		t.Errorf("child belongs to different trace")
+	}
+	if finishedChild.ParentSpanID != finishedParent.SpanID {
+		t.Errorf("child isn't attached to its parent")
+	}
+	if finishedParent.ParentSpanID != (SpanID{}) {
+		t.Errorf("root span has a parent")
+	}
+	if finishedChild.Error != "failed" {
+		t.Errorf("expected error 'failed', but got '%s'", finishedChild.Error)
+	}
+	if len(finishedParent.Attributes) != 1 || finishedParent.Attributes[0] != (Attribute{Key: "lobby", Value: "abc"}) {
+		t.Errorf("unexpected attributes %v", finishedParent.Attributes)
+	}
+	if finishedParent.End.Before(finishedParent.Start) {
+		t.Errorf("span ended before it started")
+	}
+}
+
+func Test_OTLPExporter_newRequest(t *testing.T) {
+	span := &SpanData{
+		Name:         "HandleEvent",
+		TraceID:      TraceID{1},
+		SpanID:       SpanID{2},
+		ParentSpanID: SpanID{3},
+		Attributes:   []Attribute{{Key: "event.type", Value: "line"}, {Key: "recipients", Value: 5}},
+		Error:        "failed",
+	}
+
+	request := NewOTLPExporter("http://localhost", "scribble.rs").newRequest([]*SpanData{span})
+	converted := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+
+	if converted.TraceID != hex.EncodeToString(span.TraceID[:]) || converted.SpanID != "0200000000000000" ||
+		converted.ParentSpanID != "0300000000000000" {
+		t.Errorf("IDs haven't been converted correctly: %+v", converted)
+	}
+	if *converted.Attributes[0].Value.StringValue != "line" || *converted.Attributes[1].Value.IntValue != "5" {
+		t.Errorf("attributes haven't been converted correctly: %+v", converted.Attributes)
+	}
+	if converted.Status == nil || converted.Status.Code != otlpStatusCodeError {
+		t.Errorf("error status is missing: %+v", converted.Status)
+	}
+	if *request.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "scribble.rs" {
+		t.Errorf("service name is missing")
+	}
+}